@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+// rejectedUploadStatuses are the responses to uploads whose content was
+// turned away, which count towards banning the client that sent them.
+var rejectedUploadStatuses = map[int]bool{
+	http.StatusBadRequest:            true,
+	http.StatusRequestEntityTooLarge: true,
+	http.StatusUnsupportedMediaType:  true,
+	http.StatusUnprocessableEntity:   true,
+}
+
+// middlewareUploadGuard limits upload attempts per client IP over a sliding
+// window and bans IPs whose uploads keep getting rejected, so a single
+// client can't keep ffmpeg busy with junk. Unlike middlewareRateLimit, it
+// applies per IP whatever account is used, so signing up for more accounts
+// doesn't help. IPs on the allowlist, like those of trusted ingest hosts,
+// aren't limited. IPv6 clients are limited per /64.
+func (cfg *apiConfig) middlewareUploadGuard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if cfg.uploadAllowlisted(ip) {
+			next(w, r)
+			return
+		}
+
+		key := limitKey(ip)
+		ok, retryAfter := cfg.uploadGuard.Allow(key)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			respondWithErrorCode(w, http.StatusTooManyRequests, errCodeRateLimited, "Too many uploads from this address", nil, nil)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		if rejectedUploadStatuses[recorder.status] && cfg.uploadGuard.Fail(key) {
+			log.Printf("Banning uploads from %s for %s after repeated rejected uploads", key, cfg.uploadBanDuration)
+			metrics.UploadBansTotal.Inc()
+		}
+	}
+}
+
+// ipv6LimitBits is how much of an IPv6 address identifies a client to
+// limits and bans. Hosts are usually handed a whole /64 and can pick any
+// address in it.
+const ipv6LimitBits = 64
+
+// limitKey returns the key a client IP is limited and banned under. IPv6
+// addresses are masked to their /64, so a client can't get around a limit by
+// moving to another address in its own network.
+func limitKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, err := addr.WithZone("").Prefix(ipv6LimitBits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+func (cfg *apiConfig) uploadAllowlisted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range cfg.uploadAllowlist {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPAllowlist parses a comma-separated list of IPs and CIDR ranges.
+func parseIPAllowlist(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't an IP or CIDR range", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
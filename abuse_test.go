@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+func TestLimitKey(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{"::ffff:203.0.113.7", "203.0.113.7"},
+		{"2001:db8:1:2::1", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:aaaa:bbbb:cccc:dddd", "2001:db8:1:2::/64"},
+		{"2001:db8:1:3::1", "2001:db8:1:3::/64"},
+		{"fe80::1%eth0", "fe80::/64"},
+		{"not an ip", "not an ip"},
+	}
+	for _, tt := range tests {
+		got := limitKey(tt.ip)
+		if got != tt.want {
+			t.Errorf("limitKey(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// A ban applies to the whole /64 of an IPv6 client, so rotating through its
+// addresses doesn't get around it.
+func TestUploadBanSpansIPv6Prefix(t *testing.T) {
+	cfg := &apiConfig{
+		uploadGuard:       ratelimit.NewGuard(time.Hour, 0, 2, time.Hour),
+		uploadBanDuration: time.Hour,
+	}
+	handler := cfg.middlewareUploadGuard(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	upload := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/video_upload", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	for _, addr := range []string{"[2001:db8:1:2::1]:4000", "[2001:db8:1:2::2]:4000"} {
+		if code := upload(addr); code != http.StatusBadRequest {
+			t.Fatalf("upload from %s = %d, want %d", addr, code, http.StatusBadRequest)
+		}
+	}
+	if code := upload("[2001:db8:1:2:ffff::3]:4000"); code != http.StatusTooManyRequests {
+		t.Errorf("upload from a third address in the banned /64 = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := upload("[2001:db8:1:3::1]:4000"); code != http.StatusBadRequest {
+		t.Errorf("upload from another /64 = %d, want %d", code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	errUploadInfected  = errors.New("upload is infected")
+	errVirusScanFailed = errors.New("couldn't scan upload for viruses")
+)
+
+// scanUpload scans a file uploaded for a video with clamd before it's
+// stored, and records the result on the video. It returns errUploadInfected
+// if something was found, and errVirusScanFailed if the file couldn't be
+// scanned, unless uploads fail open, in which case it's let through. It's a
+// no-op when no scanner is configured.
+func (cfg *apiConfig) scanUpload(ctx context.Context, videoID uuid.UUID, file string, r io.Reader) (err error) {
+	if cfg.virusScanner == nil {
+		return nil
+	}
+
+	ctx, span := tracer.Start(ctx, "upload.virus_scan", trace.WithAttributes(
+		attribute.String("video.id", videoID.String()),
+		attribute.String("upload.file", file),
+	))
+	defer func() { endSpan(span, err) }()
+
+	result, scanErr := cfg.virusScanner.Scan(ctx, r)
+	scan := database.VirusScan{
+		Status:    database.VirusScanStatusClean,
+		File:      file,
+		Signature: result.Signature,
+		ScannedAt: time.Now().UTC(),
+	}
+	switch {
+	case scanErr != nil:
+		scan.Status = database.VirusScanStatusFailed
+		scan.Error = scanErr.Error()
+	case result.Infected:
+		scan.Status = database.VirusScanStatusInfected
+	}
+	metrics.VirusScansTotal.WithLabelValues(string(scan.Status)).Inc()
+
+	dbErr := cfg.db.UpdateVideoVirusScan(videoID, scan)
+	if dbErr != nil {
+		log.Printf("Couldn't record virus scan of video %s: %v", videoID, dbErr)
+	}
+
+	switch scan.Status {
+	case database.VirusScanStatusInfected:
+		log.Printf("Rejected %s uploaded for video %s: found %s", file, videoID, result.Signature)
+		return fmt.Errorf("%w: %s", errUploadInfected, result.Signature)
+	case database.VirusScanStatusFailed:
+		if cfg.virusScanFailOpen {
+			log.Printf("Couldn't scan %s uploaded for video %s, accepting it anyway: %v", file, videoID, scanErr)
+			return nil
+		}
+		return fmt.Errorf("%w: %w", errVirusScanFailed, scanErr)
+	}
+	return nil
+}
+
+// scanUploadedFile scans an uploaded file like scanUpload, responding with
+// the reason it was rejected if it wasn't accepted.
+func (cfg *apiConfig) scanUploadedFile(ctx context.Context, w http.ResponseWriter, videoID uuid.UUID, file string, r io.Reader) bool {
+	err := cfg.scanUpload(ctx, videoID, file, r)
+	if errors.Is(err, errUploadInfected) {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, errCodeUploadInfected, "Upload contains malware", nil, err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't scan upload for viruses, try again later", err)
+		return false
+	}
+	return true
+}
+
+// scanSourceFile scans a video source that was received without being
+// scanned, like a fetched or directly uploaded video, before it's processed.
+func (cfg *apiConfig) scanSourceFile(ctx context.Context, videoID uuid.UUID, srcPath string) error {
+	if cfg.virusScanner == nil {
+		return nil
+	}
+	source, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("couldn't open video: %w", err)
+	}
+	defer source.Close()
+	return cfg.scanUpload(ctx, videoID, "video", source)
+}
+
+// scanStagedSource scans a staged video source where it's stored, for
+// MediaConvert, which reads sources straight from the bucket.
+func (cfg *apiConfig) scanStagedSource(ctx context.Context, videoID uuid.UUID, key string) error {
+	if cfg.virusScanner == nil {
+		return nil
+	}
+	source, err := cfg.videoStorage.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("couldn't get %s: %w", key, err)
+	}
+	defer source.Close()
+	return cfg.scanUpload(ctx, videoID, "video", source)
+}
@@ -21,12 +21,43 @@ document.getElementById('login-form').addEventListener('submit', async (event) =
   await login();
 });
 
+// authFetch retries a request once with a fresh access token when the
+// current one has expired, e.g. during a long upload.
+async function authFetch(url, options) {
+  let res = await fetch(url, options);
+  if (res.status !== 401 || !(await refreshAccessToken())) {
+    return res;
+  }
+  options.headers.Authorization = `Bearer ${localStorage.getItem('token')}`;
+  return fetch(url, options);
+}
+
+async function refreshAccessToken() {
+  const refreshToken = localStorage.getItem('refreshToken');
+  if (!refreshToken) {
+    return false;
+  }
+  const res = await fetch('/api/v1/refresh', {
+    method: 'POST',
+    headers: {
+      Authorization: `Bearer ${refreshToken}`,
+    },
+  });
+  if (!res.ok) {
+    return false;
+  }
+  const data = await res.json();
+  localStorage.setItem('token', data.token);
+  localStorage.setItem('refreshToken', data.refresh_token);
+  return true;
+}
+
 async function createVideoDraft() {
   const title = document.getElementById('video-title').value;
   const description = document.getElementById('video-description').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await authFetch('/api/v1/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -54,7 +85,7 @@ async function login() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/login', {
+    const res = await fetch('/api/v1/login', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -68,6 +99,7 @@ async function login() {
 
     if (data.token) {
       localStorage.setItem('token', data.token);
+      localStorage.setItem('refreshToken', data.refresh_token);
       document.getElementById('auth-section').style.display = 'none';
       document.getElementById('video-section').style.display = 'block';
       await getVideos();
@@ -84,7 +116,7 @@ async function signup() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/users', {
+    const res = await fetch('/api/v1/users', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -104,6 +136,7 @@ async function signup() {
 
 function logout() {
   localStorage.removeItem('token');
+  localStorage.removeItem('refreshToken');
   document.getElementById('auth-section').style.display = 'block';
   document.getElementById('video-section').style.display = 'none';
 }
@@ -130,7 +163,7 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await authFetch(`/api/v1/thumbnail_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -163,7 +196,7 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await authFetch(`/api/v1/video_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -188,7 +221,7 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
+    const res = await authFetch('/api/v1/videos', {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -231,7 +264,7 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
+    const res = await authFetch(`/api/v1/videos/${videoID}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -262,6 +295,11 @@ function viewVideo(video) {
   } else {
     thumbnailImg.style.display = 'block';
     thumbnailImg.src = video.thumbnail_url;
+    thumbnailImg.srcset = video.thumbnail_srcset
+      ? Object.entries(video.thumbnail_srcset)
+          .map(([width, url]) => `${url} ${width}`)
+          .join(', ')
+      : '';
   }
 
   const videoPlayer = document.getElementById('video-player');
@@ -271,11 +309,29 @@ function viewVideo(video) {
     } else {
       videoPlayer.style.display = 'block';
       videoPlayer.src = video.video_url;
+      // Only the first play of each video viewed counts.
+      videoPlayer.onplay = () => {
+        videoPlayer.onplay = null;
+        reportPlay(video.id);
+      };
       videoPlayer.load();
     }
   }
 }
 
+async function reportPlay(videoID) {
+  try {
+    await authFetch(`/api/v1/videos/${videoID}/plays`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+  } catch (error) {
+    console.error(`Failed to report play: ${error.message}`);
+  }
+}
+
 async function deleteVideo() {
   if (!currentVideo) {
     alert('No video selected for deletion.');
@@ -283,7 +339,7 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await authFetch(`/api/v1/videos/${currentVideo.id}`, {
       method: 'DELETE',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// errVideoArchived is returned when reading a video whose stored object is
+// archived and not restored.
+var errVideoArchived = errors.New("video is archived")
+
+// managedVideoFromRequest loads the video named in the path for its owner or
+// an admin, responding with an error and returning false otherwise.
+func (cfg *apiConfig) managedVideoFromRequest(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, false
+	}
+	if video.UserID == userID {
+		return video, true
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.Video{}, false
+	}
+	if user == nil || !auth.Role(user.Role).Has(auth.RoleAdmin) {
+		respondWithError(w, http.StatusForbidden, "Insufficient rights to video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// archivableVideo checks that the video's stored object can be archived or
+// restored, responding with an error and returning false otherwise.
+func (cfg *apiConfig) archivableVideo(w http.ResponseWriter, video database.Video) bool {
+	if cfg.videoStorageName != "s3" {
+		respondWithError(w, http.StatusNotImplemented, "Archiving requires S3 video storage", nil)
+		return false
+	}
+	if video.StorageKey == nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoNotProcessed, "Video hasn't been processed yet", nil, nil)
+		return false
+	}
+	return true
+}
+
+//openapi:summary Archive a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 database.Video
+func (cfg *apiConfig) handlerVideoArchive(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.managedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.archivableVideo(w, video) {
+		return
+	}
+	if video.ArchiveStatus != database.ArchiveStatusNone {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Video is already archived", nil, nil)
+		return
+	}
+	if video.ProcessingStatus == database.ProcessingStatusPending || video.ProcessingStatus == database.ProcessingStatusProcessing {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoProcessing, "Video is being processed", nil, nil)
+		return
+	}
+
+	// Deduplicated uploads share their stored object, which the other
+	// videos still need to be able to play.
+	refs, err := cfg.db.CountStorageKeyReferences(cfg.s3Bucket, *video.StorageKey, video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video references", err)
+		return
+	}
+	if refs > 0 {
+		respondWithError(w, http.StatusConflict, "Video is shared with other videos and can't be archived", nil)
+		return
+	}
+
+	err = cfg.s3Objects.Archive(r.Context(), *video.StorageKey, cfg.archiveStorageClass)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't archive video", err)
+		return
+	}
+	err = cfg.db.UpdateVideoArchiveStatus(video.ID, database.ArchiveStatusArchived, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	log.Printf("Archived video %s to %s", video.ID, cfg.archiveStorageClass)
+
+	video.ArchiveStatus = database.ArchiveStatusArchived
+	video.RestoredUntil = nil
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+//openapi:summary Restore an archived video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:body parameters
+//openapi:response 202 database.Video
+func (cfg *apiConfig) handlerVideoArchiveRestore(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Days is how long the restored copy is kept, RESTORE_DAYS if zero.
+		Days int `json:"days"`
+		// Tier is Expedited, Standard or Bulk, RESTORE_TIER if empty.
+		Tier s3types.Tier `json:"tier"`
+	}
+
+	video, ok := cfg.managedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.archivableVideo(w, video) {
+		return
+	}
+	if video.ArchiveStatus == database.ArchiveStatusNone {
+		respondWithError(w, http.StatusConflict, "Video isn't archived", nil)
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Days == 0 {
+		params.Days = cfg.restoreDays
+	}
+	if params.Days < 1 {
+		respondWithError(w, http.StatusBadRequest, "days must be positive", nil)
+		return
+	}
+	if params.Tier == "" {
+		params.Tier = cfg.restoreTier
+	}
+	if _, err := storage.ParseRestoreTier(string(params.Tier)); err != nil {
+		respondWithError(w, http.StatusBadRequest, "tier must be Expedited, Standard or Bulk", err)
+		return
+	}
+
+	err = cfg.s3Objects.Restore(r.Context(), *video.StorageKey, params.Days, params.Tier)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+
+	// A restored copy stays playable while its expiry is extended.
+	if video.ArchiveStatus == database.ArchiveStatusArchived {
+		video.ArchiveStatus = database.ArchiveStatusRestoring
+		err = cfg.db.UpdateVideoArchiveStatus(video.ID, video.ArchiveStatus, nil)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	log.Printf("Requested %s restore of video %s for %d days", params.Tier, video.ID, params.Days)
+
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+//openapi:summary Get the archive status of a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 response
+func (cfg *apiConfig) handlerVideoArchiveGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ArchiveStatus database.ArchiveStatus `json:"archive_status"`
+		StorageClass  s3types.StorageClass   `json:"storage_class"`
+		RestoredUntil *time.Time             `json:"restored_until,omitempty"`
+	}
+
+	video, ok := cfg.managedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.archivableVideo(w, video) {
+		return
+	}
+
+	state, err := cfg.s3Objects.ArchiveState(r.Context(), *video.StorageKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get archive status", err)
+		return
+	}
+	video, err = cfg.updateArchiveStatus(video, state)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		ArchiveStatus: video.ArchiveStatus,
+		StorageClass:  state.StorageClass,
+		RestoredUntil: video.RestoredUntil,
+	})
+}
+
+// updateArchiveStatus records the progress of a restore reported by S3. A
+// restored copy that expired leaves the video archived again.
+func (cfg *apiConfig) updateArchiveStatus(video database.Video, state storage.ArchiveState) (database.Video, error) {
+	if video.ArchiveStatus == database.ArchiveStatusNone {
+		return video, nil
+	}
+
+	status := database.ArchiveStatusArchived
+	var restoredUntil *time.Time
+	switch {
+	case state.Restoring:
+		status = database.ArchiveStatusRestoring
+	case state.RestoredUntil != nil:
+		status = database.ArchiveStatusRestored
+		restoredUntil = state.RestoredUntil
+	}
+	if status == video.ArchiveStatus && timesEqual(restoredUntil, video.RestoredUntil) {
+		return video, nil
+	}
+
+	err := cfg.db.UpdateVideoArchiveStatus(video.ID, status, restoredUntil)
+	if err != nil {
+		return video, err
+	}
+	if status == database.ArchiveStatusRestored && video.ArchiveStatus == database.ArchiveStatusRestoring {
+		log.Printf("Restored video %s until %s", video.ID, restoredUntil)
+	}
+	video.ArchiveStatus = status
+	video.RestoredUntil = restoredUntil
+	return video, nil
+}
+
+func timesEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// checkArchiveRestores tracks the restores in progress and the restored
+// copies about to expire, so the videos' archive status is current without
+// anyone asking for it.
+func (cfg *apiConfig) checkArchiveRestores(ctx context.Context) error {
+	videos, err := cfg.db.GetVideosByArchiveStatus(database.ArchiveStatusRestoring, database.ArchiveStatusRestored)
+	if err != nil {
+		return fmt.Errorf("couldn't get videos being restored: %w", err)
+	}
+	for _, video := range videos {
+		if video.StorageKey == nil {
+			continue
+		}
+		state, err := cfg.s3Objects.ArchiveState(ctx, *video.StorageKey)
+		if err != nil {
+			log.Printf("Couldn't get archive status of video %s: %v", video.ID, err)
+			continue
+		}
+		_, err = cfg.updateArchiveStatus(video, state)
+		if err != nil {
+			return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	assetFieldVideo     = "video_url"
+	assetFieldVersion   = "video_version"
+	assetFieldRendition = "video_rendition"
+	assetFieldHLS       = "hls_url"
+	assetFieldThumbnail = "thumbnail_url"
+	assetFieldSrcset    = "thumbnail_srcset"
+	assetFieldSprite    = "sprite_url"
+	assetFieldSpriteVTT = "sprite_vtt_url"
+	assetFieldPreview   = "preview_url"
+)
+
+type assetGCReport struct {
+	DryRun          bool             `json:"dry_run"`
+	OrphanedObjects []orphanedObject `json:"orphaned_objects"`
+	MissingObjects  []missingObject  `json:"missing_objects"`
+}
+
+// orphanedObject is a stored object that no video references.
+type orphanedObject struct {
+	Storage string `json:"storage"`
+	Key     string `json:"key"`
+	Size    int64  `json:"size"`
+}
+
+// missingObject is a video field that references an object that doesn't
+// exist.
+type missingObject struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Field   string    `json:"field"`
+	Key     string    `json:"key"`
+}
+
+// assetGCBackend collects the references into one storage backend. When
+// videos and thumbnails share a backend, both are tracked in the same one so
+// neither kind is mistaken for an orphan.
+type assetGCBackend struct {
+	name     string
+	storage  storage.Storage
+	keys     map[string][]missingObject
+	prefixes []string
+}
+
+func (b *assetGCBackend) reference(videoID uuid.UUID, field, key string) {
+	b.keys[key] = append(b.keys[key], missingObject{VideoID: videoID, Field: field, Key: key})
+}
+
+func (b *assetGCBackend) referenced(key string) bool {
+	if _, ok := b.keys[key]; ok {
+		return true
+	}
+	for _, prefix := range b.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectAssetGarbage finds stored objects no video references and video
+// fields pointing at objects that don't exist. Unless dryRun is set, orphaned
+// objects are deleted and dangling fields are cleared. Objects modified less
+// than minAge ago are never reported, since they may belong to an upload
+// that hasn't been recorded yet. With assetsDir set, the assets directory is
+// swept too when neither videos nor thumbnails are stored in it, for files
+// left behind by local storage.
+func (cfg *apiConfig) collectAssetGarbage(ctx context.Context, dryRun bool, minAge time.Duration, assetsDir bool) (assetGCReport, error) {
+	report := assetGCReport{
+		DryRun:          dryRun,
+		OrphanedObjects: []orphanedObject{},
+		MissingObjects:  []missingObject{},
+	}
+
+	// Videos are loaded before listing so that objects stored in between
+	// show up as unreferenced, where minAge protects them, rather than as
+	// missing.
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return report, fmt.Errorf("couldn't get videos: %w", err)
+	}
+
+	videoBackend := &assetGCBackend{name: "video", storage: cfg.videoStorage, keys: map[string][]missingObject{}}
+	thumbnailBackend := videoBackend
+	backends := []*assetGCBackend{videoBackend}
+	if cfg.thumbnailStorage != cfg.videoStorage {
+		thumbnailBackend = &assetGCBackend{name: "thumbnail", storage: cfg.thumbnailStorage, keys: map[string][]missingObject{}}
+		backends = append(backends, thumbnailBackend)
+	}
+	var diskBackend *assetGCBackend
+	if assetsDir && cfg.videoStorageName != "local" && cfg.thumbnailStorageName != "local" {
+		local := storage.NewLocal(cfg.assetsRoot, localAssetsURL(cfg.port))
+		diskBackend = &assetGCBackend{name: "assets_dir", storage: local, keys: map[string][]missingObject{}}
+		backends = append(backends, diskBackend)
+	}
+
+	for _, video := range videos {
+		if video.StorageKey != nil {
+			bucket := ""
+			if video.StorageBucket != nil {
+				bucket = *video.StorageBucket
+			}
+			if bucket == cfg.videoStorage.Bucket() {
+				videoBackend.reference(video.ID, assetFieldVideo, *video.StorageKey)
+			}
+		}
+		if video.HLSURL != nil {
+			manifestKey, ok := cfg.videoStorage.KeyFromURL(*video.HLSURL)
+			if ok {
+				videoBackend.reference(video.ID, assetFieldHLS, manifestKey)
+				videoBackend.prefixes = append(videoBackend.prefixes, path.Dir(manifestKey)+"/")
+			}
+		}
+		videoBackend.prefixes = append(videoBackend.prefixes, cfg.videoAssetPrefixes(video, aspectCaptions)...)
+		videoBackend.prefixes = append(videoBackend.prefixes, cfg.videoAssetPrefixes(video, aspectAudio)...)
+		if video.SpriteURL != nil {
+			key, ok := cfg.videoStorage.KeyFromURL(*video.SpriteURL)
+			if ok {
+				videoBackend.reference(video.ID, assetFieldSprite, key)
+			}
+		}
+		if video.SpriteVTTURL != nil {
+			key, ok := cfg.videoStorage.KeyFromURL(*video.SpriteVTTURL)
+			if ok {
+				videoBackend.reference(video.ID, assetFieldSpriteVTT, key)
+			}
+		}
+		if video.PreviewURL != nil {
+			key, ok := cfg.videoStorage.KeyFromURL(*video.PreviewURL)
+			if ok {
+				videoBackend.reference(video.ID, assetFieldPreview, key)
+			}
+		}
+		if video.ThumbnailURL != nil {
+			key, ok := cfg.thumbnailStorage.KeyFromURL(*video.ThumbnailURL)
+			if ok {
+				thumbnailBackend.reference(video.ID, assetFieldThumbnail, key)
+			}
+		}
+		for _, variantURL := range video.ThumbnailSrcset {
+			key, ok := cfg.thumbnailStorage.KeyFromURL(variantURL)
+			if ok && (video.ThumbnailURL == nil || variantURL != *video.ThumbnailURL) {
+				thumbnailBackend.reference(video.ID, assetFieldSrcset, key)
+			}
+		}
+		// Thumbnails that couldn't be migrated off local storage are still
+		// served from disk.
+		if diskBackend != nil {
+			for _, thumbnailURL := range thumbnailURLs(video) {
+				key, ok := diskBackend.storage.KeyFromURL(thumbnailURL)
+				if ok {
+					diskBackend.reference(video.ID, assetFieldThumbnail, key)
+				}
+			}
+		}
+	}
+
+	versions, err := cfg.db.GetAllVideoVersions()
+	if err != nil {
+		return report, fmt.Errorf("couldn't get video versions: %w", err)
+	}
+	for _, version := range versions {
+		if version.StorageBucket == cfg.videoStorage.Bucket() {
+			videoBackend.reference(version.VideoID, assetFieldVersion, version.StorageKey)
+		}
+	}
+
+	renditions, err := cfg.db.GetAllVideoRenditions()
+	if err != nil {
+		return report, fmt.Errorf("couldn't get video renditions: %w", err)
+	}
+	for _, rendition := range renditions {
+		if rendition.StorageBucket == cfg.videoStorage.Bucket() {
+			videoBackend.reference(rendition.VideoID, assetFieldRendition, rendition.StorageKey)
+		}
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	for _, backend := range backends {
+		objects, err := backend.storage.List(ctx, "")
+		if err != nil {
+			return report, fmt.Errorf("couldn't list %s storage: %w", backend.name, err)
+		}
+
+		existing := make(map[string]bool, len(objects))
+		for _, object := range objects {
+			existing[object.Key] = true
+			if backend.referenced(object.Key) || object.LastModified.After(cutoff) {
+				continue
+			}
+
+			report.OrphanedObjects = append(report.OrphanedObjects, orphanedObject{
+				Storage: backend.name,
+				Key:     object.Key,
+				Size:    object.Size,
+			})
+			if dryRun {
+				continue
+			}
+			err := backend.storage.Delete(ctx, object.Key)
+			if err != nil {
+				return report, fmt.Errorf("couldn't delete orphaned object %s: %w", object.Key, err)
+			}
+		}
+
+		for key, refs := range backend.keys {
+			if existing[key] {
+				continue
+			}
+			report.MissingObjects = append(report.MissingObjects, refs...)
+			if dryRun {
+				continue
+			}
+			for _, ref := range refs {
+				err := cfg.clearMissingAsset(ref)
+				if err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// clearMissingAsset removes a dangling reference from a video. The video is
+// reloaded so a reference replaced since the sweep started is left alone.
+func (cfg *apiConfig) clearMissingAsset(ref missingObject) error {
+	if ref.Field == assetFieldVersion {
+		version, err := cfg.db.GetVideoVersionByKey(ref.VideoID, cfg.videoStorage.Bucket(), ref.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't get version of video %s: %w", ref.VideoID, err)
+		}
+		if version == nil {
+			return nil
+		}
+		err = cfg.db.DeleteVideoVersion(version.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't delete version %s of video %s: %w", version.ID, ref.VideoID, err)
+		}
+		return nil
+	}
+	if ref.Field == assetFieldRendition {
+		renditions, err := cfg.db.GetVideoRenditions(ref.VideoID)
+		if err != nil {
+			return fmt.Errorf("couldn't get renditions of video %s: %w", ref.VideoID, err)
+		}
+		for _, rendition := range renditions {
+			if rendition.StorageKey != ref.Key {
+				continue
+			}
+			err = cfg.db.DeleteVideoRendition(rendition.ID)
+			if err != nil {
+				return fmt.Errorf("couldn't delete rendition %s of video %s: %w", rendition.ID, ref.VideoID, err)
+			}
+		}
+		return nil
+	}
+
+	video, err := cfg.db.GetVideo(ref.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video %s: %w", ref.VideoID, err)
+	}
+
+	var guard string
+	var guardValue *string
+	var columns []string
+	switch ref.Field {
+	case assetFieldVideo:
+		if video.StorageKey == nil || *video.StorageKey != ref.Key {
+			return nil
+		}
+		guard, guardValue = "storage_key", video.StorageKey
+		columns = []string{"video_url", "storage_bucket", "storage_key", "content_sha256"}
+	case assetFieldHLS:
+		if video.HLSURL == nil || *video.HLSURL != cfg.videoStorage.URL(ref.Key) {
+			return nil
+		}
+		guard, guardValue = "hls_url", video.HLSURL
+		columns = []string{"hls_url"}
+	case assetFieldSprite, assetFieldSpriteVTT:
+		if video.SpriteVTTURL == nil || video.SpriteURL == nil {
+			return nil
+		}
+		if cfg.videoStorage.URL(ref.Key) != *video.SpriteURL && cfg.videoStorage.URL(ref.Key) != *video.SpriteVTTURL {
+			return nil
+		}
+		// The sheet is useless without its track and vice versa.
+		guard, guardValue = "sprite_url", video.SpriteURL
+		columns = []string{"sprite_url", "sprite_vtt_url"}
+	case assetFieldPreview:
+		if video.PreviewURL == nil || *video.PreviewURL != cfg.videoStorage.URL(ref.Key) {
+			return nil
+		}
+		guard, guardValue = "preview_url", video.PreviewURL
+		columns = []string{"preview_url"}
+	case assetFieldThumbnail:
+		if video.ThumbnailURL == nil || *video.ThumbnailURL != cfg.thumbnailStorage.URL(ref.Key) {
+			return nil
+		}
+		guard, guardValue = "thumbnail_url", video.ThumbnailURL
+		columns = []string{"thumbnail_url"}
+	case assetFieldSrcset:
+		if !slices.Contains(thumbnailURLs(video), cfg.thumbnailStorage.URL(ref.Key)) {
+			return nil
+		}
+		// Without a srcset clients fall back to thumbnail_url. The other
+		// variants become orphans and are collected on a later run. A new
+		// thumbnail comes with a new thumbnail_url, so that guards the
+		// srcset too.
+		guard, guardValue = "thumbnail_url", video.ThumbnailURL
+		columns = []string{"thumbnail_srcset"}
+	default:
+		return nil
+	}
+
+	err = cfg.db.ClearVideoAssets(video.ID, guard, guardValue, columns...)
+	if err != nil {
+		return fmt.Errorf("couldn't clear %s of video %s: %w", ref.Field, video.ID, err)
+	}
+	return nil
+}
+
+// runAssetGC runs one scheduled sweep of the assets.
+func (cfg *apiConfig) runAssetGC(ctx context.Context, dryRun bool) error {
+	report, err := cfg.collectAssetGarbage(ctx, dryRun, cfg.assetGCMinAge, false)
+	if err != nil {
+		return err
+	}
+	logAssetGCReport(report)
+	return nil
+}
+
+func logAssetGCReport(report assetGCReport) {
+	log.Printf("Asset GC (dry run: %t): %d orphaned objects, %d missing objects", report.DryRun, len(report.OrphanedObjects), len(report.MissingObjects))
+	for _, object := range report.OrphanedObjects {
+		log.Printf("Orphaned %s object: %s (%d bytes)", object.Storage, object.Key, object.Size)
+	}
+	for _, missing := range report.MissingObjects {
+		log.Printf("Video %s %s points at missing object %s", missing.VideoID, missing.Field, missing.Key)
+	}
+}
+
+//openapi:summary Delete stored objects no video references
+//openapi:tags admin
+//openapi:query dry_run boolean Only report what would be deleted
+//openapi:response 200 assetGCReport
+func (cfg *apiConfig) handlerAdminAssetGC(w http.ResponseWriter, r *http.Request) {
+	dryRun := true
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid dry_run", err)
+			return
+		}
+	}
+
+	report, err := cfg.collectAssetGarbage(r.Context(), dryRun, cfg.assetGCMinAge, false)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't collect asset garbage", err)
+		return
+	}
+	logAssetGCReport(report)
+
+	respondWithJSON(w, http.StatusOK, report)
+}
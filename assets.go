@@ -4,18 +4,57 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 )
 
+// assetShardLen is the length of each of the two directory levels an asset
+// is sharded into, taken from the start of its random ID. Two levels of two
+// base64 characters spread assets over 4096 directories each.
+const assetShardLen = 2
+
 func (cfg apiConfig) ensureAssetsDir() error {
-	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
-		return os.Mkdir(cfg.assetsRoot, 0755)
+	return os.MkdirAll(cfg.assetsRoot, 0755)
+}
+
+// localAssetsURL is the base URL assets stored on local disk are served at.
+func localAssetsURL(port string) string {
+	return fmt.Sprintf("http://localhost:%s/assets", port)
+}
+
+// assetsHandler serves the assets stored on local disk under /assets/. With
+// signed set, only URLs signed by local are served.
+func assetsHandler(local *storage.Local, assetsRoot string, signed bool) http.Handler {
+	handler := http.FileServer(http.Dir(assetsRoot))
+	if signed {
+		handler = signedAssetsMiddleware(local, handler)
 	}
-	return nil
+	return noCacheMiddleware(http.StripPrefix("/assets", handler))
+}
+
+// signedAssetsMiddleware only serves local assets requested through a URL
+// signed by local, whose signature hasn't expired. It expects the /assets
+// prefix to be stripped already, so the path is the asset's key.
+func signedAssetsMiddleware(local *storage.Local, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		err := local.CheckSignature(key, r.URL.Query(), time.Now())
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "Invalid or expired asset URL", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
+// getAssetPath returns a new random asset path of the form "ab/cd/abcd...ext",
+// so that no single directory grows too large. Assets stored before sharding
+// keep their flat paths, which remain valid keys.
 func getAssetPath(mediaType string) string {
 	assetID := make([]byte, 32)
 	_, err := rand.Read(assetID)
@@ -25,28 +64,11 @@ func getAssetPath(mediaType string) string {
 
 	assetIDString := base64.URLEncoding.EncodeToString(assetID)
 	ext := mediaTypeToExt(mediaType)
-	return fmt.Sprintf("%s%s", assetIDString, ext)
-}
-
-func (cfg apiConfig) getObjectURL(key string) string {
-	return fmt.Sprintf("https://%s.cloudfront.net/%s", cfg.s3CfDistribution, key)
-}
-
-func (cfg apiConfig) getAssetDiskPath(assetPath string) string {
-	return filepath.Join(cfg.assetsRoot, assetPath)
-}
-
-func (cfg apiConfig) getAssetURL(assetPath string) string {
-	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
-}
-
-func (cfg apiConfig) getAssetDiskPathFromURL(assetURL string) (string, error) {
-	urlBase := fmt.Sprintf("http://localhost:%s/", cfg.port)
-	_, assetDiskPath, ok := strings.Cut(assetURL, urlBase)
-	if !ok {
-		return "", fmt.Errorf("Invalid asset URL. Missing expected base URL")
-	}
-	return assetDiskPath, nil
+	return path.Join(
+		assetIDString[:assetShardLen],
+		assetIDString[assetShardLen:2*assetShardLen],
+		fmt.Sprintf("%s%s", assetIDString, ext),
+	)
 }
 
 func mediaTypeToExt(mediaType string) string {
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestSignedAssetsServePresignedURLs(t *testing.T) {
+	assetsRoot := t.TempDir()
+	local := storage.NewLocal(assetsRoot, localAssetsURL("8091"))
+	local.SetSigningKey([]byte("test-signing-key"))
+
+	key := "ab/cd/abcd.png"
+	err := local.Put(context.Background(), key, strings.NewReader("thumbnail"), "image/png")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/assets/", assetsHandler(local, assetsRoot, true))
+
+	signedURL, err := local.PresignGet(context.Background(), key, time.Hour)
+	if err != nil {
+		t.Fatalf("PresignGet: %v", err)
+	}
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", signedURL, err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"signed", u.RequestURI(), http.StatusOK},
+		{"unsigned", u.Path, http.StatusForbidden},
+		{"other key", "/assets/ab/cd/other.png?" + u.RawQuery, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("GET %s: got status %d, want %d", tt.target, rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			body, _ := io.ReadAll(rec.Body)
+			if string(body) != "thumbnail" {
+				t.Errorf("GET %s: got body %q, want %q", tt.target, body, "thumbnail")
+			}
+		})
+	}
+}
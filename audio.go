@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// audioFormat is an audio-only rendition ffmpeg can extract from a video.
+type audioFormat struct {
+	ext       string
+	mediaType string
+	codecArgs []string
+}
+
+var (
+	audioFormatM4A = audioFormat{
+		ext:       ".m4a",
+		mediaType: "audio/mp4",
+		codecArgs: []string{"-c:a", "aac", "-b:a", "128k", "-movflags", "faststart"},
+	}
+	audioFormatMP3 = audioFormat{
+		ext:       ".mp3",
+		mediaType: "audio/mpeg",
+		codecArgs: []string{"-c:a", "libmp3lame", "-q:a", "2"},
+	}
+	// audioFormatSpeech is what speech recognition needs: mono, 16 kHz and
+	// a low bitrate so long recordings stay within the upload limits of
+	// cloud APIs.
+	audioFormatSpeech = audioFormat{
+		ext:       ".mp3",
+		mediaType: "audio/mpeg",
+		codecArgs: []string{"-ac", "1", "-ar", "16000", "-c:a", "libmp3lame", "-b:a", "48k"},
+	}
+)
+
+// audioExportFormats are the formats clients can request from the audio
+// endpoint.
+var audioExportFormats = map[string]audioFormat{
+	"m4a": audioFormatM4A,
+	"mp3": audioFormatMP3,
+}
+
+// extractStoredAudio extracts the audio track of a stored video into a new
+// temp file in format. The caller must remove the file.
+func (cfg *apiConfig) extractStoredAudio(ctx context.Context, video database.Video, format audioFormat) (string, error) {
+	if video.StorageKey == nil {
+		return "", errVideoNotProcessed
+	}
+	if !video.ArchiveStatus.Playable() {
+		return "", errVideoArchived
+	}
+	sourceURL, err := cfg.videoStorage.PresignGet(ctx, *video.StorageKey, cfg.processingTimeout)
+	if err != nil {
+		return "", fmt.Errorf("couldn't presign video: %w", err)
+	}
+
+	audioTmp, err := os.CreateTemp("", "tubely-audio*"+format.ext)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	audioTmp.Close()
+
+	args := []string{"-y", "-i", sourceURL, "-vn"}
+	args = append(args, format.codecArgs...)
+	args = append(args, audioTmp.Name())
+	cmd := exec.CommandContext(ctx, ffmpegBinary, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = runLimited(ctx, cmd)
+	if err != nil {
+		os.Remove(audioTmp.Name())
+		return "", fmt.Errorf("error extracting audio: %s, %v", stderr.String(), err)
+	}
+	return audioTmp.Name(), nil
+}
+
+func (cfg *apiConfig) audioPrefix(video database.Video) string {
+	return cfg.objectKey(video.UserID, aspectAudio, video.ID.String())
+}
+
+func (cfg *apiConfig) audioKey(video database.Video, format audioFormat) string {
+	return path.Join(cfg.audioPrefix(video), "audio"+format.ext)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 500
+)
+
+type auditContextKey struct{}
+
+// auditEntry collects what handlers know about an audited request that the
+// middleware can't tell from the request itself.
+type auditEntry struct {
+	userID     *uuid.UUID
+	resourceID string
+}
+
+// middlewareAudit appends an entry to the audit log for every request to
+// next, whether it succeeds or not: the user, the client IP, the response
+// status and, if resource names a path value, what the action was applied
+// to. The user is the one the bearer token belongs to; handlers that
+// authenticate differently, like login, report it with auditUser.
+func (cfg *apiConfig) middlewareAudit(action, resource string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &auditEntry{}
+		token, err := auth.GetBearerToken(r.Header)
+		if err == nil {
+			userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+			if err == nil {
+				entry.userID = &userID
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, entry)))
+
+		if entry.resourceID == "" && resource != "" {
+			entry.resourceID = r.PathValue(resource)
+		}
+		cfg.recordAudit(r, action, entry.userID, entry.resourceID, recorder.status)
+	}
+}
+
+// recordAudit appends an entry for an action of a request to the audit log.
+// Requests that apply several actions, like batches, record each of them.
+func (cfg *apiConfig) recordAudit(r *http.Request, action string, userID *uuid.UUID, resourceID string, status int) {
+	params := database.CreateAuditEntryParams{
+		UserID:     userID,
+		Action:     action,
+		IP:         clientIP(r),
+		StatusCode: status,
+		Result:     database.AuditResultSuccess,
+	}
+	if resourceID != "" {
+		params.ResourceID = &resourceID
+	}
+	if status >= http.StatusBadRequest {
+		params.Result = database.AuditResultFailure
+	}
+	err := cfg.db.CreateAuditEntry(params)
+	if err != nil {
+		log.Printf("Couldn't record audit entry for %s: %v", action, err)
+	}
+}
+
+// auditUser reports the user an audited request acted as.
+func auditUser(r *http.Request, userID uuid.UUID) {
+	if entry, ok := r.Context().Value(auditContextKey{}).(*auditEntry); ok {
+		entry.userID = &userID
+	}
+}
+
+// auditResource reports what an audited request was applied to, for
+// actions whose resource isn't in the path, like one it created.
+func auditResource(r *http.Request, id string) {
+	if entry, ok := r.Context().Value(auditContextKey{}).(*auditEntry); ok {
+		entry.resourceID = id
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handlerAdminAuditLog lists audit entries, newest first, filtered by the
+// user_id, action, resource_id, ip, result, since and until query
+// parameters. Pages continue from the cursor in the X-Next-Cursor header.
+//
+//openapi:summary Query the audit log
+//openapi:tags admin
+//openapi:query user_id uuid
+//openapi:query action string
+//openapi:query resource_id string
+//openapi:query ip string
+//openapi:query result string success or failure
+//openapi:query since date-time
+//openapi:query until date-time
+//openapi:query limit integer
+//openapi:query cursor string Where to continue, from the X-Next-Cursor header of the previous page
+//openapi:response 200 []database.AuditEntry
+func (cfg *apiConfig) handlerAdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditLogFilterFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	entries, err := cfg.db.ListAuditLog(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit log", err)
+		return
+	}
+
+	if len(entries) == filter.Limit {
+		w.Header().Set(nextCursorHeader, strconv.FormatInt(entries[len(entries)-1].ID, 10))
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+func auditLogFilterFromRequest(r *http.Request) (database.AuditLogFilter, error) {
+	query := r.URL.Query()
+	filter := database.AuditLogFilter{
+		Action:     query.Get("action"),
+		ResourceID: query.Get("resource_id"),
+		IP:         query.Get("ip"),
+		Limit:      defaultAuditPageSize,
+	}
+
+	if value := query.Get("user_id"); value != "" {
+		userID, err := uuid.Parse(value)
+		if err != nil {
+			return filter, errors.New("user_id must be a user ID")
+		}
+		filter.UserID = &userID
+	}
+
+	switch result := database.AuditResult(query.Get("result")); result {
+	case "", database.AuditResultSuccess, database.AuditResultFailure:
+		filter.Result = result
+	default:
+		return filter, errors.New("result must be success or failure")
+	}
+
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, errors.New("since must be an RFC 3339 time")
+		}
+		filter.Since = &since
+	}
+	if value := query.Get("until"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, errors.New("until must be an RFC 3339 time")
+		}
+		filter.Until = &until
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return filter, errors.New("limit must be a positive number")
+		}
+		filter.Limit = min(limit, maxAuditPageSize)
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before < 1 {
+			return filter, errors.New("invalid cursor")
+		}
+		filter.Before = before
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// checksumHeader optionally carries the SHA-256 digest of the uploaded file,
+// hex or base64 encoded.
+const checksumHeader = "X-Checksum-SHA256"
+
+// parseChecksumHeader returns the hex-encoded digest sent by the client, or
+// an empty string if the header is absent.
+func parseChecksumHeader(headers http.Header) (string, error) {
+	value := strings.TrimSpace(headers.Get(checksumHeader))
+	if value == "" {
+		return "", nil
+	}
+
+	if len(value) == hex.EncodedLen(sha256.Size) {
+		digest, err := hex.DecodeString(value)
+		if err == nil {
+			return hex.EncodeToString(digest), nil
+		}
+	}
+	digest, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(digest) != sha256.Size {
+		return "", errors.New("checksum must be a hex or base64 encoded SHA-256 digest")
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+// hashingCopy copies src to dst and returns the hex-encoded SHA-256 digest of
+// the copied bytes.
+func hashingCopy(dst io.Writer, src io.Reader) (string, error) {
+	hasher := sha256.New()
+	_, err := io.Copy(io.MultiWriter(dst, hasher), src)
+	if err != nil {
+		return "", err
+	}
+	return hexDigest(hasher), nil
+}
+
+func hexDigest(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func verifyChecksum(expected, actual string) error {
+	if expected != "" && expected != actual {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
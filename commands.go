@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// command is a task the binary runs with the server's configuration, e.g.
+// `tubely gc -dry-run=false`, so maintenance doesn't need an admin token or
+// a running server. Reports are written to stdout as JSON, logs to stderr.
+type command struct {
+	name  string
+	usage string
+	flags *flag.FlagSet
+	// run performs the task once the configuration is loaded. It's nil for
+	// serve, which main runs itself.
+	run func(ctx context.Context, cfg *apiConfig) error
+}
+
+// commands returns the commands the binary accepts. The worker flag of
+// serve is bound to worker, since it changes how the configuration is loaded.
+func commands(worker *bool) []*command {
+	serve := &command{
+		name:  "serve",
+		usage: "serve the API (the default), or with -worker only run queued jobs",
+		flags: flag.NewFlagSet("serve", flag.ExitOnError),
+	}
+	serve.flags.BoolVar(worker, "worker", false, "run queued jobs without serving the API")
+
+	gc := &command{
+		name:  "gc",
+		usage: "find stored objects no video references and, without -dry-run, delete them",
+		flags: flag.NewFlagSet("gc", flag.ExitOnError),
+	}
+	gcDryRun := gc.flags.Bool("dry-run", true, "only report what would be deleted")
+	gcMinAge := gc.flags.Duration("min-age", 0, "only delete objects older than this, ASSET_GC_MIN_AGE if zero")
+	gc.run = func(ctx context.Context, cfg *apiConfig) error {
+		minAge := *gcMinAge
+		if minAge == 0 {
+			minAge = cfg.assetGCMinAge
+		}
+		report, err := cfg.collectAssetGarbage(ctx, *gcDryRun, minAge, false)
+		if err != nil {
+			return err
+		}
+		logAssetGCReport(report)
+		return printJSON(report)
+	}
+
+	reconcile := &command{
+		name:  "reconcile",
+		usage: "compare storage and the assets directory against the database",
+		flags: flag.NewFlagSet("reconcile", flag.ExitOnError),
+	}
+	reconcileRepair := reconcile.flags.Bool("repair", false, "delete orphans, clear missing references and record missing checksums")
+	reconcileChecksums := reconcile.flags.Bool("checksums", false, "download stored videos to verify their checksums")
+	reconcile.run = func(ctx context.Context, cfg *apiConfig) error {
+		report, err := cfg.reconcile(ctx, *reconcileRepair, *reconcileChecksums)
+		if err != nil {
+			return err
+		}
+		logReconcileReport(report)
+		return printJSON(report)
+	}
+
+	resign := &command{
+		name:  "resign",
+		usage: "print a video with freshly signed URLs, e.g. to replace an expired link: resign [-expiry 24h] <video id>",
+		flags: flag.NewFlagSet("resign", flag.ExitOnError),
+	}
+	resignExpiry := resign.flags.Duration("expiry", 0, "how long the URLs are valid, PRESIGN_EXPIRY if zero")
+	resign.run = func(ctx context.Context, cfg *apiConfig) error {
+		if resign.flags.NArg() != 1 {
+			return errors.New("expected one video ID")
+		}
+		videoID, err := uuid.Parse(resign.flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("invalid video ID: %w", err)
+		}
+		expiry := *resignExpiry
+		if expiry == 0 {
+			expiry = cfg.presignExpiry
+		}
+		if expiry < time.Second {
+			return errors.New("expiry must be at least a second")
+		}
+
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			return fmt.Errorf("couldn't get video: %w", err)
+		}
+		if video.ID == uuid.Nil {
+			return fmt.Errorf("video %s doesn't exist", videoID)
+		}
+		video, err = cfg.dbVideoToSignedVideo(ctx, video, videoExpiry(video, expiry))
+		if err != nil {
+			return fmt.Errorf("couldn't sign video: %w", err)
+		}
+		return printJSON(video)
+	}
+
+	migrate := &command{
+		name:  "migrate",
+		usage: "apply database migrations and bring stored assets up to date, and with -keys move objects to OBJECT_KEY_TEMPLATE",
+		flags: flag.NewFlagSet("migrate", flag.ExitOnError),
+	}
+	migrateKeys := migrate.flags.Bool("keys", false, "move stored objects to the OBJECT_KEY_TEMPLATE layout")
+	// main applies the database migrations for migrate even without
+	// AUTO_MIGRATE, and the stored asset migrations run before any command.
+	migrate.run = func(ctx context.Context, cfg *apiConfig) error {
+		if !*migrateKeys {
+			return nil
+		}
+		return cfg.migrateObjectKeys(ctx)
+	}
+
+	usage := &command{
+		name:  "usage",
+		usage: "print the storage and processing usage of every user",
+		flags: flag.NewFlagSet("usage", flag.ExitOnError),
+	}
+	usage.run = func(ctx context.Context, cfg *apiConfig) error {
+		report, err := cfg.usageReport()
+		if err != nil {
+			return err
+		}
+		return printJSON(report)
+	}
+
+	importVideos := &command{
+		name:  "import",
+		usage: "create videos for the video objects under a prefix: import -user <user id> [-process] <prefix>",
+		flags: flag.NewFlagSet("import", flag.ExitOnError),
+	}
+	importUser := importVideos.flags.String("user", "", "ID of the user imported videos are assigned to")
+	importDryRun := importVideos.flags.Bool("dry-run", false, "only report what would be imported")
+	importProcess := importVideos.flags.Bool("process", false, "queue imported videos to be reprocessed")
+	importVideos.run = func(ctx context.Context, cfg *apiConfig) error {
+		if importVideos.flags.NArg() != 1 {
+			return errors.New("expected one prefix")
+		}
+		userID, err := uuid.Parse(*importUser)
+		if err != nil {
+			return fmt.Errorf("-user must be a user ID: %w", err)
+		}
+		report, err := cfg.importVideos(ctx, importVideos.flags.Arg(0), userID, *importDryRun, *importProcess)
+		logImportReport(report)
+		if err != nil {
+			return err
+		}
+		return printJSON(report)
+	}
+
+	return []*command{serve, gc, reconcile, resign, migrate, usage, importVideos}
+}
+
+// parseCommand picks the command named by the first argument, serve if
+// there's none, and parses its flags.
+func parseCommand(args []string, worker *bool) (*command, error) {
+	all := commands(worker)
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	for _, cmd := range all {
+		if cmd.name == name {
+			return cmd, cmd.flags.Parse(args)
+		}
+	}
+
+	names := make([]string, len(all))
+	for i, cmd := range all {
+		names[i] = cmd.name
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range all {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	return nil, fmt.Errorf("unknown command %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
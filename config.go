@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+)
+
+// getEnvString reads an optional environment variable, falling back to
+// defaultValue when it is unset.
+func getEnvString(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt reads an optional integer environment variable, falling back to
+// defaultValue when it is unset.
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", key, err)
+	}
+	return n
+}
+
+// getEnvInt64 reads an optional 64-bit integer environment variable, falling
+// back to defaultValue when it is unset.
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", key, err)
+	}
+	return n
+}
+
+// getEnvFloat reads an optional floating-point environment variable, falling
+// back to defaultValue when it is unset.
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number: %v", key, err)
+	}
+	return f
+}
+
+// getEnvDuration reads an optional duration environment variable in
+// time.ParseDuration format, falling back to defaultValue when it is unset.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration: %v", key, err)
+	}
+	return d
+}
+
+// getEnvBool reads an optional boolean environment variable, falling back to
+// defaultValue when it is unset.
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be a boolean: %v", key, err)
+	}
+	return b
+}
+
+// getEnvSecret reads an environment variable holding a secret, which can
+// instead refer to one in Secrets Manager or Parameter Store, like
+// "secretsmanager:tubely/jwt" or "ssm:/tubely/jwt-secret".
+func getEnvSecret(ctx context.Context, resolver *secrets.Resolver, key string) (string, error) {
+	value, err := resolver.Resolve(ctx, os.Getenv(key))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return value, nil
+}
+
+// loadJWTKeys reads the keys access tokens are signed and verified with:
+// JWT_SECRET, identified by JWT_KEY_ID, signs new tokens, while the
+// comma-separated "kid:secret" pairs in JWT_PREVIOUS_SECRETS and the
+// "kid:path" pairs of RSA public keys in JWT_PUBLIC_KEYS are only used to
+// verify them. Secrets can be references, as with getEnvSecret.
+func loadJWTKeys(ctx context.Context, resolver *secrets.Resolver) (*auth.Keys, error) {
+	secret, err := getEnvSecret(ctx, resolver, "JWT_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET environment variable is not set")
+	}
+	keys := auth.NewKeys(os.Getenv("JWT_KEY_ID"), secret)
+	keys.Issuer = getEnvString("JWT_ISSUER", string(auth.TokenTypeAccess))
+	keys.Audience = os.Getenv("JWT_AUDIENCE")
+
+	err = forEachKeyPair("JWT_PREVIOUS_SECRETS", func(keyID, secret string) error {
+		secret, err := resolver.Resolve(ctx, secret)
+		if err != nil {
+			return err
+		}
+		return keys.AddSecret(keyID, secret)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = forEachKeyPair("JWT_PUBLIC_KEYS", keys.AddPublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// forEachKeyPair calls add with each of the comma-separated "kid:value"
+// pairs in the environment variable key.
+func forEachKeyPair(key string, add func(keyID, value string) error) error {
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		keyID, value, ok := strings.Cut(entry, ":")
+		if !ok || value == "" {
+			return fmt.Errorf("%s must be a comma-separated list of kid:value pairs", key)
+		}
+		err := add(keyID, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// loadDatabaseDSN reads the database to connect to: the Postgres URL in
+// DATABASE_URL, or the SQLite file at DB_PATH. DATABASE_PASSWORD, if set,
+// replaces the password in DATABASE_URL, so it can come from a secret of its
+// own, like the one RDS manages and rotates. Both can be references, as with
+// getEnvSecret.
+func loadDatabaseDSN(ctx context.Context, resolver *secrets.Resolver) (string, error) {
+	dsn, err := getEnvSecret(ctx, resolver, "DATABASE_URL")
+	if err != nil {
+		return "", err
+	}
+	if dsn == "" {
+		dsn = os.Getenv("DB_PATH")
+	}
+	if dsn == "" {
+		return "", errors.New("DATABASE_URL or DB_PATH must be set")
+	}
+
+	password, err := getEnvSecret(ctx, resolver, "DATABASE_PASSWORD")
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return dsn, nil
+	}
+	// The URL isn't part of the error, since it may have a password in it.
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "", errors.New("DATABASE_PASSWORD needs DATABASE_URL to be a Postgres URL")
+	}
+	u.User = url.UserPassword(u.User.Username(), password)
+	return u.String(), nil
+}
+
+// loadCloudFrontKey reads the CloudFront key pair URLs are signed with: its
+// ID in CLOUDFRONT_KEY_ID, and its PEM-encoded private key, either in
+// CLOUDFRONT_PRIVATE_KEY or in the file at CLOUDFRONT_PRIVATE_KEY_PATH. The
+// ID and the key can be references, as with getEnvSecret.
+func loadCloudFrontKey(ctx context.Context, resolver *secrets.Resolver) (string, []byte, error) {
+	keyID, err := getEnvSecret(ctx, resolver, "CLOUDFRONT_KEY_ID")
+	if err != nil {
+		return "", nil, err
+	}
+	if keyID == "" {
+		return "", nil, errors.New("CLOUDFRONT_KEY_ID environment variable is not set")
+	}
+
+	privateKey, err := getEnvSecret(ctx, resolver, "CLOUDFRONT_PRIVATE_KEY")
+	if err != nil {
+		return "", nil, err
+	}
+	if privateKey != "" {
+		return keyID, []byte(privateKey), nil
+	}
+	privateKeyPath := os.Getenv("CLOUDFRONT_PRIVATE_KEY_PATH")
+	if privateKeyPath == "" {
+		return "", nil, errors.New("CLOUDFRONT_PRIVATE_KEY or CLOUDFRONT_PRIVATE_KEY_PATH must be set")
+	}
+	dat, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't read CloudFront private key: %w", err)
+	}
+	return keyID, dat, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+)
+
+// middlewareDiskSpace turns an upload away before reading it when the temp
+// or assets directory doesn't have room for it. Processing keeps the upload
+// and its intermediate outputs in the temp directory, so that needs several
+// times the upload's declared size.
+func (cfg *apiConfig) middlewareDiskSpace(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := max(r.ContentLength, 0)
+
+		dirs := map[string]int64{
+			os.TempDir(): int64(float64(size) * cfg.processingSpaceFactor),
+		}
+		if cfg.videoStorageName == "local" || cfg.thumbnailStorageName == "local" {
+			// Stored assets only need room for the finished file.
+			dirs[cfg.assetsRoot] = max(dirs[cfg.assetsRoot], size)
+		}
+
+		for dir, required := range dirs {
+			free, err := freeDiskSpace(dir)
+			if err != nil {
+				log.Printf("Couldn't check free space in %s: %v", dir, err)
+				continue
+			}
+			if free < required+cfg.minFreeDiskSpace {
+				log.Printf("Rejecting upload of %d bytes, %s has only %d bytes free", size, dir, free)
+				respondWithErrorCode(w, http.StatusInsufficientStorage, errCodeInsufficientStorage, "Not enough disk space to accept the upload", nil, nil)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// videoEncoder describes how ffmpeg encodes H.264 with a given encoder.
+type videoEncoder struct {
+	name string
+	// inputArgs go before the first input, e.g. to open a device.
+	inputArgs []string
+	// options tune the encoder and apply to every video stream.
+	options []string
+	// uploadFilter moves frames to the device, for encoders that can't take
+	// them from system memory. It ends every video filter chain.
+	uploadFilter string
+}
+
+var softwareEncoder = videoEncoder{
+	name:    "libx264",
+	options: []string{"-preset", "veryfast"},
+}
+
+// h264Encoder encodes transcodes, HLS ladders and renditions. It's replaced
+// in main with the detected encoder.
+var h264Encoder = softwareEncoder
+
+// hardwareEncoderNames are the hardware encoders that can be configured, in
+// the order they're tried when detecting one.
+var hardwareEncoderNames = []string{"nvenc", "vaapi", "videotoolbox"}
+
+func hardwareEncoder(name, vaapiDevice string) (videoEncoder, bool) {
+	switch name {
+	case "nvenc":
+		return videoEncoder{name: "h264_nvenc", options: []string{"-preset", "fast"}}, true
+	case "vaapi":
+		return videoEncoder{
+			name:         "h264_vaapi",
+			inputArgs:    []string{"-vaapi_device", vaapiDevice},
+			uploadFilter: "format=nv12,hwupload",
+		}, true
+	case "videotoolbox":
+		return videoEncoder{name: "h264_videotoolbox"}, true
+	}
+	return videoEncoder{}, false
+}
+
+// filter ends a video filter chain with the upload filter of the encoder, if
+// it needs one.
+func (e videoEncoder) filter(chain string) string {
+	if e.uploadFilter == "" {
+		return chain
+	}
+	if chain == "" {
+		return e.uploadFilter
+	}
+	return chain + "," + e.uploadFilter
+}
+
+// detectVideoEncoder picks the H.264 encoder for preference, which is
+// "software", "auto" or the name of a hardware encoder. Hardware encoders are
+// only used if a test encode succeeds, since ffmpeg lists the ones it was
+// built with regardless of whether the host has the hardware. Otherwise
+// encoding falls back to software.
+func detectVideoEncoder(ctx context.Context, preference, vaapiDevice string) (videoEncoder, error) {
+	if preference == "" || preference == "software" {
+		return softwareEncoder, nil
+	}
+
+	names := hardwareEncoderNames
+	if preference != "auto" {
+		names = []string{preference}
+	}
+
+	for _, name := range names {
+		encoder, ok := hardwareEncoder(name, vaapiDevice)
+		if !ok {
+			return softwareEncoder, fmt.Errorf("unknown video encoder %q", name)
+		}
+		err := testVideoEncoder(ctx, encoder)
+		if err != nil {
+			log.Printf("Video encoder %s isn't available: %v", encoder.name, err)
+			continue
+		}
+		return encoder, nil
+	}
+	return softwareEncoder, nil
+}
+
+// testVideoEncoder encodes a single generated frame with encoder.
+func testVideoEncoder(ctx context.Context, encoder videoEncoder) error {
+	args := append([]string{"-hide_banner", "-v", "error"}, encoder.inputArgs...)
+	args = append(args, "-f", "lavfi", "-i", "testsrc=size=256x144:rate=1", "-frames:v", "1")
+	if filter := encoder.filter(""); filter != "" {
+		args = append(args, "-vf", filter)
+	}
+	args = append(args, "-c:v", encoder.name)
+	args = append(args, encoder.options...)
+	args = append(args, "-f", "null", "-")
+
+	cmd := exec.CommandContext(ctx, ffmpegBinary, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// encodeWithFallback runs the ffmpeg command build returns for the
+// configured encoder. If a hardware encoder fails, e.g. because the device
+// is busy or can't handle the input's resolution, the encode is retried in
+// software.
+func encodeWithFallback(ctx context.Context, build func(encoder videoEncoder) *exec.Cmd) error {
+	err := runLimited(ctx, build(h264Encoder))
+	if err == nil || h264Encoder.name == softwareEncoder.name || ctx.Err() != nil {
+		return err
+	}
+	log.Printf("Encoding with %s failed, retrying with %s: %v", h264Encoder.name, softwareEncoder.name, err)
+	return runLimited(ctx, build(softwareEncoder))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// errorCode identifies an error in responses, so clients can tell errors
+// apart without parsing messages, which may change. Codes are never renamed
+// once released.
+//
+//openapi:schema string
+type errorCode string
+
+// Codes for errors that share a status with others clients may want to
+// handle differently. Any other error gets the code of its status.
+const (
+	errCodeVideoTooLarge         errorCode = "VIDEO_TOO_LARGE"
+	errCodeThumbnailTooLarge     errorCode = "THUMBNAIL_TOO_LARGE"
+	errCodeCaptionTooLarge       errorCode = "CAPTION_TOO_LARGE"
+	errCodeInvalidMediaType      errorCode = "INVALID_MEDIA_TYPE"
+	errCodeContentMismatch       errorCode = "CONTENT_TYPE_MISMATCH"
+	errCodeChecksumMismatch      errorCode = "CHECKSUM_MISMATCH"
+	errCodeValidationFailed      errorCode = "VIDEO_VALIDATION_FAILED"
+	errCodeVideoNotProcessed     errorCode = "VIDEO_NOT_PROCESSED"
+	errCodeVideoProcessing       errorCode = "VIDEO_PROCESSING"
+	errCodeVideoArchived         errorCode = "VIDEO_ARCHIVED"
+	errCodeIdempotencyKeyReused  errorCode = "IDEMPOTENCY_KEY_REUSED"
+	errCodeIdempotencyInProgress errorCode = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	errCodeRateLimited           errorCode = "RATE_LIMITED"
+	errCodeInsufficientStorage   errorCode = "INSUFFICIENT_STORAGE"
+	errCodeUploadInfected        errorCode = "UPLOAD_INFECTED"
+)
+
+// statusErrorCodes are the codes of errors without a code of their own.
+var statusErrorCodes = map[int]errorCode{
+	http.StatusBadRequest:          "BAD_REQUEST",
+	http.StatusUnauthorized:        "UNAUTHORIZED",
+	http.StatusForbidden:           "FORBIDDEN",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusConflict:            "CONFLICT",
+	http.StatusGone:                "GONE",
+	http.StatusUnprocessableEntity: "UNPROCESSABLE",
+	http.StatusTooManyRequests:     errCodeRateLimited,
+	http.StatusInternalServerError: "INTERNAL_ERROR",
+	http.StatusNotImplemented:      "NOT_IMPLEMENTED",
+	http.StatusBadGateway:          "BAD_GATEWAY",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+	http.StatusInsufficientStorage: errCodeInsufficientStorage,
+}
+
+// statusErrorCode returns the code of an error with the given status,
+// falling back to its status text, e.g. METHOD_NOT_ALLOWED.
+func statusErrorCode(status int) errorCode {
+	if code, ok := statusErrorCodes[status]; ok {
+		return code
+	}
+	text := http.StatusText(status)
+	if text == "" {
+		return "ERROR"
+	}
+	return errorCode(strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return '_'
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, text))
+}
+
+const (
+	requestIDHeader = "X-Request-Id"
+	// maxRequestIDLength bounds request IDs taken from clients or proxies,
+	// since they end up in logs and responses.
+	maxRequestIDLength = 128
+)
+
+// requestIDMiddleware gives every request an ID, which is returned in the
+// X-Request-Id header and error responses and logged along with errors, so a
+// failed request a client reports can be found in the logs. An ID set by the
+// client or a proxy in front of the server is kept.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
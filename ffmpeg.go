@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/proclimit"
+)
+
+// ffmpegBinary and ffprobeBinary are the commands run to process media.
+// They're replaced in main with the configured paths.
+var (
+	ffmpegBinary  = "ffmpeg"
+	ffprobeBinary = "ffprobe"
+)
+
+// ffmpegMinVersion is the oldest release of ffmpeg and ffprobe known to
+// support every option the processing pipeline passes.
+const ffmpegMinVersion = "4.2"
+
+var ffmpegVersionPattern = regexp.MustCompile(`version n?(\d+)\.(\d+)`)
+
+// checkFFmpegBinary verifies that binary can be run and is at least
+// minVersion, returning the version line it reports. Development builds, which
+// are versioned by commit rather than release, are accepted as is.
+func checkFFmpegBinary(ctx context.Context, binary, minVersion string) (string, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("couldn't run %s -version: %w", path, err)
+	}
+	version, _, _ := strings.Cut(string(out), "\n")
+	version = strings.TrimSpace(version)
+
+	match := ffmpegVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return version, nil
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	minMajor, minMinor, _ := strings.Cut(minVersion, ".")
+	wantMajor, _ := strconv.Atoi(minMajor)
+	wantMinor, _ := strconv.Atoi(minMinor)
+	if major < wantMajor || (major == wantMajor && minor < wantMinor) {
+		return version, fmt.Errorf("%s is older than the required version %s: %s", path, minVersion, version)
+	}
+	return version, nil
+}
+
+// ffmpegLimiter bounds the number of concurrent ffmpeg and ffprobe
+// processes. It's replaced in main with the configured limits.
+var ffmpegLimiter = proclimit.New(runtime.NumCPU(), 5*time.Minute)
+
+// runLimited runs cmd once the limiter has a free slot. cmd should be created
+// with exec.CommandContext from ctx so it's killed when ctx is cancelled.
+func runLimited(ctx context.Context, cmd *exec.Cmd) error {
+	release, err := ffmpegLimiter.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't start %s: %w", cmd.Path, err)
+	}
+	defer release()
+
+	start := time.Now()
+	err = cmd.Run()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.FFmpegDurationSeconds.
+		WithLabelValues(filepath.Base(cmd.Path), result).
+		Observe(time.Since(start).Seconds())
+	return err
+}
+
+// ffmpegProgress parses the key=value lines ffmpeg writes to stdout with
+// "-progress pipe:1" and reports the percentage of a media of the given duration processed so far.
+type ffmpegProgress struct {
+	durationSeconds float64
+	report          func(percent float64)
+	buf             []byte
+}
+
+func (p *ffmpegProgress) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.parseLine(string(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *ffmpegProgress) parseLine(line string) {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+	if !ok {
+		return
+	}
+	switch key {
+	// out_time_ms is in microseconds as well, despite its name. Older
+	// versions of ffmpeg only write that one.
+	case "out_time_us", "out_time_ms":
+		if p.durationSeconds <= 0 {
+			return
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || us < 0 {
+			return
+		}
+		p.report(min(float64(us)/1e6/p.durationSeconds*100, 100))
+	case "progress":
+		if value == "end" {
+			p.report(100)
+		}
+	}
+}
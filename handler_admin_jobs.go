@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+// handlerAdminDeadJobsRetrieve lists the jobs in the dead-letter state, the
+// ones that ran out of attempts, most recent first.
+//
+//openapi:summary List dead jobs
+//openapi:tags admin
+//openapi:response 200 []database.Job
+func (cfg *apiConfig) handlerAdminDeadJobsRetrieve(w http.ResponseWriter, r *http.Request) {
+	dead, err := cfg.db.GetJobsByStatus(database.JobStatusDead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve jobs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, dead)
+}
+
+// handlerAdminJobRequeue gives a dead job a fresh set of attempts, e.g. once
+// whatever made it fail has been fixed.
+//
+//openapi:summary Requeue a dead job
+//openapi:tags admin
+//openapi:response 200 database.Job
+func (cfg *apiConfig) handlerAdminJobRequeue(w http.ResponseWriter, r *http.Request) {
+	job, ok := cfg.getDeadJob(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := cfg.jobs.Requeue(job.ID)
+	if errors.Is(err, jobs.ErrNotDead) {
+		respondWithError(w, http.StatusConflict, "Job isn't dead", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't requeue job", err)
+		return
+	}
+
+	if job.Type == jobTypeProcessVideo || job.Type == jobTypeFetchVideo {
+		cfg.markVideoRequeued(job)
+	}
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// markVideoRequeued puts the video of a requeued processing or fetch job
+// back in the pending state, like a newly queued one.
+func (cfg *apiConfig) markVideoRequeued(job database.Job) {
+	var payload processVideoPayload
+	err := json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		log.Printf("Couldn't decode payload of job %s: %v", job.ID, err)
+		return
+	}
+
+	err = cfg.db.UpdateVideoProcessingStatus(payload.VideoID, database.ProcessingStatusPending, nil)
+	if err != nil {
+		log.Printf("Couldn't mark video %s as pending: %v", payload.VideoID, err)
+		return
+	}
+	cfg.progress.Publish(payload.VideoID, progress.Update{Stage: progress.StageQueued})
+}
+
+// handlerAdminJobDiscard deletes a dead job that won't be requeued, along
+// with the upload a processing job kept for it.
+//
+//openapi:summary Discard a dead job
+//openapi:tags admin
+//openapi:response 204
+func (cfg *apiConfig) handlerAdminJobDiscard(w http.ResponseWriter, r *http.Request) {
+	job, ok := cfg.getDeadJob(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.db.DeleteDeadJob(job.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete job", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusConflict, "Job isn't dead", nil)
+		return
+	}
+
+	if job.Type == jobTypeProcessVideo {
+		var payload processVideoPayload
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			log.Printf("Couldn't decode payload of job %s: %v", job.ID, err)
+		} else {
+			cfg.cleanupProcessVideoSource(payload)
+		}
+	}
+	log.Printf("Discarded dead job %s (%s)", job.ID, job.Type)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeadJob loads the job named by the request path and checks that it's
+// dead, responding with an error if it isn't.
+func (cfg *apiConfig) getDeadJob(w http.ResponseWriter, r *http.Request) (database.Job, bool) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Job{}, false
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return database.Job{}, false
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get job", nil)
+		return database.Job{}, false
+	}
+	if job.Status != database.JobStatusDead {
+		respondWithError(w, http.StatusConflict, "Job isn't dead", nil)
+		return database.Job{}, false
+	}
+	return job, true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerAdminModerationRetrieve lists the videos with a moderation status,
+// by default those flagged for review, oldest first. Their URLs are signed
+// so admins can watch them before deciding.
+//
+//openapi:summary List videos by moderation status
+//openapi:tags admin
+//openapi:query status string pending, pending_review, approved or rejected; defaults to pending_review
+//openapi:response 200 []database.Video
+func (cfg *apiConfig) handlerAdminModerationRetrieve(w http.ResponseWriter, r *http.Request) {
+	status := database.ModerationStatusReview
+	if value := r.URL.Query().Get("status"); value != "" {
+		status = database.ModerationStatus(value)
+		if status == database.ModerationStatusNone || !status.Valid() {
+			respondWithError(w, http.StatusBadRequest, "status must be pending, pending_review, approved or rejected", nil)
+			return
+		}
+	}
+
+	videos, err := cfg.db.GetVideosByModerationStatus(status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	for i := range videos {
+		videos[i], err = cfg.dbVideoToSignedVideo(r.Context(), videos[i], cfg.presignExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerAdminVideoModerationUpdate approves or rejects a video, usually one
+// flagged for review. Approved videos are visible like any other; rejected
+// ones stay visible only to their owner. The labels found by the scan are
+// kept either way.
+//
+//openapi:summary Approve or reject a video
+//openapi:tags admin
+//openapi:body parameters
+//openapi:response 200 database.Video
+func (cfg *apiConfig) handlerAdminVideoModerationUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Status database.ModerationStatus `json:"status"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	event := eventModerationApproved
+	switch params.Status {
+	case database.ModerationStatusApproved:
+	case database.ModerationStatusRejected:
+		event = eventModerationRejected
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be approved or rejected", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	err = cfg.db.UpdateVideoModeration(video.ID, params.Status, video.ModerationLabels)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.ModerationStatus = params.Status
+	cfg.publishEvent(video.UserID, event, video)
+
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// adminUser is a user as the admin endpoints return it, without their
+// password hash.
+type adminUser struct {
+	ID              uuid.UUID `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Email           string    `json:"email"`
+	Role            string    `json:"role"`
+	WatermarkOptOut bool      `json:"watermark_opt_out"`
+}
+
+func newAdminUser(user database.User) adminUser {
+	return adminUser{
+		ID:              user.ID,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		Email:           user.Email,
+		Role:            user.Role,
+		WatermarkOptOut: user.WatermarkOptOut,
+	}
+}
+
+//openapi:summary List users
+//openapi:tags admin
+//openapi:response 200 []adminUser
+func (cfg *apiConfig) handlerAdminUsersRetrieve(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+
+	resp := make([]adminUser, len(users))
+	for i, user := range users {
+		resp[i] = newAdminUser(user)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+//openapi:summary Change the role of a user
+//openapi:tags admin
+//openapi:body parameters
+//openapi:response 200 adminUser
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userIDString := r.PathValue("userID")
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Role must be admin, uploader or viewer", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.UpdateUserRole(userID, string(role))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	user.Role = string(role)
+
+	respondWithJSON(w, http.StatusOK, newAdminUser(*user))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+//openapi:summary Extract the audio of a video
+//openapi:tags videos
+//openapi:query format string m4a (the default) or mp3
+//openapi:response 200 response
+func (cfg *apiConfig) handlerAudioExtract(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL       string    `json:"url"`
+		Format    string    `json:"format"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "m4a"
+	}
+	format, ok := audioExportFormats[formatName]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "format must be one of m4a, mp3", nil)
+		return
+	}
+	if video.Metadata != nil && video.Metadata.AudioCodec == "" {
+		respondWithError(w, http.StatusUnprocessableEntity, "Video has no audio track", nil)
+		return
+	}
+
+	audioPath, err := cfg.extractStoredAudio(r.Context(), video, format)
+	if errors.Is(err, errVideoNotProcessed) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoNotProcessed, "Video hasn't been processed yet", nil, err)
+		return
+	}
+	if errors.Is(err, errVideoArchived) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Video is archived and has to be restored first", nil, err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract audio", err)
+		return
+	}
+	defer os.Remove(audioPath)
+
+	file, err := os.Open(audioPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open extracted audio", err)
+		return
+	}
+	defer file.Close()
+
+	key := cfg.audioKey(video, format)
+	err = cfg.videoStorage.Put(withVideoTags(r.Context(), video), key, file, format.mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload audio", err)
+		return
+	}
+
+	audioURL, err := cfg.signedURL(r.Context(), cfg.videoStorage, key, cfg.presignExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign audio URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:       audioURL,
+		Format:    formatName,
+		ExpiresAt: time.Now().UTC().Add(cfg.presignExpiry),
+	})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxCaptionSize = 1 << 20
+
+//openapi:summary Upload captions
+//openapi:tags captions
+//openapi:form language string Language tag, such as en or pt-BR
+//openapi:form label string Label shown in players
+//openapi:form captions file WebVTT or SRT file
+//openapi:response 201 database.Caption
+func (cfg *apiConfig) handlerCaptionUpload(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionSize)
+	err := r.ParseMultipartForm(maxCaptionSize)
+	if err != nil {
+		if respondWithTooLarge(w, err, errCodeCaptionTooLarge) {
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse multipart form", err)
+		return
+	}
+
+	language := r.FormValue("language")
+	if !captions.ValidLanguage(language) {
+		respondWithError(w, http.StatusBadRequest, "language must be a language tag such as en or pt-BR", nil)
+		return
+	}
+	label := r.FormValue("label")
+	if label == "" {
+		label = language
+	}
+
+	file, _, err := r.FormFile("captions")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	vtt, ok := readCaptionUpload(w, file)
+	if !ok {
+		return
+	}
+	if !cfg.scanUploadedFile(r.Context(), w, video.ID, "captions", bytes.NewReader(vtt)) {
+		return
+	}
+
+	caption, err := cfg.storeCaption(withVideoTags(r.Context(), video), video, language, label, database.CaptionSourceUpload, vtt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, caption)
+}
+
+// readCaptionUpload reads an uploaded WebVTT or SRT file as WebVTT,
+// responding with the reason it was rejected if it couldn't be.
+func readCaptionUpload(w http.ResponseWriter, file io.Reader) ([]byte, bool) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read caption file", err)
+		return nil, false
+	}
+	vtt, err := captions.ToWebVTT(data)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid caption file: %v", err), err)
+		return nil, false
+	}
+	return vtt, true
+}
+
+//openapi:summary List the captions of a video
+//openapi:tags captions
+//openapi:auth optional
+//openapi:response 200 []database.Caption
+func (cfg *apiConfig) handlerCaptionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !canViewVideo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	tracks, err := cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+	err = cfg.signCaptions(r.Context(), tracks, videoExpiry(video, cfg.presignExpiry))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign caption URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tracks)
+}
+
+//openapi:summary Delete captions
+//openapi:tags captions
+//openapi:auth bearer
+//openapi:response 204
+func (cfg *apiConfig) handlerCaptionDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	caption, err := cfg.db.GetCaption(video.ID, r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get captions", err)
+		return
+	}
+	if caption == nil {
+		respondWithError(w, http.StatusNotFound, "Captions not found", nil)
+		return
+	}
+
+	err = cfg.videoStorage.Delete(r.Context(), caption.StorageKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete caption file", err)
+		return
+	}
+	err = cfg.db.DeleteCaption(caption.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete captions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storeCaption stores a WebVTT track as the video's captions in language,
+// replacing any previous track in that language. Each upload gets a new key
+// so cached copies of a replaced track aren't served.
+func (cfg *apiConfig) storeCaption(ctx context.Context, video database.Video, language, label string, source database.CaptionSource, vtt []byte) (database.Caption, error) {
+	existing, err := cfg.db.GetCaption(video.ID, language)
+	if err != nil {
+		return database.Caption{}, fmt.Errorf("couldn't get existing captions: %w", err)
+	}
+
+	key := path.Join(cfg.captionsPrefix(video), fmt.Sprintf("%s-%s.vtt", language, uuid.New()))
+	err = cfg.videoStorage.Put(ctx, key, bytes.NewReader(vtt), "text/vtt")
+	if err != nil {
+		return database.Caption{}, fmt.Errorf("couldn't upload captions: %w", err)
+	}
+
+	caption, err := cfg.db.UpsertCaption(database.CreateCaptionParams{
+		VideoID:    video.ID,
+		Language:   language,
+		Label:      label,
+		URL:        cfg.videoStorage.URL(key),
+		StorageKey: key,
+		Source:     source,
+	})
+	if err != nil {
+		cfg.videoStorage.Delete(context.Background(), key)
+		return database.Caption{}, fmt.Errorf("couldn't save captions: %w", err)
+	}
+
+	if existing != nil {
+		err := cfg.videoStorage.Delete(ctx, existing.StorageKey)
+		if err != nil {
+			log.Printf("Couldn't delete replaced captions %s: %v", existing.StorageKey, err)
+		}
+	}
+	return caption, nil
+}
+
+// attachCaptions loads the caption tracks of videos for an API response.
+func (cfg *apiConfig) attachCaptions(videos []database.Video) error {
+	ids := make([]uuid.UUID, len(videos))
+	for i, video := range videos {
+		ids[i] = video.ID
+	}
+	tracks, err := cfg.db.GetCaptionsForVideos(ids)
+	if err != nil {
+		return err
+	}
+	for i := range videos {
+		videos[i].Captions = tracks[videos[i].ID]
+	}
+	return nil
+}
+
+// signCaptions rewrites caption URLs into signed URLs valid for expiry,
+// unless they're handed out unsigned.
+func (cfg *apiConfig) signCaptions(ctx context.Context, tracks []database.Caption, expiry time.Duration) error {
+	if !cfg.signsURLsIn(cfg.videoStorage) {
+		return nil
+	}
+	for i, track := range tracks {
+		signedURL, err := cfg.signedURL(ctx, cfg.videoStorage, track.StorageKey, expiry)
+		if err != nil {
+			return err
+		}
+		tracks[i].URL = signedURL
+	}
+	return nil
+}
+
+func (cfg *apiConfig) captionsPrefix(video database.Video) string {
+	return cfg.objectKey(video.UserID, aspectCaptions, video.ID.String())
+}
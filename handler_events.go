@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/google/uuid"
+)
+
+// handlerEvents streams the events of the user's videos as server-sent
+// events, so dashboards can update as videos finish processing, fail or are
+// deleted instead of polling their status. Events are the same as those sent
+// to webhooks, named by their type and with their ID as the event ID. A
+// stream that falls too far behind is closed, and clients should reload the
+// videos they show when they reconnect.
+//
+// Remote workers publish events on their own instance, so when jobs run in
+// them the stream polls the user's videos instead and sends upload.completed
+// and processing.failed when it sees processing finish.
+//
+//openapi:summary Stream the events of the user's videos
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 text/event-stream
+func (cfg *apiConfig) handlerEvents(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	feed, cancel := cfg.events.Subscribe(userID)
+	defer cancel()
+
+	var (
+		statusPoll <-chan time.Time
+		watcher    *videoStatusWatcher
+	)
+	if cfg.remoteWorkers {
+		watcher, err = cfg.newVideoStatusWatcher(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+			return
+		}
+		ticker := time.NewTicker(progressStatusPoll)
+		defer ticker.Stop()
+		statusPoll = ticker.C
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	err = rc.Flush()
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(progressHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case <-statusPoll:
+			for _, event := range watcher.poll() {
+				err = writeEvent(w, event)
+				if err != nil {
+					break
+				}
+			}
+		case event, ok := <-feed:
+			if !ok {
+				return
+			}
+			err = writeEvent(w, event)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes an event as a server-sent event.
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
+
+// videoStatusWatcher polls a user's videos for processing that finished,
+// for event streams when remote workers process the videos.
+type videoStatusWatcher struct {
+	cfg    *apiConfig
+	userID uuid.UUID
+	opened time.Time
+	since  time.Time
+	// processing records whether the videos seen so far were still being
+	// processed when last polled.
+	processing map[uuid.UUID]bool
+}
+
+func (cfg *apiConfig) newVideoStatusWatcher(userID uuid.UUID) (*videoStatusWatcher, error) {
+	// Timestamps are stored to the second, so polls overlap by up to one.
+	now := time.Now().Truncate(time.Second)
+	videos, err := cfg.db.GetUserVideosByProcessingStatus(userID, database.ProcessingStatusPending, database.ProcessingStatusProcessing)
+	if err != nil {
+		return nil, err
+	}
+	watcher := &videoStatusWatcher{
+		cfg:        cfg,
+		userID:     userID,
+		opened:     now,
+		since:      now,
+		processing: map[uuid.UUID]bool{},
+	}
+	for _, video := range videos {
+		watcher.processing[video.ID] = true
+	}
+	return watcher, nil
+}
+
+// poll returns the events of the videos that finished processing since the
+// last poll. Videos created since the watcher was opened count as being
+// processed until they're seen otherwise.
+func (sw *videoStatusWatcher) poll() []events.Event {
+	now := time.Now().Truncate(time.Second)
+	videos, err := sw.cfg.db.GetUserVideosUpdatedSince(sw.userID, sw.since)
+	if err != nil {
+		log.Printf("Couldn't check status of videos of user %s: %v", sw.userID, err)
+		return nil
+	}
+	sw.since = now
+
+	var out []events.Event
+	for _, video := range videos {
+		processing, seen := sw.processing[video.ID]
+		if !seen {
+			processing = !video.CreatedAt.Before(sw.opened)
+		}
+		switch video.ProcessingStatus {
+		case database.ProcessingStatusPending, database.ProcessingStatusProcessing:
+			sw.processing[video.ID] = true
+			continue
+		case database.ProcessingStatusNone:
+			continue
+		}
+		sw.processing[video.ID] = false
+		if !processing {
+			continue
+		}
+
+		eventType := eventUploadCompleted
+		if video.ProcessingStatus == database.ProcessingStatusFailed || video.ProcessingError != nil {
+			eventType = eventProcessingFailed
+		}
+		event, err := newEvent(eventType, video)
+		if err != nil {
+			log.Printf("Couldn't encode %s event: %v", eventType, err)
+			continue
+		}
+		out = append(out, event)
+	}
+	return out
+}
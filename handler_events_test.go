@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// With remote workers, event streams report processing that finished by
+// polling the user's videos, once per video and only for actual transitions.
+func TestVideoStatusWatcher(t *testing.T) {
+	cfg, userID := newRefreshTestConfig(t)
+
+	ready, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "ready", UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	err = cfg.db.UpdateVideoProcessingStatus(ready.ID, database.ProcessingStatusReady, nil)
+	if err != nil {
+		t.Fatalf("UpdateVideoProcessingStatus: %v", err)
+	}
+	processing, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "processing", UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	err = cfg.db.UpdateVideoProcessingStatus(processing.ID, database.ProcessingStatusProcessing, nil)
+	if err != nil {
+		t.Fatalf("UpdateVideoProcessingStatus: %v", err)
+	}
+	// Videos created since the watcher opened count as being processed, and
+	// timestamps are stored to the second.
+	time.Sleep(time.Second)
+
+	watcher, err := cfg.newVideoStatusWatcher(userID)
+	if err != nil {
+		t.Fatalf("newVideoStatusWatcher: %v", err)
+	}
+	err = cfg.db.UpdateVideoProcessingStatus(ready.ID, database.ProcessingStatusReady, nil)
+	if err != nil {
+		t.Fatalf("UpdateVideoProcessingStatus: %v", err)
+	}
+	msg := "transcoding failed"
+	err = cfg.db.UpdateVideoProcessingStatus(processing.ID, database.ProcessingStatusFailed, &msg)
+	if err != nil {
+		t.Fatalf("UpdateVideoProcessingStatus: %v", err)
+	}
+
+	got := watcher.poll()
+	if len(got) != 1 || got[0].Type != eventProcessingFailed {
+		t.Fatalf("first poll: got %+v, want one %s event", got, eventProcessingFailed)
+	}
+	var event struct {
+		Data database.Video `json:"data"`
+	}
+	err = json.Unmarshal(got[0].Data, &event)
+	if err != nil {
+		t.Fatalf("decoding event: %v", err)
+	}
+	if event.Data.ID != processing.ID {
+		t.Errorf("event is for video %s, want %s", event.Data.ID, processing.ID)
+	}
+
+	if got := watcher.poll(); len(got) != 0 {
+		t.Errorf("second poll: got %+v, want no events", got)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+)
+
+// progressHeartbeat is how often an idle progress stream sends a comment to
+// keep proxies from closing the connection.
+const progressHeartbeat = 15 * time.Second
+
+// progressStatusPoll is how often a progress stream checks the video's status
+// when jobs run in remote workers, whose updates don't reach the API.
+const progressStatusPoll = 2 * time.Second
+
+// handlerVideoProgress streams the upload and processing progress of a video
+// as server-sent events until it's ready or has failed.
+//
+//openapi:summary Stream the upload and processing progress of a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 text/event-stream
+func (cfg *apiConfig) handlerVideoProgress(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	updates, cancel := cfg.progress.Subscribe(video.ID)
+	defer cancel()
+
+	current, ok := cfg.progress.Last(video.ID)
+	if !ok {
+		current = progressFromStatus(video)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	heartbeat := time.NewTicker(progressHeartbeat)
+	defer heartbeat.Stop()
+
+	var statusPoll <-chan time.Time
+	if cfg.remoteWorkers {
+		ticker := time.NewTicker(progressStatusPoll)
+		defer ticker.Stop()
+		statusPoll = ticker.C
+	}
+
+	update := current
+	var sent progress.Stage
+	for {
+		if update.Stage != "" {
+			sent = update.Stage
+			err := writeProgressEvent(w, update)
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				log.Printf("Couldn't stream progress of video %s: %v", video.ID, err)
+				return
+			}
+			if update.Stage.Done() {
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+			update = progress.Update{}
+		case <-statusPoll:
+			update = progress.Update{}
+			latest, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				log.Printf("Couldn't check status of video %s: %v", video.ID, err)
+				continue
+			}
+			if next := progressFromStatus(latest); next.Stage != sent {
+				update = next
+			}
+		case next, ok := <-updates:
+			if !ok {
+				return
+			}
+			update = next
+		}
+	}
+}
+
+func writeProgressEvent(w http.ResponseWriter, update progress.Update) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+	return err
+}
+
+// progressFromStatus describes a video that no progress has been reported
+// for since the server started, e.g. because it was processed before.
+func progressFromStatus(video database.Video) progress.Update {
+	switch video.ProcessingStatus {
+	case database.ProcessingStatusPending:
+		return progress.Update{Stage: progress.StageQueued}
+	case database.ProcessingStatusProcessing:
+		return progress.Update{Stage: progress.StageProcessing}
+	case database.ProcessingStatusReady:
+		return progress.Update{Stage: progress.StageReady, Percent: 100}
+	case database.ProcessingStatusFailed:
+		update := progress.Update{Stage: progress.StageFailed}
+		if video.ProcessingError != nil {
+			update.Error = *video.ProcessingError
+		}
+		return update
+	}
+	return progress.Update{}
+}
@@ -1,15 +1,28 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+// handlerRefresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token is single use: presenting one that was
+// already rotated means it leaked, so all of the user's sessions and access
+// tokens are revoked. Tokens revoked otherwise, such as by logging out, are
+// just refused.
+//
+//openapi:summary Refresh an access token
+//openapi:tags auth
+//openapi:auth bearer
+//openapi:response 200 response
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,27 +31,94 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	stored, err := cfg.db.GetRefreshToken(refreshToken)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if stored.Token == "" || time.Now().After(stored.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token", nil)
+		return
+	}
+	if stored.RevokedAt != nil {
+		cfg.refuseRevokedRefreshToken(w, stored)
+		return
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+
+	err = cfg.db.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		Token:     newRefreshToken,
+		UserID:    stored.UserID,
+		ExpiresAt: time.Now().UTC().Add(auth.RefreshTokenExpiry),
+	})
+	if errors.Is(err, database.ErrRefreshTokenRevoked) {
+		// It was revoked since it was read, by a parallel refresh or a
+		// logout.
+		stored, err = cfg.db.GetRefreshToken(refreshToken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+			return
+		}
+		cfg.refuseRevokedRefreshToken(w, stored)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(stored.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token", nil)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
+		user.TokenVersion,
+		cfg.jwtKeys,
+		auth.AccessTokenExpiry,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+// refuseRevokedRefreshToken responds to a refresh with a revoked token. A
+// token that was rotated was used twice, so every session of its user is
+// revoked as well.
+func (cfg *apiConfig) refuseRevokedRefreshToken(w http.ResponseWriter, stored database.RefreshToken) {
+	if stored.RevokedReason != database.RevokedReasonRotated {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token", nil)
+		return
+	}
+	err := cfg.db.RevokeUserTokens(stored.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Refresh token was already used", nil)
+}
+
+//openapi:summary Revoke a refresh token
+//openapi:tags auth
+//openapi:auth bearer
+//openapi:response 204
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func newRefreshTestConfig(t *testing.T) (*apiConfig, uuid.UUID) {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	user, err := db.CreateUser(database.CreateUserParams{
+		Email:    "user@example.com",
+		Password: "hash",
+		Role:     string(auth.RoleUploader),
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	keys := auth.NewKeys("", "test-secret")
+	keys.Revocations = db
+	return &apiConfig{db: db, jwtKeys: keys}, user.ID
+}
+
+func newTestSession(t *testing.T, cfg *apiConfig, userID uuid.UUID) string {
+	t.Helper()
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken: %v", err)
+	}
+	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	return token
+}
+
+func callWithToken(handler http.HandlerFunc, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func refreshWith(t *testing.T, cfg *apiConfig, token string) (int, string) {
+	t.Helper()
+	rec := callWithToken(cfg.handlerRefresh, "/api/v1/refresh", token)
+	var resp struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if rec.Code == http.StatusOK {
+		err := json.NewDecoder(rec.Body).Decode(&resp)
+		if err != nil {
+			t.Fatalf("decoding refresh response: %v", err)
+		}
+	}
+	return rec.Code, resp.RefreshToken
+}
+
+func TestRefreshAfterLogoutKeepsOtherSessions(t *testing.T) {
+	cfg, userID := newRefreshTestConfig(t)
+	loggedOut := newTestSession(t, cfg, userID)
+	other := newTestSession(t, cfg, userID)
+
+	rec := callWithToken(cfg.handlerRevoke, "/api/v1/revoke", loggedOut)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	status, _ := refreshWith(t, cfg, loggedOut)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	status, _ = refreshWith(t, cfg, other)
+	if status != http.StatusOK {
+		t.Fatalf("refresh of another session after logout: got status %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestRefreshWithRotatedTokenRevokesAllSessions(t *testing.T) {
+	cfg, userID := newRefreshTestConfig(t)
+	rotated := newTestSession(t, cfg, userID)
+	other := newTestSession(t, cfg, userID)
+
+	status, replacement := refreshWith(t, cfg, rotated)
+	if status != http.StatusOK {
+		t.Fatalf("first refresh: got status %d, want %d", status, http.StatusOK)
+	}
+
+	status, _ = refreshWith(t, cfg, rotated)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("refresh with rotated token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	for name, token := range map[string]string{"replacement": replacement, "other session": other} {
+		status, _ = refreshWith(t, cfg, token)
+		if status != http.StatusUnauthorized {
+			t.Errorf("refresh with %s after reuse: got status %d, want %d", name, status, http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultShareLinkExpiry = 7 * 24 * time.Hour
+	maxShareLinkExpiry     = 30 * 24 * time.Hour
+	// shareVideoExpiry caps how long the video URL handed out for a share
+	// link stays valid, so revoking the link takes effect quickly.
+	shareVideoExpiry = 15 * time.Minute
+)
+
+var errShareLinkNotFound = errors.New("share link not found or expired")
+
+//openapi:summary Create a share link
+//openapi:tags sharing
+//openapi:auth bearer
+//openapi:body parameters
+//openapi:response 201 database.ShareLink
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresIn int `json:"expires_in"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ExpiresIn < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in must be a positive number of seconds", nil)
+		return
+	}
+	expiry := defaultShareLinkExpiry
+	if params.ExpiresIn > 0 {
+		expiry = min(time.Duration(params.ExpiresIn)*time.Second, maxShareLinkExpiry)
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't share this video", nil)
+		return
+	}
+	if cfg.sharesStreamVideos() && cfg.videoStorageName != "s3" {
+		respondWithError(w, http.StatusNotImplemented, "Share links need signed video URLs or S3 video storage", nil)
+		return
+	}
+
+	shareToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share token", err)
+		return
+	}
+
+	link, err := cfg.db.CreateShareLink(database.CreateShareLinkParams{
+		Token:     shareToken,
+		VideoID:   videoID,
+		ExpiresAt: time.Now().UTC().Add(expiry),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, link)
+}
+
+// handlerShareLinkGet is public: anyone holding a valid share token gets the
+// video with a short-lived URL, whatever its visibility. When video URLs
+// aren't signed they'd stay valid after the link is revoked, so the video is
+// streamed through the link instead and its HLS playlist, preview and sprites
+// are left out.
+//
+//openapi:summary Get a shared video
+//openapi:tags sharing
+//openapi:response 200 database.Video
+func (cfg *apiConfig) handlerShareLinkGet(w http.ResponseWriter, r *http.Request) {
+	video, remaining, err := cfg.sharedVideo(r.PathValue("token"))
+	if errors.Is(err, errShareLinkNotFound) {
+		respondWithError(w, http.StatusNotFound, "Share link not found or expired", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+
+	video.Captions, err = cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+	video.Tags, err = cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tags", err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, min(shareVideoExpiry, remaining))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	if cfg.sharesStreamVideos() {
+		if video.VideoURL != nil {
+			streamURL := "/api/v1/share/" + url.PathEscape(r.PathValue("token")) + "/stream"
+			video.VideoURL = &streamURL
+		}
+		video.HLSURL = nil
+		video.PreviewURL = nil
+		video.SpriteURL = nil
+		video.SpriteVTTURL = nil
+	}
+	cfg.recordVideoURLIssued(video)
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerShareLinkStream plays a shared video through the link, which is
+// checked on every request, so revoking or expiring it stops playback. Share
+// links point here when video URLs aren't signed.
+//
+//openapi:summary Stream a shared video
+//openapi:tags sharing
+//openapi:header Range string Bytes of the video to return, e.g. bytes=0-1048575
+//openapi:header If-Range string ETag or Last-Modified of the video the range is only returned for
+//openapi:response 200 video/mp4
+//openapi:response 206 video/mp4
+func (cfg *apiConfig) handlerShareLinkStream(w http.ResponseWriter, r *http.Request) {
+	video, _, err := cfg.sharedVideo(r.PathValue("token"))
+	if errors.Is(err, errShareLinkNotFound) {
+		respondWithError(w, http.StatusNotFound, "Share link not found or expired", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	cfg.streamVideo(w, r, video)
+}
+
+// sharedVideo returns the video a share token gives access to and how long
+// the link stays valid. Links that are unknown, revoked or expired, or whose
+// video isn't cleared by moderation, are reported as errShareLinkNotFound.
+func (cfg *apiConfig) sharedVideo(token string) (database.Video, time.Duration, error) {
+	link, err := cfg.db.GetShareLink(token)
+	if err != nil {
+		return database.Video{}, 0, err
+	}
+	remaining := time.Until(link.ExpiresAt)
+	if link.Token == "" || link.RevokedAt != nil || remaining <= 0 {
+		return database.Video{}, 0, errShareLinkNotFound
+	}
+
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil {
+		return database.Video{}, 0, fmt.Errorf("couldn't get video: %w", err)
+	}
+	// Share links don't get around moderation.
+	if video.ID == uuid.Nil || !video.ModerationStatus.Cleared() {
+		return database.Video{}, 0, errShareLinkNotFound
+	}
+	return video, remaining, nil
+}
+
+// sharesStreamVideos reports whether share links stream videos through the
+// API rather than handing out URLs of the stored objects, which is the case
+// when those URLs aren't signed and so can't be revoked.
+func (cfg *apiConfig) sharesStreamVideos() bool {
+	return !cfg.signsURLsIn(cfg.videoStorage)
+}
+
+//openapi:summary Revoke a share link
+//openapi:tags sharing
+//openapi:auth bearer
+//openapi:response 204
+func (cfg *apiConfig) handlerShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	link, err := cfg.db.GetShareLink(r.PathValue("token"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.Token == "" {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't revoke this share link", nil)
+		return
+	}
+
+	err = cfg.db.RevokeShareLink(link.Token)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// In public delivery mode, a share link hands out a URL that streams through
+// the link rather than the object URL, so revoking the link stops playback.
+func TestShareLinkStreamsPublicVideos(t *testing.T) {
+	cfg, userID := newRefreshTestConfig(t)
+	videoStorage := storage.NewS3(s3.New(s3.Options{Region: "us-east-1"}), "tubely", "https://tubely.s3.amazonaws.com")
+	cfg.videoDelivery = videoDeliveryPublic
+	cfg.videoStorage = videoStorage
+	cfg.videoStorageName = "s3"
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "shared", UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	key := "videos/shared.mp4"
+	videoURL := videoStorage.URL(key)
+	video.StorageKey = &key
+	video.VideoURL = &videoURL
+	hlsURL := videoStorage.URL("videos/shared/hls/master.m3u8")
+	video.HLSURL = &hlsURL
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	link, err := cfg.db.CreateShareLink(database.CreateShareLinkParams{
+		Token:     "share-token",
+		VideoID:   video.ID,
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateShareLink: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/share/{token}", cfg.handlerShareLinkGet)
+	mux.HandleFunc("GET /api/v1/share/{token}/stream", cfg.handlerShareLinkStream)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/share/"+link.Token, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get share link: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var shared database.Video
+	err = json.NewDecoder(rec.Body).Decode(&shared)
+	if err != nil {
+		t.Fatalf("decoding shared video: %v", err)
+	}
+	streamURL := "/api/v1/share/" + link.Token + "/stream"
+	if shared.VideoURL == nil || *shared.VideoURL != streamURL {
+		t.Fatalf("video_url: got %v, want %q", shared.VideoURL, streamURL)
+	}
+	// The playlist and its segments are public and would outlive the link.
+	if shared.HLSURL != nil {
+		t.Fatalf("hls_url: got %q, want none", *shared.HLSURL)
+	}
+
+	err = cfg.db.RevokeShareLink(link.Token)
+	if err != nil {
+		t.Fatalf("RevokeShareLink: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, streamURL, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("stream after revoke: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultFrameCandidates = 6
+	maxFrameCandidates     = 12
+	// frameCandidateWidth keeps candidate previews small, since they're
+	// returned inline.
+	frameCandidateWidth = 320
+	// frameSourceExpiry bounds how long ffmpeg can read the stored video
+	// through its presigned URL.
+	frameSourceExpiry = 15 * time.Minute
+)
+
+var errVideoNotProcessed = errors.New("video hasn't been processed yet")
+
+//openapi:summary Use a frame of the video as its thumbnail
+//openapi:tags videos
+//openapi:body parameters
+//openapi:response 200 database.Video
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Timestamp float64 `json:"timestamp"`
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Timestamp < 0 || (video.Metadata != nil && params.Timestamp > video.Metadata.DurationSeconds) {
+		respondWithError(w, http.StatusBadRequest, "Timestamp is outside the video", nil)
+		return
+	}
+
+	data, err := cfg.extractStoredFrame(r.Context(), video, params.Timestamp, 0)
+	if errors.Is(err, errVideoNotProcessed) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoNotProcessed, "Video hasn't been processed yet", nil, err)
+		return
+	}
+	if errors.Is(err, errVideoArchived) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Video is archived and has to be restored first", nil, err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+		return
+	}
+
+	videoOld := video
+	err = cfg.storeThumbnail(withVideoTags(r.Context(), video), &video, data, "image/jpeg")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+		return
+	}
+
+	err = cfg.db.UpdateVideoThumbnail(video.ID, video.ThumbnailURL, video.ThumbnailSrcset)
+	if err != nil {
+		cfg.deleteThumbnail(context.Background(), video)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.deleteThumbnail(r.Context(), videoOld)
+
+	err = cfg.enqueueModeration(r.Context(), &video)
+	if err != nil {
+		log.Printf("Couldn't queue moderation of video %s: %v", video.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+//openapi:summary List frames to pick a thumbnail from
+//openapi:tags videos
+//openapi:query count integer How many frames to return
+//openapi:response 200 []candidate
+func (cfg *apiConfig) handlerThumbnailCandidatesGet(w http.ResponseWriter, r *http.Request) {
+	type candidate struct {
+		Timestamp float64 `json:"timestamp"`
+		Image     string  `json:"image"`
+	}
+
+	count := defaultFrameCandidates
+	if value := r.URL.Query().Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxFrameCandidates {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxFrameCandidates), err)
+			return
+		}
+		count = n
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if video.Metadata == nil || video.Metadata.DurationSeconds <= 0 {
+		respondWithError(w, http.StatusConflict, "Video duration is unknown", nil)
+		return
+	}
+
+	// Candidates are spread evenly, each in the middle of its slice of the
+	// video, so the first and last frames (often black) are avoided.
+	step := video.Metadata.DurationSeconds / float64(count)
+	candidates := make([]candidate, 0, count)
+	for i := 0; i < count; i++ {
+		timestamp := step * (float64(i) + 0.5)
+		data, err := cfg.extractStoredFrame(r.Context(), video, timestamp, frameCandidateWidth)
+		if errors.Is(err, errVideoNotProcessed) {
+			respondWithErrorCode(w, http.StatusConflict, errCodeVideoNotProcessed, "Video hasn't been processed yet", nil, err)
+			return
+		}
+		if errors.Is(err, errVideoArchived) {
+			respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Video is archived and has to be restored first", nil, err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+			return
+		}
+		candidates = append(candidates, candidate{
+			Timestamp: timestamp,
+			Image:     "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, candidates)
+}
+
+// ownedVideoFromRequest authenticates the request and loads the video named
+// in the path, making sure it belongs to the caller. It writes the error
+// response and reports false if any of that fails.
+func (cfg *apiConfig) ownedVideoFromRequest(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Insufficient rights to video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// extractStoredFrame returns the frame at the given offset of the stored
+// video as a JPEG. ffmpeg reads the video through a presigned URL, so only
+// the parts around the frame are downloaded.
+func (cfg *apiConfig) extractStoredFrame(ctx context.Context, video database.Video, seconds float64, width int) ([]byte, error) {
+	if video.StorageKey == nil {
+		return nil, errVideoNotProcessed
+	}
+	if !video.ArchiveStatus.Playable() {
+		return nil, errVideoArchived
+	}
+	sourceURL, err := cfg.videoStorage.PresignGet(ctx, *video.StorageKey, frameSourceExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't presign video: %w", err)
+	}
+
+	frameTmp, err := os.CreateTemp("", "tubely-thumbnail*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer os.Remove(frameTmp.Name())
+	frameTmp.Close()
+
+	err = extractFrame(ctx, sourceURL, seconds, width, frameTmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(frameTmp.Name())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// handlerTokenRevoke revokes an access token before it expires, such as one
+// that leaked. Without a token in the body, it revokes the one the request
+// is made with, logging it out. Users can revoke their own tokens; admins
+// can revoke anyone's.
+//
+//openapi:summary Revoke an access token
+//openapi:tags auth
+//openapi:body parameters
+//openapi:response 204
+func (cfg *apiConfig) handlerTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Token == "" {
+		params.Token = token
+	}
+
+	claims, err := auth.ParseJWT(params.Token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid or already expired", err)
+		return
+	}
+	ownerID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid or already expired", err)
+		return
+	}
+	if ownerID != userID {
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user == nil || !auth.Role(user.Role).Has(auth.RoleAdmin) {
+			respondWithError(w, http.StatusForbidden, "You can only revoke your own tokens", nil)
+			return
+		}
+	}
+	auditResource(r, ownerID.String())
+	// Tokens issued before tokens had IDs can only be revoked along with
+	// the rest of the user's.
+	if claims.ID == "" {
+		respondWithError(w, http.StatusBadRequest, "Token has no ID, revoke all of the user's tokens instead", nil)
+		return
+	}
+
+	err = cfg.db.RevokeToken(claims.ID, ownerID, claims.ExpiresAt.Time)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerTokensRevokeAll logs the user out everywhere: every access token
+// and refresh token they hold stops working, including the one the request
+// is made with.
+//
+//openapi:summary Revoke all of your tokens
+//openapi:tags auth
+//openapi:response 204
+func (cfg *apiConfig) handlerTokensRevokeAll(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	err = cfg.db.RevokeUserTokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke tokens", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminUserTokensRevoke revokes every access token and refresh token
+// of a user, e.g. when their account is compromised.
+//
+//openapi:summary Revoke all tokens of a user
+//openapi:tags admin
+//openapi:response 204
+func (cfg *apiConfig) handlerAdminUserTokensRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.RevokeUserTokens(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke tokens", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeRevokedTokens forgets revoked tokens once they've expired.
+func (cfg *apiConfig) purgeRevokedTokens(ctx context.Context) error {
+	deleted, err := cfg.db.DeleteRevokedTokensBefore(time.Now())
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Forgot %d expired revoked tokens", deleted)
+	}
+	return nil
+}
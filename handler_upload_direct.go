@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const directUploadExpiry = 15 * time.Minute
+
+var (
+	errDirectUploadNotFound  = errors.New("uploaded object not found")
+	errDirectUploadMediaType = errors.New("uploaded object isn't an MP4")
+	errDirectUploadTooLarge  = errors.New("uploaded object is too large")
+	errDirectUploadMismatch  = errors.New("uploaded content doesn't match its Content-Type")
+)
+
+func directUploadKey(videoID uuid.UUID) string {
+	return fmt.Sprintf("uploads/%s.mp4", videoID)
+}
+
+//openapi:summary Create a URL to upload a video to storage directly
+//openapi:tags uploads
+//openapi:response 200 response
+func (cfg *apiConfig) handlerUploadURLCreate(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		UploadURL string    `json:"upload_url"`
+		Method    string    `json:"method"`
+		Headers   []string  `json:"headers"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Insufficient rights to upload the video", nil)
+		return
+	}
+
+	if cfg.videoStorageName != "s3" {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads require S3 video storage", nil)
+		return
+	}
+
+	tagging := storage.ObjectTags(withVideoTags(r.Context(), video), "video/mp4").Encode()
+	presigned, err := cfg.s3PresignClient.PresignPutObject(r.Context(), &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.s3Bucket),
+		Key:         aws.String(directUploadKey(videoID)),
+		ContentType: aws.String("video/mp4"),
+		// The encryption, storage class and tagging headers are signed, so
+		// the client has to send them.
+		ServerSideEncryption: cfg.s3Encryption.Algorithm,
+		SSEKMSKeyId:          cfg.s3Encryption.KeyID(),
+		StorageClass:         cfg.s3StorageClass,
+		Tagging:              aws.String(tagging),
+	}, s3.WithPresignExpires(directUploadExpiry))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	headers := append([]string{"Content-Type: video/mp4"}, cfg.s3Encryption.Headers()...)
+	if cfg.s3StorageClass != "" {
+		headers = append(headers, "x-amz-storage-class: "+string(cfg.s3StorageClass))
+	}
+	headers = append(headers, "x-amz-tagging: "+tagging)
+	respondWithJSON(w, http.StatusOK, response{
+		UploadURL: presigned.URL,
+		Method:    presigned.Method,
+		Headers:   headers,
+		ExpiresAt: time.Now().UTC().Add(directUploadExpiry),
+	})
+}
+
+//openapi:summary Complete a direct upload
+//openapi:tags uploads
+//openapi:header X-Checksum-SHA256 string Hex SHA-256 of the file, which the upload is checked against
+//openapi:query filename string Name of the uploaded file, which downloads are named after
+//openapi:response 202 database.Video
+func (cfg *apiConfig) handlerUploadComplete(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Insufficient rights to upload the video", nil)
+		return
+	}
+
+	if cfg.videoStorageName != "s3" {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads require S3 video storage", nil)
+		return
+	}
+
+	// The upload may already have been picked up from its S3 event.
+	if cfg.uploadEventsEnabled && (video.ProcessingStatus == database.ProcessingStatusPending || video.ProcessingStatus == database.ProcessingStatusProcessing) {
+		respondWithJSON(w, http.StatusAccepted, video)
+		return
+	}
+
+	stagingKey := directUploadKey(videoID)
+	size, err := cfg.checkDirectUpload(r.Context(), stagingKey)
+	switch {
+	case errors.Is(err, errDirectUploadNotFound):
+		respondWithError(w, http.StatusBadRequest, "Uploaded object not found", err)
+		return
+	case errors.Is(err, errDirectUploadMediaType):
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Invalid media type, only MP4 supported.", nil, nil)
+		return
+	case errors.Is(err, errDirectUploadTooLarge):
+		respondWithSizeLimit(w, cfg.maxVideoSize, errCodeVideoTooLarge)
+		return
+	case errors.Is(err, errDirectUploadMismatch):
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeContentMismatch, "Video content doesn't match its Content-Type", nil, nil)
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded object", err)
+		return
+	}
+
+	expectedChecksum, err := parseChecksumHeader(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid checksum header", err)
+		return
+	}
+
+	sourceURL, err := cfg.videoStorage.PresignGet(r.Context(), stagingKey, cfg.processingTimeout)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded object", err)
+		return
+	}
+	report, err := cfg.validateVideo(r.Context(), sourceURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate video", err)
+		return
+	}
+	if len(report.Issues) > 0 {
+		err = cfg.videoStorage.Delete(context.Background(), stagingKey)
+		if err != nil {
+			log.Printf("Couldn't delete staging object %s: %v", stagingKey, err)
+		}
+		respondWithValidationReport(w, report)
+		return
+	}
+
+	video, err = cfg.enqueueVideoProcessing(r.Context(), video, processVideoPayload{
+		SourceKey:        stagingKey,
+		MediaType:        "video/mp4",
+		ExpectedChecksum: expectedChecksum,
+		Filename:         cleanFilename(r.URL.Query().Get("filename")),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return
+	}
+	metrics.UploadsTotal.WithLabelValues("video").Inc()
+	metrics.UploadSizeBytes.WithLabelValues("video").Observe(float64(size))
+
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+// checkDirectUpload inspects the object a client uploaded to key before its
+// contents are validated, and returns its size. Problems with the upload are
+// reported as one of the errDirectUpload errors; failing to reach S3 isn't
+// one of them, so those errors are returned as is.
+func (cfg *apiConfig) checkDirectUpload(ctx context.Context, key string) (int64, error) {
+	head, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(cfg.s3Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *s3types.NotFound
+	var respErr *awshttp.ResponseError
+	switch {
+	case errors.As(err, &notFound),
+		errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound:
+		return 0, fmt.Errorf("%w: %w", errDirectUploadNotFound, err)
+	case err != nil:
+		return 0, fmt.Errorf("couldn't inspect uploaded object: %w", err)
+	}
+	if aws.ToString(head.ContentType) != "video/mp4" {
+		return 0, errDirectUploadMediaType
+	}
+	size := aws.ToInt64(head.ContentLength)
+	if size > cfg.maxVideoSize {
+		return 0, errDirectUploadTooLarge
+	}
+
+	prefix, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.s3Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", sniffLen-1)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read uploaded object: %w", err)
+	}
+	header, err := io.ReadAll(prefix.Body)
+	prefix.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read uploaded object: %w", err)
+	}
+	if sniffVideoContainer(header) != videoContainerFamily["video/mp4"] {
+		return 0, errDirectUploadMismatch
+	}
+	return size, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxCaptionParts bounds the caption files of a single upload.
+const maxCaptionParts = 20
+
+const (
+	captionsPartPrefix = "captions."
+	labelPartPrefix    = "label."
+)
+
+// handlerUploadFiles accepts a video, its thumbnail and caption files in a
+// single multipart request, so uploaders don't need a request for each.
+// Captions are sent as captions.<language> parts, with an optional
+// label.<language> field naming them. Every part is checked before any is
+// stored, and each is then handled as its own endpoint would.
+//
+//openapi:summary Upload a video with its thumbnail and captions
+//openapi:tags uploads
+//openapi:form video file MP4, MOV, WebM or MKV video
+//openapi:form thumbnail file JPEG or PNG image
+//openapi:form captions.{language} file WebVTT or SRT file with captions in the language, such as captions.pt-BR
+//openapi:form label.{language} string Label shown in players for the captions in the language
+//openapi:header X-Checksum-SHA256 string Hex SHA-256 of the video, which the upload is checked against
+//openapi:response 200 response
+//openapi:response 202 response
+func (cfg *apiConfig) handlerUploadFiles(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Video    database.Video     `json:"video"`
+		Captions []database.Caption `json:"captions"`
+	}
+	type captionPart struct {
+		language string
+		label    string
+		vtt      []byte
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, span := tracer.Start(r.Context(), "upload.parse", trace.WithAttributes(
+		attribute.String("video.id", video.ID.String()),
+	))
+	defer span.End()
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoSize+cfg.maxThumbnailSize+maxCaptionParts*maxCaptionSize)
+	r.Body = &progressReader{r: r.Body, report: cfg.uploadProgress(video.ID, max(r.ContentLength, 0))}
+
+	const maxMemory = 10 << 20
+	err := r.ParseMultipartForm(maxMemory)
+	if err != nil {
+		if respondWithTooLarge(w, err, errCodeVideoTooLarge) {
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse multipart form", err)
+		return
+	}
+
+	files := r.MultipartForm.File
+	var languages []string
+	for name, headers := range files {
+		language, isCaption := strings.CutPrefix(name, captionsPartPrefix)
+		if !isCaption && name != "video" && name != "thumbnail" {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown part %q", name), nil)
+			return
+		}
+		if len(headers) > 1 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Part %q is sent more than once", name), nil)
+			return
+		}
+		if isCaption {
+			if !captions.ValidLanguage(language) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Part %q doesn't end in a language tag such as en or pt-BR", name), nil)
+				return
+			}
+			languages = append(languages, language)
+		}
+	}
+	if len(files) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No files given", nil)
+		return
+	}
+	if len(languages) > maxCaptionParts {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("An upload can have at most %d caption files", maxCaptionParts), nil)
+		return
+	}
+	slices.Sort(languages)
+
+	var captionParts []captionPart
+	for _, language := range languages {
+		header := files[captionsPartPrefix+language][0]
+		if header.Size > maxCaptionSize {
+			respondWithSizeLimit(w, maxCaptionSize, errCodeCaptionTooLarge)
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to open caption file", err)
+			return
+		}
+		vtt, ok := readCaptionUpload(w, file)
+		file.Close()
+		if !ok {
+			return
+		}
+		if !cfg.scanUploadedFile(ctx, w, video.ID, "captions", bytes.NewReader(vtt)) {
+			return
+		}
+		label := r.FormValue(labelPartPrefix + language)
+		if label == "" {
+			label = language
+		}
+		captionParts = append(captionParts, captionPart{language: language, label: label, vtt: vtt})
+	}
+
+	var thumbnail []byte
+	var thumbnailType string
+	if headers := files["thumbnail"]; len(headers) > 0 {
+		if headers[0].Size > cfg.maxThumbnailSize {
+			respondWithSizeLimit(w, cfg.maxThumbnailSize, errCodeThumbnailTooLarge)
+			return
+		}
+		file, err := headers[0].Open()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to open thumbnail", err)
+			return
+		}
+		thumbnail, thumbnailType, ok = readThumbnailUpload(w, file, headers[0])
+		file.Close()
+		if !ok {
+			return
+		}
+	}
+
+	// The video is checked last, since it's by far the slowest to check.
+	var payload *processVideoPayload
+	var videoSize int64
+	if headers := files["video"]; len(headers) > 0 {
+		videoSize = headers[0].Size
+		if videoSize > cfg.maxVideoSize {
+			respondWithSizeLimit(w, cfg.maxVideoSize, errCodeVideoTooLarge)
+			return
+		}
+		file, err := headers[0].Open()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to open video", err)
+			return
+		}
+		saved, ok := cfg.saveVideoUpload(ctx, w, r, video.ID, file, headers[0])
+		file.Close()
+		if !ok {
+			return
+		}
+		payload = &saved
+	}
+
+	fmt.Println("uploading files for video", video.ID, "by user", video.UserID)
+
+	if thumbnail != nil {
+		video, ok = cfg.replaceThumbnail(ctx, w, video, thumbnail, thumbnailType)
+		if !ok {
+			if payload != nil {
+				cfg.cleanupProcessVideoSource(*payload)
+			}
+			return
+		}
+	}
+
+	resp := response{Captions: []database.Caption{}}
+	for _, part := range captionParts {
+		caption, err := cfg.storeCaption(withVideoTags(ctx, video), video, part.language, part.label, database.CaptionSourceUpload, part.vtt)
+		if err != nil {
+			if payload != nil {
+				cfg.cleanupProcessVideoSource(*payload)
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+			return
+		}
+		resp.Captions = append(resp.Captions, caption)
+	}
+
+	status := http.StatusOK
+	if payload != nil {
+		video, ok = cfg.queueVideoUpload(ctx, w, video, *payload, videoSize)
+		if !ok {
+			return
+		}
+		status = http.StatusAccepted
+	}
+
+	resp.Video = video
+	respondWithJSON(w, status, resp)
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
 	"github.com/google/uuid"
 )
 
+//openapi:summary Upload a thumbnail
+//openapi:tags uploads
+//openapi:form thumbnail file JPEG or PNG image
+//openapi:response 200 database.Video
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -26,7 +34,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -34,9 +42,14 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
 
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxThumbnailSize)
+
 	const maxMemory = 10 << 20
 	err = r.ParseMultipartForm(maxMemory)
 	if err != nil {
+		if respondWithTooLarge(w, err, errCodeThumbnailTooLarge) {
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse multipart form", err)
 		return
 	}
@@ -48,13 +61,8 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 	defer file.Close()
 
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
-		return
-	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Only JPEG and PNG are valid file types for a thumbnail", nil)
+	data, mediaType, ok := readThumbnailUpload(w, file, header)
+	if !ok {
 		return
 	}
 
@@ -68,42 +76,71 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	assetPath := getAssetPath(mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	video, ok = cfg.replaceThumbnail(r.Context(), w, video, data, mediaType)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
 
-	assetOnDisk, err := os.Create(assetDiskPath)
+// readThumbnailUpload checks an uploaded thumbnail and reads it, responding
+// with the reason it was rejected if it wasn't accepted.
+func readThumbnailUpload(w http.ResponseWriter, file multipart.File, header *multipart.FileHeader) ([]byte, string, bool) {
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create thumbnail", err)
-		return
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Invalid Content-Type", nil, err)
+		return nil, "", false
+	}
+	if mediaType != "image/jpeg" && mediaType != "image/png" {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Only JPEG and PNG are valid file types for a thumbnail", nil, nil)
+		return nil, "", false
+	}
+	err = checkImageContent(file, mediaType)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeContentMismatch, "Thumbnail content doesn't match its Content-Type", nil, err)
+		return nil, "", false
 	}
 
-	_, err = io.Copy(assetOnDisk, file)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read thumbnail", err)
+		return nil, "", false
 	}
+	return data, mediaType, true
+}
 
-	thumbnailURL := cfg.getAssetURL(assetPath)
-	thumbnailURLOld := *video.ThumbnailURL
-	video.ThumbnailURL = &thumbnailURL
+// replaceThumbnail stores an uploaded thumbnail as the video's, deleting the
+// one it replaces, and responds with an error if it couldn't or if it didn't
+// pass the virus scan.
+func (cfg *apiConfig) replaceThumbnail(ctx context.Context, w http.ResponseWriter, video database.Video, data []byte, mediaType string) (database.Video, bool) {
+	if !cfg.scanUploadedFile(ctx, w, video.ID, "thumbnail", bytes.NewReader(data)) {
+		return video, false
+	}
 
-	err = cfg.db.UpdateVideo(video)
+	videoOld := video
+	err := cfg.storeThumbnail(withVideoTags(ctx, video), &video, data, mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+		return videoOld, false
 	}
 
-	assedOnDiskOld, err := cfg.getAssetDiskPathFromURL(thumbnailURLOld)
+	err = cfg.db.UpdateVideoThumbnail(video.ID, video.ThumbnailURL, video.ThumbnailSrcset)
 	if err != nil {
-		log.Println(err)
-	} else {
-		if assedOnDiskOld != "" {
-			err = os.Remove(assedOnDiskOld)
-			if err != nil {
-				log.Printf("Couldn't delete old thumbnail: %v", err)
-			}
-		}
+		// Nothing references the new thumbnail.
+		cfg.deleteThumbnail(context.Background(), video)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return videoOld, false
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	metrics.UploadsTotal.WithLabelValues("thumbnail").Inc()
+	metrics.UploadSizeBytes.WithLabelValues("thumbnail").Observe(float64(len(data)))
+
+	cfg.deleteThumbnail(ctx, videoOld)
+
+	err = cfg.enqueueModeration(ctx, &video)
+	if err != nil {
+		log.Printf("Couldn't queue moderation of video %s: %v", video.ID, err)
+	}
+	return video, true
 }
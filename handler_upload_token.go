@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultUploadTokenExpiry = time.Hour
+	maxUploadTokenExpiry     = 24 * time.Hour
+)
+
+// handlerUploadTokenCreate mints a token that lets whoever holds it upload
+// media to the video once, such as a render farm, without the owner sharing
+// their credentials. The token is good for nothing else.
+//
+//openapi:summary Create a single-use upload token
+//openapi:tags uploads
+//openapi:body parameters
+//openapi:response 201 database.UploadToken
+func (cfg *apiConfig) handlerUploadTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresIn int `json:"expires_in"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ExpiresIn < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in must be a positive number of seconds", nil)
+		return
+	}
+	expiry := defaultUploadTokenExpiry
+	if params.ExpiresIn > 0 {
+		expiry = min(time.Duration(params.ExpiresIn)*time.Second, maxUploadTokenExpiry)
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Insufficient rights to upload the video", nil)
+		return
+	}
+
+	uploadToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload token", err)
+		return
+	}
+
+	created, err := cfg.db.CreateUploadToken(database.CreateUploadTokenParams{
+		Token:     uploadToken,
+		VideoID:   video.ID,
+		UserID:    userID,
+		ExpiresAt: time.Now().UTC().Add(expiry),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+// handlerUploadVideoWithToken accepts an upload of a video's media made with
+// an upload token instead of the owner's JWT. The token is used up as soon
+// as the upload starts, whether or not it's accepted.
+//
+//openapi:summary Upload a video's media with an upload token
+//openapi:tags uploads
+//openapi:form video file MP4, MOV, WebM or MKV video
+//openapi:header X-Checksum-SHA256 string Hex SHA-256 of the file, which the upload is checked against
+//openapi:response 202 database.Video
+func (cfg *apiConfig) handlerUploadVideoWithToken(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoSize)
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	ctx, span := tracer.Start(r.Context(), "upload.parse", trace.WithAttributes(
+		attribute.String("video.id", videoID.String()),
+	))
+	defer span.End()
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find upload token", err)
+		return
+	}
+	uploadToken, err := cfg.db.GetUploadToken(token)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload token", err)
+		return
+	}
+	if uploadToken.Token == "" || uploadToken.VideoID != videoID || uploadToken.UsedAt != nil || time.Now().After(uploadToken.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid, expired or used upload token", nil)
+		return
+	}
+	auditUser(r, uploadToken.UserID)
+
+	// The owner may have lost the right to upload since minting the token.
+	user, err := cfg.db.GetUser(uploadToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil || !auth.Role(user.Role).Has(auth.RoleUploader) {
+		respondWithError(w, http.StatusForbidden, "Insufficient role", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != uploadToken.UserID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+
+	used, err := cfg.db.UseUploadToken(token)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use upload token", err)
+		return
+	}
+	if !used {
+		respondWithError(w, http.StatusUnauthorized, "Invalid, expired or used upload token", nil)
+		return
+	}
+
+	cfg.receiveVideoUpload(ctx, w, r, video)
+}
+
+// purgeUploadTokens forgets upload tokens once they've expired.
+func (cfg *apiConfig) purgeUploadTokens(ctx context.Context) error {
+	deleted, err := cfg.db.DeleteUploadTokensBefore(time.Now())
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Forgot %d expired upload tokens", deleted)
+	}
+	return nil
+}
@@ -3,26 +3,47 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
-	"math"
+	"log"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// supportedVideoTypes lists the accepted upload containers. Anything other
+// than MP4 is transcoded to MP4 before it is stored.
+var supportedVideoTypes = map[string]bool{
+	"video/mp4":        true,
+	"video/quicktime":  true,
+	"video/webm":       true,
+	"video/x-matroska": true,
+}
+
+// handlerUploadVideo accepts the first upload of a video as well as
+// replacements of its media. The previous media stays in place until the new
+// upload has been processed.
+//
+//openapi:summary Upload a video, or replace its media
+//openapi:tags uploads
+//openapi:form video file MP4, MOV, WebM or MKV video
+//openapi:header X-Checksum-SHA256 string Hex SHA-256 of the file, which the upload is checked against
+//openapi:response 202 database.Video
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const uploadLimit = 1 << 30
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoSize)
 
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -31,13 +52,18 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	ctx, span := tracer.Start(r.Context(), "upload.parse", trace.WithAttributes(
+		attribute.String("video.id", videoID.String()),
+	))
+	defer span.End()
+
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -53,146 +79,378 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	cfg.receiveVideoUpload(ctx, w, r, video)
+}
+
+// receiveVideoUpload reads the video file of an upload by the video's owner
+// and queues it to be processed, responding with the video or with the
+// reason the upload was rejected.
+func (cfg *apiConfig) receiveVideoUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, video database.Video) {
+	// Uploads of others' videos are turned away before this, so only the
+	// owner's upload is reported.
+	r.Body = &progressReader{r: r.Body, report: cfg.uploadProgress(video.ID, max(r.ContentLength, 0))}
+
 	file, header, err := r.FormFile("video")
 	if err != nil {
+		if respondWithTooLarge(w, err, errCodeVideoTooLarge) {
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
 	defer file.Close()
 
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+	fmt.Println("uploading video", video.ID, "by user", video.UserID)
+
+	payload, ok := cfg.saveVideoUpload(ctx, w, r, video.ID, file, header)
+	if !ok {
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid media type, only MP4 supported.", nil)
+	video, ok = cfg.queueVideoUpload(ctx, w, video, payload, header.Size)
+	if !ok {
 		return
 	}
 
-	fmt.Println("uploading video", videoID, "by user", userID)
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+// saveVideoUpload checks an uploaded video file and copies it to a temp file
+// to be processed from, responding with the reason it was rejected if it
+// wasn't accepted. The file is checked against the X-Checksum-SHA256 header
+// of the request, if it has one, and scanned for viruses if scanning is on.
+func (cfg *apiConfig) saveVideoUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, videoID uuid.UUID, file multipart.File, header *multipart.FileHeader) (processVideoPayload, bool) {
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Invalid Content-Type", nil, err)
+		return processVideoPayload{}, false
+	}
+	if !supportedVideoTypes[mediaType] {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Invalid media type, only MP4, MOV, WebM and MKV supported.", nil, nil)
+		return processVideoPayload{}, false
+	}
+	err = checkVideoContent(file, mediaType)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeContentMismatch, "Video content doesn't match its Content-Type", nil, err)
+		return processVideoPayload{}, false
+	}
+
+	expectedChecksum, err := parseChecksumHeader(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid checksum header", err)
+		return processVideoPayload{}, false
+	}
 
 	const fileTmpPath = "tubely-upload.mp4"
 	fileTmp, err := os.CreateTemp("", fileTmpPath)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return processVideoPayload{}, false
 	}
-	defer os.Remove(fileTmp.Name())
 	defer fileTmp.Close()
 
-	_, err = io.Copy(fileTmp, file)
+	checksum, err := hashingCopy(fileTmp, file)
 	if err != nil {
+		os.Remove(fileTmp.Name())
+		if respondWithTooLarge(w, err, errCodeVideoTooLarge) {
+			return processVideoPayload{}, false
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save video to disk", err)
-		return
+		return processVideoPayload{}, false
 	}
 
-	aspectRatio, err := getVideoAspectRatio(fileTmp.Name())
+	err = verifyChecksum(expectedChecksum, checksum)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't calculate aspect ratio", err)
-		return
+		os.Remove(fileTmp.Name())
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeChecksumMismatch, "Uploaded file doesn't match the provided checksum", nil, err)
+		return processVideoPayload{}, false
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("upload.size", header.Size), attribute.String("upload.media_type", mediaType))
+
+	_, err = fileTmp.Seek(0, io.SeekStart)
+	if err != nil {
+		os.Remove(fileTmp.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video from disk", err)
+		return processVideoPayload{}, false
+	}
+	if !cfg.scanUploadedFile(ctx, w, videoID, "video", fileTmp) {
+		os.Remove(fileTmp.Name())
+		return processVideoPayload{}, false
 	}
 
-	prefixKey := "other"
-	if aspectRatio == "16:9" {
-		prefixKey = "landscape"
-	} else if aspectRatio == "9:16" {
-		prefixKey = "portrait"
+	report, err := cfg.validateVideo(ctx, fileTmp.Name())
+	if err != nil {
+		os.Remove(fileTmp.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate video", err)
+		return processVideoPayload{}, false
+	}
+	if len(report.Issues) > 0 {
+		os.Remove(fileTmp.Name())
+		respondWithValidationReport(w, report)
+		return processVideoPayload{}, false
 	}
 
-	fileKey := getAssetPath(mediaType)
-	fileKey = filepath.Join(prefixKey, fileKey)
+	return processVideoPayload{
+		SourcePath: fileTmp.Name(),
+		MediaType:  mediaType,
+		Checksum:   checksum,
+		Filename:   cleanFilename(header.Filename),
+		Scanned:    cfg.virusScanner != nil,
+	}, true
+}
 
-	fileProcessedPath, err := processVideoForFastStart(fileTmp.Name())
+// queueVideoUpload queues a saved upload of size bytes to be processed as
+// the video's media, responding with an error if it couldn't be.
+func (cfg *apiConfig) queueVideoUpload(ctx context.Context, w http.ResponseWriter, video database.Video, payload processVideoPayload, size int64) (database.Video, bool) {
+	var err error
+	if cfg.remoteWorkers {
+		payload, err = cfg.stageUploadedSource(withVideoTags(ctx, video), video.ID, payload)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't stage video for processing", err)
+			return video, false
+		}
+	}
+
+	video, err = cfg.enqueueVideoProcessing(ctx, video, payload)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't process video", err)
-		return
+		cfg.cleanupProcessVideoSource(payload)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return video, false
 	}
-	defer os.Remove(fileProcessedPath)
+	metrics.UploadsTotal.WithLabelValues("video").Inc()
+	metrics.UploadSizeBytes.WithLabelValues("video").Observe(float64(size))
+	return video, true
+}
 
-	fileProcessed, err := os.Open(fileProcessedPath)
+// processVideo derives the aspect ratio prefix for the video at srcPath,
+// remuxes (or transcodes, for non-MP4 containers) it into a fast start MP4,
+// uploads it to S3 and records the new URL, marking the video as ready.
+// Media derived from a previous upload is regenerated, and the new media only
+// replaces the old one if nothing else has replaced it in the meantime. With
+// reuse set, the media of an identical earlier upload is reused instead.
+func (cfg *apiConfig) processVideo(ctx context.Context, video database.Video, srcPath, mediaType string, reuse bool) (database.Video, error) {
+	previousKey := video.StorageKey
+	video.HLSURL = nil
+	video.SpriteURL = nil
+	video.SpriteVTTURL = nil
+	video.PreviewURL = nil
+
+	wm, err := cfg.watermarkFor(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't open processed video", err)
-		return
+		return video, err
 	}
-	defer fileProcessed.Close()
 
-	params := s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(fileKey),
-		Body:        fileProcessed,
-		ContentType: aws.String(mediaType),
+	// An identical upload may have been processed with other watermark
+	// settings, so its media is only reused while watermarks are off.
+	if reuse && video.ChecksumSHA256 != nil && cfg.watermark == nil {
+		existing, err := cfg.db.GetProcessedVideoByChecksum(video.UserID, *video.ChecksumSHA256, video.ID)
+		if err != nil {
+			return video, fmt.Errorf("couldn't look up duplicate videos: %w", err)
+		}
+		if existing != nil {
+			return cfg.reuseProcessedVideo(ctx, video, *existing, srcPath, previousKey)
+		}
 	}
 
-	_, err = cfg.s3Client.PutObject(context.Background(), &params)
+	start := time.Now()
+	spanCtx, span := tracer.Start(ctx, "video.probe")
+	metadata, err := probeVideo(spanCtx, srcPath)
+	endSpan(span, err)
+	recordStage(ctx, "probe", start)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
-		return
+		return video, fmt.Errorf("couldn't probe video: %w", err)
 	}
-
-	fileURL := cfg.getObjectURL(fileKey)
-	video.VideoURL = &fileURL
-	err = cfg.db.UpdateVideo(video)
+	video.Metadata = &metadata
+
+	orientation := videoOrientation(metadata.Width, metadata.Height)
+
+	onProgress := cfg.stageProgress(video.ID, progress.StageProcessing)
+	var fileProcessedPath string
+	start = time.Now()
+	if mediaType == "video/mp4" && wm == nil {
+		spanCtx, span = tracer.Start(ctx, "video.faststart")
+		fastStart, checkErr := isFastStart(srcPath)
+		if checkErr != nil {
+			log.Printf("Couldn't check the box layout of video %s, remuxing it: %v", video.ID, checkErr)
+		}
+		span.SetAttributes(attribute.Bool("video.faststart_skipped", fastStart))
+		if fastStart {
+			fileProcessedPath = srcPath
+			onProgress(100)
+		} else {
+			fileProcessedPath, err = processVideoForFastStart(spanCtx, srcPath, metadata.DurationSeconds, onProgress)
+		}
+		recordStage(ctx, "faststart", start)
+	} else {
+		spanCtx, span = tracer.Start(ctx, "video.transcode")
+		fileProcessedPath, err = transcodeVideoToMP4(spanCtx, srcPath, wm, metadata.DurationSeconds, onProgress)
+		recordStage(ctx, "transcode", start)
+		mediaType = "video/mp4"
+	}
+	endSpan(span, err)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return video, err
+	}
+	// The source is kept for retries; it's removed with the job's payload.
+	if fileProcessedPath != srcPath {
+		defer os.Remove(fileProcessedPath)
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
-}
+	fileKey := cfg.objectKey(video.UserID, orientation, getAssetPath(mediaType))
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command(
-		"ffprobe",
-		"-v",
-		"error",
-		"-print_format",
-		"json",
-		"-show_streams",
-		filePath)
+	// Objects stored from here on are only referenced once the video row is
+	// swapped over, so they're removed if that doesn't happen.
+	var undo rollback
+	defer undo.run()
 
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
+	fileProcessed, err := os.Open(fileProcessedPath)
+	if err != nil {
+		return video, fmt.Errorf("couldn't open processed video: %w", err)
+	}
+	defer fileProcessed.Close()
 
-	err := cmd.Run()
+	fileInfo, err := fileProcessed.Stat()
 	if err != nil {
-		return "", err
+		return video, fmt.Errorf("couldn't stat processed video: %w", err)
 	}
+	// Reads run ahead of the upload by the parts being sent, so this is
+	// close to but not exactly what has reached storage.
+	onStored := cfg.stageProgress(video.ID, progress.StageStoring)
+	body := &progressReader{r: fileProcessed, report: func(read int64) {
+		onStored(float64(read) / float64(fileInfo.Size()) * 100)
+	}}
+
+	hasher := sha256.New()
+	start = time.Now()
+	spanCtx, span = tracer.Start(ctx, "storage.put", trace.WithAttributes(attribute.String("storage.key", fileKey)))
+	err = cfg.videoStorage.Put(spanCtx, fileKey, io.TeeReader(body, hasher), mediaType)
+	endSpan(span, err)
+	recordStage(ctx, "upload", start)
+	if err != nil {
+		return video, fmt.Errorf("error uploading video to storage: %w", err)
+	}
+	undo.add(cfg.deleteVideoObject(fileKey))
 
-	var videoInfo struct {
-		Streams []struct {
-			Width  int `json:"width,omitempty"`
-			Height int `json:"height,omitempty"`
-		} `json:"streams"`
+	fileURL := cfg.videoStorage.URL(fileKey)
+	bucket := cfg.videoStorage.Bucket()
+	video.VideoURL = &fileURL
+	video.StorageBucket = &bucket
+	video.StorageKey = &fileKey
+	contentSHA256 := hexDigest(hasher)
+	video.ContentSHA256 = &contentSHA256
+
+	if video.ThumbnailURL == nil && cfg.autoThumbnailEnabled {
+		start = time.Now()
+		err = cfg.generateThumbnail(ctx, &video, fileProcessedPath)
+		recordStage(ctx, "thumbnail", start)
+		if err != nil {
+			log.Printf("Couldn't generate thumbnail for video %s: %v", video.ID, err)
+		} else {
+			thumbnailed := video
+			undo.add(func() { cfg.deleteThumbnail(context.Background(), thumbnailed) })
+		}
 	}
 
-	err = json.Unmarshal(stdout.Bytes(), &videoInfo)
-	if err != nil {
-		return "", fmt.Errorf("Couldn't parse ffprobe output: %v", err)
+	if cfg.spritesEnabled {
+		start = time.Now()
+		spanCtx, span = tracer.Start(ctx, "video.sprites")
+		err = cfg.generateAndUploadSprites(spanCtx, &video, fileProcessedPath)
+		endSpan(span, err)
+		recordStage(ctx, "sprites", start)
+		if err != nil {
+			log.Printf("Couldn't generate sprites for video %s: %v", video.ID, err)
+		}
+	}
+
+	if cfg.previewsEnabled {
+		start = time.Now()
+		spanCtx, span = tracer.Start(ctx, "video.preview")
+		err = cfg.generateAndUploadPreview(spanCtx, &video, fileProcessedPath)
+		endSpan(span, err)
+		recordStage(ctx, "preview", start)
+		if err != nil {
+			log.Printf("Couldn't generate preview clip for video %s: %v", video.ID, err)
+		}
 	}
 
-	if len(videoInfo.Streams) == 0 {
-		return "", errors.New("No video streams found")
+	// Like sprites and previews, HLS is best-effort: players fall back to the
+	// MP4. The playlist of the media being replaced is dropped either way, as
+	// its segments may have been partly overwritten.
+	if cfg.hlsEnabled {
+		video.HLSURL = nil
+		start = time.Now()
+		spanCtx, span = tracer.Start(ctx, "video.hls")
+		hlsDir, err := generateHLS(spanCtx, fileProcessedPath, metadata)
+		endSpan(span, err)
+		recordStage(ctx, "hls", start)
+		if err != nil {
+			log.Printf("Couldn't generate HLS renditions for video %s: %v", video.ID, err)
+		} else {
+			defer os.RemoveAll(hlsDir)
+
+			start = time.Now()
+			manifestKey, err := cfg.uploadHLS(ctx, video, hlsDir)
+			recordStage(ctx, "hls_upload", start)
+			if err != nil {
+				log.Printf("Couldn't upload HLS renditions for video %s: %v", video.ID, err)
+			} else {
+				hlsURL := cfg.videoStorage.URL(manifestKey)
+				video.HLSURL = &hlsURL
+			}
+		}
 	}
 
-	width := videoInfo.Streams[0].Width
-	height := videoInfo.Streams[0].Height
+	var renditions []database.CreateVideoRenditionParams
+	if cfg.renditionsEnabled {
+		start = time.Now()
+		spanCtx, span = tracer.Start(ctx, "video.renditions")
+		renditions, err = cfg.createRenditions(spanCtx, video, fileProcessedPath, metadata, orientation)
+		endSpan(span, err)
+		recordStage(ctx, "renditions", start)
+		if err != nil {
+			log.Printf("Couldn't generate renditions for video %s: %v", video.ID, err)
+		}
+		undo.add(func() { cfg.deleteRenditionObjects(context.Background(), video.ID, renditions) })
+	}
 
-	sizeRatio := float64(width) / float64(height)
-	if math.Abs(sizeRatio-1.777) < 0.2 {
-		return "16:9", nil
-	} else if math.Abs(sizeRatio-0.5625) < 0.2 {
-		return "9:16", nil
-	} else {
-		return "other", nil
+	video.ProcessingStatus = database.ProcessingStatusReady
+	video.ProcessingError = nil
+	cfg.holdForModeration(&video)
+	_, span = tracer.Start(ctx, "db.update")
+	err = cfg.db.SwapVideoMedia(video, previousKey)
+	endSpan(span, err)
+	if errors.Is(err, database.ErrVideoMediaChanged) {
+		// HLS, sprites and previews live at per-video keys that the other
+		// upload has overwritten too, so they're left to it.
+		return video, err
+	}
+	if err != nil {
+		return video, fmt.Errorf("couldn't update video: %w", err)
 	}
+	undo.commit()
 
+	err = cfg.replaceVideoRenditions(ctx, video.ID, renditions)
+	if err != nil {
+		// The video keeps its previous renditions, so the new ones are
+		// unreferenced.
+		log.Printf("Couldn't replace renditions of video %s: %v", video.ID, err)
+		cfg.deleteRenditionObjects(context.Background(), video.ID, renditions)
+	}
+
+	return video, nil
 }
 
-func processVideoForFastStart(filepath string) (string, error) {
+// processVideoForFastStart moves the moov atom of an MP4 to the front. Its
+// progress is reported through onProgress, given the video's duration.
+func processVideoForFastStart(ctx context.Context, filepath string, durationSeconds float64, onProgress func(percent float64)) (string, error) {
 	newPath := filepath + ".processing"
 
-	cmd := exec.Command(
-		"ffmpeg",
+	cmd := exec.CommandContext(
+		ctx,
+		ffmpegBinary,
+		"-progress",
+		"pipe:1",
+		"-nostats",
 		"-i",
 		filepath,
 		"-c",
@@ -206,9 +464,11 @@ func processVideoForFastStart(filepath string) (string, error) {
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
+	cmd.Stdout = &ffmpegProgress{durationSeconds: durationSeconds, report: onProgress}
 
-	err := cmd.Run()
+	err := runLimited(ctx, cmd)
 	if err != nil {
+		os.Remove(newPath)
 		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
 	}
 
@@ -223,3 +483,45 @@ func processVideoForFastStart(filepath string) (string, error) {
 
 	return newPath, nil
 }
+
+// transcodeVideoToMP4 re-encodes a video as H.264/AAC in an MP4, overlaying
+// wm unless it's nil. Its progress is reported through onProgress, given the
+// video's duration.
+func transcodeVideoToMP4(ctx context.Context, filepath string, wm *watermark, durationSeconds float64, onProgress func(percent float64)) (string, error) {
+	newPath := filepath + ".processing"
+
+	var stderr bytes.Buffer
+	err := encodeWithFallback(ctx, func(encoder videoEncoder) *exec.Cmd {
+		args := append([]string{"-y", "-progress", "pipe:1", "-nostats"}, encoder.inputArgs...)
+		args = append(args, "-i", filepath)
+		if wm != nil {
+			args = append(args, "-i", wm.path, "-filter_complex", wm.filterGraph(encoder), "-map", "[vout]", "-map", "0:a?")
+		} else if filter := encoder.filter(""); filter != "" {
+			args = append(args, "-vf", filter)
+		}
+		args = append(args, "-c:v", encoder.name)
+		args = append(args, encoder.options...)
+		args = append(args, "-c:a", "aac", "-movflags", "faststart", "-f", "mp4", newPath)
+
+		cmd := exec.CommandContext(ctx, ffmpegBinary, args...)
+		stderr.Reset()
+		cmd.Stderr = &stderr
+		cmd.Stdout = &ffmpegProgress{durationSeconds: durationSeconds, report: onProgress}
+		return cmd
+	})
+	if err != nil {
+		os.Remove(newPath)
+		return "", fmt.Errorf("error transcoding video: %s, %v", stderr.String(), err)
+	}
+
+	fileInfo, err := os.Stat(newPath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat transcoded video: %v", err)
+	}
+
+	if fileInfo.Size() < 1 {
+		return "", errors.New("transcoded video is empty")
+	}
+
+	return newPath, nil
+}
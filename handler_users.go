@@ -8,6 +8,10 @@ import (
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+//openapi:summary Sign up
+//openapi:tags users
+//openapi:body parameters
+//openapi:response 201 database.User
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
@@ -36,11 +40,14 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	user, err := cfg.db.CreateUser(database.CreateUserParams{
 		Email:    params.Email,
 		Password: hashedPassword,
+		Role:     string(cfg.roleForNewUser(params.Email)),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
+	auditUser(r, user.ID)
+	auditResource(r, user.ID.String())
 
 	respondWithJSON(w, http.StatusCreated, user)
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxBatchOperations bounds the operations of a batch, which run one after
+// another while the client waits.
+const maxBatchOperations = 100
+
+const (
+	batchOpDelete        = "delete"
+	batchOpSetVisibility = "set_visibility"
+	batchOpAddTag        = "add_tag"
+)
+
+// batchOperations are the audit actions of the operations, the same as those
+// of the endpoints they stand in for.
+var batchOperations = map[string]string{
+	batchOpDelete:        "video.delete",
+	batchOpSetVisibility: "video.update",
+	batchOpAddTag:        "video.tag_add",
+}
+
+type batchOperation struct {
+	Op      string    `json:"op"`
+	VideoID uuid.UUID `json:"video_id"`
+	// Visibility is the visibility set_visibility sets.
+	Visibility database.Visibility `json:"visibility,omitempty"`
+	// Tag is the tag add_tag adds.
+	Tag string `json:"tag,omitempty"`
+}
+
+type batchResult struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Op      string    `json:"op"`
+	// Status is the status the operation's own endpoint would have
+	// responded with.
+	Status int         `json:"status"`
+	Error  *batchError `json:"error,omitempty"`
+}
+
+type batchError struct {
+	Code    errorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// handlerVideoBatch applies a list of operations to the user's videos, so
+// clients acting on many videos at once don't need a request for each. Each
+// operation succeeds or fails on its own, and the results are returned in
+// the order of the operations.
+//
+//openapi:summary Apply operations to several videos
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:body parameters
+//openapi:response 200 response
+func (cfg *apiConfig) handlerVideoBatch(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Operations []batchOperation `json:"operations"`
+	}
+	type response struct {
+		Results []batchResult `json:"results"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Operations) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No operations given", nil)
+		return
+	}
+	if len(params.Operations) > maxBatchOperations {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A batch can have at most %d operations", maxBatchOperations), nil)
+		return
+	}
+	for i, op := range params.Operations {
+		if _, ok := batchOperations[op.Op]; !ok {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Operation %d has an unknown op %q", i, op.Op), nil)
+			return
+		}
+	}
+
+	results := make([]batchResult, len(params.Operations))
+	for i, op := range params.Operations {
+		result := batchResult{VideoID: op.VideoID, Op: op.Op}
+		status, message, err := cfg.runBatchOperation(r, userID, op)
+		result.Status = status
+		if status >= http.StatusBadRequest {
+			if status >= http.StatusInternalServerError {
+				log.Printf("Batch %s of video %s failed: %v", op.Op, op.VideoID, err)
+			}
+			result.Error = &batchError{Code: statusErrorCode(status), Message: message}
+		}
+		cfg.recordAudit(r, batchOperations[op.Op], &userID, op.VideoID.String(), status)
+		results[i] = result
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Results: results})
+}
+
+// runBatchOperation applies an operation of a batch, returning its status,
+// with a message and any error behind it if it failed.
+func (cfg *apiConfig) runBatchOperation(r *http.Request, userID uuid.UUID, op batchOperation) (int, string, error) {
+	video, err := cfg.db.GetVideo(op.VideoID)
+	if err != nil {
+		return http.StatusInternalServerError, "Couldn't get video", err
+	}
+	if video.ID == uuid.Nil {
+		return http.StatusNotFound, "Video not found", nil
+	}
+	if video.UserID != userID {
+		return http.StatusForbidden, "Insufficient rights to video", nil
+	}
+
+	switch op.Op {
+	case batchOpDelete:
+		err = cfg.deleteVideo(r.Context(), video)
+		if err != nil {
+			return http.StatusInternalServerError, "Couldn't delete video", err
+		}
+		return http.StatusNoContent, "", nil
+
+	case batchOpSetVisibility:
+		if !op.Visibility.Valid() {
+			return http.StatusBadRequest, "Visibility must be private, unlisted or public", nil
+		}
+		err = cfg.db.UpdateVideoVisibility(video.ID, op.Visibility)
+		if err != nil {
+			return http.StatusInternalServerError, "Couldn't update video", err
+		}
+		return http.StatusOK, "", nil
+
+	case batchOpAddTag:
+		tag, err := normalizeTag(op.Tag)
+		if err != nil {
+			return http.StatusBadRequest, err.Error(), err
+		}
+		_, err = cfg.addVideoTag(video, tag)
+		if errors.Is(err, errTooManyTags) {
+			return http.StatusBadRequest, "Video has too many tags", err
+		}
+		if err != nil {
+			return http.StatusInternalServerError, "Couldn't add tag", err
+		}
+		return http.StatusOK, "", nil
+	}
+	return http.StatusBadRequest, fmt.Sprintf("Unknown op %q", op.Op), nil
+}
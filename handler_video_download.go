@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxFilenameLength bounds the length in bytes of stored upload file names,
+// which most file systems don't allow to be longer.
+const maxFilenameLength = 255
+
+// cleanFilename makes a file name sent by a client safe to store and to
+// send back in a Content-Disposition header: any directories and control
+// characters are dropped, and long names are cut.
+func cleanFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// downloadFilename names the file a video is downloaded as: the file it was
+// uploaded as, or its title for videos uploaded without a name. Stored videos
+// are always MP4s, whatever was uploaded.
+func downloadFilename(video database.Video) string {
+	name := video.Title
+	if video.OriginalFilename != nil && *video.OriginalFilename != "" {
+		name = *video.OriginalFilename
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	name = cleanFilename(name)
+	if name == "" {
+		name = video.ID.String()
+	}
+	return name + ".mp4"
+}
+
+//openapi:summary Create a URL to download a video
+//openapi:tags videos
+//openapi:auth optional
+//openapi:query expires_in integer How long the URL is valid, in seconds
+//openapi:response 200 response
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		DownloadURL string    `json:"download_url"`
+		Filename    string    `json:"filename"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	expiry, err := cfg.presignExpiryFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid expiry", err)
+		return
+	}
+
+	userID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !canViewVideo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if cfg.videoStorageName != "s3" {
+		respondWithError(w, http.StatusNotImplemented, "Downloads require S3 video storage", nil)
+		return
+	}
+	if video.StorageKey == nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoNotProcessed, "Video hasn't been processed yet", nil, nil)
+		return
+	}
+	if !video.ArchiveStatus.Playable() {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Video is archived", nil, nil)
+		return
+	}
+
+	expiry = videoExpiry(video, expiry)
+	filename := downloadFilename(video)
+	downloadURL, err := cfg.s3Objects.PresignDownload(r.Context(), *video.StorageKey, filename, expiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign download", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		DownloadURL: downloadURL,
+		Filename:    filename,
+		ExpiresAt:   time.Now().UTC().Add(expiry),
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "video.mp4", "video.mp4"},
+		{"unix directories", "/home/user/video.mp4", "video.mp4"},
+		{"windows directories", `C:\Users\user\video.mp4`, "video.mp4"},
+		{"traversal", "../../etc/passwd", "passwd"},
+		{"trailing slash", "videos/", "videos"},
+		{"only slashes", "///", ""},
+		{"dot", ".", ""},
+		{"dot dot", "..", ""},
+		{"dot dot with spaces", " .. ", ""},
+		{"dot dot directory", `..\`, ""},
+		{"empty", "", ""},
+		{"whitespace", "   ", ""},
+		{"control characters", "vid\x00eo\r\n.mp4", "video.mp4"},
+		{"invalid UTF-8", "vid\xffeo.mp4", "video.mp4"},
+		{"unicode", "vidéo 日本語.mp4", "vidéo 日本語.mp4"},
+		{"surrounding spaces", "  video.mp4  ", "video.mp4"},
+		{"at the limit", strings.Repeat("a", maxFilenameLength), strings.Repeat("a", maxFilenameLength)},
+		{"over the limit", strings.Repeat("a", maxFilenameLength+10), strings.Repeat("a", maxFilenameLength)},
+		// 2-byte runes don't fit 255 bytes evenly, so the cut has to back
+		// off to a rune boundary.
+		{"multi-byte over the limit", strings.Repeat("é", 200), strings.Repeat("é", maxFilenameLength/2)},
+		{"cut leaves trailing space", strings.Repeat("a", maxFilenameLength-1) + "  b", strings.Repeat("a", maxFilenameLength-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cleanFilename(tt.in)
+			if got != tt.want {
+				t.Errorf("cleanFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
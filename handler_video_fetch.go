@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/safehttp"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const jobTypeFetchVideo = "fetch_video"
+
+// errFetchRejected is returned for a fetched video that can't be accepted,
+// which downloading it again won't change.
+var errFetchRejected = errors.New("fetched video was rejected")
+
+// fetchVideoPayload is a video to download from a URL and then process like
+// an upload. VideoID shares its JSON name with processVideoPayload, so a dead
+// fetch fails the video the same way.
+type fetchVideoPayload struct {
+	VideoID      uuid.UUID         `json:"video_id"`
+	URL          string            `json:"url"`
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// handlerVideoFetch queues a video's media to be downloaded from a URL,
+// e.g. to migrate videos from another host without downloading them first.
+// Once downloaded, the file is processed like an upload.
+//
+//openapi:summary Upload a video from a URL
+//openapi:tags uploads
+//openapi:body parameters
+//openapi:response 202 database.Video
+func (cfg *apiConfig) handlerVideoFetch(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL string `json:"url"`
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	_, err = safehttp.CheckURL(params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid url: %v", err), err)
+		return
+	}
+
+	err = cfg.db.UpdateVideoProcessingStatus(video.ID, database.ProcessingStatusPending, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.ProcessingStatus = database.ProcessingStatusPending
+	video.ProcessingError = nil
+
+	_, err = cfg.jobs.Enqueue(jobTypeFetchVideo, video.ID.String(), fetchVideoPayload{
+		VideoID:      video.ID,
+		URL:          params.URL,
+		TraceContext: injectTraceContext(r.Context()),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for fetching", err)
+		return
+	}
+	cfg.progress.Publish(video.ID, progress.Update{Stage: progress.StageQueued})
+
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+func (cfg *apiConfig) runFetchVideoJob(ctx context.Context, job database.Job) (err error) {
+	var payload fetchVideoPayload
+	err = json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("couldn't decode job payload: %w", err)
+	}
+
+	ctx, span := tracer.Start(extractTraceContext(ctx, payload.TraceContext), "video.fetch", trace.WithAttributes(
+		attribute.String("video.id", payload.VideoID.String()),
+		attribute.Int("job.attempt", job.Attempts),
+	))
+	defer func() { endSpan(span, err) }()
+
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		log.Printf("Video %s was deleted, skipping fetch", payload.VideoID)
+		return nil
+	}
+
+	source, err := cfg.fetchVideoSource(ctx, video.ID, payload.URL)
+	if errors.Is(err, errFetchRejected) {
+		// Downloading it again would only be rejected again.
+		cfg.failProcessVideoJob(job, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cfg.remoteWorkers {
+		source, err = cfg.stageUploadedSource(withVideoTags(ctx, video), video.ID, source)
+		if err != nil {
+			return fmt.Errorf("couldn't stage video for processing: %w", err)
+		}
+	}
+
+	// The video may have been edited or deleted while it was downloaded.
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil || video.ID == uuid.Nil {
+		cfg.cleanupProcessVideoSource(source)
+		if err != nil {
+			return fmt.Errorf("couldn't get video: %w", err)
+		}
+		return nil
+	}
+	_, err = cfg.enqueueVideoProcessing(ctx, video, source)
+	if err != nil {
+		cfg.cleanupProcessVideoSource(source)
+		return fmt.Errorf("couldn't queue video for processing: %w", err)
+	}
+	return nil
+}
+
+// fetchVideoSource downloads a video from rawURL into a temp file and checks
+// it like an upload. Downloads are capped at the maximum upload size, and
+// only made to public addresses unless URL_FETCH_ALLOW_PRIVATE is set. A
+// video that's rejected for what it is rather than for how the download went
+// returns errFetchRejected.
+func (cfg *apiConfig) fetchVideoSource(ctx context.Context, videoID uuid.UUID, rawURL string) (processVideoPayload, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return processVideoPayload{}, fmt.Errorf("couldn't create request: %w", err)
+	}
+	resp, err := cfg.fetchClient.Do(req)
+	if err != nil {
+		return processVideoPayload{}, fmt.Errorf("couldn't fetch video: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return processVideoPayload{}, fmt.Errorf("%w: source responded with %s", errFetchRejected, resp.Status)
+	}
+	if resp.ContentLength > cfg.maxVideoSize {
+		return processVideoPayload{}, fmt.Errorf("%w: video exceeds the maximum size of %d bytes", errFetchRejected, cfg.maxVideoSize)
+	}
+	mediaType := fetchedMediaType(resp)
+	if mediaType == "" {
+		return processVideoPayload{}, fmt.Errorf("%w: source isn't an MP4, MOV, WebM or MKV video", errFetchRejected)
+	}
+
+	// Named like uploads, so it's kept by temp file cleanup while the
+	// processing job it's handed to waits for it.
+	fileTmp, err := os.CreateTemp("", "tubely-upload.mp4")
+	if err != nil {
+		return processVideoPayload{}, fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer fileTmp.Close()
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(fileTmp.Name())
+		}
+	}()
+
+	body := &progressReader{
+		r:      io.LimitReader(resp.Body, cfg.maxVideoSize+1),
+		report: cfg.uploadProgress(videoID, max(resp.ContentLength, 0)),
+	}
+	checksum, err := hashingCopy(fileTmp, body)
+	if err != nil {
+		return processVideoPayload{}, fmt.Errorf("couldn't download video: %w", err)
+	}
+	if body.read > cfg.maxVideoSize {
+		return processVideoPayload{}, fmt.Errorf("%w: video exceeds the maximum size of %d bytes", errFetchRejected, cfg.maxVideoSize)
+	}
+
+	_, err = fileTmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return processVideoPayload{}, err
+	}
+	err = checkVideoContent(fileTmp, mediaType)
+	if err != nil {
+		return processVideoPayload{}, fmt.Errorf("%w: %w", errFetchRejected, err)
+	}
+	err = cfg.scanSourceFile(ctx, videoID, fileTmp.Name())
+	if errors.Is(err, errUploadInfected) {
+		return processVideoPayload{}, fmt.Errorf("%w: %w", errFetchRejected, err)
+	}
+	if err != nil {
+		return processVideoPayload{}, err
+	}
+	report, err := cfg.validateVideo(ctx, fileTmp.Name())
+	if err != nil {
+		return processVideoPayload{}, fmt.Errorf("couldn't validate video: %w", err)
+	}
+	if len(report.Issues) > 0 {
+		messages := make([]string, len(report.Issues))
+		for i, issue := range report.Issues {
+			messages[i] = issue.Message
+		}
+		return processVideoPayload{}, fmt.Errorf("%w: video failed validation: %s", errFetchRejected, strings.Join(messages, "; "))
+	}
+
+	keep = true
+	return processVideoPayload{
+		SourcePath: fileTmp.Name(),
+		MediaType:  mediaType,
+		Checksum:   checksum,
+		// The name is taken from where redirects ended up.
+		Filename: cleanFilename(path.Base(resp.Request.URL.Path)),
+		Scanned:  cfg.virusScanner != nil,
+	}, nil
+}
+
+// fetchedMediaType returns the media type of a downloaded video from its
+// Content-Type, or from the extension of its URL when hosts send a generic
+// type such as application/octet-stream. It's empty for unsupported videos.
+func fetchedMediaType(resp *http.Response) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil && supportedVideoTypes[mediaType] {
+		return mediaType
+	}
+	return importMediaTypes[strings.ToLower(path.Ext(resp.Request.URL.Path))]
+}
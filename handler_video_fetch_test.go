@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/safehttp"
+)
+
+// A fetched video that's rejected for what it is fails the video instead of
+// being downloaded again on every retry.
+func TestFetchRejectedVideoIsNotRetried(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+		},
+		{
+			name: "too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "video/mp4")
+				w.Write(make([]byte, 2048))
+			},
+		},
+		{
+			name: "not a video",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte("<html></html>"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+
+			cfg, userID := newRefreshTestConfig(t)
+			cfg.fetchClient = safehttp.NewClient(time.Minute, true)
+			cfg.maxVideoSize = 1024
+			cfg.progress = progress.NewTracker()
+			cfg.events = events.NewBroker()
+
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "fetched", UserID: userID})
+			if err != nil {
+				t.Fatalf("CreateVideo: %v", err)
+			}
+			payload, err := json.Marshal(fetchVideoPayload{VideoID: video.ID, URL: server.URL + "/video"})
+			if err != nil {
+				t.Fatalf("encoding payload: %v", err)
+			}
+
+			err = cfg.runFetchVideoJob(context.Background(), database.Job{Type: jobTypeFetchVideo, Payload: string(payload), Attempts: 1})
+			if err != nil {
+				t.Fatalf("runFetchVideoJob returned %v, want nil so the job isn't retried", err)
+			}
+			if requests != 1 {
+				t.Errorf("source requested %d times, want 1", requests)
+			}
+
+			video, err = cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			if video.ProcessingStatus != database.ProcessingStatusFailed {
+				t.Errorf("processing status = %q, want %q", video.ProcessingStatus, database.ProcessingStatusFailed)
+			}
+			if video.ProcessingError == nil {
+				t.Error("processing error wasn't recorded")
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
+//openapi:summary Create a video draft
+//openapi:tags videos
+//openapi:body parameters
+//openapi:response 201 database.Video
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
@@ -19,7 +25,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -39,10 +45,15 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	auditResource(r, video.ID.String())
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
+//openapi:summary Delete a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 204
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -56,7 +67,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -72,7 +83,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.deleteVideo(r.Context(), video)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
@@ -81,6 +92,27 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// deleteVideo moves a video to the trash, or purges it right away without a
+// retention window, since there's no trash to restore from then.
+func (cfg *apiConfig) deleteVideo(ctx context.Context, video database.Video) error {
+	var err error
+	if cfg.trashRetention <= 0 {
+		err = cfg.purgeVideo(ctx, video)
+	} else {
+		err = cfg.db.SoftDeleteVideo(video.ID)
+	}
+	if err != nil {
+		return err
+	}
+	cfg.publishEvent(video.UserID, eventVideoDeleted, video)
+	return nil
+}
+
+//openapi:summary Get a video
+//openapi:tags videos
+//openapi:auth optional
+//openapi:query expires_in integer How long signed URLs are valid, in seconds
+//openapi:response 200 database.Video
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -89,32 +121,288 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expiry, err := cfg.presignExpiryFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid expiry", err)
+		return
+	}
+
+	userID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil || !canViewVideo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	video.Captions, err = cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+	video.Tags, err = cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tags", err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, videoExpiry(video, expiry))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	cfg.recordVideoURLIssued(video)
 
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+//openapi:summary List videos
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:query limit integer How many videos to return
+//openapi:query cursor string Where to continue, from the X-Next-Cursor header of the previous page
+//openapi:query status string Only list videos with this processing status
+//openapi:query tag string Only list videos with this tag
+//openapi:query sort string created_at, or -created_at for newest first (the default)
+//openapi:query sign_urls boolean Whether to sign video URLs, true by default
+//openapi:response 200 []database.Video
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params, err := listVideosParamsFromRequest(r, userID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	sign, err := signURLsFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// Fetch one extra row to find out whether there is a next page.
+	limit := params.Limit
+	params.Limit++
+	videos, err := cfg.db.ListVideos(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[len(videos)-1]
+		w.Header().Set(nextCursorHeader, encodeVideoCursor(database.VideoCursor{
+			CreatedAt: last.CreatedAt,
+			ID:        last.ID,
+		}))
+	}
+
+	err = cfg.attachCaptions(videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+	err = cfg.attachTags(videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tags", err)
+		return
+	}
+
+	if sign {
+		for i, video := range videos {
+			videos[i], err = cfg.dbVideoToSignedVideo(r.Context(), video, videoExpiry(video, cfg.presignExpiry))
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+				return
+			}
+		}
+	}
 
 	respondWithJSON(w, http.StatusOK, videos)
 }
+
+//openapi:summary Get the processing status of a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 response
+func (cfg *apiConfig) handlerVideoStatusGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID               uuid.UUID                 `json:"id"`
+		ProcessingStatus database.ProcessingStatus `json:"processing_status"`
+		ProcessingError  *string                   `json:"processing_error,omitempty"`
+		VideoURL         *string                   `json:"video_url"`
+		Job              *database.Job             `json:"job,omitempty"`
+		Transcription    *database.Job             `json:"transcription,omitempty"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, videoExpiry(video, cfg.presignExpiry))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	job, err := cfg.db.GetLatestJob(video.ID.String())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing job", err)
+		return
+	}
+	transcription, err := cfg.db.GetLatestJob(transcriptionJobReference(video.ID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get transcription job", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		ID:               video.ID,
+		ProcessingStatus: video.ProcessingStatus,
+		ProcessingError:  video.ProcessingError,
+		VideoURL:         video.VideoURL,
+		Job:              job,
+		Transcription:    transcription,
+	})
+}
+
+//openapi:summary Update a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:body parameters
+//openapi:response 200 database.Video
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility *database.Visibility `json:"visibility"`
+		PublishAt  nullableTime         `json:"publish_at"`
+		ExpiresAt  nullableTime         `json:"expires_at"`
+		// WatermarkDisabled takes effect the next time media is processed.
+		WatermarkDisabled *bool `json:"watermark_disabled"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Visibility != nil && !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	if params.Visibility != nil {
+		video.Visibility = *params.Visibility
+	}
+	if params.PublishAt.Set {
+		video.PublishAt = params.PublishAt.Value
+	}
+	if params.WatermarkDisabled != nil {
+		video.WatermarkDisabled = *params.WatermarkDisabled
+	}
+	if params.ExpiresAt.Set {
+		if params.ExpiresAt.Value != nil && !params.ExpiresAt.Value.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+			return
+		}
+		video.ExpiresAt = params.ExpiresAt.Value
+	}
+	if video.PublishAt != nil && video.ExpiresAt != nil && !video.ExpiresAt.After(*video.PublishAt) {
+		respondWithError(w, http.StatusBadRequest, "expires_at must be after publish_at", nil)
+		return
+	}
+	// Only the changed columns are written, so an edit can't revert media
+	// that processing swapped in since the video was read.
+	if params.Visibility != nil {
+		err = cfg.db.UpdateVideoVisibility(video.ID, video.Visibility)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	if params.PublishAt.Set || params.ExpiresAt.Set {
+		err = cfg.db.UpdateVideoSchedule(video.ID, video.PublishAt, video.ExpiresAt)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	if params.WatermarkDisabled != nil {
+		err = cfg.db.UpdateVideoWatermarkDisabled(video.ID, video.WatermarkDisabled)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultStatsDays is how many days of stats are returned by default,
+	// including today.
+	defaultStatsDays = 30
+	// maxStatsDays bounds the days of stats returned at once.
+	maxStatsDays = 366
+)
+
+// recordVideoEvent counts an event in the video's stats. Stats aren't worth
+// failing a request over, so errors are only logged.
+func (cfg *apiConfig) recordVideoEvent(video database.Video, event database.VideoEvent) {
+	err := cfg.db.RecordVideoEvent(video.ID, event, time.Now())
+	if err != nil {
+		log.Printf("Couldn't record %s of video %s: %v", event, video.ID, err)
+	}
+}
+
+// recordVideoURLIssued counts a playable URL of the video being handed out,
+// if the video has one.
+func (cfg *apiConfig) recordVideoURLIssued(video database.Video) {
+	if video.VideoURL != nil {
+		cfg.recordVideoEvent(video, database.VideoEventURLIssued)
+	}
+}
+
+//openapi:summary Report a play of a video
+//openapi:tags videos
+//openapi:auth optional
+//openapi:response 204
+func (cfg *apiConfig) handlerVideoPlayRecord(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !canViewVideo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	err = cfg.db.RecordVideoEvent(video.ID, database.VideoEventPlay, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record play", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//openapi:summary Get the view counts of a video per day
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:query since string First day to count, as YYYY-MM-DD in UTC; 30 days ago by default
+//openapi:query until string Last day to count, as YYYY-MM-DD in UTC; today by default
+//openapi:response 200 response
+func (cfg *apiConfig) handlerVideoStats(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID    uuid.UUID                  `json:"video_id"`
+		Since      string                     `json:"since"`
+		Until      string                     `json:"until"`
+		URLsIssued int64                      `json:"urls_issued"`
+		Plays      int64                      `json:"plays"`
+		Days       []database.DailyVideoStats `json:"days"`
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	since, until, err := statsRangeFromRequest(r, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	days, err := cfg.db.GetDailyVideoStats(video.ID, since, until)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video stats", err)
+		return
+	}
+
+	resp := response{
+		VideoID: video.ID,
+		Since:   since.Format(time.DateOnly),
+		Until:   until.Format(time.DateOnly),
+		Days:    days,
+	}
+	for _, day := range days {
+		resp.URLsIssued += day.URLsIssued
+		resp.Plays += day.Plays
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// statsRangeFromRequest returns the UTC days stats are requested for with the
+// since and until query parameters.
+func statsRangeFromRequest(r *http.Request, now time.Time) (since, until time.Time, err error) {
+	query := r.URL.Query()
+	until = now.UTC().Truncate(24 * time.Hour)
+	if value := query.Get("until"); value != "" {
+		until, err = time.Parse(time.DateOnly, value)
+		if err != nil {
+			return since, until, errors.New("until must be a date like 2006-01-02")
+		}
+	}
+	since = until.AddDate(0, 0, -(defaultStatsDays - 1))
+	if value := query.Get("since"); value != "" {
+		since, err = time.Parse(time.DateOnly, value)
+		if err != nil {
+			return since, until, errors.New("since must be a date like 2006-01-02")
+		}
+	}
+
+	if since.After(until) {
+		return since, until, errors.New("since must not be after until")
+	}
+	if until.Sub(since) >= maxStatsDays*24*time.Hour {
+		return since, until, fmt.Errorf("stats can be requested for at most %d days at once", maxStatsDays)
+	}
+	return since, until, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// handlerVideoStream proxies a video's stored object from S3, so it can be
+// played through the API with the same access checks as fetching the video,
+// without handing out links to the bucket. Ranged reads are passed on to S3,
+// which lets players seek.
+//
+//openapi:summary Stream a video
+//openapi:tags videos
+//openapi:auth optional
+//openapi:header Range string Bytes of the video to return, e.g. bytes=0-1048575
+//openapi:header If-Range string ETag or Last-Modified of the video the range is only returned for
+//openapi:response 200 video/mp4
+//openapi:response 206 video/mp4
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !canViewVideo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	cfg.streamVideo(w, r, video)
+}
+
+// streamVideo proxies video's stored object to w once the caller has checked
+// the request may play it.
+func (cfg *apiConfig) streamVideo(w http.ResponseWriter, r *http.Request, video database.Video) {
+	if cfg.videoStorageName != "s3" {
+		respondWithError(w, http.StatusNotImplemented, "Streaming requires S3 video storage", nil)
+		return
+	}
+	if video.StorageKey == nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoNotProcessed, "Video hasn't been processed yet", nil, nil)
+		return
+	}
+	if !video.ArchiveStatus.Playable() {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Video is archived", nil, nil)
+		return
+	}
+
+	object, err := cfg.s3Objects.GetRange(r.Context(), *video.StorageKey, r.Header.Get("Range"), r.Header.Get("If-Range"))
+	if errors.Is(err, storage.ErrInvalidRange) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Range isn't satisfiable", err)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video file not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read video", err)
+		return
+	}
+	defer object.Body.Close()
+
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Length", strconv.FormatInt(object.ContentLength, 10))
+	if object.ContentType != "" {
+		header.Set("Content-Type", object.ContentType)
+	}
+	if object.ETag != "" {
+		header.Set("ETag", object.ETag)
+	}
+	if !object.LastModified.IsZero() {
+		header.Set("Last-Modified", object.LastModified.UTC().Format(http.TimeFormat))
+	}
+	// Shared caches mustn't serve videos that are only visible to some.
+	if video.Visibility != database.VisibilityPublic {
+		header.Set("Cache-Control", "private")
+	}
+
+	status := http.StatusOK
+	if object.ContentRange != "" {
+		header.Set("Content-Range", object.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	_, err = io.Copy(w, object.Body)
+	if err != nil && r.Context().Err() == nil {
+		log.Printf("Couldn't stream video %s: %v", video.ID, err)
+	}
+}
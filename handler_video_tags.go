@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxVideoTags = 20
+	maxTagLength = 50
+)
+
+// normalizeTag lowercases a tag and checks that it only has letters, digits
+// and the separators - _ . and :, so a prefix like category: can group tags.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", errors.New("tags can't be empty")
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d bytes", tag, maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.:", r) {
+			return "", fmt.Errorf("tag %q can only have letters, digits, -, _, . and :", tag)
+		}
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes a set of tags, dropping duplicates and sorting
+// them.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+	if len(normalized) > maxVideoTags {
+		return nil, errTooManyTags
+	}
+	return normalized, nil
+}
+
+// attachTags loads the tags of videos for a response.
+func (cfg *apiConfig) attachTags(videos []database.Video) error {
+	ids := make([]uuid.UUID, len(videos))
+	for i, video := range videos {
+		ids[i] = video.ID
+	}
+	tags, err := cfg.db.GetTagsForVideos(ids)
+	if err != nil {
+		return err
+	}
+	for i := range videos {
+		videos[i].Tags = tags[videos[i].ID]
+	}
+	return nil
+}
+
+type videoTagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+//openapi:summary Replace the tags of a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:body parameters
+//openapi:response 200 videoTagsResponse
+func (cfg *apiConfig) handlerVideoTagsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	tags, err := normalizeTags(params.Tags)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.SetVideoTags(video.ID, tags)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videoTagsResponse{Tags: tags})
+}
+
+//openapi:summary Add a tag to a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 videoTagsResponse
+func (cfg *apiConfig) handlerVideoTagAdd(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	tags, err := cfg.addVideoTag(video, tag)
+	if errors.Is(err, errTooManyTags) {
+		respondWithError(w, http.StatusBadRequest, "Video has too many tags", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add tag", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videoTagsResponse{Tags: tags})
+}
+
+var errTooManyTags = fmt.Errorf("videos can have at most %d tags", maxVideoTags)
+
+// addVideoTag adds a normalized tag to a video unless it has it already, and
+// returns the video's tags.
+func (cfg *apiConfig) addVideoTag(video database.Video, tag string) ([]string, error) {
+	tags, err := cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get tags: %w", err)
+	}
+	if slices.Contains(tags, tag) {
+		return tags, nil
+	}
+	if len(tags) >= maxVideoTags {
+		return nil, errTooManyTags
+	}
+	err = cfg.db.AddVideoTag(video.ID, tag)
+	if err != nil {
+		return nil, err
+	}
+	tags = append(tags, tag)
+	slices.Sort(tags)
+	return tags, nil
+}
+
+//openapi:summary Remove a tag from a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 204
+func (cfg *apiConfig) handlerVideoTagRemove(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.RemoveVideoTag(video.ID, tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove tag", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", in: "music", want: "music"},
+		{name: "lowercased", in: "Music", want: "music"},
+		{name: "trimmed", in: "  music\t", want: "music"},
+		{name: "separators", in: "category:lo-fi_2.0", want: "category:lo-fi_2.0"},
+		{name: "unicode letters", in: "Café", want: "café"},
+		{name: "non-latin script", in: "日本語", want: "日本語"},
+		{name: "unicode digits", in: "٣", want: "٣"},
+		{name: "empty", in: "", wantErr: true},
+		{name: "whitespace", in: "   ", wantErr: true},
+		{name: "inner space", in: "lo fi", wantErr: true},
+		{name: "slash", in: "a/b", wantErr: true},
+		{name: "backslash", in: `a\b`, wantErr: true},
+		{name: "emoji", in: "🎵", wantErr: true},
+		{name: "control character", in: "a\x00b", wantErr: true},
+		{name: "at the limit", in: strings.Repeat("a", maxTagLength), want: strings.Repeat("a", maxTagLength)},
+		{name: "over the limit", in: strings.Repeat("a", maxTagLength+1), wantErr: true},
+		// The limit is in bytes, not letters.
+		{name: "multi-byte over the limit", in: strings.Repeat("é", maxTagLength/2+1), wantErr: true},
+		// Lowercasing can make a tag longer, and the limit applies after.
+		{name: "longer once lowercased", in: strings.Repeat("a", maxTagLength-2) + "Ⱥ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTag(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("normalizeTag(%q) = %q, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("normalizeTag(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTagsDeduplicatesAndSorts(t *testing.T) {
+	got, err := normalizeTags([]string{"Rock", "jazz", "rock ", "Jazz"})
+	if err != nil {
+		t.Fatalf("normalizeTags: %v", err)
+	}
+	if strings.Join(got, ",") != "jazz,rock" {
+		t.Errorf("normalizeTags = %q, want [jazz rock]", got)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/safehttp"
+	"github.com/google/uuid"
+)
+
+//openapi:summary Register a webhook
+//openapi:tags webhooks
+//openapi:auth bearer
+//openapi:body parameters
+//openapi:response 201 response
+func (cfg *apiConfig) handlerWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL string `json:"url"`
+	}
+	type response struct {
+		database.Webhook
+		Secret string `json:"secret"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	callbackURL, err := safehttp.CheckURL(params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid webhook url: %v", err), err)
+		return
+	}
+	// Host names are checked when deliveries connect, since what they
+	// resolve to can change; addresses can be refused right away.
+	if addr, err := netip.ParseAddr(callbackURL.Hostname()); err == nil && !cfg.webhookAllowPrivate && !safehttp.PublicAddress(addr) {
+		respondWithError(w, http.StatusBadRequest, "Webhook url must be on the public internet", nil)
+		return
+	}
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook secret", err)
+		return
+	}
+
+	webhook, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID: userID,
+		URL:    callbackURL.String(),
+		Secret: secret,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+	auditResource(r, webhook.ID.String())
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Webhook: webhook,
+		Secret:  webhook.Secret,
+	})
+}
+
+//openapi:summary List webhooks
+//openapi:tags webhooks
+//openapi:auth bearer
+//openapi:response 200 []database.Webhook
+func (cfg *apiConfig) handlerWebhooksRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhooks)
+}
+
+//openapi:summary Delete a webhook
+//openapi:tags webhooks
+//openapi:auth bearer
+//openapi:response 204
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	webhookIDString := r.PathValue("webhookID")
+	webhookID, err := uuid.Parse(webhookIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	webhook, err := cfg.db.GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get webhook", err)
+		return
+	}
+	if webhook.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't delete this webhook", nil)
+		return
+	}
+
+	err = cfg.db.DeleteWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
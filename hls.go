@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type hlsRendition struct {
+	Height  int
+	Bitrate string
+}
+
+// hlsLadder is the bitrate ladder produced for adaptive playback, from the
+// highest to the lowest quality.
+var hlsLadder = []hlsRendition{
+	{Height: 1080, Bitrate: "5000k"},
+	{Height: 720, Bitrate: "2800k"},
+	{Height: 480, Bitrate: "1400k"},
+}
+
+const hlsMasterPlaylist = "master.m3u8"
+
+// hlsRenditions returns the steps of the HLS ladder a video displayed at
+// width x height is transcoded to: those up to its own resolution, sized to
+// its orientation. A video below the lowest step gets a single rendition at
+// its own size rather than being upscaled.
+func hlsRenditions(width, height int) []renditionFile {
+	var files []renditionFile
+	for _, step := range hlsLadder {
+		if step.Height > min(width, height) {
+			continue
+		}
+		w, h := renditionDimensions(width, height, step.Height)
+		files = append(files, renditionFile{nominal: step.Height, width: w, height: h, bitrate: step.Bitrate})
+	}
+	if len(files) == 0 {
+		nominal := min(width, height) &^ 1
+		w, h := renditionDimensions(width, height, nominal)
+		files = append(files, renditionFile{nominal: nominal, width: w, height: h, bitrate: hlsLadder[len(hlsLadder)-1].Bitrate})
+	}
+	return files
+}
+
+// generateHLS transcodes the video at filePath into the steps of the HLS
+// ladder that fit it, inside a new temp directory, and returns it. The caller
+// must remove the directory.
+func generateHLS(ctx context.Context, filePath string, metadata database.VideoMetadata) (string, error) {
+	if min(metadata.Width, metadata.Height) < 2 {
+		return "", fmt.Errorf("video resolution %dx%d is too small for HLS", metadata.Width, metadata.Height)
+	}
+	files := hlsRenditions(metadata.Width, metadata.Height)
+	hasAudio := metadata.AudioCodec != ""
+
+	outDir, err := os.MkdirTemp("", "tubely-hls")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create HLS directory: %w", err)
+	}
+
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(files))
+	for i := range files {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	split := filter.String()
+
+	var stderr bytes.Buffer
+	err = encodeWithFallback(ctx, func(encoder videoEncoder) *exec.Cmd {
+		graph := split
+		for i, file := range files {
+			graph += fmt.Sprintf(";[v%d]%s[v%dout]", i, encoder.filter(fmt.Sprintf("scale=%d:%d", file.width, file.height)), i)
+		}
+
+		args := append(append([]string{"-y"}, encoder.inputArgs...), "-i", filePath, "-filter_complex", graph)
+		var streamMap []string
+		for i, file := range files {
+			args = append(args,
+				"-map", fmt.Sprintf("[v%dout]", i),
+				fmt.Sprintf("-c:v:%d", i), encoder.name,
+				fmt.Sprintf("-b:v:%d", i), file.bitrate,
+			)
+			entry := fmt.Sprintf("v:%d", i)
+			if hasAudio {
+				args = append(args, "-map", "0:a:0", fmt.Sprintf("-c:a:%d", i), "aac")
+				entry += fmt.Sprintf(",a:%d", i)
+			}
+			streamMap = append(streamMap, fmt.Sprintf("%s,name:%dp", entry, file.nominal))
+		}
+		args = append(args, encoder.options...)
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", "6",
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(outDir, "%v", "segment_%03d.ts"),
+			"-master_pl_name", hlsMasterPlaylist,
+			"-var_stream_map", strings.Join(streamMap, " "),
+			filepath.Join(outDir, "%v", "playlist.m3u8"),
+		)
+
+		cmd := exec.CommandContext(ctx, ffmpegBinary, args...)
+		stderr.Reset()
+		cmd.Stderr = &stderr
+		return cmd
+	})
+	if err != nil {
+		os.RemoveAll(outDir)
+		return "", fmt.Errorf("error generating HLS renditions: %s, %v", stderr.String(), err)
+	}
+
+	return outDir, nil
+}
+
+// uploadHLS uploads every file in dir under the per-video HLS prefix and
+// returns the key of the master playlist.
+func (cfg *apiConfig) uploadHLS(ctx context.Context, video database.Video, dir string) (string, error) {
+	prefix := cfg.hlsPrefix(video)
+
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		err = cfg.videoStorage.Put(ctx, path.Join(prefix, filepath.ToSlash(rel)), file, hlsContentType(filePath))
+		if err != nil {
+			return fmt.Errorf("couldn't upload %s: %w", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(prefix, hlsMasterPlaylist), nil
+}
+
+func (cfg *apiConfig) hlsPrefix(video database.Video) string {
+	return cfg.objectKey(video.UserID, aspectHLS, video.ID.String())
+}
+
+func hlsContentType(filePath string) string {
+	switch filepath.Ext(filePath) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	default:
+		return "application/octet-stream"
+	}
+}
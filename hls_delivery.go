@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// hlsPlaylistRoute serves HLS playlists when video URLs are signed. Segments
+// are referred to by relative paths that carry no signature, so playlists
+// are rewritten on the way out to point at signed URLs instead.
+const hlsPlaylistRoute = "/api/v1/hls/"
+
+// maxHLSPlaylistSize bounds how much of a stored playlist is read. Even long
+// videos have playlists of a few hundred kilobytes.
+const maxHLSPlaylistSize = 4 << 20
+
+var (
+	errInvalidHLSSignature = errors.New("invalid or expired HLS playlist signature")
+	// hlsURIAttribute matches the URI attribute of tags like EXT-X-MEDIA.
+	hlsURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+)
+
+// signedHLSPlaylistURL returns the URL the playlist at key is served from
+// until expires.
+func (cfg *apiConfig) signedHLSPlaylistURL(key string, expires time.Time) string {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{
+		"expires":   {unix},
+		"signature": {cfg.signHLSPlaylist(key, unix)},
+	}
+	return hlsPlaylistRoute + key + "?" + query.Encode()
+}
+
+func (cfg *apiConfig) signHLSPlaylist(key, expires string) string {
+	mac := hmac.New(sha256.New, cfg.hlsSigningKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkHLSPlaylistSignature returns when a signed playlist URL for key
+// expires, or errInvalidHLSSignature if it was tampered with or has expired.
+func (cfg *apiConfig) checkHLSPlaylistSignature(key string, query url.Values, now time.Time) (time.Time, error) {
+	if len(cfg.hlsSigningKey) == 0 {
+		return time.Time{}, errInvalidHLSSignature
+	}
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return time.Time{}, errInvalidHLSSignature
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(query.Get("signature"))
+	if err != nil {
+		return time.Time{}, errInvalidHLSSignature
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(cfg.signHLSPlaylist(key, expires))
+	if !hmac.Equal(signature, expected) {
+		return time.Time{}, errInvalidHLSSignature
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// handlerVideoHLSPlaylist serves a stored HLS playlist through a signed URL
+// handed out with the video, rewriting what it refers to into signed URLs
+// that expire with it.
+//
+//openapi:summary Get a signed HLS playlist
+//openapi:tags videos
+//openapi:query expires integer When the URL expires, as a Unix time
+//openapi:query signature string
+//openapi:response 200 application/vnd.apple.mpegurl
+func (cfg *apiConfig) handlerVideoHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if path.Ext(key) != ".m3u8" {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+	expires, err := cfg.checkHLSPlaylistSignature(key, r.URL.Query(), time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired playlist URL", err)
+		return
+	}
+
+	object, err := cfg.videoStorage.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read playlist", err)
+		return
+	}
+	defer object.Close()
+	playlist, err := io.ReadAll(io.LimitReader(object, maxHLSPlaylistSize))
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read playlist", err)
+		return
+	}
+
+	rewritten, err := cfg.signHLSPlaylistURIs(r.Context(), key, playlist, expires)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign playlist", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(rewritten)
+}
+
+// signHLSPlaylistURIs rewrites the URIs in the playlist stored at key, which
+// are relative to it, into signed URLs valid until expires: nested playlists
+// are served through hlsPlaylistRoute again, and segments are signed the way
+// the delivery mode signs objects.
+func (cfg *apiConfig) signHLSPlaylistURIs(ctx context.Context, key string, playlist []byte, expires time.Time) ([]byte, error) {
+	dir := path.Dir(key)
+	sign := func(uri string) (string, error) {
+		if uri == "" || strings.Contains(uri, "://") || strings.HasPrefix(uri, "/") {
+			return uri, nil
+		}
+		refKey := path.Join(dir, uri)
+		if path.Ext(refKey) == ".m3u8" {
+			return cfg.signedHLSPlaylistURL(refKey, expires), nil
+		}
+		return cfg.signedURL(ctx, cfg.videoStorage, refKey, time.Until(expires))
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			signed, err := sign(line)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't sign %s: %w", line, err)
+			}
+			line = signed
+		} else if match := hlsURIAttribute.FindStringSubmatchIndex(line); match != nil {
+			signed, err := sign(line[match[2]:match[3]])
+			if err != nil {
+				return nil, fmt.Errorf("couldn't sign %s: %w", line[match[2]:match[3]], err)
+			}
+			line = line[:match[2]] + signed + line[match[3]:]
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), scanner.Err()
+}
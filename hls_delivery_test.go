@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// With signed video URLs, the HLS playlist is served through the API, which
+// points the variant playlists back at itself and signs every segment.
+func TestSignedHLSPlaylists(t *testing.T) {
+	videoStorage := storage.NewLocal(t.TempDir(), localAssetsURL("8091"))
+	videoStorage.SetSigningKey([]byte("test-signing-key"))
+	cfg := &apiConfig{
+		videoDelivery:     videoDeliveryPublic,
+		videoStorage:      videoStorage,
+		thumbnailStorage:  videoStorage,
+		signedLocalAssets: true,
+		hlsSigningKey:     []byte("test-hls-key"),
+	}
+
+	ctx := context.Background()
+	put := func(key, body string) {
+		err := videoStorage.Put(ctx, key, strings.NewReader(body), "application/vnd.apple.mpegurl")
+		if err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	put("hls/a/master.m3u8", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=5000000\n720p/playlist.m3u8\n")
+	put("hls/a/720p/playlist.m3u8", "#EXTM3U\n#EXTINF:6.0,\nsegment_000.ts\n#EXT-X-ENDLIST\n")
+
+	hlsURL := videoStorage.URL("hls/a/master.m3u8")
+	video, err := cfg.dbVideoToSignedVideo(ctx, database.Video{ID: uuid.New(), HLSURL: &hlsURL}, time.Hour)
+	if err != nil {
+		t.Fatalf("dbVideoToSignedVideo: %v", err)
+	}
+	if video.HLSURL == nil || !strings.HasPrefix(*video.HLSURL, hlsPlaylistRoute+"hls/a/master.m3u8?") {
+		t.Fatalf("hls_url: got %v, want a signed playlist URL", video.HLSURL)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/hls/{key...}", cfg.handlerVideoHLSPlaylist)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get(*video.HLSURL)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get master playlist: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	variantURL := lines[len(lines)-1]
+	if !strings.HasPrefix(variantURL, hlsPlaylistRoute+"hls/a/720p/playlist.m3u8?") {
+		t.Fatalf("variant playlist: got %q, want a signed playlist URL", variantURL)
+	}
+
+	rec = get(variantURL)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get variant playlist: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	segmentURL := strings.Split(rec.Body.String(), "\n")[2]
+	if !strings.HasPrefix(segmentURL, videoStorage.URL("hls/a/720p/segment_000.ts")+"?") || !strings.Contains(segmentURL, "signature=") {
+		t.Errorf("segment: got %q, want a signed asset URL", segmentURL)
+	}
+
+	tampered := strings.Replace(*video.HLSURL, "master.m3u8", "720p/playlist.m3u8", 1)
+	if rec := get(tampered); rec.Code != http.StatusForbidden {
+		t.Errorf("get playlist with another playlist's signature: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
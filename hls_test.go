@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The HLS ladder never upscales and keeps portrait videos upright.
+func TestHLSRenditions(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          [][2]int
+	}{
+		{name: "1080p landscape", width: 1920, height: 1080, want: [][2]int{{1920, 1080}, {1280, 720}, {854, 480}}},
+		{name: "720p portrait", width: 720, height: 1280, want: [][2]int{{720, 1280}, {480, 854}}},
+		{name: "below the ladder", width: 640, height: 359, want: [][2]int{{638, 358}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][2]int
+			for _, file := range hlsRenditions(tt.width, tt.height) {
+				got = append(got, [2]int{file.width, file.height})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hlsRenditions(%d, %d) = %v, want %v", tt.width, tt.height, got, tt.want)
+			}
+		})
+	}
+}
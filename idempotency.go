@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	// idempotencyLockTimeout is how long a key stays claimed by a request
+	// that never completed, e.g. because the server restarted mid-upload.
+	idempotencyLockTimeout = time.Hour
+)
+
+// responseRecorder keeps a copy of a response so it can be replayed.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// middlewareIdempotency makes uploads safe to retry. The first request with
+// a given Idempotency-Key runs normally and, if it succeeds, its response is
+// stored; retries with the same key get that response back instead of
+// storing the upload again. Requests without the header are unaffected.
+func (cfg *apiConfig) middlewareIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key is too long", nil)
+			return
+		}
+
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+
+		request := r.Method + " " + r.URL.Path
+		claim, err := cfg.db.CreateIdempotencyKey(userID, key, request)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record Idempotency-Key", err)
+			return
+		}
+		if claim == nil {
+			existing, err := cfg.db.GetIdempotencyKey(userID, key)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't get Idempotency-Key", err)
+				return
+			}
+			claim, err = cfg.reclaimIdempotencyKey(existing, userID, key, request)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't record Idempotency-Key", err)
+				return
+			}
+			if claim == nil {
+				if existing == nil {
+					respondWithErrorCode(w, http.StatusConflict, errCodeIdempotencyInProgress, "A request with this Idempotency-Key is still in progress", nil, nil)
+					return
+				}
+				replayIdempotentResponse(w, *existing, request)
+				return
+			}
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		// Only successful responses are replayed. After a failure the key is
+		// released so the client can retry the upload. If the request ran past
+		// idempotencyLockTimeout and the key was claimed again meanwhile, the
+		// new claim is left alone.
+		if recorder.status < 200 || recorder.status > 299 {
+			_, err = cfg.db.DeleteIdempotencyKey(*claim)
+			if err != nil {
+				log.Printf("Couldn't release Idempotency-Key %q: %v", key, err)
+			}
+			return
+		}
+		err = cfg.db.CompleteIdempotencyKey(*claim, recorder.status, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		if err != nil {
+			log.Printf("Couldn't store response for Idempotency-Key %q: %v", key, err)
+		}
+	}
+}
+
+// reclaimIdempotencyKey takes over a key that is no longer in use: its
+// record expired, its request was abandoned, or it vanished in between. It
+// returns the new claim, or nil if the key is still in use or another
+// request reclaimed it first.
+func (cfg *apiConfig) reclaimIdempotencyKey(existing *database.IdempotencyKey, userID uuid.UUID, key, request string) (*database.IdempotencyKey, error) {
+	if existing != nil {
+		age := time.Since(existing.CreatedAt)
+		expired := age > cfg.idempotencyKeyTTL
+		abandoned := !existing.Completed() && age > idempotencyLockTimeout
+		if !expired && !abandoned {
+			return nil, nil
+		}
+		deleted, err := cfg.db.DeleteIdempotencyKey(*existing)
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, nil
+		}
+	}
+	return cfg.db.CreateIdempotencyKey(userID, key, request)
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, existing database.IdempotencyKey, request string) {
+	if existing.Request != request {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, errCodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request", nil, nil)
+		return
+	}
+	if !existing.Completed() {
+		respondWithErrorCode(w, http.StatusConflict, errCodeIdempotencyInProgress, "A request with this Idempotency-Key is still in progress", nil, nil)
+		return
+	}
+
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write(existing.Body)
+}
+
+// purgeIdempotencyKeys forgets keys older than the configured TTL.
+func (cfg *apiConfig) purgeIdempotencyKeys(ctx context.Context) error {
+	deleted, err := cfg.db.DeleteIdempotencyKeysBefore(time.Now().Add(-cfg.idempotencyKeyTTL))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Forgot %d expired idempotency keys", deleted)
+	}
+	return nil
+}
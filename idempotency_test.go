@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Reclaiming an abandoned key, or releasing it once the abandoned request
+// finally fails, mustn't delete a claim another request made in between.
+func TestIdempotencyKeyReclaimKeepsNewClaim(t *testing.T) {
+	cfg, userID := newRefreshTestConfig(t)
+	cfg.idempotencyKeyTTL = -time.Second
+	request := "POST /api/v1/videos/upload"
+
+	abandoned, err := cfg.db.CreateIdempotencyKey(userID, "key", request)
+	if err != nil || abandoned == nil {
+		t.Fatalf("CreateIdempotencyKey: got %v, %v", abandoned, err)
+	}
+	// Claims are timestamped to the second.
+	time.Sleep(time.Second)
+
+	claim, err := cfg.reclaimIdempotencyKey(abandoned, userID, "key", request)
+	if err != nil || claim == nil {
+		t.Fatalf("first reclaim: got %v, %v, want a claim", claim, err)
+	}
+	again, err := cfg.reclaimIdempotencyKey(abandoned, userID, "key", request)
+	if err != nil {
+		t.Fatalf("second reclaim: %v", err)
+	}
+	if again != nil {
+		t.Fatal("second reclaim of the same record took over the key")
+	}
+
+	deleted, err := cfg.db.DeleteIdempotencyKey(*abandoned)
+	if err != nil {
+		t.Fatalf("DeleteIdempotencyKey: %v", err)
+	}
+	if deleted {
+		t.Error("releasing the abandoned claim deleted the new one")
+	}
+	existing, err := cfg.db.GetIdempotencyKey(userID, "key")
+	if err != nil {
+		t.Fatalf("GetIdempotencyKey: %v", err)
+	}
+	if existing == nil || !existing.CreatedAt.Equal(claim.CreatedAt) {
+		t.Fatalf("key record: got %+v, want the new claim %+v", existing, claim)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// importProbeURLExpiry is how long ffprobe may read an object being imported
+// through its presigned URL.
+const importProbeURLExpiry = 15 * time.Minute
+
+// importMediaTypes maps the extensions of objects that are imported to
+// their media type. Other objects under the prefix, such as HLS segments or
+// thumbnails, are skipped.
+var importMediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+}
+
+type importReport struct {
+	Prefix   string          `json:"prefix"`
+	DryRun   bool            `json:"dry_run"`
+	Imported []importedVideo `json:"imported"`
+	// Skipped counts the objects that aren't videos or are already
+	// referenced by a video or one of its versions.
+	Skipped int             `json:"skipped"`
+	Failed  []importFailure `json:"failed"`
+}
+
+type importedVideo struct {
+	Key string `json:"key"`
+	// VideoID is nil on dry runs.
+	VideoID  *uuid.UUID             `json:"video_id,omitempty"`
+	Metadata database.VideoMetadata `json:"metadata"`
+}
+
+type importFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// importVideos creates a ready video owned by userID for every video object
+// under prefix in video storage that no video references yet, so an existing
+// library can be onboarded without uploading it again. The objects are
+// probed in place and stay where they are; from then on they're managed like
+// uploaded ones. With process set, each imported video is also queued to be
+// reprocessed, which generates its thumbnail, HLS and other derived media.
+func (cfg *apiConfig) importVideos(ctx context.Context, prefix string, userID uuid.UUID, dryRun, process bool) (importReport, error) {
+	report := importReport{
+		Prefix:   prefix,
+		DryRun:   dryRun,
+		Imported: []importedVideo{},
+		Failed:   []importFailure{},
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return report, fmt.Errorf("couldn't get user: %w", err)
+	}
+	if user == nil {
+		return report, fmt.Errorf("user %s doesn't exist", userID)
+	}
+
+	referenced, err := cfg.referencedVideoKeys()
+	if err != nil {
+		return report, err
+	}
+	objects, err := cfg.videoStorage.List(ctx, prefix)
+	if err != nil {
+		return report, fmt.Errorf("couldn't list %s: %w", prefix, err)
+	}
+
+	for _, object := range objects {
+		mediaType, ok := importMediaTypes[strings.ToLower(path.Ext(object.Key))]
+		if !ok || referenced[object.Key] {
+			report.Skipped++
+			continue
+		}
+
+		metadata, err := cfg.probeStoredVideo(ctx, object.Key)
+		if err != nil {
+			report.Failed = append(report.Failed, importFailure{Key: object.Key, Error: err.Error()})
+			continue
+		}
+		imported := importedVideo{Key: object.Key, Metadata: metadata}
+		if dryRun {
+			report.Imported = append(report.Imported, imported)
+			continue
+		}
+
+		video, err := cfg.importVideo(ctx, userID, object.Key, mediaType, metadata, process)
+		if err != nil {
+			report.Failed = append(report.Failed, importFailure{Key: object.Key, Error: err.Error()})
+			continue
+		}
+		imported.VideoID = &video.ID
+		report.Imported = append(report.Imported, imported)
+		referenced[object.Key] = true
+	}
+	return report, nil
+}
+
+// storedVideoMediaType returns the media type of a stored video from its
+// extension. Videos are stored as MP4 unless they were imported as is.
+func storedVideoMediaType(key string) string {
+	if mediaType, ok := importMediaTypes[strings.ToLower(path.Ext(key))]; ok {
+		return mediaType
+	}
+	return "video/mp4"
+}
+
+// referencedVideoKeys returns the keys in video storage that a video or a
+// version of one is stored under.
+func (cfg *apiConfig) referencedVideoKeys() (map[string]bool, error) {
+	bucket := cfg.videoStorage.Bucket()
+	referenced := map[string]bool{}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get videos: %w", err)
+	}
+	for _, video := range videos {
+		if video.StorageKey != nil && video.StorageBucket != nil && *video.StorageBucket == bucket {
+			referenced[*video.StorageKey] = true
+		}
+	}
+
+	versions, err := cfg.db.GetAllVideoVersions()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get video versions: %w", err)
+	}
+	for _, version := range versions {
+		if version.StorageBucket == bucket {
+			referenced[version.StorageKey] = true
+		}
+	}
+	return referenced, nil
+}
+
+// probeStoredVideo runs ffprobe on a stored video. Objects in a bucket are
+// probed through a presigned URL, so ffprobe only fetches the ranges it
+// needs; local objects are copied to a temp file first.
+func (cfg *apiConfig) probeStoredVideo(ctx context.Context, key string) (database.VideoMetadata, error) {
+	if cfg.videoStorage.Bucket() != "" {
+		url, err := cfg.videoStorage.PresignGet(ctx, key, importProbeURLExpiry)
+		if err != nil {
+			return database.VideoMetadata{}, fmt.Errorf("couldn't presign %s: %w", key, err)
+		}
+		return probeVideo(ctx, url)
+	}
+
+	srcPath, _, err := cfg.downloadObjectToTemp(ctx, key)
+	if err != nil {
+		return database.VideoMetadata{}, err
+	}
+	defer os.Remove(srcPath)
+	return probeVideo(ctx, srcPath)
+}
+
+func (cfg *apiConfig) importVideo(ctx context.Context, userID uuid.UUID, key, mediaType string, metadata database.VideoMetadata, process bool) (database.Video, error) {
+	filename := path.Base(key)
+	title := strings.TrimSuffix(filename, path.Ext(filename))
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:  title,
+		UserID: userID,
+	})
+	if err != nil {
+		return video, fmt.Errorf("couldn't create video: %w", err)
+	}
+
+	videoURL := cfg.videoStorage.URL(key)
+	bucket := cfg.videoStorage.Bucket()
+	video.VideoURL = &videoURL
+	video.StorageBucket = &bucket
+	video.StorageKey = &key
+	video.OriginalFilename = &filename
+	video.Metadata = &metadata
+	video.ProcessingStatus = database.ProcessingStatusReady
+	// The video was just created without media, so this can't race with
+	// another swap.
+	err = cfg.db.SwapVideoMedia(video, nil)
+	if err != nil {
+		return video, fmt.Errorf("couldn't update video: %w", err)
+	}
+
+	err = cfg.ensureVideoVersion(ctx, video)
+	if err != nil {
+		log.Printf("Couldn't record version of video %s: %v", video.ID, err)
+	}
+	if cfg.videoStorageName == "s3" {
+		_, err = cfg.tagVideoObjects(ctx, video)
+		if err != nil {
+			log.Printf("Couldn't tag objects of video %s: %v", video.ID, err)
+		}
+	}
+
+	if process {
+		video, err = cfg.enqueueVideoProcessing(ctx, video, processVideoPayload{
+			SourceKey: key,
+			MediaType: mediaType,
+			Reprocess: true,
+		})
+		if err != nil {
+			return video, fmt.Errorf("couldn't queue video %s for processing: %w", video.ID, err)
+		}
+	}
+	return video, nil
+}
+
+func logImportReport(report importReport) {
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Would import"
+	}
+	log.Printf("Import of %q: %s %d videos, skipped %d objects, %d failed", report.Prefix, verb, len(report.Imported), report.Skipped, len(report.Failed))
+	for _, failure := range report.Failed {
+		log.Printf("Couldn't import %s: %s", failure.Key, failure.Error)
+	}
+}
+
+// handlerAdminImport imports the video objects under a prefix of video
+// storage for a user.
+//
+//openapi:summary Import videos from storage
+//openapi:tags admin
+//openapi:body parameters
+//openapi:response 200 importReport
+func (cfg *apiConfig) handlerAdminImport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Prefix string    `json:"prefix"`
+		UserID uuid.UUID `json:"user_id"`
+		DryRun bool      `json:"dry_run"`
+		// Process queues the imported videos to be reprocessed.
+		Process bool `json:"process"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.UserID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "user_id is required", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(params.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", nil)
+		return
+	}
+
+	report, err := cfg.importVideos(r.Context(), params.Prefix, params.UserID, params.DryRun, params.Process)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't import videos", err)
+		return
+	}
+	logImportReport(report)
+
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -20,8 +20,18 @@ const (
 	TokenTypeAccess TokenType = "tubely-access"
 )
 
+const (
+	// AccessTokenExpiry is kept short; clients renew access tokens with a
+	// refresh token instead of holding long-lived JWTs.
+	AccessTokenExpiry  = time.Hour
+	RefreshTokenExpiry = 60 * 24 * time.Hour
+)
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
+// ErrTokenRevoked is returned for access tokens revoked before they expired.
+var ErrTokenRevoked = errors.New("token was revoked")
+
 func HashPassword(password string) (string, error) {
 	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -34,48 +44,89 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// AccessClaims are the claims of access tokens. Version is the user's token
+// version when the token was issued: revoking all of a user's tokens bumps
+// it, which retires every token with an older one.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Version int `json:"ver,omitempty"`
+}
+
+// MakeJWT issues an access token for userID, signed with the current secret
+// of keys. tokenVersion is the user's current token version.
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	tokenVersion int,
+	keys *Keys,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
-	})
-	return token.SignedString(signingKey)
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keys.Issuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+		},
+		Version: tokenVersion,
+	}
+	if keys.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{keys.Audience}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	keyID, secret := keys.signingKey()
+	if keyID != "" {
+		token.Header["kid"] = keyID
+	}
+	return token.SignedString(secret)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
+// ParseJWT checks the signature of an access token against keys, along with
+// its issuer, its audience if keys has one, and its expiry, and returns its
+// claims. Unlike ValidateJWT, it doesn't check whether it was revoked.
+func ParseJWT(tokenString string, keys *Keys) (*AccessClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(keys.Issuer),
+		jwt.WithExpirationRequired(),
+	}
+	if keys.Audience != "" {
+		options = append(options, jwt.WithAudience(keys.Audience))
+	}
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(
 		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		claims,
+		keys.verificationKey,
+		options...,
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
+	return claims, nil
+}
 
-	userIDString, err := token.Claims.GetSubject()
+// ValidateJWT checks an access token like ParseJWT, and that it wasn't
+// revoked if keys has revocations, and returns the user it was issued for.
+func ValidateJWT(tokenString string, keys *Keys) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, keys)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, err
-	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
 	}
 
-	id, err := uuid.Parse(userIDString)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	if keys.Revocations != nil {
+		revoked, err := keys.Revocations.TokenRevoked(id, claims.ID, claims.Version)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("couldn't check token revocation: %w", err)
+		}
+		if revoked {
+			return uuid.Nil, ErrTokenRevoked
+		}
 	}
 	return id, nil
 }
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Keys are what access tokens are signed and verified with. Tokens are
+// signed with HS256 and the current secret, whose key ID goes in their kid
+// header. Secrets that were rotated out are still accepted until the tokens
+// signed with them expire, and tokens issued elsewhere can be verified with
+// RS256 public keys.
+type Keys struct {
+	// Issuer is set as the iss claim of issued tokens, and required of
+	// verified ones.
+	Issuer string
+	// Audience, if set, is the aud claim of issued tokens, and verified
+	// tokens must list it.
+	Audience string
+	// Revocations, if set, is consulted for every validated token.
+	Revocations Revocations
+
+	mu         sync.RWMutex
+	keyID      string
+	secret     []byte
+	replaced   []byte
+	secrets    map[string][]byte
+	publicKeys map[string]*rsa.PublicKey
+}
+
+// Revocations tells whether access tokens were revoked before they
+// expired, either one at a time by their ID, or all of a user's at once by
+// bumping the user's token version.
+type Revocations interface {
+	TokenRevoked(userID uuid.UUID, tokenID string, version int) (bool, error)
+}
+
+// NewKeys returns keys that sign tokens with secret, identified by keyID.
+// With an empty keyID, tokens have no kid header, like those issued before
+// keys were rotated.
+func NewKeys(keyID, secret string) *Keys {
+	return &Keys{
+		Issuer:     string(TokenTypeAccess),
+		keyID:      keyID,
+		secret:     []byte(secret),
+		secrets:    map[string][]byte{keyID: []byte(secret)},
+		publicKeys: map[string]*rsa.PublicKey{},
+	}
+}
+
+// AddSecret accepts tokens signed with secret, a previous signing secret
+// identified by keyID, without signing new ones with it.
+func (k *Keys) AddSecret(keyID, secret string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if keyID == "" {
+		return errors.New("previous secrets need a key ID")
+	}
+	if _, ok := k.secrets[keyID]; ok {
+		return fmt.Errorf("key ID %q is used more than once", keyID)
+	}
+	k.secrets[keyID] = []byte(secret)
+	return nil
+}
+
+// AddPublicKeyFile accepts RS256 tokens with keyID as their kid, verified
+// with the PEM-encoded RSA public key in path.
+func (k *Keys) AddPublicKeyFile(keyID, path string) error {
+	if keyID == "" {
+		return errors.New("public keys need a key ID")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.publicKeys[keyID]; ok {
+		return fmt.Errorf("key ID %q is used more than once", keyID)
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read public key: %w", err)
+	}
+	publicKey, err := parsePublicKey(dat)
+	if err != nil {
+		return fmt.Errorf("couldn't parse public key %s: %w", path, err)
+	}
+	k.publicKeys[keyID] = publicKey
+	return nil
+}
+
+// SetSecret replaces the current signing secret, such as when it was rotated
+// where it's stored, keeping its key ID. Tokens signed with the secret it
+// replaces are still accepted, until it's replaced in turn.
+func (k *Keys) SetSecret(secret string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if secret == string(k.secret) {
+		return
+	}
+	k.replaced = k.secret
+	k.secret = []byte(secret)
+	k.secrets[k.keyID] = k.secret
+}
+
+// signingKey returns the key ID and secret new tokens are signed with.
+func (k *Keys) signingKey() (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keyID, k.secret
+}
+
+// verificationKey picks the key a token is verified with by its algorithm
+// and kid, so a public key is never used as an HMAC secret. HS256 tokens
+// without a kid were issued before keys had IDs, and are checked against
+// every secret.
+func (k *Keys) verificationKey(token *jwt.Token) (any, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keyID, _ := token.Header["kid"].(string)
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if keyID == "" {
+			set := jwt.VerificationKeySet{}
+			for _, secret := range k.secrets {
+				set.Keys = append(set.Keys, secret)
+			}
+			if k.replaced != nil {
+				set.Keys = append(set.Keys, k.replaced)
+			}
+			return set, nil
+		}
+		if keyID == k.keyID && k.replaced != nil {
+			return jwt.VerificationKeySet{Keys: []jwt.VerificationKey{k.secret, k.replaced}}, nil
+		}
+		if secret, ok := k.secrets[keyID]; ok {
+			return secret, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if publicKey, ok := k.publicKeys[keyID]; ok {
+			return publicKey, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return nil, fmt.Errorf("unknown key ID %q", keyID)
+}
+
+func parsePublicKey(dat []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("public key isn't PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key must be an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,33 @@
+package auth
+
+import "fmt"
+
+// Role determines what a user may do beyond managing their own videos.
+// Roles are ordered: each one includes the permissions of those below it.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleUploader Role = "uploader"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleUploader: 2,
+	RoleAdmin:    3,
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// Has reports whether the role grants at least the permissions of required.
+func (r Role) Has(required Role) bool {
+	rank, ok := roleRank[r]
+	return ok && rank >= roleRank[required]
+}
@@ -0,0 +1,171 @@
+package captions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrEmpty       = errors.New("caption file has no cues")
+	ErrNotUTF8     = errors.New("caption file must be UTF-8 encoded")
+	languageRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	// timestampRegexp matches both WebVTT (00:01.000, 00:00:01.000) and SRT
+	// (00:00:01,000) timestamps.
+	timestampRegexp = regexp.MustCompile(`^(?:(\d+):)?(\d{2}):(\d{2})[.,](\d{3})$`)
+)
+
+// ValidLanguage reports whether tag looks like a BCP 47 language tag, such
+// as "en" or "pt-BR".
+func ValidLanguage(tag string) bool {
+	return languageRegexp.MatchString(tag)
+}
+
+// ToWebVTT validates a WebVTT or SRT caption file and returns it as WebVTT.
+// SRT files are converted; WebVTT files keep their blocks, such as STYLE and
+// NOTE, but get normalized line endings and no byte order mark. Either way
+// every cue timing must be well-formed, with the end after the start.
+func ToWebVTT(data []byte) ([]byte, error) {
+	if !utf8.Valid(data) {
+		return nil, ErrNotUTF8
+	}
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	isVTT := strings.HasPrefix(text, "WEBVTT")
+	blocks := splitBlocks(text)
+
+	var out bytes.Buffer
+	if isVTT {
+		out.WriteString(blocks[0])
+		out.WriteString("\n")
+		blocks = blocks[1:]
+	} else {
+		out.WriteString("WEBVTT\n")
+	}
+
+	cues := 0
+	for _, block := range blocks {
+		lines := strings.Split(block, "\n")
+		timing := -1
+		for i, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing == -1 {
+			if !isVTT {
+				return nil, fmt.Errorf("cue %q has no timing line", lines[0])
+			}
+			// STYLE, REGION and NOTE blocks have no timing.
+			out.WriteString("\n" + block + "\n")
+			continue
+		}
+
+		start, end, settings, err := parseTiming(lines[timing])
+		if err != nil {
+			return nil, err
+		}
+		cues++
+
+		out.WriteString("\n")
+		if isVTT && timing > 0 {
+			// Keep the cue identifier.
+			out.WriteString(lines[timing-1] + "\n")
+		}
+		fmt.Fprintf(&out, "%s --> %s", formatTimestamp(start), formatTimestamp(end))
+		if isVTT && settings != "" {
+			out.WriteString(" " + settings)
+		}
+		out.WriteString("\n")
+		for _, line := range lines[timing+1:] {
+			out.WriteString(line + "\n")
+		}
+	}
+
+	if cues == 0 {
+		return nil, ErrEmpty
+	}
+	return out.Bytes(), nil
+}
+
+// splitBlocks splits caption text into blocks separated by blank lines.
+func splitBlocks(text string) []string {
+	var blocks []string
+	var current []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, strings.Join(current, "\n"))
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, strings.Join(current, "\n"))
+	}
+	if len(blocks) == 0 {
+		return []string{""}
+	}
+	return blocks
+}
+
+// parseTiming parses a cue timing line, returning the start and end times
+// and any WebVTT cue settings that follow them.
+func parseTiming(line string) (time.Duration, time.Duration, string, error) {
+	before, after, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(after)
+	if len(fields) == 0 {
+		return 0, 0, "", fmt.Errorf("invalid cue timing %q", line)
+	}
+
+	start, err := parseTimestamp(strings.TrimSpace(before))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	end, err := parseTimestamp(fields[0])
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if end <= start {
+		return 0, 0, "", fmt.Errorf("cue %q ends before it starts", line)
+	}
+	return start, end, strings.Join(fields[1:], " "), nil
+}
+
+func parseTimestamp(value string) (time.Duration, error) {
+	m := timestampRegexp.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	var hours int
+	if m[1] != "" {
+		hours, _ = strconv.Atoi(m[1])
+	}
+	minutes, _ := strconv.Atoi(m[2])
+	seconds, _ := strconv.Atoi(m[3])
+	millis, _ := strconv.Atoi(m[4])
+	if minutes > 59 || seconds > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	return time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second +
+		time.Duration(millis)*time.Millisecond, nil
+}
+
+func formatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,111 @@
+package cdn
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+// Signer produces CloudFront signed URLs using a canned policy.
+type Signer struct {
+	domain string
+
+	mu         sync.RWMutex
+	keyID      string
+	privateKey *rsa.PrivateKey
+}
+
+// NewSigner returns a signer for the CloudFront key pair identified by keyID,
+// whose PEM-encoded RSA private key is privateKeyPEM.
+func NewSigner(domain, keyID string, privateKeyPEM []byte) (*Signer, error) {
+	privateKey, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		domain:     strings.TrimSuffix(domain, "/"),
+		keyID:      keyID,
+		privateKey: privateKey,
+	}, nil
+}
+
+// SetKey switches to another key pair, or another private key for the same
+// one, for URLs signed from then on.
+func (s *Signer) SetKey(keyID string, privateKeyPEM []byte) error {
+	privateKey, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyID = keyID
+	s.privateKey = privateKey
+	return nil
+}
+
+// SignedURL returns a URL for key on the distribution that stops working
+// after expiry.
+func (s *Signer) SignedURL(key string, expiry time.Duration) (string, error) {
+	resource := fmt.Sprintf("https://%s/%s", s.domain, key)
+	expires := time.Now().Add(expiry).Unix()
+
+	policy := fmt.Sprintf(
+		`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`,
+		resource,
+		expires,
+	)
+	s.mu.RLock()
+	keyID, privateKey := s.keyID, s.privateKey
+	s.mu.RUnlock()
+
+	digest := sha1.Sum([]byte(policy))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA1, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("couldn't sign CloudFront policy: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("Expires", fmt.Sprint(expires))
+	query.Set("Signature", urlSafeBase64(signature))
+	query.Set("Key-Pair-Id", keyID)
+	metrics.PresignsTotal.WithLabelValues("cloudfront").Inc()
+	return resource + "?" + query.Encode(), nil
+}
+
+// urlSafeBase64 applies CloudFront's substitutions for characters that are
+// invalid in query strings.
+func urlSafeBase64(dat []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(dat))
+}
+
+func parsePrivateKey(dat []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("CloudFront private key isn't PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse CloudFront private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("CloudFront private key must be an RSA key")
+	}
+	return rsaKey, nil
+}
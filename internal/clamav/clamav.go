@@ -0,0 +1,119 @@
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunkSize is how much of a stream is sent to clamd at a time.
+const chunkSize = 64 << 10
+
+// Result is the outcome of a scan. Signature names what was found in an
+// infected stream.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Client scans streams with a clamd daemon using its INSTREAM command. It
+// opens a connection per scan, so it's safe for concurrent use.
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// New returns a client for the clamd listening at address, which is either
+// the path of a Unix socket or a TCP host:port. Scans fail if they take
+// longer than timeout.
+func New(address string, timeout time.Duration) *Client {
+	network := "tcp"
+	if strings.Contains(address, "/") {
+		network = "unix"
+	}
+	return &Client{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// Scan streams r to clamd and reports whether it's infected. clamd refuses
+// streams over its StreamMaxLength, which is an error like any other.
+func (c *Client) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("couldn't connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	err = stream(conn, r)
+	// clamd answers and hangs up when it rejects a stream, e.g. for being
+	// too long, so its reply explains a failed write better than the write.
+	reply, readErr := bufio.NewReader(conn).ReadString(0)
+	if readErr != nil {
+		if err != nil {
+			return Result{}, fmt.Errorf("couldn't send stream to clamd: %w", err)
+		}
+		return Result{}, fmt.Errorf("couldn't read clamd reply: %w", readErr)
+	}
+	return parseReply(strings.TrimSuffix(reply, "\x00"))
+}
+
+// stream sends r as an INSTREAM command: chunks prefixed with their length,
+// ended by an empty one.
+func stream(conn net.Conn, r io.Reader) error {
+	_, err := io.WriteString(conn, "zINSTREAM\x00")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, readErr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			_, err = conn.Write(buf[:4+n])
+			if err != nil {
+				return err
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseReply parses replies like "stream: OK", "stream: Eicar-Signature
+// FOUND" and "INSTREAM size limit exceeded. ERROR".
+func parseReply(reply string) (Result, error) {
+	if reply == "stream: OK" {
+		return Result{}, nil
+	}
+	if signature, ok := strings.CutSuffix(reply, " FOUND"); ok {
+		return Result{
+			Infected:  true,
+			Signature: strings.TrimPrefix(signature, "stream: "),
+		}, nil
+	}
+	return Result{}, fmt.Errorf("clamd couldn't scan stream: %s", reply)
+}
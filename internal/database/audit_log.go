@@ -0,0 +1,154 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditResult string
+
+const (
+	AuditResultSuccess AuditResult = "success"
+	AuditResultFailure AuditResult = "failure"
+)
+
+// AuditEntry records an action a user attempted through the API. Entries
+// are only ever appended.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// UserID is the user who attempted the action, nil if the request
+	// wasn't authenticated.
+	UserID *uuid.UUID `json:"user_id"`
+	Action string     `json:"action"`
+	// ResourceID identifies what the action was applied to, e.g. a video.
+	ResourceID *string     `json:"resource_id"`
+	IP         string      `json:"ip"`
+	StatusCode int         `json:"status_code"`
+	Result     AuditResult `json:"result"`
+}
+
+type CreateAuditEntryParams struct {
+	UserID     *uuid.UUID
+	Action     string
+	ResourceID *string
+	IP         string
+	StatusCode int
+	Result     AuditResult
+}
+
+// AuditLogFilter selects audit entries. Zero fields match everything.
+type AuditLogFilter struct {
+	UserID     *uuid.UUID
+	Action     string
+	ResourceID string
+	IP         string
+	Result     AuditResult
+	Since      *time.Time
+	Until      *time.Time
+	// Before continues a listing after the entry with this ID.
+	Before int64
+	Limit  int
+}
+
+func (c Client) CreateAuditEntry(params CreateAuditEntryParams) error {
+	query := `
+	INSERT INTO audit_log (
+		created_at,
+		user_id,
+		action,
+		resource_id,
+		ip,
+		status_code,
+		result
+	) VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		params.UserID,
+		params.Action,
+		params.ResourceID,
+		params.IP,
+		params.StatusCode,
+		params.Result,
+	)
+	return err
+}
+
+// ListAuditLog returns a page of the entries matching filter, newest first.
+func (c Client) ListAuditLog(filter AuditLogFilter) ([]AuditEntry, error) {
+	query := `
+	SELECT id, created_at, user_id, action, resource_id, ip, status_code, result
+	FROM audit_log
+	WHERE true`
+	args := []any{}
+
+	if filter.UserID != nil {
+		query += ` AND user_id = ?`
+		args = append(args, *filter.UserID)
+	}
+	if filter.Action != "" {
+		query += ` AND action = ?`
+		args = append(args, filter.Action)
+	}
+	if filter.ResourceID != "" {
+		query += ` AND resource_id = ?`
+		args = append(args, filter.ResourceID)
+	}
+	if filter.IP != "" {
+		query += ` AND ip = ?`
+		args = append(args, filter.IP)
+	}
+	if filter.Result != "" {
+		query += ` AND result = ?`
+		args = append(args, filter.Result)
+	}
+	if filter.Since != nil {
+		query += ` AND created_at >= ?`
+		args = append(args, filter.Since.UTC().Format(cursorTimestampLayout))
+	}
+	if filter.Until != nil {
+		query += ` AND created_at < ?`
+		args = append(args, filter.Until.UTC().Format(cursorTimestampLayout))
+	}
+	if filter.Before > 0 {
+		query += ` AND id < ?`
+		args = append(args, filter.Before)
+	}
+	query += `
+	ORDER BY id DESC
+	LIMIT ?
+	`
+	args = append(args, filter.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var userID uuid.NullUUID
+		err := rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&userID,
+			&entry.Action,
+			&entry.ResourceID,
+			&entry.IP,
+			&entry.StatusCode,
+			&entry.Result,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			entry.UserID = &userID.UUID
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
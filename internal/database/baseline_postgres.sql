@@ -0,0 +1,150 @@
+-- The baseline schema of Postgres databases, matching the one autoMigrate
+-- creates for SQLite. Like there, references between tables aren't declared
+-- as foreign keys: SQLite doesn't enforce them, and the client deletes rows
+-- in an order that relies on that.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	role TEXT NOT NULL DEFAULT 'uploader',
+	watermark_opt_out BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT,
+	processing_status TEXT NOT NULL DEFAULT '',
+	processing_error TEXT,
+	hls_url TEXT,
+	metadata TEXT,
+	checksum_sha256 TEXT,
+	storage_bucket TEXT,
+	storage_key TEXT,
+	visibility TEXT NOT NULL DEFAULT 'private',
+	thumbnail_srcset TEXT,
+	sprite_url TEXT,
+	sprite_vtt_url TEXT,
+	preview_url TEXT,
+	deleted_at TIMESTAMP,
+	publish_at TIMESTAMP,
+	expires_at TIMESTAMP,
+	watermark_disabled BOOLEAN NOT NULL DEFAULT FALSE,
+	archive_status TEXT NOT NULL DEFAULT '',
+	restored_until TIMESTAMP,
+	content_sha256 TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_videos_checksum_sha256 ON videos(checksum_sha256);
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	type TEXT NOT NULL,
+	reference TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMP NOT NULL,
+	last_error TEXT,
+	timings TEXT,
+	external_id TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs(status, run_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_reference ON jobs(reference);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS share_links (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	video_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS video_versions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	storage_bucket TEXT NOT NULL,
+	storage_key TEXT NOT NULL,
+	video_url TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	checksum_sha256 TEXT,
+	metadata TEXT,
+	content_sha256 TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_video_versions_video_id ON video_versions(video_id);
+
+CREATE TABLE IF NOT EXISTS video_renditions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	height INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	storage_bucket TEXT NOT NULL,
+	storage_key TEXT NOT NULL,
+	video_url TEXT NOT NULL,
+	size BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_video_renditions_video_id ON video_renditions(video_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id TEXT NOT NULL,
+	key TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	request TEXT NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	content_type TEXT,
+	body BYTEA,
+	PRIMARY KEY(user_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS captions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	language TEXT NOT NULL,
+	label TEXT NOT NULL,
+	url TEXT NOT NULL,
+	storage_key TEXT NOT NULL,
+	source TEXT NOT NULL DEFAULT 'upload',
+	UNIQUE(video_id, language)
+);
+
+CREATE TABLE IF NOT EXISTS user_usage (
+	user_id TEXT PRIMARY KEY,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_count INTEGER NOT NULL DEFAULT 0,
+	storage_bytes BIGINT NOT NULL DEFAULT 0,
+	thumbnail_bytes BIGINT NOT NULL DEFAULT 0,
+	processing_seconds DOUBLE PRECISION NOT NULL DEFAULT 0
+);
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CaptionSource records how a caption track was produced.
+type CaptionSource string
+
+const (
+	CaptionSourceUpload        CaptionSource = "upload"
+	CaptionSourceTranscription CaptionSource = "transcription"
+)
+
+// Caption is a WebVTT caption track of a video in one language.
+type Caption struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreateCaptionParams
+}
+
+type CreateCaptionParams struct {
+	VideoID    uuid.UUID     `json:"video_id"`
+	Language   string        `json:"language"`
+	Label      string        `json:"label"`
+	URL        string        `json:"url"`
+	StorageKey string        `json:"-"`
+	Source     CaptionSource `json:"source"`
+}
+
+const captionColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		language,
+		label,
+		url,
+		storage_key,
+		source`
+
+func scanCaption(row rowScanner) (Caption, error) {
+	var caption Caption
+	err := row.Scan(
+		&caption.ID,
+		&caption.CreatedAt,
+		&caption.UpdatedAt,
+		&caption.VideoID,
+		&caption.Language,
+		&caption.Label,
+		&caption.URL,
+		&caption.StorageKey,
+		&caption.Source,
+	)
+	return caption, err
+}
+
+// UpsertCaption stores the caption track for a video and language,
+// replacing any existing track in that language.
+func (c Client) UpsertCaption(params CreateCaptionParams) (Caption, error) {
+	query := `
+	INSERT INTO captions (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		language,
+		label,
+		url,
+		storage_key,
+		source
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id, language) DO UPDATE SET
+		label = excluded.label,
+		url = excluded.url,
+		storage_key = excluded.storage_key,
+		source = excluded.source,
+		updated_at = CURRENT_TIMESTAMP
+	RETURNING` + captionColumns
+
+	return scanCaption(c.db.QueryRow(
+		query,
+		uuid.New(),
+		params.VideoID,
+		params.Language,
+		params.Label,
+		params.URL,
+		params.StorageKey,
+		params.Source,
+	))
+}
+
+// GetCaption returns the caption track of a video in the given language, or
+// nil if there is none.
+func (c Client) GetCaption(videoID uuid.UUID, language string) (*Caption, error) {
+	query := `
+	SELECT` + captionColumns + `
+	FROM captions
+	WHERE video_id = ? AND language = ?
+	`
+	caption, err := scanCaption(c.db.QueryRow(query, videoID, language))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &caption, nil
+}
+
+func (c Client) GetCaptions(videoID uuid.UUID) ([]Caption, error) {
+	captions, err := c.GetCaptionsForVideos([]uuid.UUID{videoID})
+	if err != nil {
+		return nil, err
+	}
+	if captions[videoID] == nil {
+		return []Caption{}, nil
+	}
+	return captions[videoID], nil
+}
+
+// GetCaptionsForVideos returns the caption tracks of several videos at once,
+// keyed by video ID.
+func (c Client) GetCaptionsForVideos(videoIDs []uuid.UUID) (map[uuid.UUID][]Caption, error) {
+	captions := map[uuid.UUID][]Caption{}
+	if len(videoIDs) == 0 {
+		return captions, nil
+	}
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `
+	SELECT` + captionColumns + `
+	FROM captions
+	WHERE video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)
+	ORDER BY language
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		caption, err := scanCaption(rows)
+		if err != nil {
+			return nil, err
+		}
+		captions[caption.VideoID] = append(captions[caption.VideoID], caption)
+	}
+	return captions, rows.Err()
+}
+
+func (c Client) DeleteCaption(id uuid.UUID) error {
+	query := `
+	DELETE FROM captions
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+func (c Client) DeleteCaptionsForVideo(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM captions
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, videoID)
+	return err
+}
+
+// UpdateCaptionKey points a caption track at its object's new key and URL.
+func (c Client) UpdateCaptionKey(id uuid.UUID, key, url string) error {
+	query := `
+	UPDATE captions
+	SET storage_key = ?, url = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, key, url, id)
+	return err
+}
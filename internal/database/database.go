@@ -1,30 +1,149 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// timestampLayout is the format SQLite's CURRENT_TIMESTAMP writes, used when
+// comparing against stored timestamps.
+const timestampLayout = "2006-01-02 15:04:05"
+
+// cursorTimestampLayout is timestampLayout with the fractional seconds
+// Postgres keeps, so rows created within the same second page correctly.
+// They're left out when zero, as they always are in SQLite.
+const cursorTimestampLayout = "2006-01-02 15:04:05.999999"
+
 type Client struct {
-	db *sql.DB
+	db *conn
+}
+
+// PoolConfig limits the connections the client keeps open. Zero values
+// leave the database/sql defaults in place.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+// NewClient opens the database and applies any pending migrations.
+func NewClient(dsn string) (Client, error) {
+	c, err := Open(dsn, PoolConfig{})
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	err = c.Migrate()
 	if err != nil {
 		return Client{}, err
 	}
 	return c, nil
+}
 
+// Open opens the database without migrating it. A postgres:// or
+// postgresql:// URL connects to Postgres, which instances running side by
+// side can share; anything else is the path of a SQLite database.
+func Open(dsn string, pool PoolConfig) (Client, error) {
+	var d dialect = sqliteDialect{}
+	var connector *postgresConnector
+	var db *sql.DB
+	if isPostgresURL(dsn) {
+		d = postgresDialect{}
+		var err error
+		dsn, err = postgresDSN(dsn)
+		if err != nil {
+			return Client{}, err
+		}
+		connector = &postgresConnector{dsn: dsn}
+		db = sql.OpenDB(connector)
+	} else {
+		var err error
+		db, err = sql.Open(d.driverName(), dsn)
+		if err != nil {
+			return Client{}, err
+		}
+	}
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	return Client{db: &conn{DB: db, dialect: d, connector: connector}}, nil
 }
 
+// SetDSN changes the URL new Postgres connections are made with, such as
+// when the password in it was rotated. Connections already open are kept
+// until the pool closes them, which DB_CONN_MAX_LIFETIME bounds.
+func (c Client) SetDSN(dsn string) error {
+	if c.db.connector == nil || !isPostgresURL(dsn) {
+		return errors.New("only Postgres connection URLs can be changed")
+	}
+	dsn, err := postgresDSN(dsn)
+	if err != nil {
+		return err
+	}
+	c.db.connector.mu.Lock()
+	defer c.db.connector.mu.Unlock()
+	c.db.connector.dsn = dsn
+	return nil
+}
+
+// postgresConnector connects to Postgres with the URL that was set last.
+type postgresConnector struct {
+	mu  sync.RWMutex
+	dsn string
+}
+
+func (c *postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.RLock()
+	dsn := c.dsn
+	c.mu.RUnlock()
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *postgresConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+func isPostgresURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// postgresDSN sets the session time zone to UTC unless the URL picks one, so
+// CURRENT_TIMESTAMP writes the same UTC timestamps as SQLite.
+func postgresDSN(dsn string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	query := u.Query()
+	if query.Get("timezone") == "" {
+		query.Set("timezone", "UTC")
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+
+// autoMigrate creates the baseline schema of SQLite databases, the one from
+// before versioned migrations, and brings databases created by older
+// versions up to date with it. New schema changes go in migrations instead.
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -32,7 +151,9 @@ func (c *Client) autoMigrate() error {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
+		email TEXT UNIQUE NOT NULL,
+		role TEXT NOT NULL DEFAULT 'uploader',
+		watermark_opt_out BOOLEAN NOT NULL DEFAULT 0
 	);
 	`
 	_, err := c.db.Exec(userTable)
@@ -65,6 +186,25 @@ func (c *Client) autoMigrate() error {
 		thumbnail_url TEXT,
 		video_url TEXT TEXT,
 		user_id INTEGER,
+		processing_status TEXT NOT NULL DEFAULT '',
+		processing_error TEXT,
+		hls_url TEXT,
+		metadata TEXT,
+		checksum_sha256 TEXT,
+		storage_bucket TEXT,
+		storage_key TEXT,
+		visibility TEXT NOT NULL DEFAULT 'private',
+		thumbnail_srcset TEXT,
+		sprite_url TEXT,
+		sprite_vtt_url TEXT,
+		preview_url TEXT,
+		deleted_at TIMESTAMP,
+		publish_at TIMESTAMP,
+		expires_at TIMESTAMP,
+		watermark_disabled BOOLEAN NOT NULL DEFAULT 0,
+		archive_status TEXT NOT NULL DEFAULT '',
+		restored_until TIMESTAMP,
+		content_sha256 TEXT,
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
 	`
@@ -72,18 +212,353 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		type TEXT NOT NULL,
+		reference TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL,
+		run_at TIMESTAMP NOT NULL,
+		last_error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs(status, run_at);
+	CREATE INDEX IF NOT EXISTS idx_jobs_reference ON jobs(reference);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	webhookTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(webhookTable)
+	if err != nil {
+		return err
+	}
+
+	shareLinkTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		token TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP,
+		video_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(shareLinkTable)
+	if err != nil {
+		return err
+	}
+
+	videoVersionTable := `
+	CREATE TABLE IF NOT EXISTS video_versions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		storage_bucket TEXT NOT NULL,
+		storage_key TEXT NOT NULL,
+		video_url TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		checksum_sha256 TEXT,
+		metadata TEXT,
+		content_sha256 TEXT,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_versions_video_id ON video_versions(video_id);
+	`
+	_, err = c.db.Exec(videoVersionTable)
+	if err != nil {
+		return err
+	}
+
+	videoRenditionTable := `
+	CREATE TABLE IF NOT EXISTS video_renditions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		height INTEGER NOT NULL,
+		width INTEGER NOT NULL,
+		storage_bucket TEXT NOT NULL,
+		storage_key TEXT NOT NULL,
+		video_url TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_renditions_video_id ON video_renditions(video_id);
+	`
+	_, err = c.db.Exec(videoRenditionTable)
+	if err != nil {
+		return err
+	}
+
+	idempotencyKeyTable := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		request TEXT NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT,
+		body BLOB,
+		PRIMARY KEY(user_id, key),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(idempotencyKeyTable)
+	if err != nil {
+		return err
+	}
+
+	captionTable := `
+	CREATE TABLE IF NOT EXISTS captions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		label TEXT NOT NULL,
+		url TEXT NOT NULL,
+		storage_key TEXT NOT NULL,
+		source TEXT NOT NULL DEFAULT 'upload',
+		UNIQUE(video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(captionTable)
+	if err != nil {
+		return err
+	}
+
+	userUsageTable := `
+	CREATE TABLE IF NOT EXISTS user_usage (
+		user_id TEXT PRIMARY KEY,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_count INTEGER NOT NULL DEFAULT 0,
+		storage_bytes INTEGER NOT NULL DEFAULT 0,
+		thumbnail_bytes INTEGER NOT NULL DEFAULT 0,
+		processing_seconds REAL NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userUsageTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfNotExists("users", "role", "TEXT NOT NULL DEFAULT 'uploader'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "watermark_opt_out", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "processing_status", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "processing_error", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "hls_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "metadata", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "checksum_sha256", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "storage_bucket", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "storage_key", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "visibility", "TEXT NOT NULL DEFAULT 'private'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "thumbnail_srcset", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "sprite_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "sprite_vtt_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "preview_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "deleted_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "publish_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "expires_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "watermark_disabled", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "archive_status", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "restored_until", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "content_sha256", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("video_versions", "content_sha256", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("captions", "source", "TEXT NOT NULL DEFAULT 'upload'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "timings", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "external_id", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS idx_videos_checksum_sha256 ON videos(checksum_sha256)")
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumnIfNotExists brings tables created by older versions up to date,
+// since CREATE TABLE IF NOT EXISTS leaves existing tables untouched.
+func (c *Client) addColumnIfNotExists(table, column, definition string) error {
+	exists, err := c.columnExists(table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func (c *Client) columnExists(table, column string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			dfltValue  sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM upload_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table upload_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM revoked_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table revoked_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM idempotency_keys"); err != nil {
+		return fmt.Errorf("failed to reset table idempotency_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_usage"); err != nil {
+		return fmt.Errorf("failed to reset table user_usage: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM share_links"); err != nil {
+		return fmt.Errorf("failed to reset table share_links: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_renditions"); err != nil {
+		return fmt.Errorf("failed to reset table video_renditions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_versions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM captions"); err != nil {
+		return fmt.Errorf("failed to reset table captions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_stats"); err != nil {
+		return fmt.Errorf("failed to reset table video_stats: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect is what differs between the databases the client supports.
+// Queries are written once, with ? placeholders and the SQL both databases
+// understand, and only the parts that can't be shared go through here.
+type dialect interface {
+	// name is what migrations written for the dialect alone are suffixed
+	// with.
+	name() string
+	// driverName is the database/sql driver the dialect connects with.
+	driverName() string
+	// rebind rewrites the ? placeholders of a query into the ones the
+	// driver expects.
+	rebind(query string) string
+	// baseline creates the schema from before versioned migrations.
+	baseline(c *Client) error
+	// newestFirst orders rows by a timestamp column, newest first, breaking
+	// ties by insertion order where the database keeps one.
+	newestFirst(column string) string
+	// skipLocked is appended to a subquery picking a row to claim, so
+	// concurrent claimers each get a different row.
+	skipLocked() string
+}
+
+// dialectNames are the names of the supported dialects.
+var dialectNames = []string{sqliteDialect{}.name(), postgresDialect{}.name()}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return "sqlite" }
+
+func (sqliteDialect) driverName() string { return "sqlite3" }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) baseline(c *Client) error { return c.autoMigrate() }
+
+func (sqliteDialect) newestFirst(column string) string {
+	return column + " DESC, rowid DESC"
+}
+
+// skipLocked returns nothing, since SQLite serializes writers.
+func (sqliteDialect) skipLocked() string { return "" }
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return "postgres" }
+
+func (postgresDialect) driverName() string { return "postgres" }
+
+// rebind numbers the placeholders $1, $2 and so on, leaving question marks
+// in string literals and quoted identifiers alone.
+func (postgresDialect) rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+func (postgresDialect) baseline(c *Client) error {
+	_, err := c.db.Exec(postgresBaseline)
+	return err
+}
+
+// newestFirst only orders by the column, since Postgres has no stable row
+// order to break ties with. Its timestamps have microsecond precision, so
+// ties are rare.
+func (postgresDialect) newestFirst(column string) string {
+	return column + " DESC"
+}
+
+func (postgresDialect) skipLocked() string { return "FOR UPDATE SKIP LOCKED" }
+
+// conn is the database handle the client queries through. It rebinds every
+// query for the dialect, so the rest of the package can use ? placeholders
+// regardless of the database.
+type conn struct {
+	*sql.DB
+	dialect dialect
+	// connector is set for Postgres, whose connection URL can change.
+	connector *postgresConnector
+}
+
+func (c *conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Begin() (*txConn, error) {
+	t, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &txConn{Tx: t, dialect: c.dialect}, nil
+}
+
+// txConn is a transaction that rebinds its queries like conn.
+type txConn struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *txConn) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *txConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *txConn) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKey records a request made with an Idempotency-Key header and,
+// once it completed, the response to replay on retries.
+type IdempotencyKey struct {
+	UserID    uuid.UUID
+	Key       string
+	CreatedAt time.Time
+	// Request identifies the endpoint the key was first used with.
+	Request     string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Completed reports whether the original request finished and its response
+// was stored.
+func (k IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
+
+// CreateIdempotencyKey claims key for an in-flight request. It returns the
+// claim, or nil if the user already used the key.
+func (c Client) CreateIdempotencyKey(userID uuid.UUID, key, request string) (*IdempotencyKey, error) {
+	query := `
+	INSERT INTO idempotency_keys (
+		user_id,
+		key,
+		created_at,
+		request,
+		status_code
+	) VALUES (?, ?, CURRENT_TIMESTAMP, ?, 0)
+	ON CONFLICT(user_id, key) DO NOTHING
+	RETURNING created_at
+	`
+	record := IdempotencyKey{UserID: userID, Key: key, Request: request}
+	err := c.db.QueryRow(query, userID, key, request).Scan(&record.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+// GetIdempotencyKey returns the request recorded for a key, or nil if the
+// user hasn't used it.
+func (c Client) GetIdempotencyKey(userID uuid.UUID, key string) (*IdempotencyKey, error) {
+	query := `
+	SELECT user_id, key, created_at, request, status_code, content_type, body
+	FROM idempotency_keys
+	WHERE user_id = ? AND key = ?
+	`
+	var (
+		record      IdempotencyKey
+		contentType sql.NullString
+	)
+	err := c.db.QueryRow(query, userID, key).Scan(
+		&record.UserID,
+		&record.Key,
+		&record.CreatedAt,
+		&record.Request,
+		&record.StatusCode,
+		&contentType,
+		&record.Body,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	record.ContentType = contentType.String
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for a claimed key.
+// It does nothing if the claim was since deleted and the key claimed again.
+func (c Client) CompleteIdempotencyKey(claim IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	query := `
+	UPDATE idempotency_keys
+	SET
+		status_code = ?,
+		content_type = ?,
+		body = ?
+	WHERE user_id = ? AND key = ? AND created_at = ?
+	`
+	_, err := c.db.Exec(query, statusCode, contentType, body, claim.UserID, claim.Key, claim.CreatedAt.UTC().Format(cursorTimestampLayout))
+	return err
+}
+
+// DeleteIdempotencyKey deletes the record of a key, as long as it's still
+// the one that was read: a request that claimed the key since is left
+// alone. It reports whether the record was deleted.
+func (c Client) DeleteIdempotencyKey(record IdempotencyKey) (bool, error) {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE user_id = ? AND key = ? AND created_at = ?
+	`
+	result, err := c.db.Exec(query, record.UserID, record.Key, record.CreatedAt.UTC().Format(cursorTimestampLayout))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// DeleteIdempotencyKeysBefore forgets keys first used before cutoff.
+func (c Client) DeleteIdempotencyKeysBefore(cutoff time.Time) (int64, error) {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE created_at < ?
+	`
+	result, err := c.db.Exec(query, cutoff.UTC().Format(timestampLayout))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,365 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	// JobStatusDead is the dead-letter state of jobs that ran out of
+	// attempts or can't be run. They're kept until an admin requeues or
+	// discards them.
+	JobStatusDead JobStatus = "dead"
+)
+
+type Job struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Type        string     `json:"type"`
+	Reference   string     `json:"reference"`
+	Payload     string     `json:"-"`
+	Status      JobStatus  `json:"status"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	RunAt       time.Time  `json:"run_at"`
+	LastError   *string    `json:"last_error,omitempty"`
+	Timings     JobTimings `json:"timings,omitempty"`
+	// ExternalID identifies work the job handed to another service, so a
+	// later attempt can pick it up instead of starting over.
+	ExternalID *string `json:"external_id,omitempty"`
+}
+
+// JobTimings maps the stages of a job's latest attempt to how long they took,
+// in seconds. It is persisted as JSON in the jobs.timings column.
+type JobTimings map[string]float64
+
+func (t JobTimings) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (t *JobTimings) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case []byte:
+		return json.Unmarshal(v, t)
+	default:
+		return fmt.Errorf("unsupported type for job timings: %T", src)
+	}
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		type,
+		reference,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		last_error,
+		timings,
+		external_id`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Type,
+		&job.Reference,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.Timings,
+		&job.ExternalID,
+	)
+	return job, err
+}
+
+func (c Client) CreateJob(job Job) error {
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		type,
+		reference,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		job.ID,
+		job.Type,
+		job.Reference,
+		job.Payload,
+		job.Status,
+		job.Attempts,
+		job.MaxAttempts,
+		job.RunAt.UTC(),
+	)
+	return err
+}
+
+func (c Client) UpdateJob(job Job) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = ?,
+		run_at = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, job.Status, job.Attempts, job.RunAt.UTC(), job.LastError, job.ID)
+	return err
+}
+
+// UpdateJobTimings records how long the stages of a job's latest attempt
+// took.
+func (c Client) UpdateJobTimings(id uuid.UUID, timings JobTimings) error {
+	query := `
+	UPDATE jobs
+	SET
+		timings = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, timings, id)
+	return err
+}
+
+// UpdateJobExternalID records the ID of the work a job handed to another
+// service.
+func (c Client) UpdateJobExternalID(id uuid.UUID, externalID *string) error {
+	query := `
+	UPDATE jobs
+	SET
+		external_id = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, externalID, id)
+	return err
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// GetLatestJob returns the most recently created job for the given
+// reference, or nil if there is none.
+func (c Client) GetLatestJob(reference string) (*Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE reference = ?
+	ORDER BY created_at DESC
+	LIMIT 1
+	`
+	job, err := scanJob(c.db.QueryRow(query, reference))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimNextJob atomically moves the oldest due job to the running state and
+// returns it, or nil if no job is due.
+func (c Client) ClaimNextJob(now time.Time) (*Job, error) {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE status = ? AND run_at <= ?
+		ORDER BY run_at
+		LIMIT 1
+		` + c.db.dialect.skipLocked() + `
+	) AND status = ?
+	RETURNING` + jobColumns
+
+	// The outer status check makes a claimer that raced another one for the
+	// same job come back empty instead of claiming it twice.
+	job, err := scanJob(c.db.QueryRow(query, JobStatusRunning, JobStatusQueued, now.UTC(), JobStatusQueued))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// HeartbeatJob marks a running job as still being worked on.
+func (c Client) HeartbeatJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`
+	_, err := c.db.Exec(query, id, JobStatusRunning)
+	return err
+}
+
+// RequeueStaleJobs puts running jobs that haven't been updated since before
+// back in the queue, since the process running them has gone away.
+func (c Client) RequeueStaleJobs(before time.Time) (int64, error) {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ? AND updated_at < ?
+	`
+	result, err := c.db.Exec(query, JobStatusQueued, JobStatusRunning, before.UTC().Format(time.DateTime))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RequeueRunningJobs puts jobs that were running when the server stopped back
+// in the queue so they are picked up again.
+func (c Client) RequeueRunningJobs() error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, JobStatusRunning)
+	return err
+}
+
+// GetUnfinishedJobs returns the jobs of the given type that may still run:
+// queued and running ones, and dead ones, which can be requeued.
+func (c Client) GetUnfinishedJobs(jobType string) ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE type = ? AND status IN (?, ?, ?)
+	`
+	return c.queryJobs(query, jobType, JobStatusQueued, JobStatusRunning, JobStatusDead)
+}
+
+// GetJobsByStatus returns the jobs in a status, most recently updated first.
+func (c Client) GetJobsByStatus(status JobStatus) ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE status = ?
+	ORDER BY updated_at DESC
+	`
+	return c.queryJobs(query, status)
+}
+
+func (c Client) queryJobs(query string, args ...any) ([]Job, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RequeueDeadJob puts a dead job back in the queue with its attempts reset,
+// and returns it, or nil if there's no dead job with the ID. Work it handed
+// to another service is forgotten, so it starts over.
+func (c Client) RequeueDeadJob(id uuid.UUID, now time.Time) (*Job, error) {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = 0,
+		run_at = ?,
+		external_id = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	RETURNING` + jobColumns
+
+	job, err := scanJob(c.db.QueryRow(query, JobStatusQueued, now.UTC(), id, JobStatusDead))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// DeleteDeadJob deletes a dead job, reporting whether there was one with the
+// ID.
+func (c Client) DeleteDeadJob(id uuid.UUID) (bool, error) {
+	result, err := c.db.Exec(`DELETE FROM jobs WHERE id = ? AND status = ?`, id, JobStatusDead)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
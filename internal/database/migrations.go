@@ -0,0 +1,240 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds the schema changes made since migrations were
+// introduced, one file per version named like 0002_add_column.sql. Each is
+// applied once, in order, in its own transaction. A change that has to be
+// written differently for one database goes in a file named after it, like
+// 0003_add_column.postgres.sql, which replaces the shared one there.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// postgresBaseline is the baseline schema of Postgres databases.
+//
+//go:embed baseline_postgres.sql
+var postgresBaseline string
+
+// baselineVersion is the schema the dialect's baseline creates, which for
+// SQLite also brings databases created before versioned migrations up to
+// date. The SQL migrations start after it.
+const baselineVersion = 1
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// Migrate applies the migrations the database hasn't had yet. Processes
+// starting against the same database at once each apply a migration at most
+// once, since recording its version is the first write of its transaction.
+func (c Client) Migrate() error {
+	migrations, err := loadMigrations(c.db.dialect.name())
+	if err != nil {
+		return err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	err = checkSchemaVersion(migrations, applied)
+	if err != nil {
+		return err
+	}
+
+	if !applied[baselineVersion] {
+		err = c.db.dialect.baseline(&c)
+		if err != nil {
+			return fmt.Errorf("couldn't apply baseline schema: %w", err)
+		}
+		_, err = c.db.Exec(`
+		INSERT INTO schema_migrations (version, name)
+		VALUES (?, 'baseline')
+		ON CONFLICT(version) DO NOTHING
+		`, baselineVersion)
+		if err != nil {
+			return fmt.Errorf("couldn't record baseline schema: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		err = c.applyMigration(m)
+		if err != nil {
+			return fmt.Errorf("couldn't apply migration %04d_%s: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// PendingMigrations returns the names of the migrations the database hasn't
+// had yet.
+func (c Client) PendingMigrations() ([]string, error) {
+	migrations, err := loadMigrations(c.db.dialect.name())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	err = checkSchemaVersion(migrations, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []string{}
+	if !applied[baselineVersion] {
+		pending = append(pending, fmt.Sprintf("%04d_baseline", baselineVersion))
+	}
+	for _, m := range migrations {
+		if !applied[m.version] {
+			pending = append(pending, fmt.Sprintf("%04d_%s", m.version, m.name))
+		}
+	}
+	return pending, nil
+}
+
+func (c Client) applyMigration(m migration) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	INSERT INTO schema_migrations (version, name)
+	VALUES (?, ?)
+	ON CONFLICT(version) DO NOTHING
+	`, m.version, m.name)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		// Another process applied it in the meantime.
+		return nil
+	}
+
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appliedMigrations returns the versions applied so far, creating the table
+// they're recorded in if needed.
+func (c Client) appliedMigrations() (map[int]bool, error) {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create schema_migrations: %w", err)
+	}
+
+	rows, err := c.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// checkSchemaVersion refuses databases migrated by a newer build, whose
+// schema this one may not know how to use.
+func checkSchemaVersion(migrations []migration, applied map[int]bool) error {
+	latest := baselineVersion
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].version
+	}
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("database schema version %d is newer than the latest this build knows, %d", version, latest)
+		}
+	}
+	return nil
+}
+
+// loadMigrations returns the migrations for the named dialect, in order.
+func loadMigrations(dialectName string) ([]migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	type source struct {
+		file     string
+		specific bool
+	}
+	byVersion := map[int]migration{}
+	sources := map[int]source{}
+	for _, file := range files {
+		base, variant, specific := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), ".")
+		prefix, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= baselineVersion {
+			return nil, fmt.Errorf("migration %s must be named <version>_<name>.sql with a version above %d", file, baselineVersion)
+		}
+		if specific && !slices.Contains(dialectNames, variant) {
+			return nil, fmt.Errorf("migration %s is for unknown database %q, expected one of %s", file, variant, strings.Join(dialectNames, ", "))
+		}
+		if specific && variant != dialectName {
+			continue
+		}
+		if other, ok := sources[version]; ok {
+			if other.specific == specific {
+				return nil, fmt.Errorf("migrations %s and %s share version %d", other.file, file, version)
+			}
+			// The dialect's own file replaces the shared one.
+			if !specific {
+				continue
+			}
+		}
+		sources[version] = source{file: file, specific: specific}
+
+		data, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		byVersion[version] = migration{version: version, name: name, sql: string(data)}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
@@ -0,0 +1,4 @@
+-- Videos are listed per user and looked up by their stored object when
+-- deduplicated uploads and reconciliation check who still references it.
+CREATE INDEX idx_videos_user_id ON videos(user_id);
+CREATE INDEX idx_videos_storage_key ON videos(storage_key);
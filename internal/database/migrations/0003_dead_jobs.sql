@@ -0,0 +1,3 @@
+-- Jobs that ran out of attempts were marked failed before the dead-letter
+-- state was introduced, and can be requeued like dead ones.
+UPDATE jobs SET status = 'dead' WHERE status = 'failed';
@@ -0,0 +1,15 @@
+-- The audit log is append-only. Entries are numbered in the order they were
+-- recorded, like SQLite's AUTOINCREMENT.
+CREATE TABLE audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT,
+	action TEXT NOT NULL,
+	resource_id TEXT,
+	ip TEXT NOT NULL,
+	status_code INTEGER NOT NULL,
+	result TEXT NOT NULL
+);
+CREATE INDEX idx_audit_log_user_id ON audit_log(user_id);
+CREATE INDEX idx_audit_log_resource_id ON audit_log(resource_id);
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
@@ -0,0 +1,3 @@
+-- The name of the file a video was uploaded as, which downloads are named
+-- after. Videos uploaded before it was recorded have none.
+ALTER TABLE videos ADD COLUMN original_filename TEXT;
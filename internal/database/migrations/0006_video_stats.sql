@@ -0,0 +1,9 @@
+-- Counts of what happened to videos per day (UTC), like playable URLs being
+-- handed out or clients reporting plays, for the stats owners see.
+CREATE TABLE video_stats (
+	video_id TEXT NOT NULL,
+	day TEXT NOT NULL,
+	event TEXT NOT NULL,
+	total INTEGER NOT NULL,
+	PRIMARY KEY (video_id, day, event)
+);
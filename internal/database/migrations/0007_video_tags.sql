@@ -0,0 +1,8 @@
+-- Tags owners put on their videos. Listings filtered by a tag look videos up
+-- by the tag, so it leads the index.
+CREATE TABLE video_tags (
+	video_id TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (video_id, tag)
+);
+CREATE INDEX idx_video_tags_tag ON video_tags(tag, video_id);
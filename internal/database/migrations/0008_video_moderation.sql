@@ -0,0 +1,7 @@
+-- Whether a video's content was cleared for publishing by moderation, and
+-- what moderation found in it if it was flagged. Videos from before
+-- moderation have no status and stay visible. Admins list the videos
+-- awaiting a decision by status.
+ALTER TABLE videos ADD COLUMN moderation_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN moderation_labels TEXT;
+CREATE INDEX idx_videos_moderation_status ON videos(moderation_status);
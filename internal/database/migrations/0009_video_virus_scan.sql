@@ -0,0 +1,3 @@
+-- The result of the latest virus scan of a file uploaded for a video, as
+-- JSON. Videos uploaded while scanning was off have none.
+ALTER TABLE videos ADD COLUMN virus_scan TEXT;
@@ -0,0 +1,13 @@
+-- Access tokens carry the token version of their user, which is bumped to
+-- revoke all of them at once, and single tokens are revoked by their ID
+-- until they expire.
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE revoked_tokens (
+	token_id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
@@ -0,0 +1,12 @@
+-- Single-use tokens that let whoever holds them upload media to one video
+-- on behalf of its owner, until they expire.
+CREATE TABLE upload_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+);
+
+CREATE INDEX idx_upload_tokens_expires_at ON upload_tokens(expires_at);
@@ -0,0 +1,4 @@
+-- Why a refresh token was revoked, so that presenting one that was rotated,
+-- which means it leaked, can be told apart from one that was logged out.
+-- Tokens revoked before this was recorded have none.
+ALTER TABLE refresh_tokens ADD COLUMN revoked_reason TEXT;
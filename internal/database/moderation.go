@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ModerationStatus tracks whether a video's content was cleared for
+// publishing. Until it is, only the video's owner can see it.
+type ModerationStatus string
+
+const (
+	// ModerationStatusNone is the status of videos that were never
+	// moderated, because moderation wasn't enabled when they were uploaded.
+	ModerationStatusNone ModerationStatus = ""
+	// ModerationStatusPending is the status of videos waiting to be
+	// scanned.
+	ModerationStatusPending ModerationStatus = "pending"
+	// ModerationStatusReview is the status of videos whose scan flagged
+	// something, until an admin approves or rejects them.
+	ModerationStatusReview   ModerationStatus = "pending_review"
+	ModerationStatusApproved ModerationStatus = "approved"
+	ModerationStatusRejected ModerationStatus = "rejected"
+)
+
+func (s ModerationStatus) Valid() bool {
+	switch s {
+	case ModerationStatusNone, ModerationStatusPending, ModerationStatusReview, ModerationStatusApproved, ModerationStatusRejected:
+		return true
+	}
+	return false
+}
+
+// Cleared reports whether a video with the status may be seen by anyone
+// other than its owner.
+func (s ModerationStatus) Cleared() bool {
+	return s == ModerationStatusNone || s == ModerationStatusApproved
+}
+
+// ModerationLabel is something moderation found in a video, such as
+// "Explicit Nudity", with its confidence from 0 to 100 and where it was
+// found, e.g. "thumbnail" or "frame at 12.5s".
+type ModerationLabel struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source"`
+}
+
+// ModerationLabels are persisted as JSON in the videos.moderation_labels
+// column.
+type ModerationLabels []ModerationLabel
+
+func (l ModerationLabels) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (l *ModerationLabels) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("unsupported type for moderation labels: %T", src)
+	}
+}
+
+// UpdateVideoModeration sets the moderation status and labels of a video,
+// leaving the rest of the row alone.
+func (c Client) UpdateVideoModeration(id uuid.UUID, status ModerationStatus, labels ModerationLabels) error {
+	query := `
+	UPDATE videos
+	SET
+		moderation_status = ?,
+		moderation_labels = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, labels, id)
+	return err
+}
+
+// CompleteVideoModeration records the outcome of scanning video, but only
+// if it's still pending and its media and thumbnail are still those that
+// were scanned. Otherwise it returns ErrVideoMediaChanged, and the scan
+// queued for the new media decides.
+func (c Client) CompleteVideoModeration(video Video, status ModerationStatus, labels ModerationLabels) error {
+	query := `
+	UPDATE videos
+	SET
+		moderation_status = ?,
+		moderation_labels = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+		AND moderation_status = ?
+		AND storage_key IS NOT DISTINCT FROM ?
+		AND thumbnail_url IS NOT DISTINCT FROM ?
+	`
+	result, err := c.db.Exec(query, status, labels, video.ID, ModerationStatusPending, video.StorageKey, video.ThumbnailURL)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrVideoMediaChanged
+	}
+	return nil
+}
+
+// GetVideosByModerationStatus returns the videos not in the trash with the
+// given moderation status, oldest first, so they're reviewed in the order
+// they came in.
+func (c Client) GetVideosByModerationStatus(status ModerationStatus) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NULL AND moderation_status = ?
+	ORDER BY created_at, id
+	`
+	return c.queryVideos(query, status)
+}
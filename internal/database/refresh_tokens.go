@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,11 +10,27 @@ import (
 
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	RevokedAt     *time.Time    `json:"revoked_at"`
+	RevokedReason RevokedReason `json:"revoked_reason,omitempty"`
 }
 
+// RevokedReason tells why a refresh token was revoked. It's empty for tokens
+// that weren't, or were revoked before the reason was recorded.
+type RevokedReason string
+
+const (
+	// RevokedReasonRotated tokens were exchanged for a new one, so
+	// presenting them again means they leaked.
+	RevokedReasonRotated RevokedReason = "rotated"
+	// RevokedReasonLogout tokens were revoked by logging out.
+	RevokedReasonLogout RevokedReason = "logout"
+	// RevokedReasonRevoked tokens were revoked along with the rest of their
+	// user's.
+	RevokedReasonRevoked RevokedReason = "revoked"
+)
+
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
@@ -38,26 +55,74 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RevokeRefreshToken revokes a refresh token on logout. Tokens that were
+// already revoked keep the reason they were revoked for.
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
-		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.Exec(query, RevokedReasonLogout, token)
 	return err
 }
 
+// ErrRefreshTokenRevoked is returned by RotateRefreshToken when the token
+// was revoked concurrently, e.g. by a parallel refresh with the same token.
+var ErrRefreshTokenRevoked = errors.New("refresh token already revoked")
+
+// RotateRefreshToken revokes oldToken and stores its replacement in a single
+// transaction, so a refresh token can only ever be exchanged once.
+func (c Client) RotateRefreshToken(oldToken string, params CreateRefreshTokenParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
+	`, RevokedReasonRotated, oldToken)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRefreshTokenRevoked
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			user_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`, params.Token, params.UserID.String(), params.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, revoked_reason
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
+	var revokedReason sql.NullString
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &revokedReason)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -69,6 +134,7 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	rt.RevokedReason = RevokedReason(revokedReason.String)
 
 	return rt, nil
 }
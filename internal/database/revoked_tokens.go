@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RevokeToken revokes the access token with ID tokenID, issued for userID,
+// until it expires.
+func (c Client) RevokeToken(tokenID string, userID uuid.UUID, expiresAt time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (token_id, user_id, expires_at, revoked_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (token_id) DO NOTHING
+	`
+	_, err := c.db.Exec(query, tokenID, userID.String(), expiresAt.UTC().Format(timestampLayout))
+	return err
+}
+
+// RevokeUserTokens revokes every access and refresh token of a user, by
+// bumping the user's token version and revoking the refresh tokens in a
+// single transaction.
+func (c Client) RevokeUserTokens(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET token_version = token_version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, userID.String())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, RevokedReasonRevoked, userID.String())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TokenRevoked reports whether the access token with ID tokenID, issued for
+// userID at the given token version, was revoked, either by itself or along
+// with all of the user's tokens. Tokens of users that don't exist aren't
+// considered revoked.
+func (c Client) TokenRevoked(userID uuid.UUID, tokenID string, version int) (bool, error) {
+	query := `
+		SELECT
+			token_version,
+			EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = ?)
+		FROM users
+		WHERE id = ?
+	`
+	var current int
+	var revoked bool
+	err := c.db.QueryRow(query, tokenID, userID.String()).Scan(&current, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return revoked || version < current, nil
+}
+
+// DeleteRevokedTokensBefore forgets revoked tokens that expired before
+// cutoff, since they're refused anyway.
+func (c Client) DeleteRevokedTokensBefore(cutoff time.Time) (int64, error) {
+	query := `
+	DELETE FROM revoked_tokens
+	WHERE expires_at < ?
+	`
+	result, err := c.db.Exec(query, cutoff.UTC().Format(timestampLayout))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ShareLink struct {
+	CreateShareLinkParams
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type CreateShareLinkParams struct {
+	Token     string    `json:"token"`
+	VideoID   uuid.UUID `json:"video_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateShareLink(params CreateShareLinkParams) (ShareLink, error) {
+	query := `
+		INSERT INTO share_links (
+			token,
+			created_at,
+			updated_at,
+			video_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.Token, params.VideoID.String(), params.ExpiresAt.UTC())
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	return c.GetShareLink(params.Token)
+}
+
+func (c Client) GetShareLink(token string) (ShareLink, error) {
+	query := `
+		SELECT token, created_at, updated_at, video_id, expires_at, revoked_at
+		FROM share_links
+		WHERE token = ?
+	`
+	var link ShareLink
+	var videoID string
+	err := c.db.QueryRow(query, token).
+		Scan(&link.Token, &link.CreatedAt, &link.UpdatedAt, &videoID, &link.ExpiresAt, &link.RevokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+
+	link.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	return link, nil
+}
+
+func (c Client) RevokeShareLink(token string) error {
+	query := `
+		UPDATE share_links
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ?
+	`
+	_, err := c.db.Exec(query, token)
+	return err
+}
+
+func (c Client) DeleteShareLinksForVideo(videoID uuid.UUID) error {
+	query := `
+		DELETE FROM share_links
+		WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, videoID.String())
+	return err
+}
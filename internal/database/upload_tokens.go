@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadToken lets whoever holds it upload media to a video once on behalf
+// of the video's owner, until it expires.
+type UploadToken struct {
+	Token     string     `json:"token"`
+	CreatedAt time.Time  `json:"created_at"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+type CreateUploadTokenParams struct {
+	Token     string
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+func (c Client) CreateUploadToken(params CreateUploadTokenParams) (UploadToken, error) {
+	query := `
+		INSERT INTO upload_tokens (
+			token,
+			created_at,
+			video_id,
+			user_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.Token, params.VideoID.String(), params.UserID.String(), params.ExpiresAt.UTC().Format(timestampLayout))
+	if err != nil {
+		return UploadToken{}, err
+	}
+
+	return c.GetUploadToken(params.Token)
+}
+
+// GetUploadToken returns the upload token, or a zero UploadToken if there's
+// none.
+func (c Client) GetUploadToken(token string) (UploadToken, error) {
+	query := `
+		SELECT token, created_at, video_id, user_id, expires_at, used_at
+		FROM upload_tokens
+		WHERE token = ?
+	`
+	var ut UploadToken
+	var videoID, userID string
+	err := c.db.QueryRow(query, token).
+		Scan(&ut.Token, &ut.CreatedAt, &videoID, &userID, &ut.ExpiresAt, &ut.UsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UploadToken{}, nil
+	}
+	if err != nil {
+		return UploadToken{}, err
+	}
+
+	ut.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return UploadToken{}, err
+	}
+	ut.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return UploadToken{}, err
+	}
+	return ut, nil
+}
+
+// UseUploadToken marks an unused, unexpired upload token as used. It
+// reports false if the token was used already, e.g. by a concurrent upload,
+// or has expired, so each token is only ever used once.
+func (c Client) UseUploadToken(token string) (bool, error) {
+	query := `
+		UPDATE upload_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND used_at IS NULL AND expires_at > ?
+	`
+	result, err := c.db.Exec(query, token, time.Now().UTC().Format(timestampLayout))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// DeleteUploadTokensBefore forgets upload tokens that expired before cutoff.
+func (c Client) DeleteUploadTokensBefore(cutoff time.Time) (int64, error) {
+	query := `
+	DELETE FROM upload_tokens
+	WHERE expires_at < ?
+	`
+	result, err := c.db.Exec(query, cutoff.UTC().Format(timestampLayout))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserUsage totals what a user's videos take up. It's kept up to date as
+// media is stored and deleted instead of being computed from the storage
+// backends.
+type UserUsage struct {
+	UserID    uuid.UUID `json:"user_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// VideoCount includes videos in the trash, whose media is still stored.
+	VideoCount int `json:"video_count"`
+	// StorageBytes is the size of the stored videos, their versions and
+	// their renditions. Objects shared by deduplicated uploads count towards
+	// every video using them.
+	StorageBytes int64 `json:"storage_bytes"`
+	// ThumbnailBytes is the size of the thumbnails stored in the assets
+	// directory.
+	ThumbnailBytes    int64   `json:"thumbnail_bytes"`
+	ProcessingSeconds float64 `json:"processing_seconds"`
+}
+
+// UsageDelta is a change to a user's usage.
+type UsageDelta struct {
+	VideoCount        int
+	StorageBytes      int64
+	ThumbnailBytes    int64
+	ProcessingSeconds float64
+}
+
+// execer is implemented by both conn and txConn, so usage can be recorded in
+// the transaction of the change it accounts for.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+const userUsageColumns = `
+	user_id,
+	updated_at,
+	video_count,
+	storage_bytes,
+	thumbnail_bytes,
+	processing_seconds
+`
+
+// addUsageOnConflict adds a delta to the usage a user already has.
+const addUsageOnConflict = `
+	ON CONFLICT(user_id) DO UPDATE SET
+		video_count = video_count + excluded.video_count,
+		storage_bytes = storage_bytes + excluded.storage_bytes,
+		thumbnail_bytes = thumbnail_bytes + excluded.thumbnail_bytes,
+		processing_seconds = processing_seconds + excluded.processing_seconds,
+		updated_at = CURRENT_TIMESTAMP
+`
+
+// AddUserUsage adds delta to the usage of a user.
+func (c Client) AddUserUsage(userID uuid.UUID, delta UsageDelta) error {
+	return addUserUsage(c.db, userID, delta)
+}
+
+func addUserUsage(db execer, userID uuid.UUID, delta UsageDelta) error {
+	query := `
+	INSERT INTO user_usage (` + userUsageColumns + `)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	` + addUsageOnConflict
+	_, err := db.Exec(query, userID, delta.VideoCount, delta.StorageBytes, delta.ThumbnailBytes, delta.ProcessingSeconds)
+	return err
+}
+
+// addVideoUsage adds delta to the usage of the user owning a video. The
+// casts tell Postgres the types of the selected placeholders, which it
+// would otherwise take for text.
+func addVideoUsage(db execer, videoID uuid.UUID, delta UsageDelta) error {
+	query := `
+	INSERT INTO user_usage (` + userUsageColumns + `)
+	SELECT user_id, CURRENT_TIMESTAMP, CAST(? AS INTEGER), CAST(? AS BIGINT), CAST(? AS BIGINT), CAST(? AS DOUBLE PRECISION)
+	FROM videos
+	WHERE id = ?
+	` + addUsageOnConflict
+	_, err := db.Exec(query, delta.VideoCount, delta.StorageBytes, delta.ThumbnailBytes, delta.ProcessingSeconds, videoID)
+	return err
+}
+
+// GetAllUserUsage returns the usage of every user that has stored anything,
+// largest storage first.
+func (c Client) GetAllUserUsage() ([]UserUsage, error) {
+	query := `
+	SELECT` + userUsageColumns + `
+	FROM user_usage
+	ORDER BY storage_bytes DESC, user_id
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []UserUsage{}
+	for rows.Next() {
+		var usage UserUsage
+		err := rows.Scan(
+			&usage.UserID,
+			&usage.UpdatedAt,
+			&usage.VideoCount,
+			&usage.StorageBytes,
+			&usage.ThumbnailBytes,
+			&usage.ProcessingSeconds,
+		)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+	return usages, rows.Err()
+}
+
+// BackfillUserUsage computes the usage of databases that predate usage
+// tracking from the videos, versions, renditions and processing jobs
+// recorded so far. It does nothing and returns false once any usage was
+// recorded, or when there are no videos. Thumbnail sizes aren't recorded in
+// the database, so they're left to the caller.
+func (c Client) BackfillUserUsage() (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var recorded, videos int
+	err = tx.QueryRow(`
+	SELECT
+		(SELECT COUNT(*) FROM user_usage),
+		(SELECT COUNT(*) FROM videos)
+	`).Scan(&recorded, &videos)
+	if err != nil {
+		return false, err
+	}
+	if recorded > 0 || videos == 0 {
+		return false, nil
+	}
+
+	queries := []string{
+		`INSERT INTO user_usage (` + userUsageColumns + `)
+		SELECT user_id, CURRENT_TIMESTAMP, COUNT(*), 0, 0, 0
+		FROM videos
+		WHERE true
+		GROUP BY user_id
+		` + addUsageOnConflict,
+		`INSERT INTO user_usage (` + userUsageColumns + `)
+		SELECT videos.user_id, CURRENT_TIMESTAMP, 0, SUM(video_versions.size), 0, 0
+		FROM video_versions
+		JOIN videos ON videos.id = video_versions.video_id
+		WHERE true
+		GROUP BY videos.user_id
+		` + addUsageOnConflict,
+		`INSERT INTO user_usage (` + userUsageColumns + `)
+		SELECT videos.user_id, CURRENT_TIMESTAMP, 0, SUM(video_renditions.size), 0, 0
+		FROM video_renditions
+		JOIN videos ON videos.id = video_renditions.video_id
+		WHERE true
+		GROUP BY videos.user_id
+		` + addUsageOnConflict,
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = backfillProcessingTime(tx)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// backfillProcessingTime adds up the stage timings of processing jobs. Jobs
+// only keep the timings of their last attempt. They're summed here rather
+// than in SQL, whose JSON functions differ between the databases.
+func backfillProcessingTime(tx *txConn) error {
+	rows, err := tx.Query(`
+	SELECT videos.user_id, jobs.timings
+	FROM jobs
+	JOIN videos ON videos.id = jobs.reference
+	WHERE jobs.type = 'process_video' AND jobs.timings IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+	seconds := map[uuid.UUID]float64{}
+	for rows.Next() {
+		var userID uuid.UUID
+		var timings JobTimings
+		err := rows.Scan(&userID, &timings)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		for _, stage := range timings {
+			seconds[userID] += stage
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for userID, total := range seconds {
+		err = addUserUsage(tx, userID, UsageDelta{ProcessingSeconds: total})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
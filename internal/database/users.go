@@ -13,18 +13,29 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	CreateUserParams
+	// WatermarkOptOut exempts the user's videos from the watermark.
+	WatermarkOptOut bool `json:"watermark_opt_out"`
+	// TokenVersion is put in the user's access tokens. It's bumped to revoke
+	// all of them.
+	TokenVersion int `json:"-"`
 }
 
 type CreateUserParams struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Role     string `json:"role"`
 }
 
+// DefaultUserRole is given to users created without an explicit role.
+const DefaultUserRole = "uploader"
+
 func (c Client) GetUsers() ([]User, error) {
 	query := `
 		SELECT
 			id,
-			email
+			email,
+			role,
+			watermark_opt_out
 		FROM users
 	`
 
@@ -38,7 +49,7 @@ func (c Client) GetUsers() ([]User, error) {
 	for rows.Next() {
 		var user User
 		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
+		if err := rows.Scan(&id, &user.Email, &user.Role, &user.WatermarkOptOut); err != nil {
 			return nil, err
 		}
 		user.ID, err = uuid.Parse(id)
@@ -53,13 +64,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role, token_version
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.TokenVersion)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,7 +86,7 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.password, u.role
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,7 +94,7 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password, &user.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -103,11 +114,15 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 	query := `
 		INSERT INTO users
-		    (id, created_at, updated_at, email, password)
+		    (id, created_at, updated_at, email, password, role)
 		VALUES
-		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	role := params.Role
+	if role == "" {
+		role = DefaultUserRole
+	}
+	_, err := c.db.Exec(query, id.String(), params.Email, params.Password, role)
 	if err != nil {
 		return nil, err
 	}
@@ -117,13 +132,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role, watermark_opt_out, token_version
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.WatermarkOptOut, &user.TokenVersion)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,11 +152,35 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
+func (c Client) UpdateUserRole(id uuid.UUID, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+func (c Client) UpdateUserWatermarkOptOut(id uuid.UUID, optOut bool) error {
+	query := `
+		UPDATE users
+		SET watermark_opt_out = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, optOut, id.String())
+	return err
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.db.Exec(`DELETE FROM user_usage WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(query, id.String())
 	return err
 }
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// VideoMetadata holds the technical details reported by ffprobe for a stored
+// video. It is persisted as JSON in the videos.metadata column. Width, Height
+// and AspectRatio describe the video as displayed, i.e. after Rotation has
+// been applied.
+type VideoMetadata struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	AspectRatio     string  `json:"aspect_ratio,omitempty"`
+	Rotation        int     `json:"rotation,omitempty"`
+	VideoCodec      string  `json:"video_codec"`
+	AudioCodec      string  `json:"audio_codec,omitempty"`
+	BitRate         int64   `json:"bit_rate"`
+	FrameRate       float64 `json:"frame_rate"`
+}
+
+func (m VideoMetadata) Value() (driver.Value, error) {
+	dat, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (m *VideoMetadata) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	default:
+		return fmt.Errorf("unsupported type for video metadata: %T", src)
+	}
+}
+
+// ThumbnailSrcset maps a width descriptor such as "320w" to the URL of the
+// thumbnail resized to that width. It is persisted as JSON in the
+// videos.thumbnail_srcset column.
+type ThumbnailSrcset map[string]string
+
+func (s ThumbnailSrcset) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (s *ThumbnailSrcset) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case []byte:
+		return json.Unmarshal(v, s)
+	default:
+		return fmt.Errorf("unsupported type for thumbnail srcset: %T", src)
+	}
+}
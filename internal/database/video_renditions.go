@@ -0,0 +1,206 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoRendition is a lower resolution MP4 of a video's current media, for
+// clients that pick a quality themselves rather than streaming adaptively.
+type VideoRendition struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateVideoRenditionParams
+}
+
+type CreateVideoRenditionParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Height is the nominal resolution, e.g. 720 for both 1280x720 and
+	// 720x1280.
+	Height        int    `json:"height"`
+	Width         int    `json:"width"`
+	StorageBucket string `json:"-"`
+	StorageKey    string `json:"-"`
+	VideoURL      string `json:"video_url"`
+	Size          int64  `json:"size"`
+}
+
+const videoRenditionColumns = `
+		id,
+		created_at,
+		video_id,
+		height,
+		width,
+		storage_bucket,
+		storage_key,
+		video_url,
+		size`
+
+func scanVideoRendition(row rowScanner) (VideoRendition, error) {
+	var rendition VideoRendition
+	err := row.Scan(
+		&rendition.ID,
+		&rendition.CreatedAt,
+		&rendition.VideoID,
+		&rendition.Height,
+		&rendition.Width,
+		&rendition.StorageBucket,
+		&rendition.StorageKey,
+		&rendition.VideoURL,
+		&rendition.Size,
+	)
+	return rendition, err
+}
+
+// ReplaceVideoRenditions swaps the renditions of a video for new ones in a
+// single transaction and returns the renditions it replaced.
+func (c Client) ReplaceVideoRenditions(videoID uuid.UUID, renditions []CreateVideoRenditionParams) ([]VideoRendition, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	DELETE FROM video_renditions
+	WHERE video_id = ?
+	RETURNING`+videoRenditionColumns, videoID)
+	if err != nil {
+		return nil, err
+	}
+	replaced := []VideoRendition{}
+	for rows.Next() {
+		rendition, err := scanVideoRendition(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		replaced = append(replaced, rendition)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var added int64
+	for _, rendition := range replaced {
+		added -= rendition.Size
+	}
+	for _, params := range renditions {
+		added += params.Size
+		_, err = tx.Exec(`
+		INSERT INTO video_renditions (
+			id,
+			created_at,
+			video_id,
+			height,
+			width,
+			storage_bucket,
+			storage_key,
+			video_url,
+			size
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			uuid.New(),
+			videoID,
+			params.Height,
+			params.Width,
+			params.StorageBucket,
+			params.StorageKey,
+			params.VideoURL,
+			params.Size,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = addVideoUsage(tx, videoID, UsageDelta{StorageBytes: added})
+	if err != nil {
+		return nil, err
+	}
+
+	return replaced, tx.Commit()
+}
+
+// GetVideoRenditions returns the renditions of a video, highest resolution
+// first.
+func (c Client) GetVideoRenditions(videoID uuid.UUID) ([]VideoRendition, error) {
+	query := `
+	SELECT` + videoRenditionColumns + `
+	FROM video_renditions
+	WHERE video_id = ?
+	ORDER BY height DESC
+	`
+	return c.queryVideoRenditions(query, videoID)
+}
+
+// GetAllVideoRenditions returns the renditions of every video.
+func (c Client) GetAllVideoRenditions() ([]VideoRendition, error) {
+	query := `
+	SELECT` + videoRenditionColumns + `
+	FROM video_renditions
+	`
+	return c.queryVideoRenditions(query)
+}
+
+func (c Client) queryVideoRenditions(query string, args ...any) ([]VideoRendition, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []VideoRendition{}
+	for rows.Next() {
+		rendition, err := scanVideoRendition(rows)
+		if err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, rendition)
+	}
+	return renditions, rows.Err()
+}
+
+// CountRenditionStorageKeyReferences returns how many renditions of videos
+// other than excludeVideoID use the object at bucket and key.
+func (c Client) CountRenditionStorageKeyReferences(bucket, key string, excludeVideoID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM video_renditions
+	WHERE storage_bucket = ? AND storage_key = ? AND video_id != ?
+	`
+	var count int
+	err := c.db.QueryRow(query, bucket, key, excludeVideoID).Scan(&count)
+	return count, err
+}
+
+func (c Client) DeleteVideoRendition(id uuid.UUID) error {
+	query := `
+	DELETE FROM video_renditions
+	WHERE id = ?
+	RETURNING video_id, size
+	`
+	return deleteStoredMedia(c.db, query, id)
+}
+
+func (c Client) DeleteVideoRenditions(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM video_renditions
+	WHERE video_id = ?
+	RETURNING video_id, size
+	`
+	return deleteStoredMedia(c.db, query, videoID)
+}
+
+// UpdateVideoRenditionKey points a rendition at its object's new key and URL
+// in the same bucket.
+func (c Client) UpdateVideoRenditionKey(id uuid.UUID, key, videoURL string) error {
+	query := `
+	UPDATE video_renditions
+	SET storage_key = ?, video_url = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, key, videoURL, id)
+	return err
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoEvent is something counted in a video's stats.
+type VideoEvent string
+
+const (
+	// VideoEventURLIssued is a playable URL of the video being handed out.
+	VideoEventURLIssued VideoEvent = "url_issued"
+	// VideoEventPlay is a play reported by a client.
+	VideoEventPlay VideoEvent = "play"
+)
+
+// DailyVideoStats are the counts of a video's events on a day.
+type DailyVideoStats struct {
+	Date       string `json:"date"`
+	URLsIssued int64  `json:"urls_issued"`
+	Plays      int64  `json:"plays"`
+}
+
+// RecordVideoEvent counts an event of a video on the UTC day of at.
+func (c Client) RecordVideoEvent(videoID uuid.UUID, event VideoEvent, at time.Time) error {
+	query := `
+	INSERT INTO video_stats (video_id, day, event, total)
+	VALUES (?, ?, ?, 1)
+	ON CONFLICT (video_id, day, event) DO UPDATE SET total = video_stats.total + 1
+	`
+	_, err := c.db.Exec(query, videoID, at.UTC().Format(time.DateOnly), event)
+	return err
+}
+
+// GetDailyVideoStats returns the stats of a video for the UTC days from
+// since through until, oldest first. Days without events are left out.
+func (c Client) GetDailyVideoStats(videoID uuid.UUID, since, until time.Time) ([]DailyVideoStats, error) {
+	query := `
+	SELECT day, event, total
+	FROM video_stats
+	WHERE video_id = ? AND day >= ? AND day <= ?
+	ORDER BY day
+	`
+	rows, err := c.db.Query(query, videoID, since.UTC().Format(time.DateOnly), until.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []DailyVideoStats{}
+	for rows.Next() {
+		var day string
+		var event VideoEvent
+		var total int64
+		err := rows.Scan(&day, &event, &total)
+		if err != nil {
+			return nil, err
+		}
+		if len(days) == 0 || days[len(days)-1].Date != day {
+			days = append(days, DailyVideoStats{Date: day})
+		}
+		switch event {
+		case VideoEventURLIssued:
+			days[len(days)-1].URLsIssued = total
+		case VideoEventPlay:
+			days[len(days)-1].Plays = total
+		}
+	}
+	return days, rows.Err()
+}
+
+func (c Client) DeleteVideoStats(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM video_stats
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, videoID)
+	return err
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// GetVideoTags returns the tags of a video in alphabetical order.
+func (c Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	tags, err := c.GetTagsForVideos([]uuid.UUID{videoID})
+	if err != nil {
+		return nil, err
+	}
+	if tags[videoID] == nil {
+		return []string{}, nil
+	}
+	return tags[videoID], nil
+}
+
+// GetTagsForVideos returns the tags of several videos at once, keyed by
+// video ID.
+func (c Client) GetTagsForVideos(videoIDs []uuid.UUID) (map[uuid.UUID][]string, error) {
+	tags := map[uuid.UUID][]string{}
+	if len(videoIDs) == 0 {
+		return tags, nil
+	}
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `
+	SELECT video_id, tag
+	FROM video_tags
+	WHERE video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)
+	ORDER BY tag
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var videoID uuid.UUID
+		var tag string
+		err := rows.Scan(&videoID, &tag)
+		if err != nil {
+			return nil, err
+		}
+		tags[videoID] = append(tags[videoID], tag)
+	}
+	return tags, rows.Err()
+}
+
+// SetVideoTags replaces the tags of a video.
+func (c Client) SetVideoTags(videoID uuid.UUID, tags []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM video_tags WHERE video_id = ?`, videoID)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		_, err = tx.Exec(`INSERT INTO video_tags (video_id, tag) VALUES (?, ?)`, videoID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddVideoTag tags a video. Adding a tag the video already has is not an
+// error.
+func (c Client) AddVideoTag(videoID uuid.UUID, tag string) error {
+	query := `
+	INSERT INTO video_tags (video_id, tag)
+	VALUES (?, ?)
+	ON CONFLICT (video_id, tag) DO NOTHING
+	`
+	_, err := c.db.Exec(query, videoID, tag)
+	return err
+}
+
+// RemoveVideoTag removes a tag from a video. Removing a tag the video doesn't
+// have is not an error.
+func (c Client) RemoveVideoTag(videoID uuid.UUID, tag string) error {
+	query := `
+	DELETE FROM video_tags
+	WHERE video_id = ? AND tag = ?
+	`
+	_, err := c.db.Exec(query, videoID, tag)
+	return err
+}
+
+func (c Client) DeleteVideoTags(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM video_tags
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, videoID)
+	return err
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoVersion is one media upload of a video, kept so the video can be
+// rolled back to it.
+type VideoVersion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateVideoVersionParams
+}
+
+type CreateVideoVersionParams struct {
+	VideoID        uuid.UUID      `json:"video_id"`
+	StorageBucket  string         `json:"-"`
+	StorageKey     string         `json:"key"`
+	VideoURL       string         `json:"-"`
+	Size           int64          `json:"size"`
+	ChecksumSHA256 *string        `json:"checksum_sha256,omitempty"`
+	Metadata       *VideoMetadata `json:"metadata,omitempty"`
+	// ContentSHA256 is the hex SHA-256 of the stored object, which differs
+	// from ChecksumSHA256 once the upload is processed.
+	ContentSHA256 *string `json:"content_sha256,omitempty"`
+}
+
+const videoVersionColumns = `
+		id,
+		created_at,
+		video_id,
+		storage_bucket,
+		storage_key,
+		video_url,
+		size,
+		checksum_sha256,
+		metadata,
+		content_sha256`
+
+func scanVideoVersion(row rowScanner) (VideoVersion, error) {
+	var version VideoVersion
+	err := row.Scan(
+		&version.ID,
+		&version.CreatedAt,
+		&version.VideoID,
+		&version.StorageBucket,
+		&version.StorageKey,
+		&version.VideoURL,
+		&version.Size,
+		&version.ChecksumSHA256,
+		&version.Metadata,
+		&version.ContentSHA256,
+	)
+	return version, err
+}
+
+func (c Client) CreateVideoVersion(params CreateVideoVersionParams) (VideoVersion, error) {
+	query := `
+	INSERT INTO video_versions (
+		id,
+		created_at,
+		video_id,
+		storage_bucket,
+		storage_key,
+		video_url,
+		size,
+		checksum_sha256,
+		metadata,
+		content_sha256
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING` + videoVersionColumns
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return VideoVersion{}, err
+	}
+	defer tx.Rollback()
+
+	version, err := scanVideoVersion(tx.QueryRow(
+		query,
+		uuid.New(),
+		params.VideoID,
+		params.StorageBucket,
+		params.StorageKey,
+		params.VideoURL,
+		params.Size,
+		params.ChecksumSHA256,
+		params.Metadata,
+		params.ContentSHA256,
+	))
+	if err != nil {
+		return VideoVersion{}, err
+	}
+	err = addVideoUsage(tx, params.VideoID, UsageDelta{StorageBytes: params.Size})
+	if err != nil {
+		return VideoVersion{}, err
+	}
+	return version, tx.Commit()
+}
+
+func (c Client) GetVideoVersion(id uuid.UUID) (VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	WHERE id = ?
+	`
+	return scanVideoVersion(c.db.QueryRow(query, id))
+}
+
+// GetVideoVersionByKey returns the version of a video stored at key, or nil
+// if none was recorded.
+func (c Client) GetVideoVersionByKey(videoID uuid.UUID, bucket, key string) (*VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ? AND storage_bucket = ? AND storage_key = ?
+	`
+	version, err := scanVideoVersion(c.db.QueryRow(query, videoID, bucket, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &version, nil
+}
+
+// GetVideoVersions returns the versions of a video, newest first.
+func (c Client) GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ?
+	ORDER BY ` + c.db.dialect.newestFirst("created_at")
+	return c.queryVideoVersions(query, videoID)
+}
+
+// GetAllVideoVersions returns the versions of every video.
+func (c Client) GetAllVideoVersions() ([]VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	`
+	return c.queryVideoVersions(query)
+}
+
+func (c Client) queryVideoVersions(query string, args ...any) ([]VideoVersion, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		version, err := scanVideoVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// CountVersionStorageKeyReferences returns how many versions of videos other
+// than excludeVideoID keep the object at bucket and key.
+func (c Client) CountVersionStorageKeyReferences(bucket, key string, excludeVideoID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM video_versions
+	WHERE storage_bucket = ? AND storage_key = ? AND video_id != ?
+	`
+	var count int
+	err := c.db.QueryRow(query, bucket, key, excludeVideoID).Scan(&count)
+	return count, err
+}
+
+func (c Client) DeleteVideoVersion(id uuid.UUID) error {
+	query := `
+	DELETE FROM video_versions
+	WHERE id = ?
+	RETURNING video_id, size
+	`
+	return deleteStoredMedia(c.db, query, id)
+}
+
+func (c Client) DeleteVideoVersions(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM video_versions
+	WHERE video_id = ?
+	RETURNING video_id, size
+	`
+	return deleteStoredMedia(c.db, query, videoID)
+}
+
+// deleteStoredMedia runs a query deleting versions or renditions, which
+// returns the video and size of each row it deleted, and takes their sizes
+// off the usage of the videos' owners.
+func deleteStoredMedia(db *conn, query string, args ...any) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	sizes := map[uuid.UUID]int64{}
+	for rows.Next() {
+		var videoID uuid.UUID
+		var size int64
+		err := rows.Scan(&videoID, &size)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		sizes[videoID] += size
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for videoID, size := range sizes {
+		err = addVideoUsage(tx, videoID, UsageDelta{StorageBytes: -size})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateVideoVersionKey points a version at its object's new key and URL in
+// the same bucket.
+func (c Client) UpdateVideoVersionKey(id uuid.UUID, key, videoURL string) error {
+	query := `
+	UPDATE video_versions
+	SET storage_key = ?, video_url = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, key, videoURL, id)
+	return err
+}
+
+// UpdateVideoVersionContentChecksum records the checksum of a version's
+// stored object.
+func (c Client) UpdateVideoVersionContentChecksum(id uuid.UUID, checksum string) error {
+	query := `
+	UPDATE video_versions
+	SET content_sha256 = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, checksum, id)
+	return err
+}
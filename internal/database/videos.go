@@ -3,17 +3,99 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ProcessingStatus string
+
+const (
+	ProcessingStatusNone       ProcessingStatus = ""
+	ProcessingStatusPending    ProcessingStatus = "pending"
+	ProcessingStatusProcessing ProcessingStatus = "processing"
+	ProcessingStatusReady      ProcessingStatus = "ready"
+	ProcessingStatusFailed     ProcessingStatus = "failed"
+)
+
+// ArchiveStatus tracks a video whose stored object was moved to an archive
+// storage class, from which it has to be restored before it can be played.
+type ArchiveStatus string
+
+const (
+	ArchiveStatusNone      ArchiveStatus = ""
+	ArchiveStatusArchived  ArchiveStatus = "archived"
+	ArchiveStatusRestoring ArchiveStatus = "restoring"
+	ArchiveStatusRestored  ArchiveStatus = "restored"
+)
+
+// Playable reports whether the video's stored object can be read.
+func (s ArchiveStatus) Playable() bool {
+	return s == ArchiveStatusNone || s == ArchiveStatusRestored
+}
+
+// Visibility controls who can get a playable URL for a video.
+type Visibility string
+
+const (
+	VisibilityPrivate  Visibility = "private"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID               uuid.UUID        `json:"id"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+	ThumbnailURL     *string          `json:"thumbnail_url"`
+	VideoURL         *string          `json:"video_url"`
+	ProcessingStatus ProcessingStatus `json:"processing_status"`
+	ProcessingError  *string          `json:"processing_error,omitempty"`
+	HLSURL           *string          `json:"hls_url"`
+	Metadata         *VideoMetadata   `json:"metadata"`
+	ChecksumSHA256   *string          `json:"checksum_sha256"`
+	// ContentSHA256 is the hex SHA-256 of the stored MP4, which differs
+	// from ChecksumSHA256 once the upload is processed.
+	ContentSHA256     *string         `json:"content_sha256,omitempty"`
+	StorageBucket     *string         `json:"-"`
+	StorageKey        *string         `json:"-"`
+	Visibility        Visibility      `json:"visibility"`
+	ThumbnailSrcset   ThumbnailSrcset `json:"thumbnail_srcset"`
+	SpriteURL         *string         `json:"sprite_url"`
+	SpriteVTTURL      *string         `json:"sprite_vtt_url"`
+	PreviewURL        *string         `json:"preview_url"`
+	DeletedAt         *time.Time      `json:"deleted_at,omitempty"`
+	PublishAt         *time.Time      `json:"publish_at,omitempty"`
+	ExpiresAt         *time.Time      `json:"expires_at,omitempty"`
+	WatermarkDisabled bool            `json:"watermark_disabled"`
+	ArchiveStatus     ArchiveStatus   `json:"archive_status"`
+	// RestoredUntil is when the restored copy of an archived video expires.
+	RestoredUntil *time.Time `json:"restored_until,omitempty"`
+	// OriginalFilename is the name of the file the current media was
+	// uploaded as, if the client sent one.
+	OriginalFilename *string `json:"original_filename,omitempty"`
+	// ModerationStatus is whether the video's content was cleared for
+	// publishing, and ModerationLabels what was found if it was flagged.
+	ModerationStatus ModerationStatus `json:"moderation_status"`
+	ModerationLabels ModerationLabels `json:"moderation_labels,omitempty"`
+	// VirusScan is the result of the latest virus scan of a file uploaded
+	// for the video.
+	VirusScan *VirusScan `json:"virus_scan,omitempty"`
+	// Captions live in their own table and are only loaded for API
+	// responses.
+	Captions []Caption `json:"captions,omitempty"`
+	// Tags are likewise kept in their own table.
+	Tags []string `json:"tags,omitempty"`
 	CreateVideoParams
 }
 
@@ -23,9 +105,7 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,13 +113,122 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		processing_status,
+		processing_error,
+		hls_url,
+		metadata,
+		checksum_sha256,
+		storage_bucket,
+		storage_key,
+		visibility,
+		thumbnail_srcset,
+		sprite_url,
+		sprite_vtt_url,
+		preview_url,
+		deleted_at,
+		publish_at,
+		expires_at,
+		watermark_disabled,
+		archive_status,
+		restored_until,
+		content_sha256,
+		original_filename,
+		moderation_status,
+		moderation_labels,
+		virus_scan`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.ProcessingStatus,
+		&video.ProcessingError,
+		&video.HLSURL,
+		&video.Metadata,
+		&video.ChecksumSHA256,
+		&video.StorageBucket,
+		&video.StorageKey,
+		&video.Visibility,
+		&video.ThumbnailSrcset,
+		&video.SpriteURL,
+		&video.SpriteVTTURL,
+		&video.PreviewURL,
+		&video.DeletedAt,
+		&video.PublishAt,
+		&video.ExpiresAt,
+		&video.WatermarkDisabled,
+		&video.ArchiveStatus,
+		&video.RestoredUntil,
+		&video.ContentSHA256,
+		&video.OriginalFilename,
+		&video.ModerationStatus,
+		&video.ModerationLabels,
+		&video.VirusScan,
+	)
+	return video, err
+}
+
+// VideoCursor identifies the last video of a page so the next page can
+// continue after it.
+type VideoCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type ListVideosParams struct {
+	UserID    uuid.UUID
+	Status    *ProcessingStatus
+	Tag       *string
+	Ascending bool
+	After     *VideoCursor
+	Limit     int
+}
+
+// ListVideos returns a page of a user's videos ordered by created_at, with
+// the ID as a tie-breaker so pages are stable.
+func (c Client) ListVideos(params ListVideosParams) ([]Video, error) {
+	order, cmp := "DESC", "<"
+	if params.Ascending {
+		order, cmp = "ASC", ">"
+	}
+
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
+	WHERE user_id = ? AND deleted_at IS NULL`
+	args := []any{params.UserID}
+
+	if params.Status != nil {
+		query += ` AND processing_status = ?`
+		args = append(args, *params.Status)
+	}
+	if params.Tag != nil {
+		query += ` AND id IN (SELECT video_id FROM video_tags WHERE tag = ?)`
+		args = append(args, *params.Tag)
+	}
+	if params.After != nil {
+		query += ` AND (created_at, id) ` + cmp + ` (?, ?)`
+		args = append(args, params.After.CreatedAt.UTC().Format(cursorTimestampLayout), params.After.ID)
+	}
+	query += `
+	ORDER BY created_at ` + order + `, id ` + order + `
+	LIMIT ?
 	`
+	args = append(args, params.Limit)
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +236,13 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
-
-	return videos, nil
+	return videos, rows.Err()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -78,7 +257,21 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		user_id
 	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Video{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(query, id, params.Title, params.Description, params.UserID)
+	if err != nil {
+		return Video{}, err
+	}
+	err = addUserUsage(tx, params.UserID, UsageDelta{VideoCount: 1})
+	if err != nil {
+		return Video{}, err
+	}
+	err = tx.Commit()
 	if err != nil {
 		return Video{}, err
 	}
@@ -86,31 +279,16 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	return c.GetVideo(id)
 }
 
+// GetVideo returns a video that isn't in the trash, or a zero Video if there
+// is none.
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,6 +299,112 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// GetProcessedVideoByChecksum finds another ready video of the user whose
+// source had the same content, so its stored objects can be reused. Videos
+// of other users are never matched, since their objects live under their
+// owner's prefix and go away with their account. It returns nil if there is
+// none.
+func (c Client) GetProcessedVideoByChecksum(userID uuid.UUID, checksum string, excludeID uuid.UUID) (*Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+		AND checksum_sha256 = ?
+		AND id != ?
+		AND processing_status = ?
+		AND video_url IS NOT NULL
+		AND archive_status = ''
+	ORDER BY created_at
+	LIMIT 1
+	`
+
+	video, err := scanVideo(c.db.QueryRow(query, userID, checksum, excludeID, ProcessingStatusReady))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &video, nil
+}
+
+// CountVideoURLReferences returns how many videos other than excludeID point
+// at the given HLS, sprite WebVTT or preview clip URL. Deduplicated uploads share stored
+// objects, which may only be deleted once nothing references them.
+func (c Client) CountVideoURLReferences(url string, excludeID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE (hls_url = ? OR sprite_vtt_url = ? OR preview_url = ?) AND id != ?
+	`
+	var count int
+	err := c.db.QueryRow(query, url, url, url, excludeID).Scan(&count)
+	return count, err
+}
+
+// CountStorageKeyReferences returns how many videos other than excludeID
+// are stored under the given bucket and key.
+func (c Client) CountStorageKeyReferences(bucket, key string, excludeID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE COALESCE(storage_bucket, '') = ? AND storage_key = ? AND id != ?
+	`
+	var count int
+	err := c.db.QueryRow(query, bucket, key, excludeID).Scan(&count)
+	return count, err
+}
+
+// GetAllVideos returns every video of every user.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// GetVideosMissingStorageKey returns videos created before the storage
+// location was recorded separately from the video URL.
+func (c Client) GetVideosMissingStorageKey() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_url IS NOT NULL AND storage_key IS NULL
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
 func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
@@ -129,7 +413,26 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		user_id = ?,
+		processing_status = ?,
+		processing_error = ?,
+		hls_url = ?,
+		metadata = ?,
+		checksum_sha256 = ?,
+		content_sha256 = ?,
+		storage_bucket = ?,
+		storage_key = ?,
+		visibility = ?,
+		thumbnail_srcset = ?,
+		sprite_url = ?,
+		sprite_vtt_url = ?,
+		preview_url = ?,
+		deleted_at = ?,
+		publish_at = ?,
+		expires_at = ?,
+		watermark_disabled = ?,
+		original_filename = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
@@ -140,16 +443,452 @@ func (c Client) UpdateVideo(video Video) error {
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.ProcessingStatus,
+		video.ProcessingError,
+		video.HLSURL,
+		video.Metadata,
+		video.ChecksumSHA256,
+		video.ContentSHA256,
+		video.StorageBucket,
+		video.StorageKey,
+		video.Visibility,
+		video.ThumbnailSrcset,
+		video.SpriteURL,
+		video.SpriteVTTURL,
+		video.PreviewURL,
+		video.DeletedAt,
+		video.PublishAt,
+		video.ExpiresAt,
+		video.WatermarkDisabled,
+		video.OriginalFilename,
 		video.ID,
 	)
 	return err
 }
 
+// ErrVideoMediaChanged is returned by SwapVideoMedia when the video's media
+// was replaced by someone else since it was loaded.
+var ErrVideoMediaChanged = errors.New("video media changed concurrently")
+
+// SwapVideoMedia records newly processed media for a video and marks it as
+// ready, in a single statement that only succeeds if the video still points
+// at previousKey. Only media columns are written, so concurrent edits to the
+// title or visibility are kept. New media isn't archived, so the archive
+// status is cleared. The moderation status is written too, since new media
+// may have to be moderated again.
+func (c Client) SwapVideoMedia(video Video, previousKey *string) error {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		video_url = ?,
+		processing_status = ?,
+		processing_error = ?,
+		hls_url = ?,
+		metadata = ?,
+		checksum_sha256 = ?,
+		content_sha256 = ?,
+		storage_bucket = ?,
+		storage_key = ?,
+		thumbnail_srcset = ?,
+		sprite_url = ?,
+		sprite_vtt_url = ?,
+		preview_url = ?,
+		original_filename = ?,
+		moderation_status = ?,
+		archive_status = '',
+		restored_until = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND storage_key IS NOT DISTINCT FROM ?
+	`
+
+	result, err := c.db.Exec(
+		query,
+		video.ThumbnailURL,
+		video.VideoURL,
+		video.ProcessingStatus,
+		video.ProcessingError,
+		video.HLSURL,
+		video.Metadata,
+		video.ChecksumSHA256,
+		video.ContentSHA256,
+		video.StorageBucket,
+		video.StorageKey,
+		video.ThumbnailSrcset,
+		video.SpriteURL,
+		video.SpriteVTTURL,
+		video.PreviewURL,
+		video.OriginalFilename,
+		video.ModerationStatus,
+		video.ID,
+		previousKey,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrVideoMediaChanged
+	}
+	return nil
+}
+
+// UpdateVideoObjectKeys points a video at copies of its objects under new
+// keys, in a single statement that only succeeds if the video still points
+// at previousKey. Only the columns that locate objects are written.
+func (c Client) UpdateVideoObjectKeys(video Video, previousKey *string) error {
+	query := `
+	UPDATE videos
+	SET
+		video_url = ?,
+		storage_key = ?,
+		hls_url = ?,
+		sprite_url = ?,
+		sprite_vtt_url = ?,
+		preview_url = ?,
+		thumbnail_url = ?,
+		thumbnail_srcset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND storage_key IS NOT DISTINCT FROM ?
+	`
+
+	result, err := c.db.Exec(
+		query,
+		video.VideoURL,
+		video.StorageKey,
+		video.HLSURL,
+		video.SpriteURL,
+		video.SpriteVTTURL,
+		video.PreviewURL,
+		video.ThumbnailURL,
+		video.ThumbnailSrcset,
+		video.ID,
+		previousKey,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrVideoMediaChanged
+	}
+	return nil
+}
+
+// SetVideoStorageLocation records where a video's media is stored, unless
+// a location was recorded since. The rest of the row is left alone.
+func (c Client) SetVideoStorageLocation(id uuid.UUID, bucket, key string) error {
+	query := `
+	UPDATE videos
+	SET
+		storage_bucket = ?,
+		storage_key = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND storage_key IS NULL
+	`
+	_, err := c.db.Exec(query, bucket, key, id)
+	return err
+}
+
+// UpdateVideoProcessingStatus only touches the processing columns so that
+// background workers don't overwrite concurrent edits to the rest of the row.
+func (c Client) UpdateVideoProcessingStatus(id uuid.UUID, status ProcessingStatus, processingErr *string) error {
+	query := `
+	UPDATE videos
+	SET
+		processing_status = ?,
+		processing_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, processingErr, id)
+	return err
+}
+
+// UpdateVideoVisibility changes who can see a video, leaving the rest of the
+// row alone.
+func (c Client) UpdateVideoVisibility(id uuid.UUID, visibility Visibility) error {
+	query := `
+	UPDATE videos
+	SET
+		visibility = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, visibility, id)
+	return err
+}
+
+// UpdateVideoSchedule sets when a video is published and when it expires,
+// leaving the rest of the row alone. Nil times clear them.
+func (c Client) UpdateVideoSchedule(id uuid.UUID, publishAt, expiresAt *time.Time) error {
+	query := `
+	UPDATE videos
+	SET
+		publish_at = ?,
+		expires_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, publishAt, expiresAt, id)
+	return err
+}
+
+// UpdateVideoWatermarkDisabled sets whether a video is exempt from the
+// watermark, leaving the rest of the row alone.
+func (c Client) UpdateVideoWatermarkDisabled(id uuid.UUID, disabled bool) error {
+	query := `
+	UPDATE videos
+	SET
+		watermark_disabled = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, disabled, id)
+	return err
+}
+
+// UpdateVideoThumbnail points a video at a new thumbnail and its resized
+// variants, leaving the rest of the row alone.
+func (c Client) UpdateVideoThumbnail(id uuid.UUID, thumbnailURL *string, srcset ThumbnailSrcset) error {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_srcset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, thumbnailURL, srcset, id)
+	return err
+}
+
+// UpdateVideoArchiveStatus records where a video's stored object is in its
+// archive lifecycle, leaving the rest of the row alone.
+func (c Client) UpdateVideoArchiveStatus(id uuid.UUID, status ArchiveStatus, restoredUntil *time.Time) error {
+	query := `
+	UPDATE videos
+	SET
+		archive_status = ?,
+		restored_until = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, restoredUntil, id)
+	return err
+}
+
+// GetVideosByArchiveStatus returns the videos not in the trash with any of
+// the given archive statuses.
+func (c Client) GetVideosByArchiveStatus(statuses ...ArchiveStatus) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NULL AND archive_status IN (?` + strings.Repeat(", ?", len(statuses)-1) + `)
+	`
+	args := make([]any, len(statuses))
+	for i, status := range statuses {
+		args[i] = status
+	}
+	return c.queryVideos(query, args...)
+}
+
+// GetUserVideosByProcessingStatus returns a user's videos not in the trash
+// with any of the given processing statuses.
+func (c Client) GetUserVideosByProcessingStatus(userID uuid.UUID, statuses ...ProcessingStatus) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NULL AND processing_status IN (?` + strings.Repeat(", ?", len(statuses)-1) + `)
+	`
+	args := []any{userID}
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	return c.queryVideos(query, args...)
+}
+
+// GetUserVideosUpdatedSince returns a user's videos not in the trash that
+// were updated at or after since.
+func (c Client) GetUserVideosUpdatedSince(userID uuid.UUID, since time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NULL AND updated_at >= ?
+	`
+	return c.queryVideos(query, userID, since.UTC().Format(cursorTimestampLayout))
+}
+
+// GetDeletedVideo returns a video in the trash, or nil if there is none.
+func (c Client) GetDeletedVideo(id uuid.UUID) (*Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	video, err := scanVideo(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &video, nil
+}
+
+// GetDeletedVideos returns the videos of a user that are in the trash, most
+// recently deleted first.
+func (c Client) GetDeletedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+// GetVideosDeletedBefore returns the videos moved to the trash before
+// cutoff.
+func (c Client) GetVideosDeletedBefore(cutoff time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	`
+	return c.queryVideos(query, cutoff.UTC().Format(timestampLayout))
+}
+
+// GetVideosExpiredBefore returns the videos not yet in the trash whose
+// scheduled expiration is before cutoff.
+func (c Client) GetVideosExpiredBefore(cutoff time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NULL AND expires_at IS NOT NULL AND expires_at < ?
+	`
+	return c.queryVideos(query, cutoff.UTC())
+}
+
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// SoftDeleteVideo moves a video to the trash.
+func (c Client) SoftDeleteVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC().Format(timestampLayout), id)
+	return err
+}
+
+// RestoreVideo takes a video out of the trash.
+func (c Client) RestoreVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = addVideoUsage(tx, id, UsageDelta{VideoCount: -1})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateVideoContentChecksum records the checksum of a video's stored
+// object, unless the video was pointed at another object since.
+func (c Client) UpdateVideoContentChecksum(id uuid.UUID, key, checksum string) error {
+	query := `
+	UPDATE videos
+	SET content_sha256 = ?
+	WHERE id = ? AND storage_key = ?
+	`
+	_, err := c.db.Exec(query, checksum, id, key)
+	return err
+}
+
+// videoAssetColumns are the columns ClearVideoAssets may clear.
+var videoAssetColumns = map[string]bool{
+	"video_url":        true,
+	"storage_bucket":   true,
+	"storage_key":      true,
+	"content_sha256":   true,
+	"hls_url":          true,
+	"thumbnail_url":    true,
+	"thumbnail_srcset": true,
+	"sprite_url":       true,
+	"sprite_vtt_url":   true,
+	"preview_url":      true,
+}
+
+// ClearVideoAssets sets asset columns of a video to NULL, leaving the rest
+// of the row alone. They're only cleared while the guard column still holds
+// guardValue, so an asset replaced since the video was loaded is kept.
+func (c Client) ClearVideoAssets(id uuid.UUID, guard string, guardValue *string, columns ...string) error {
+	if !videoAssetColumns[guard] {
+		return fmt.Errorf("%s isn't a video asset column", guard)
+	}
+	assignments := make([]string, 0, len(columns)+1)
+	for _, column := range columns {
+		if !videoAssetColumns[column] {
+			return fmt.Errorf("%s isn't a video asset column", column)
+		}
+		assignments = append(assignments, column+" = NULL")
+	}
+	assignments = append(assignments, "updated_at = CURRENT_TIMESTAMP")
+
+	query := `
+	UPDATE videos
+	SET ` + strings.Join(assignments, ", ") + `
+	WHERE id = ? AND ` + guard + ` IS NOT DISTINCT FROM ?
+	`
+	_, err := c.db.Exec(query, id, guardValue)
 	return err
 }
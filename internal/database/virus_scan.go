@@ -0,0 +1,64 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VirusScanStatus string
+
+const (
+	VirusScanStatusClean    VirusScanStatus = "clean"
+	VirusScanStatusInfected VirusScanStatus = "infected"
+	// VirusScanStatusFailed is recorded for files that couldn't be scanned,
+	// which were accepted anyway if uploads fail open.
+	VirusScanStatusFailed VirusScanStatus = "failed"
+)
+
+// VirusScan is the result of the latest virus scan of a file uploaded for a
+// video. File is what was scanned: video, thumbnail or captions. It is
+// persisted as JSON in the videos.virus_scan column.
+type VirusScan struct {
+	Status    VirusScanStatus `json:"status"`
+	File      string          `json:"file"`
+	Signature string          `json:"signature,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ScannedAt time.Time       `json:"scanned_at"`
+}
+
+func (s VirusScan) Value() (driver.Value, error) {
+	dat, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (s *VirusScan) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case []byte:
+		return json.Unmarshal(v, s)
+	default:
+		return fmt.Errorf("unsupported type for virus scan: %T", src)
+	}
+}
+
+// UpdateVideoVirusScan records the result of a virus scan on a video,
+// leaving the rest of the row alone.
+func (c Client) UpdateVideoVirusScan(id uuid.UUID, scan VirusScan) error {
+	query := `
+	UPDATE videos
+	SET
+		virus_scan = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, scan, id)
+	return err
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreateWebhookParams
+}
+
+type CreateWebhookParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	URL    string    `json:"url"`
+	Secret string    `json:"-"`
+}
+
+const webhookColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		url,
+		secret`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var webhook Webhook
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+	)
+	return webhook, err
+}
+
+func (c Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO webhooks (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		url,
+		secret
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.URL, params.Secret)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return c.GetWebhook(id)
+}
+
+func (c Client) GetWebhook(id uuid.UUID) (Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE id = ?
+	`
+	webhook, err := scanWebhook(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, nil
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (c Client) DeleteWebhook(id uuid.UUID) error {
+	query := `
+	DELETE FROM webhooks
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,104 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Event is an event encoded for subscribers, with the ID and type they tell
+// events apart by.
+type Event struct {
+	ID   string
+	Type string
+	Data []byte
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind by before
+// it's dropped.
+const subscriberBuffer = 32
+
+// Broker fans out the events of a user to the user's subscribers. Like
+// progress.Tracker, it lives in memory, so events are only seen by
+// subscribers on the instance that published them.
+type Broker struct {
+	mu     sync.Mutex
+	users  map[uuid.UUID]map[chan Event]struct{}
+	closed bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{users: map[uuid.UUID]map[chan Event]struct{}{}}
+}
+
+// Publish passes an event on to the user's subscribers. Unlike progress
+// updates, events can't be skipped, so a subscriber too slow to keep up has
+// its channel closed instead, and can subscribe again and catch up from the
+// API.
+func (b *Broker) Publish(userID uuid.UUID, event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := b.users[userID]
+	for ch := range subs {
+		select {
+		case ch <- event:
+		default:
+			delete(subs, ch)
+			close(ch)
+		}
+	}
+	if subs != nil && len(subs) == 0 {
+		delete(b.users, userID)
+	}
+}
+
+// Subscribe returns a channel receiving the events of a user. It's closed
+// when the broker is closed or the subscriber falls behind; call cancel to
+// stop receiving.
+func (b *Broker) Subscribe(userID uuid.UUID) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	subs, ok := b.users[userID]
+	if !ok {
+		subs = map[chan Event]struct{}{}
+		b.users[userID] = subs
+	}
+	subs[ch] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subs := b.users[userID]
+		if _, ok := subs[ch]; ok {
+			delete(subs, ch)
+			close(ch)
+		}
+		if subs != nil && len(subs) == 0 {
+			delete(b.users, userID)
+		}
+	}
+	return ch, cancel
+}
+
+// Close ends all subscriptions, e.g. so streaming responses don't hold up a
+// server shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for userID, subs := range b.users {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.users, userID)
+	}
+}
@@ -0,0 +1,56 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+const jpegQuality = 85
+
+// Decode decodes a JPEG or PNG image.
+func Decode(data []byte, mediaType string) (image.Image, error) {
+	switch mediaType {
+	case "image/jpeg":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		return png.Decode(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported image type %s", mediaType)
+	}
+}
+
+// Encode encodes img as a JPEG or PNG image.
+func Encode(img image.Image, mediaType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mediaType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("unsupported image type %s", mediaType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ResizeToWidth scales img to the given width, keeping its aspect ratio.
+func ResizeToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,398 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	pollInterval       = 5 * time.Second
+	baseBackoff        = 5 * time.Second
+	maxBackoff         = 5 * time.Minute
+)
+
+// ErrNotDead is returned when requeueing a job that isn't dead.
+var ErrNotDead = errors.New("job isn't dead")
+
+// Store persists jobs so that queued and in-flight work survives restarts.
+type Store interface {
+	CreateJob(job database.Job) error
+	UpdateJob(job database.Job) error
+	GetJob(id uuid.UUID) (database.Job, error)
+	ClaimNextJob(now time.Time) (*database.Job, error)
+	RequeueRunningJobs() error
+	HeartbeatJob(id uuid.UUID) error
+	RequeueStaleJobs(before time.Time) (int64, error)
+	RequeueDeadJob(id uuid.UUID, now time.Time) (*database.Job, error)
+}
+
+// Runner queues jobs and runs them with the handlers registered for their
+// types. Jobs are always recorded in the Store; runners differ in how they're
+// handed to workers.
+type Runner interface {
+	// Register sets the handler for a job type. It must be called before
+	// Start.
+	Register(jobType string, handler Handler)
+	// Enqueue persists a new job whose payload is the JSON encoding of
+	// payload and hands it to a worker.
+	Enqueue(jobType, reference string, payload any) (database.Job, error)
+	// Requeue gives a dead job a fresh set of attempts and hands it to a
+	// worker. It returns ErrNotDead if the job isn't dead.
+	Requeue(id uuid.UUID) (database.Job, error)
+	// Start launches the workers. They exit when ctx is cancelled.
+	Start(ctx context.Context) error
+	// Shutdown stops workers from taking new jobs and waits for running
+	// ones to finish. If ctx expires first, running jobs are cancelled and
+	// put back in the queue without counting the attempt.
+	Shutdown(ctx context.Context) error
+}
+
+// Handler executes one attempt of a job. Failed, if set, is called once the
+// job has exhausted its attempts and is dead.
+type Handler struct {
+	Run    func(ctx context.Context, job database.Job) error
+	Failed func(job database.Job, err error)
+}
+
+type Queue struct {
+	store    Store
+	workers  int
+	handlers map[string]Handler
+	wake     chan struct{}
+	stopping chan struct{}
+	wg       sync.WaitGroup
+
+	// lease is how long a running job may go without a heartbeat before
+	// it's considered abandoned. Zero means this process is the only one
+	// running jobs.
+	lease time.Duration
+
+	// ctx is cancelled to interrupt running jobs when shutdown runs out of
+	// time.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewQueue(store Store, workers int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Queue{
+		store:    store,
+		workers:  workers,
+		handlers: map[string]Handler{},
+		wake:     make(chan struct{}, 1),
+		stopping: make(chan struct{}),
+	}
+}
+
+// Register sets the handler for a job type. It must be called before Start.
+func (q *Queue) Register(jobType string, handler Handler) {
+	q.handlers[jobType] = handler
+}
+
+// Enqueue persists a new job whose payload is the JSON encoding of payload
+// and wakes an idle worker.
+func (q *Queue) Enqueue(jobType, reference string, payload any) (database.Job, error) {
+	job, err := createJob(q.store, jobType, reference, payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	q.notify()
+	return job, nil
+}
+
+// createJob persists a new queued job.
+func createJob(store Store, jobType, reference string, payload any) (database.Job, error) {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, fmt.Errorf("couldn't encode job payload: %w", err)
+	}
+
+	job := database.Job{
+		ID:          uuid.New(),
+		Type:        jobType,
+		Reference:   reference,
+		Payload:     string(dat),
+		Status:      database.JobStatusQueued,
+		MaxAttempts: DefaultMaxAttempts,
+		RunAt:       time.Now().UTC(),
+	}
+	err = store.CreateJob(job)
+	if err != nil {
+		return database.Job{}, fmt.Errorf("couldn't persist job: %w", err)
+	}
+	return job, nil
+}
+
+// Requeue gives a dead job a fresh set of attempts and wakes an idle worker.
+func (q *Queue) Requeue(id uuid.UUID) (database.Job, error) {
+	job, err := requeueDead(q.store, id)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	q.notify()
+	return job, nil
+}
+
+func requeueDead(store Store, id uuid.UUID) (database.Job, error) {
+	job, err := store.RequeueDeadJob(id, time.Now())
+	if err != nil {
+		return database.Job{}, fmt.Errorf("couldn't requeue job: %w", err)
+	}
+	if job == nil {
+		return database.Job{}, ErrNotDead
+	}
+	log.Printf("Job %s (%s) requeued from the dead-letter state", job.ID, job.Type)
+	return *job, nil
+}
+
+func (q *Queue) Get(id uuid.UUID) (database.Job, error) {
+	return q.store.GetJob(id)
+}
+
+// SetLease lets several processes share the queue. Running jobs send a
+// heartbeat well within lease, and jobs that miss it are taken to belong to
+// a process that died and are put back in the queue. It must be called before
+// Start.
+func (q *Queue) SetLease(lease time.Duration) {
+	q.lease = lease
+}
+
+// Start requeues jobs interrupted by a previous shutdown and launches the
+// worker pool. Workers exit when ctx is cancelled; use Wait to block on them.
+func (q *Queue) Start(ctx context.Context) error {
+	var err error
+	if q.lease > 0 {
+		// Other processes may be running jobs right now, so only the ones
+		// without a recent heartbeat are requeued.
+		_, err = q.store.RequeueStaleJobs(time.Now().Add(-q.lease))
+	} else {
+		err = q.store.RequeueRunningJobs()
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't requeue interrupted jobs: %w", err)
+	}
+
+	q.ctx, q.cancel = context.WithCancel(ctx)
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work(q.ctx)
+	}
+	if q.lease > 0 {
+		q.wg.Add(1)
+		go q.requeueStale(q.ctx)
+	}
+	return nil
+}
+
+// requeueStale periodically requeues the jobs of processes that stopped
+// sending heartbeats.
+func (q *Queue) requeueStale(ctx context.Context) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.lease / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		n, err := q.store.RequeueStaleJobs(time.Now().Add(-q.lease))
+		if err != nil {
+			log.Printf("Couldn't requeue stale jobs: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Requeued %d jobs that missed their heartbeat", n)
+			q.notify()
+		}
+	}
+}
+
+// heartbeat keeps the lease on a running job until stop is closed.
+func (q *Queue) heartbeat(id uuid.UUID, stop <-chan struct{}) {
+	ticker := time.NewTicker(q.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := q.store.HeartbeatJob(id)
+		if err != nil {
+			log.Printf("Couldn't send heartbeat for job %s: %v", id, err)
+		}
+	}
+}
+
+func (q *Queue) Wait() {
+	q.wg.Wait()
+}
+
+// Shutdown stops workers from claiming new jobs and waits for running ones
+// to finish. If ctx expires first, running jobs are cancelled and put back
+// in the queue, without counting the attempt, for the next start.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	close(q.stopping)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) isStopping() bool {
+	select {
+	case <-q.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for !q.isStopping() && q.runNext(ctx) {
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.stopping:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNext claims and runs a single due job, reporting whether one was found.
+func (q *Queue) runNext(ctx context.Context) bool {
+	job, err := q.store.ClaimNextJob(time.Now())
+	if err != nil {
+		log.Printf("Couldn't claim job: %v", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		finish(q.store, *job, handler, fmt.Errorf("no handler registered for job type %q", job.Type), false)
+		return true
+	}
+
+	if q.lease > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go q.heartbeat(job.ID, stop)
+	}
+
+	err = handler.Run(ctx, *job)
+	if err != nil && ctx.Err() != nil {
+		requeue(q.store, *job)
+		return true
+	}
+	finish(q.store, *job, handler, err, true)
+	return true
+}
+
+// requeue puts a job interrupted by shutdown back in the queue without
+// counting the interrupted attempt.
+func requeue(store Store, job database.Job) {
+	job.Status = database.JobStatusQueued
+	job.Attempts--
+	job.RunAt = time.Now().UTC()
+	log.Printf("Job %s (%s) interrupted by shutdown, requeued", job.ID, job.Type)
+
+	err := store.UpdateJob(job)
+	if err != nil {
+		log.Printf("Couldn't requeue job %s: %v", job.ID, err)
+	}
+}
+
+// finish records the outcome of an attempt and returns the updated job. A
+// failed job is queued again after a backoff until it runs out of attempts,
+// when it's dead.
+func finish(store Store, job database.Job, handler Handler, runErr error, retryable bool) database.Job {
+	if runErr == nil {
+		job.Status = database.JobStatusSucceeded
+		job.LastError = nil
+	} else {
+		msg := runErr.Error()
+		job.LastError = &msg
+		if retryable && job.Attempts < job.MaxAttempts {
+			job.Status = database.JobStatusQueued
+			job.RunAt = time.Now().UTC().Add(backoff(job.Attempts))
+			log.Printf("Job %s (%s) attempt %d failed, retrying at %s: %v", job.ID, job.Type, job.Attempts, job.RunAt, runErr)
+		} else {
+			job.Status = database.JobStatusDead
+			log.Printf("Job %s (%s) is dead after %d attempts: %v", job.ID, job.Type, job.Attempts, runErr)
+		}
+	}
+
+	err := store.UpdateJob(job)
+	if err != nil {
+		log.Printf("Couldn't update job %s: %v", job.ID, err)
+	}
+
+	if job.Status == database.JobStatusDead && handler.Failed != nil {
+		handler.Failed(job, runErr)
+	}
+	return job
+}
+
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d <= 0 || d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
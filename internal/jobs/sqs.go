@@ -0,0 +1,294 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// sqsWaitTime is how long a receive long polls for a message, the most
+	// SQS allows.
+	sqsWaitTime = 20 * time.Second
+	// sqsMaxDelay is the longest SQS hides a message for.
+	sqsMaxDelay = 12 * time.Hour
+)
+
+// sqsMessage is the body of a message announcing a job.
+type sqsMessage struct {
+	JobID uuid.UUID `json:"job_id"`
+}
+
+// SQSQueue hands jobs to workers through an SQS queue, so any number of
+// processes can share them without claiming rows in the database. Jobs are
+// still recorded in the Store.
+//
+// A message stays invisible while its job runs and reappears if the worker
+// dies, and failed attempts are retried by hiding the message for the
+// backoff. Messages that keep failing to be handled, e.g. because they crash
+// the worker, should be moved to a dead-letter queue by the queue's redrive
+// policy, with a maxReceiveCount above DefaultMaxAttempts.
+type SQSQueue struct {
+	store             Store
+	client            *sqs.Client
+	queueURL          string
+	workers           int
+	visibilityTimeout time.Duration
+	handlers          map[string]Handler
+	stopping          chan struct{}
+	wg                sync.WaitGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewSQSQueue returns a runner that delivers jobs through the queue at
+// queueURL. Messages are hidden from other workers for visibilityTimeout at a
+// time while their job runs.
+func NewSQSQueue(store Store, client *sqs.Client, queueURL string, workers int, visibilityTimeout time.Duration) *SQSQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &SQSQueue{
+		store:             store,
+		client:            client,
+		queueURL:          queueURL,
+		workers:           workers,
+		visibilityTimeout: visibilityTimeout,
+		handlers:          map[string]Handler{},
+		stopping:          make(chan struct{}),
+	}
+}
+
+func (q *SQSQueue) Register(jobType string, handler Handler) {
+	q.handlers[jobType] = handler
+}
+
+func (q *SQSQueue) Enqueue(jobType, reference string, payload any) (database.Job, error) {
+	job, err := createJob(q.store, jobType, reference, payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	err = q.send(context.Background(), job.ID)
+	if err != nil {
+		q.markUnsent(job, err)
+		return database.Job{}, err
+	}
+	return job, nil
+}
+
+// Requeue gives a dead job a fresh set of attempts and sends a message for
+// it.
+func (q *SQSQueue) Requeue(id uuid.UUID) (database.Job, error) {
+	job, err := requeueDead(q.store, id)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	err = q.send(context.Background(), job.ID)
+	if err != nil {
+		q.markUnsent(job, err)
+		return database.Job{}, err
+	}
+	return job, nil
+}
+
+// markUnsent moves a job whose message couldn't be sent to the dead-letter
+// state, since nothing will ever run it, rather than leaving it looking
+// queued.
+func (q *SQSQueue) markUnsent(job database.Job, sendErr error) {
+	msg := sendErr.Error()
+	job.Status = database.JobStatusDead
+	job.LastError = &msg
+	err := q.store.UpdateJob(job)
+	if err != nil {
+		log.Printf("Couldn't update job %s: %v", job.ID, err)
+	}
+}
+
+func (q *SQSQueue) send(ctx context.Context, jobID uuid.UUID) error {
+	body, err := json.Marshal(sqsMessage{JobID: jobID})
+	if err != nil {
+		return err
+	}
+	_, err = q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't send job %s to SQS: %w", jobID, err)
+	}
+	return nil
+}
+
+// Start launches the workers. Jobs interrupted by a previous shutdown don't
+// need requeueing; their messages reappear once they're visible again.
+func (q *SQSQueue) Start(ctx context.Context) error {
+	q.ctx, q.cancel = context.WithCancel(ctx)
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work(q.ctx)
+	}
+	return nil
+}
+
+func (q *SQSQueue) Shutdown(ctx context.Context) error {
+	close(q.stopping)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *SQSQueue) work(ctx context.Context) {
+	defer q.wg.Done()
+
+	// Long polls are cut short on shutdown rather than left to run out.
+	receiveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-q.stopping:
+			cancel()
+		case <-receiveCtx.Done():
+		}
+	}()
+
+	for receiveCtx.Err() == nil {
+		out, err := q.client.ReceiveMessage(receiveCtx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(q.queueURL),
+			MaxNumberOfMessages: 1,
+			WaitTimeSeconds:     int32(sqsWaitTime.Seconds()),
+			VisibilityTimeout:   int32(q.visibilityTimeout.Seconds()),
+		})
+		if err != nil {
+			if receiveCtx.Err() != nil {
+				return
+			}
+			log.Printf("Couldn't receive jobs from SQS: %v", err)
+			select {
+			case <-receiveCtx.Done():
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		for _, message := range out.Messages {
+			q.handle(ctx, aws.ToString(message.Body), aws.ToString(message.ReceiptHandle))
+		}
+	}
+}
+
+// handle runs the job announced by a message and decides what becomes of
+// the message: it's deleted once the job is done, hidden for the backoff if
+// it's retried, and made visible again if shutdown interrupted it.
+func (q *SQSQueue) handle(ctx context.Context, body, receiptHandle string) {
+	var message sqsMessage
+	err := json.Unmarshal([]byte(body), &message)
+	if err != nil {
+		log.Printf("Discarding malformed SQS message %q: %v", body, err)
+		q.delete(receiptHandle)
+		return
+	}
+
+	job, err := q.store.GetJob(message.JobID)
+	if err != nil {
+		log.Printf("Couldn't get job %s: %v", message.JobID, err)
+		return
+	}
+	// Messages are delivered at least once, so jobs that were deleted or
+	// already finished are skipped.
+	if job.ID == uuid.Nil || job.Status == database.JobStatusSucceeded || job.Status == database.JobStatusDead {
+		q.delete(receiptHandle)
+		return
+	}
+
+	job.Status = database.JobStatusRunning
+	job.Attempts++
+	err = q.store.UpdateJob(job)
+	if err != nil {
+		log.Printf("Couldn't mark job %s as running: %v", job.ID, err)
+		return
+	}
+
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		finish(q.store, job, handler, fmt.Errorf("no handler registered for job type %q", job.Type), false)
+		q.delete(receiptHandle)
+		return
+	}
+
+	stop := make(chan struct{})
+	go q.extendVisibility(receiptHandle, stop)
+	err = handler.Run(ctx, job)
+	close(stop)
+
+	if err != nil && ctx.Err() != nil {
+		requeue(q.store, job)
+		q.changeVisibility(receiptHandle, 0)
+		return
+	}
+	job = finish(q.store, job, handler, err, true)
+	if job.Status == database.JobStatusQueued {
+		q.changeVisibility(receiptHandle, min(time.Until(job.RunAt), sqsMaxDelay))
+		return
+	}
+	q.delete(receiptHandle)
+}
+
+// extendVisibility keeps a message hidden from other workers until stop is
+// closed.
+func (q *SQSQueue) extendVisibility(receiptHandle string, stop <-chan struct{}) {
+	ticker := time.NewTicker(q.visibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		q.changeVisibility(receiptHandle, q.visibilityTimeout)
+	}
+}
+
+func (q *SQSQueue) changeVisibility(receiptHandle string, timeout time.Duration) {
+	_, err := q.client.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: int32(max(timeout, 0).Seconds()),
+	})
+	if err != nil {
+		log.Printf("Couldn't change visibility of SQS message: %v", err)
+	}
+}
+
+func (q *SQSQueue) delete(receiptHandle string) {
+	_, err := q.client.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.queueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+	if err != nil {
+		log.Printf("Couldn't delete SQS message: %v", err)
+	}
+}
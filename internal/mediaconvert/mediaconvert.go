@@ -0,0 +1,404 @@
+package mediaconvert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/mediaconvert"
+	"github.com/aws/aws-sdk-go-v2/service/mediaconvert/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// ErrNoVideoStream is returned by Probe for sources without a video track.
+var ErrNoVideoStream = errors.New("no video stream found")
+
+// Client submits transcoding jobs to AWS Elemental MediaConvert and waits for
+// them to finish. Jobs are polled; Notify wakes waiters early when a
+// completion event arrives.
+type Client struct {
+	api          *mediaconvert.Client
+	role         string
+	queue        string
+	pollInterval time.Duration
+
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+// New returns a client that runs jobs with the IAM role ARN role, on queue or
+// the account's default queue if it's empty.
+func New(api *mediaconvert.Client, role, queue string, pollInterval time.Duration) *Client {
+	return &Client{
+		api:          api,
+		role:         role,
+		queue:        queue,
+		pollInterval: pollInterval,
+		waiters:      map[string][]chan struct{}{},
+	}
+}
+
+// JobSpec describes the outputs of a job. Destinations are S3 URLs whose last
+// path element is the base name of the written files.
+type JobSpec struct {
+	// Token makes submission idempotent for about a minute.
+	Token string
+	// Input is the S3 URL of the source.
+	Input    string
+	HasAudio bool
+	// Destination receives a fast start MP4 at the source's resolution.
+	Destination string
+	// HLSDestination receives an adaptive bitrate ladder, unless it's empty.
+	HLSDestination string
+	// HLSMaxRenditions and HLSMaxBitrate bound the ladder, which
+	// MediaConvert picks based on the content.
+	HLSMaxRenditions int
+	HLSMaxBitrate    int
+	Metadata         map[string]string
+	// ServerSideEncryption is how the outputs are encrypted at rest, the
+	// S3 x-amz-server-side-encryption value, or empty for the bucket's
+	// default. KMSKeyID picks the key for aws:kms.
+	ServerSideEncryption string
+	KMSKeyID             string
+	// StorageClass is the S3 storage class of the outputs, or empty for
+	// STANDARD.
+	StorageClass string
+}
+
+// Result describes the MP4 written by a completed job, rotated to its display
+// orientation.
+type Result struct {
+	Width           int
+	Height          int
+	DurationSeconds float64
+}
+
+// Probe describes the source at url, an S3 URL.
+func (c *Client) Probe(ctx context.Context, url string) (database.VideoMetadata, bool, error) {
+	out, err := c.api.Probe(ctx, &mediaconvert.ProbeInput{
+		InputFiles: []types.ProbeInputFile{{FileUrl: aws.String(url)}},
+	})
+	if err != nil {
+		return database.VideoMetadata{}, false, fmt.Errorf("couldn't probe %s: %w", url, err)
+	}
+	if len(out.ProbeResults) == 0 || out.ProbeResults[0].Container == nil {
+		return database.VideoMetadata{}, false, fmt.Errorf("couldn't probe %s: no results", url)
+	}
+	container := out.ProbeResults[0].Container
+
+	var metadata database.VideoMetadata
+	var foundVideo, hasAudio bool
+	for _, track := range container.Tracks {
+		switch {
+		case track.TrackType == types.TrackTypeVideo && track.VideoProperties != nil && !foundVideo:
+			foundVideo = true
+			props := track.VideoProperties
+			metadata.VideoCodec = codecName(track.Codec)
+			metadata.Width = int(aws.ToInt32(props.Width))
+			metadata.Height = int(aws.ToInt32(props.Height))
+			metadata.BitRate = aws.ToInt64(props.BitRate)
+			if rate := props.FrameRate; rate != nil && aws.ToInt32(rate.Denominator) != 0 {
+				metadata.FrameRate = float64(aws.ToInt32(rate.Numerator)) / float64(aws.ToInt32(rate.Denominator))
+			}
+		case track.TrackType == types.TrackTypeAudio && !hasAudio:
+			hasAudio = true
+			metadata.AudioCodec = codecName(track.Codec)
+		}
+	}
+	if !foundVideo {
+		return metadata, false, ErrNoVideoStream
+	}
+	metadata.DurationSeconds = aws.ToFloat64(container.Duration)
+	return metadata, hasAudio, nil
+}
+
+// codecName converts a probed codec to the name ffprobe uses for it.
+func codecName(codec types.Codec) string {
+	if codec == types.CodecAvc {
+		return "h264"
+	}
+	return strings.ToLower(string(codec))
+}
+
+// Submit creates a job for spec and returns its ID.
+func (c *Client) Submit(ctx context.Context, spec JobSpec) (string, error) {
+	input := &mediaconvert.CreateJobInput{
+		Role:                 aws.String(c.role),
+		ClientRequestToken:   aws.String(spec.Token),
+		UserMetadata:         spec.Metadata,
+		StatusUpdateInterval: types.StatusUpdateIntervalSeconds10,
+		Settings:             jobSettings(spec),
+	}
+	if c.queue != "" {
+		input.Queue = aws.String(c.queue)
+	}
+
+	out, err := c.api.CreateJob(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create MediaConvert job: %w", err)
+	}
+	return aws.ToString(out.Job.Id), nil
+}
+
+// mp4MaxBitrate caps the MP4 output, which keeps the source's resolution.
+const mp4MaxBitrate = 10_000_000
+
+func jobSettings(spec JobSpec) *types.JobSettings {
+	input := types.Input{
+		FileInput:      aws.String(spec.Input),
+		TimecodeSource: types.InputTimecodeSourceZerobased,
+		VideoSelector:  &types.VideoSelector{Rotate: types.InputRotateAuto},
+	}
+	if spec.HasAudio {
+		input.AudioSelectors = map[string]types.AudioSelector{
+			"Audio Selector 1": {DefaultSelection: types.AudioDefaultSelectionDefault},
+		}
+	}
+
+	mp4 := types.Output{
+		ContainerSettings: &types.ContainerSettings{
+			Container:   types.ContainerTypeMp4,
+			Mp4Settings: &types.Mp4Settings{MoovPlacement: types.Mp4MoovPlacementProgressiveDownload},
+		},
+		VideoDescription: h264Description(mp4MaxBitrate),
+	}
+	if spec.HasAudio {
+		mp4.AudioDescriptions = []types.AudioDescription{aacDescription()}
+	}
+	groups := []types.OutputGroup{{
+		Name: aws.String("File Group"),
+		OutputGroupSettings: &types.OutputGroupSettings{
+			Type: types.OutputGroupTypeFileGroupSettings,
+			FileGroupSettings: &types.FileGroupSettings{
+				Destination:         aws.String(spec.Destination),
+				DestinationSettings: destinationSettings(spec),
+			},
+		},
+		Outputs: []types.Output{mp4},
+	}}
+
+	if spec.HLSDestination != "" {
+		// Automated ABR sizes the ladder to the video, so portrait videos
+		// get portrait renditions without knowing the orientation upfront.
+		outputs := []types.Output{{
+			NameModifier:      aws.String("_video"),
+			ContainerSettings: &types.ContainerSettings{Container: types.ContainerTypeM3u8},
+			VideoDescription:  h264Description(0),
+		}}
+		if spec.HasAudio {
+			outputs = append(outputs, types.Output{
+				NameModifier:      aws.String("_audio"),
+				ContainerSettings: &types.ContainerSettings{Container: types.ContainerTypeM3u8},
+				AudioDescriptions: []types.AudioDescription{aacDescription()},
+			})
+		}
+		groups = append(groups, types.OutputGroup{
+			Name: aws.String("Apple HLS"),
+			OutputGroupSettings: &types.OutputGroupSettings{
+				Type: types.OutputGroupTypeHlsGroupSettings,
+				HlsGroupSettings: &types.HlsGroupSettings{
+					Destination:         aws.String(spec.HLSDestination),
+					DestinationSettings: destinationSettings(spec),
+					SegmentLength:       aws.Int32(6),
+					MinSegmentLength:    aws.Int32(0),
+				},
+			},
+			AutomatedEncodingSettings: &types.AutomatedEncodingSettings{
+				AbrSettings: &types.AutomatedAbrSettings{
+					MaxRenditions: aws.Int32(int32(spec.HLSMaxRenditions)),
+					MaxAbrBitrate: aws.Int32(int32(spec.HLSMaxBitrate)),
+				},
+			},
+			Outputs: outputs,
+		})
+	}
+
+	return &types.JobSettings{
+		Inputs:       []types.Input{input},
+		OutputGroups: groups,
+	}
+}
+
+// destinationSettings encrypts and stores outputs as spec asks, or returns
+// nil to leave them to the bucket's defaults.
+func destinationSettings(spec JobSpec) *types.DestinationSettings {
+	settings := types.S3DestinationSettings{StorageClass: types.S3StorageClass(spec.StorageClass)}
+	switch spec.ServerSideEncryption {
+	case "AES256":
+		settings.Encryption = &types.S3EncryptionSettings{
+			EncryptionType: types.S3ServerSideEncryptionTypeServerSideEncryptionS3,
+		}
+	case "aws:kms":
+		settings.Encryption = &types.S3EncryptionSettings{
+			EncryptionType: types.S3ServerSideEncryptionTypeServerSideEncryptionKms,
+		}
+		if spec.KMSKeyID != "" {
+			settings.Encryption.KmsKeyArn = aws.String(spec.KMSKeyID)
+		}
+	}
+	if settings.Encryption == nil && settings.StorageClass == "" {
+		return nil
+	}
+	return &types.DestinationSettings{S3Settings: &settings}
+}
+
+// h264Description encodes with QVBR, capped at maxBitrate unless it's zero.
+func h264Description(maxBitrate int) *types.VideoDescription {
+	settings := &types.H264Settings{
+		RateControlMode:    types.H264RateControlModeQvbr,
+		SceneChangeDetect:  types.H264SceneChangeDetectTransitionDetection,
+		QualityTuningLevel: types.H264QualityTuningLevelSinglePassHq,
+	}
+	if maxBitrate > 0 {
+		settings.MaxBitrate = aws.Int32(int32(maxBitrate))
+	}
+	return &types.VideoDescription{
+		CodecSettings: &types.VideoCodecSettings{
+			Codec:        types.VideoCodecH264,
+			H264Settings: settings,
+		},
+	}
+}
+
+func aacDescription() types.AudioDescription {
+	return types.AudioDescription{
+		CodecSettings: &types.AudioCodecSettings{
+			Codec: types.AudioCodecAac,
+			AacSettings: &types.AacSettings{
+				Bitrate:    aws.Int32(128000),
+				CodingMode: types.AacCodingModeCodingMode20,
+				SampleRate: aws.Int32(48000),
+			},
+		},
+	}
+}
+
+// Status reports whether the job with the given ID is still running. A job
+// that failed or was canceled is reported as an error.
+func (c *Client) Status(ctx context.Context, id string) (running bool, err error) {
+	job, err := c.get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	switch job.Status {
+	case types.JobStatusSubmitted, types.JobStatusProgressing:
+		return true, nil
+	case types.JobStatusComplete:
+		return false, nil
+	default:
+		return false, jobError(job)
+	}
+}
+
+// Wait blocks until the job with the given ID completes and returns its
+// result, reporting progress through onProgress.
+func (c *Client) Wait(ctx context.Context, id string, onProgress func(percent float64)) (Result, error) {
+	wake := c.subscribe(id)
+	defer c.unsubscribe(id, wake)
+
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := c.get(ctx, id)
+		if err != nil {
+			return Result{}, err
+		}
+		switch job.Status {
+		case types.JobStatusSubmitted:
+		case types.JobStatusProgressing:
+			onProgress(float64(aws.ToInt32(job.JobPercentComplete)))
+		case types.JobStatusComplete:
+			return jobResult(job)
+		default:
+			return Result{}, jobError(job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// Cancel stops the job with the given ID.
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	_, err := c.api.CancelJob(ctx, &mediaconvert.CancelJobInput{Id: aws.String(id)})
+	if err != nil {
+		return fmt.Errorf("couldn't cancel MediaConvert job %s: %w", id, err)
+	}
+	return nil
+}
+
+// Notify wakes the waiters of the job with the given ID so they check on it
+// before the next poll.
+func (c *Client) Notify(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, wake := range c.waiters[id] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *Client) subscribe(id string) chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wake := make(chan struct{}, 1)
+	c.waiters[id] = append(c.waiters[id], wake)
+	return wake
+}
+
+func (c *Client) unsubscribe(id string, wake chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waiters := c.waiters[id]
+	for i, w := range waiters {
+		if w == wake {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.waiters, id)
+	} else {
+		c.waiters[id] = waiters
+	}
+}
+
+func (c *Client) get(ctx context.Context, id string) (*types.Job, error) {
+	out, err := c.api.GetJob(ctx, &mediaconvert.GetJobInput{Id: aws.String(id)})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get MediaConvert job %s: %w", id, err)
+	}
+	return out.Job, nil
+}
+
+func jobError(job *types.Job) error {
+	if job.Status == types.JobStatusCanceled {
+		return fmt.Errorf("MediaConvert job %s was canceled", aws.ToString(job.Id))
+	}
+	return fmt.Errorf("MediaConvert job %s failed with code %d: %s", aws.ToString(job.Id), aws.ToInt32(job.ErrorCode), aws.ToString(job.ErrorMessage))
+}
+
+// jobResult reads the MP4 output from the first output group, which is the
+// file group.
+func jobResult(job *types.Job) (Result, error) {
+	if len(job.OutputGroupDetails) == 0 || len(job.OutputGroupDetails[0].OutputDetails) == 0 {
+		return Result{}, fmt.Errorf("MediaConvert job %s reported no outputs", aws.ToString(job.Id))
+	}
+	output := job.OutputGroupDetails[0].OutputDetails[0]
+	result := Result{DurationSeconds: float64(aws.ToInt32(output.DurationInMs)) / 1000}
+	if output.VideoDetails != nil {
+		result.Width = int(aws.ToInt32(output.VideoDetails.WidthInPx))
+		result.Height = int(aws.ToInt32(output.VideoDetails.HeightInPx))
+	}
+	return result, nil
+}
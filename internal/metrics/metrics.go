@@ -0,0 +1,79 @@
+// Package metrics defines the Prometheus collectors exported on /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	UploadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_uploads_total",
+		Help: "Uploads accepted, by kind (video, thumbnail).",
+	}, []string{"kind"})
+
+	UploadSizeBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_upload_size_bytes",
+		Help:    "Size of accepted uploads, by kind.",
+		Buckets: prometheus.ExponentialBuckets(64<<10, 4, 10), // 64KiB to 16GiB
+	}, []string{"kind"})
+
+	FFmpegDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_ffmpeg_duration_seconds",
+		Help:    "Run time of ffmpeg and ffprobe processes, excluding time spent queued.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 15), // 50ms to ~27m
+	}, []string{"command", "result"})
+
+	ProcessingStageDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_processing_stage_duration_seconds",
+		Help:    "Time spent in each stage of processing an uploaded video.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 15), // 50ms to ~27m
+	}, []string{"stage"})
+
+	S3PutDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tubely_s3_put_duration_seconds",
+		Help:    "Time to upload an object to S3, including all multipart parts.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16), // 10ms to ~5m
+	})
+
+	S3PutErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tubely_s3_put_errors_total",
+		Help: "S3 uploads that failed.",
+	})
+
+	S3PutRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_s3_put_retries_total",
+		Help: "Requests of S3 uploads retried after the SDK's own retries failed, by operation.",
+	}, []string{"operation"})
+
+	AWSRetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tubely_aws_retries_total",
+		Help: "Requests to AWS retried by the SDK.",
+	})
+
+	PresignsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_presigns_total",
+		Help: "Signed URLs handed out, by source (s3, cloudfront, cache).",
+	}, []string{"source"})
+
+	UploadBansTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tubely_upload_bans_total",
+		Help: "Client IPs banned from uploading after repeated rejected uploads.",
+	})
+
+	VirusScansTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_virus_scans_total",
+		Help: "Virus scans of uploaded files, by status (clean, infected, failed).",
+	}, []string{"status"})
+
+	LegacyAPIRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_legacy_api_requests_total",
+		Help: "Requests to deprecated unversioned API paths, by route pattern.",
+	}, []string{"handler"})
+
+	HTTPRequestDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_http_request_duration_seconds",
+		Help:    "HTTP request duration by route pattern, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+)
@@ -0,0 +1,29 @@
+package moderation
+
+import "context"
+
+// MaxImageSize is the size of the largest image moderators accept, which is
+// the limit of images sent to Rekognition inline.
+const MaxImageSize = 5 << 20
+
+// Label is something unsafe found in an image, such as "Explicit Nudity",
+// with its confidence from 0 to 100.
+type Label struct {
+	Name       string
+	Confidence float64
+}
+
+// Moderator scans images for content that needs a review before it's
+// published.
+type Moderator interface {
+	// Moderate returns the labels of the unsafe content found in a JPEG or
+	// PNG image of at most MaxImageSize bytes, or none if it looks fine.
+	Moderate(ctx context.Context, image []byte) ([]Label, error)
+}
+
+// Noop flags nothing. It's the moderator when no backend is configured.
+type Noop struct{}
+
+func (Noop) Moderate(ctx context.Context, image []byte) ([]Label, error) {
+	return nil, nil
+}
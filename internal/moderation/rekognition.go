@@ -0,0 +1,44 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+// Rekognition moderates images with Amazon Rekognition's moderation labels.
+type Rekognition struct {
+	api           *rekognition.Client
+	minConfidence float64
+}
+
+// NewRekognition returns a moderator that flags the labels Rekognition finds
+// with at least minConfidence, from 0 to 100.
+func NewRekognition(api *rekognition.Client, minConfidence float64) *Rekognition {
+	return &Rekognition{
+		api:           api,
+		minConfidence: minConfidence,
+	}
+}
+
+func (r *Rekognition) Moderate(ctx context.Context, image []byte) ([]Label, error) {
+	out, err := r.api.DetectModerationLabels(ctx, &rekognition.DetectModerationLabelsInput{
+		Image:         &types.Image{Bytes: image},
+		MinConfidence: aws.Float32(float32(r.minConfidence)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't detect moderation labels: %w", err)
+	}
+
+	labels := make([]Label, 0, len(out.ModerationLabels))
+	for _, label := range out.ModerationLabels {
+		labels = append(labels, Label{
+			Name:       aws.ToString(label.Name),
+			Confidence: float64(aws.ToFloat32(label.Confidence)),
+		})
+	}
+	return labels, nil
+}
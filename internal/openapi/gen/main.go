@@ -0,0 +1,41 @@
+// Command gen writes the OpenAPI document of the API. With -check it only
+// compares it against the one already written and fails if that's out of
+// date, e.g. in CI after a route or handler changed.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "root of the module")
+	out := flag.String("out", "openapi.json", "file to write the document to")
+	check := flag.Bool("check", false, "fail if -out isn't up to date instead of writing it")
+	flag.Parse()
+
+	doc, err := openapi.Generate(*dir, openapi.APIOptions)
+	if err != nil {
+		log.Fatalf("Couldn't generate OpenAPI document: %v", err)
+	}
+
+	if *check {
+		written, err := os.ReadFile(*out)
+		if err != nil {
+			log.Fatalf("Couldn't read %s: %v", *out, err)
+		}
+		if !bytes.Equal(written, doc) {
+			log.Fatalf("%s is out of date, run go generate", *out)
+		}
+		return
+	}
+
+	err = os.WriteFile(*out, doc, 0o644)
+	if err != nil {
+		log.Fatalf("Couldn't write %s: %v", *out, err)
+	}
+}
@@ -0,0 +1,944 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const directivePrefix = "//openapi:"
+
+// Options describe the API the document is generated for.
+type Options struct {
+	Title   string
+	Version string
+	// ErrorType is the type of the package's error responses, which any
+	// operation can respond with.
+	ErrorType string
+}
+
+// APIOptions are the options the API's document is generated with, both by
+// the gen command and by the test that checks it's up to date.
+var APIOptions = Options{
+	Title:     "Tubely API",
+	Version:   "1.0.0",
+	ErrorType: "errorResponse",
+}
+
+// Generate returns the OpenAPI document, as indented JSON, of the routes the
+// main package in dir registers with HandleFunc. dir has to be the root of
+// the module, so the packages its types come from can be found.
+func Generate(dir string, opts Options) ([]byte, error) {
+	module, err := modulePath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		fset:   token.NewFileSet(),
+		module: module,
+		root:   dir,
+		pkgs:   map[string]*pkg{},
+		names:  map[string]string{},
+		doc: &Document{
+			OpenAPI: "3.1.0",
+			Info:    Info{Title: opts.Title, Version: opts.Version},
+			Paths:   map[string]*PathItem{},
+			Components: Components{
+				Schemas: map[string]*Schema{},
+				SecuritySchemes: map[string]SecurityScheme{
+					"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				},
+			},
+		},
+	}
+
+	main, err := g.load(module)
+	if err != nil {
+		return nil, err
+	}
+	errorType, ok := main.types[opts.ErrorType]
+	if !ok {
+		return nil, fmt.Errorf("error type %s not found", opts.ErrorType)
+	}
+	g.errorSchema, err = g.ref(errorType)
+	if err != nil {
+		return nil, fmt.Errorf("error type %s: %w", opts.ErrorType, err)
+	}
+
+	routes, err := g.routes(main)
+	if err != nil {
+		return nil, err
+	}
+	operationIDs := map[string]bool{}
+	for _, r := range routes {
+		op, err := g.operation(main, r)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", r.method, r.path, err)
+		}
+		if op == nil {
+			continue
+		}
+		if operationIDs[op.OperationID] {
+			op.OperationID += upperFirst(strings.ToLower(r.method))
+		}
+		operationIDs[op.OperationID] = true
+
+		item := g.doc.Paths[r.path]
+		if item == nil {
+			item = &PathItem{}
+			g.doc.Paths[r.path] = item
+		}
+		(*item)[strings.ToLower(r.method)] = op
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(g.doc)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func modulePath(dir string) (string, error) {
+	dat, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+			return strings.Trim(strings.TrimSpace(module), `"`), nil
+		}
+	}
+	return "", errors.New("go.mod has no module directive")
+}
+
+type generator struct {
+	fset   *token.FileSet
+	module string
+	root   string
+	pkgs   map[string]*pkg
+	// names maps component names to the types they were generated for, so
+	// two types with the same name aren't mixed up.
+	names       map[string]string
+	doc         *Document
+	errorSchema *Schema
+}
+
+// pkg is a parsed package of the module.
+type pkg struct {
+	path  string
+	files []*ast.File
+	types map[string]typeDecl
+	funcs map[string]funcDecl
+	// enums are the values of the string constants of each type.
+	enums map[string][]string
+}
+
+type typeDecl struct {
+	name string
+	spec *ast.TypeSpec
+	doc  *ast.CommentGroup
+	file *ast.File
+	pkg  *pkg
+}
+
+type funcDecl struct {
+	decl *ast.FuncDecl
+	file *ast.File
+}
+
+// scope is where a type expression is resolved: the file it's written in,
+// for its imports, and the types of the handler it belongs to, if any.
+type scope struct {
+	pkg    *pkg
+	file   *ast.File
+	locals map[string]*ast.TypeSpec
+}
+
+// load parses the package with the given import path, which has to belong
+// to the module. Functions and methods are indexed by name alone.
+func (g *generator) load(importPath string) (*pkg, error) {
+	if p, ok := g.pkgs[importPath]; ok {
+		return p, nil
+	}
+
+	dir := g.root
+	if importPath != g.module {
+		dir = filepath.Join(g.root, filepath.FromSlash(strings.TrimPrefix(importPath, g.module+"/")))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pkg{
+		path:  importPath,
+		types: map[string]typeDecl{},
+		funcs: map[string]funcDecl{},
+		enums: map[string][]string{},
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(g.fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		p.files = append(p.files, file)
+		p.index(file)
+	}
+	g.pkgs[importPath] = p
+	return p, nil
+}
+
+func (p *pkg) index(file *ast.File) {
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if _, ok := p.funcs[decl.Name.Name]; !ok {
+				p.funcs[decl.Name.Name] = funcDecl{decl: decl, file: file}
+			}
+		case *ast.GenDecl:
+			switch decl.Tok {
+			case token.TYPE:
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.TypeSpec)
+					doc := spec.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					if _, ok := p.types[spec.Name.Name]; !ok {
+						p.types[spec.Name.Name] = typeDecl{name: spec.Name.Name, spec: spec, doc: doc, file: file, pkg: p}
+					}
+				}
+			case token.CONST:
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.ValueSpec)
+					typ, ok := spec.Type.(*ast.Ident)
+					if !ok {
+						continue
+					}
+					for _, value := range spec.Values {
+						lit, ok := value.(*ast.BasicLit)
+						if !ok || lit.Kind != token.STRING {
+							continue
+						}
+						s, err := strconv.Unquote(lit.Value)
+						if err == nil {
+							p.enums[typ.Name] = append(p.enums[typ.Name], s)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// route is a route registered with HandleFunc, along with the calls that
+// wrap its handler, such as middleware.
+type route struct {
+	method  string
+	path    string
+	handler string
+	calls   []*ast.CallExpr
+}
+
+func (g *generator) routes(main *pkg) ([]route, error) {
+	var routes []route
+	var err error
+	for _, file := range main.files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			lits := funcLits(fn.Body)
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				if err != nil {
+					return false
+				}
+				call, ok := n.(*ast.CallExpr)
+				if !ok || callName(call) != "HandleFunc" || len(call.Args) < 2 {
+					return true
+				}
+				lit, ok := call.Args[0].(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					return true
+				}
+				pattern, _ := strconv.Unquote(lit.Value)
+				method, path, ok := strings.Cut(pattern, " ")
+				if !ok {
+					err = fmt.Errorf("route %s has no method", pattern)
+					return false
+				}
+				r := route{method: method, path: pathParam.ReplaceAllString(path, "{$1}")}
+				err = r.unwrap(call.Args[1], lits)
+				if err != nil {
+					err = fmt.Errorf("%s: %w", pattern, err)
+					return false
+				}
+				routes = append(routes, r)
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return routes, nil
+}
+
+// funcLits returns the function literals assigned to variables in body, such
+// as helpers that wrap handlers in several middlewares.
+func funcLits(body *ast.BlockStmt) map[string]*ast.FuncLit {
+	lits := map[string]*ast.FuncLit{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != len(assign.Rhs) {
+			return true
+		}
+		for i, lhs := range assign.Lhs {
+			ident, ok := lhs.(*ast.Ident)
+			lit, isLit := assign.Rhs[i].(*ast.FuncLit)
+			if ok && isLit {
+				lits[ident.Name] = lit
+			}
+		}
+		return true
+	})
+	return lits
+}
+
+// unwrap finds the handler in expr, taking the last argument of each call
+// wrapping it, and records the calls.
+func (r *route) unwrap(expr ast.Expr, lits map[string]*ast.FuncLit) error {
+	switch e := expr.(type) {
+	case *ast.CallExpr:
+		r.calls = append(r.calls, e)
+		if ident, ok := e.Fun.(*ast.Ident); ok {
+			if lit, ok := lits[ident.Name]; ok {
+				ast.Inspect(lit.Body, func(n ast.Node) bool {
+					if call, ok := n.(*ast.CallExpr); ok {
+						r.calls = append(r.calls, call)
+					}
+					return true
+				})
+			}
+		}
+		if len(e.Args) == 0 {
+			return fmt.Errorf("can't find the handler passed to %s", callName(e))
+		}
+		return r.unwrap(e.Args[len(e.Args)-1], lits)
+	case *ast.SelectorExpr:
+		r.handler = e.Sel.Name
+	case *ast.Ident:
+		r.handler = e.Name
+	default:
+		return errors.New("can't find the handler")
+	}
+	return nil
+}
+
+func callName(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.SelectorExpr:
+		return fun.Sel.Name
+	case *ast.Ident:
+		return fun.Name
+	}
+	return ""
+}
+
+// middlewares document what the middlewares wrapping handlers add to their
+// operations.
+var middlewares = map[string]func(g *generator, op *Operation, call *ast.CallExpr){
+	"middlewareRequireRole": func(g *generator, op *Operation, call *ast.CallExpr) {
+		op.Security = []map[string][]string{{"bearer": {}}}
+		g.errorResponse(op, http.StatusUnauthorized)
+		g.errorResponse(op, http.StatusForbidden)
+		if len(call.Args) == 0 {
+			return
+		}
+		if role, ok := call.Args[0].(*ast.SelectorExpr); ok {
+			op.Description = fmt.Sprintf("Requires the %s role or higher.", strings.ToLower(strings.TrimPrefix(role.Sel.Name, "Role")))
+		}
+	},
+	"middlewareIdempotency": func(g *generator, op *Operation, call *ast.CallExpr) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        "Idempotency-Key",
+			In:          "header",
+			Description: "Replays the response of an earlier request with the same key instead of repeating it",
+			Schema:      &Schema{Type: "string"},
+		})
+	},
+	"middlewareRateLimit": func(g *generator, op *Operation, call *ast.CallExpr) {
+		g.errorResponse(op, http.StatusTooManyRequests)
+	},
+	"middlewareDiskSpace": func(g *generator, op *Operation, call *ast.CallExpr) {
+		g.errorResponse(op, http.StatusInsufficientStorage)
+	},
+}
+
+var pathParam = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// operation documents r from the directives of its handler. It returns nil
+// for handlers that are ignored.
+func (g *generator) operation(main *pkg, r route) (*Operation, error) {
+	fn, ok := main.funcs[r.handler]
+	if !ok {
+		return nil, fmt.Errorf("handler %s not found", r.handler)
+	}
+	directives := parseDirectives(fn.decl.Doc)
+	if _, ok := directives["ignore"]; ok {
+		return nil, nil
+	}
+	summaries := directives["summary"]
+	if len(summaries) == 0 {
+		return nil, fmt.Errorf("handler %s has no %ssummary", r.handler, directivePrefix)
+	}
+
+	op := &Operation{
+		OperationID: lowerFirst(strings.TrimPrefix(r.handler, "handler")),
+		Summary:     summaries[0],
+		Responses:   map[string]Response{},
+	}
+	for _, tags := range directives["tags"] {
+		op.Tags = append(op.Tags, strings.Fields(tags)...)
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(r.path, -1) {
+		schema := &Schema{Type: "string"}
+		if strings.HasSuffix(match[1], "ID") {
+			schema.Format = "uuid"
+		}
+		op.Parameters = append(op.Parameters, Parameter{Name: match[1], In: "path", Required: true, Schema: schema})
+	}
+
+	sc := scope{pkg: main, file: fn.file, locals: localTypes(fn.decl.Body)}
+	for _, auth := range directives["auth"] {
+		switch auth {
+		case "bearer":
+			op.Security = []map[string][]string{{"bearer": {}}}
+		case "optional":
+			op.Security = []map[string][]string{{}, {"bearer": {}}}
+		default:
+			return nil, fmt.Errorf("unknown auth %q", auth)
+		}
+		g.errorResponse(op, http.StatusUnauthorized)
+	}
+
+	for _, in := range []string{"query", "header"} {
+		for _, param := range directives[in] {
+			fields := strings.SplitN(param, " ", 3)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s parameter %q needs a name and a type", in, param)
+			}
+			schema, err := paramSchema(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			parameter := Parameter{Name: fields[0], In: in, Schema: schema}
+			if len(fields) == 3 {
+				parameter.Description = fields[2]
+			}
+			op.Parameters = append(op.Parameters, parameter)
+		}
+	}
+
+	for _, body := range directives["body"] {
+		schema, err := g.schemaOf(body, sc)
+		if err != nil {
+			return nil, err
+		}
+		op.RequestBody = &RequestBody{Content: map[string]MediaType{"application/json": {Schema: optionalFields(schema)}}}
+	}
+
+	if forms := directives["form"]; len(forms) > 0 {
+		form := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for _, field := range forms {
+			fields := strings.SplitN(field, " ", 3)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("form field %q needs a name and a type", field)
+			}
+			schema := &Schema{Type: "string"}
+			switch fields[1] {
+			case "file":
+				schema.Format = "binary"
+			case "string":
+			default:
+				return nil, fmt.Errorf("form field %s has unknown type %s", fields[0], fields[1])
+			}
+			if len(fields) == 3 {
+				schema.Description = fields[2]
+			}
+			form.Properties[fields[0]] = schema
+		}
+		op.RequestBody = &RequestBody{Content: map[string]MediaType{"multipart/form-data": {Schema: form}}}
+	}
+
+	for _, response := range directives["response"] {
+		status, typ, _ := strings.Cut(response, " ")
+		code, err := strconv.Atoi(status)
+		if err != nil || http.StatusText(code) == "" {
+			return nil, fmt.Errorf("response %q has no valid status", response)
+		}
+		resp := Response{Description: http.StatusText(code)}
+		switch {
+		case typ == "":
+		case strings.Contains(typ, "/"):
+			resp.Content = map[string]MediaType{typ: {}}
+		default:
+			schema, err := g.schemaOf(typ, sc)
+			if err != nil {
+				return nil, err
+			}
+			resp.Content = map[string]MediaType{"application/json": {Schema: schema}}
+		}
+		op.Responses[status] = resp
+	}
+	if len(op.Responses) == 0 {
+		return nil, fmt.Errorf("handler %s has no %sresponse", r.handler, directivePrefix)
+	}
+
+	for _, call := range r.calls {
+		if document, ok := middlewares[callName(call)]; ok {
+			document(g, op, call)
+		}
+	}
+	op.Responses["default"] = Response{
+		Description: "Error",
+		Content:     map[string]MediaType{"application/json": {Schema: g.errorSchema}},
+	}
+	return op, nil
+}
+
+func (g *generator) errorResponse(op *Operation, status int) {
+	op.Responses[strconv.Itoa(status)] = Response{
+		Description: http.StatusText(status),
+		Content:     map[string]MediaType{"application/json": {Schema: g.errorSchema}},
+	}
+}
+
+// parseDirectives maps the names of the directives in doc to their
+// arguments, in order.
+func parseDirectives(doc *ast.CommentGroup) map[string][]string {
+	directives := map[string][]string{}
+	if doc == nil {
+		return directives
+	}
+	for _, comment := range doc.List {
+		directive, ok := strings.CutPrefix(comment.Text, directivePrefix)
+		if !ok {
+			continue
+		}
+		name, args, _ := strings.Cut(directive, " ")
+		directives[name] = append(directives[name], strings.TrimSpace(args))
+	}
+	return directives
+}
+
+// localTypes returns the types declared in a function body.
+func localTypes(body *ast.BlockStmt) map[string]*ast.TypeSpec {
+	types := map[string]*ast.TypeSpec{}
+	if body == nil {
+		return types
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		decl, ok := n.(*ast.GenDecl)
+		if !ok || decl.Tok != token.TYPE {
+			return true
+		}
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.TypeSpec)
+			types[spec.Name.Name] = spec
+		}
+		return true
+	})
+	return types
+}
+
+func paramSchema(typ string) (*Schema, error) {
+	switch typ {
+	case "string", "integer", "number", "boolean":
+		return &Schema{Type: typ}, nil
+	case "date-time", "uuid":
+		return &Schema{Type: "string", Format: typ}, nil
+	}
+	return nil, fmt.Errorf("unknown parameter type %s", typ)
+}
+
+// schemaOf returns the schema of the type written in a directive.
+func (g *generator) schemaOf(typ string, sc scope) (*Schema, error) {
+	expr, err := parser.ParseExpr(typ)
+	if err != nil {
+		return nil, fmt.Errorf("invalid type %q: %w", typ, err)
+	}
+	return g.schema(expr, sc)
+}
+
+var basicTypes = map[string]string{
+	"string":  "string",
+	"bool":    "boolean",
+	"int":     "integer",
+	"int8":    "integer",
+	"int16":   "integer",
+	"int32":   "integer",
+	"int64":   "integer",
+	"uint":    "integer",
+	"uint8":   "integer",
+	"uint16":  "integer",
+	"uint32":  "integer",
+	"uint64":  "integer",
+	"byte":    "integer",
+	"rune":    "integer",
+	"float32": "number",
+	"float64": "number",
+}
+
+// externalTypes are the types from outside the module the API encodes, by
+// import path and name.
+var externalTypes = map[string]Schema{
+	"time.Time":                       {Type: "string", Format: "date-time"},
+	"time.Duration":                   {Type: "integer"},
+	"encoding/json.RawMessage":        {},
+	"github.com/google/uuid.UUID":     {Type: "string", Format: "uuid"},
+	"github.com/google/uuid.NullUUID": {Type: []string{"string", "null"}, Format: "uuid"},
+	"database/sql.NullString":         {Type: []string{"string", "null"}},
+	"database/sql.NullTime":           {Type: []string{"string", "null"}, Format: "date-time"},
+	"database/sql.NullInt64":          {Type: []string{"integer", "null"}},
+	"database/sql.NullFloat64":        {Type: []string{"number", "null"}},
+	"database/sql.NullBool":           {Type: []string{"boolean", "null"}},
+	"github.com/aws/aws-sdk-go-v2/service/s3/types.StorageClass": {Type: "string"},
+	"github.com/aws/aws-sdk-go-v2/service/s3/types.Tier":         {Type: "string"},
+}
+
+func (g *generator) schema(expr ast.Expr, sc scope) (*Schema, error) {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if typ, ok := basicTypes[e.Name]; ok {
+			return &Schema{Type: typ}, nil
+		}
+		if e.Name == "any" {
+			return &Schema{}, nil
+		}
+		if spec, ok := sc.locals[e.Name]; ok {
+			return g.schema(spec.Type, sc)
+		}
+		if decl, ok := sc.pkg.types[e.Name]; ok {
+			return g.ref(decl)
+		}
+		return nil, fmt.Errorf("unknown type %s", e.Name)
+	case *ast.SelectorExpr:
+		decl, external, err := g.resolve(e, sc)
+		if err != nil {
+			return nil, err
+		}
+		if decl == nil {
+			schema := external
+			return &schema, nil
+		}
+		return g.ref(*decl)
+	case *ast.StarExpr:
+		schema, err := g.schema(e.X, sc)
+		if err != nil {
+			return nil, err
+		}
+		return nullable(schema), nil
+	case *ast.ArrayType:
+		if ident, ok := e.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+		items, err := g.schema(e.Elt, sc)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case *ast.MapType:
+		values, err := g.schema(e.Value, sc)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case *ast.StructType:
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		return schema, g.addFields(schema, e, sc)
+	case *ast.InterfaceType:
+		return &Schema{}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", expr)
+}
+
+// resolve returns the declaration of a type from another package of the
+// module, or the schema of a type from outside it.
+func (g *generator) resolve(e *ast.SelectorExpr, sc scope) (*typeDecl, Schema, error) {
+	pkgName, ok := e.X.(*ast.Ident)
+	if !ok {
+		return nil, Schema{}, fmt.Errorf("unsupported type %T", e.X)
+	}
+	// Directives can name packages their file doesn't import, as long as
+	// another file of the package does.
+	importPath := importedAs(sc.file, pkgName.Name)
+	for _, file := range sc.pkg.files {
+		if importPath != "" {
+			break
+		}
+		importPath = importedAs(file, pkgName.Name)
+	}
+	if importPath == "" {
+		return nil, Schema{}, fmt.Errorf("package %s isn't imported", pkgName.Name)
+	}
+
+	if schema, ok := externalTypes[importPath+"."+e.Sel.Name]; ok {
+		return nil, schema, nil
+	}
+	if !strings.HasPrefix(importPath, g.module+"/") {
+		return nil, Schema{}, fmt.Errorf("unsupported type %s.%s", importPath, e.Sel.Name)
+	}
+	p, err := g.load(importPath)
+	if err != nil {
+		return nil, Schema{}, err
+	}
+	decl, ok := p.types[e.Sel.Name]
+	if !ok {
+		return nil, Schema{}, fmt.Errorf("unknown type %s.%s", importPath, e.Sel.Name)
+	}
+	return &decl, Schema{}, nil
+}
+
+// importedAs returns the path of the package file imports as name.
+func importedAs(file *ast.File, name string) string {
+	for _, spec := range file.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		imported := path.Base(p)
+		if spec.Name != nil {
+			imported = spec.Name.Name
+		}
+		if imported == name {
+			return p
+		}
+	}
+	return ""
+}
+
+// ref returns a reference to the component schema of a declared type,
+// generating it the first time.
+func (g *generator) ref(decl typeDecl) (*Schema, error) {
+	name := decl.name
+	if decl.pkg.path == g.module {
+		name = upperFirst(name)
+	}
+	key := decl.pkg.path + "." + decl.name
+	ref := &Schema{Ref: "#/components/schemas/" + name}
+	if existing, ok := g.names[name]; ok {
+		if existing != key {
+			return nil, fmt.Errorf("types %s and %s would both be named %s", existing, key, name)
+		}
+		return ref, nil
+	}
+
+	// The name is taken before the schema is generated, so types that
+	// refer to themselves end.
+	g.names[name] = key
+	schema := &Schema{}
+	g.doc.Components.Schemas[name] = schema
+
+	generated, err := g.declSchema(decl)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	*schema = *generated
+	return ref, nil
+}
+
+func (g *generator) declSchema(decl typeDecl) (*Schema, error) {
+	if schemas := parseDirectives(decl.doc)["schema"]; len(schemas) > 0 {
+		typ, format, _ := strings.Cut(schemas[0], " ")
+		schema := &Schema{Type: typ, Format: format}
+		if types := strings.Split(typ, ","); len(types) > 1 {
+			schema.Type = types
+		}
+		return schema, nil
+	}
+
+	schema, err := g.schema(decl.spec.Type, scope{pkg: decl.pkg, file: decl.file})
+	if err != nil {
+		return nil, err
+	}
+	if values, ok := decl.pkg.enums[decl.name]; ok && schema.Type == "string" {
+		schema.Enum = values
+	}
+	return schema, nil
+}
+
+// addFields adds the fields of a struct to schema the way encoding/json
+// encodes them, promoting the fields of embedded structs.
+func (g *generator) addFields(schema *Schema, st *ast.StructType, sc scope) error {
+	for _, field := range st.Fields.List {
+		name, omitEmpty, skip := jsonTag(field)
+		if skip {
+			continue
+		}
+
+		if len(field.Names) == 0 && name == "" {
+			embedded, embeddedScope, err := g.structOf(field.Type, sc)
+			if err != nil {
+				return err
+			}
+			err = g.addFields(schema, embedded, embeddedScope)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		names := []string{name}
+		if name == "" {
+			names = nil
+			for _, ident := range field.Names {
+				if ident.IsExported() {
+					names = append(names, ident.Name)
+				}
+			}
+		} else if len(field.Names) > 0 && !field.Names[0].IsExported() {
+			continue
+		}
+		for _, name := range names {
+			fieldSchema, err := g.schema(field.Type, sc)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+			schema.Properties[name] = fieldSchema
+			if !omitEmpty {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+	}
+	return nil
+}
+
+// structOf returns the struct type of an embedded field.
+func (g *generator) structOf(expr ast.Expr, sc scope) (*ast.StructType, scope, error) {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+
+	var decl *typeDecl
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if spec, ok := sc.locals[e.Name]; ok {
+			if st, ok := spec.Type.(*ast.StructType); ok {
+				return st, sc, nil
+			}
+		}
+		if d, ok := sc.pkg.types[e.Name]; ok {
+			decl = &d
+		}
+	case *ast.SelectorExpr:
+		d, _, err := g.resolve(e, sc)
+		if err != nil {
+			return nil, sc, err
+		}
+		decl = d
+	}
+	if decl == nil {
+		return nil, sc, errors.New("unsupported embedded field")
+	}
+	st, ok := decl.spec.Type.(*ast.StructType)
+	if !ok {
+		return nil, sc, fmt.Errorf("embedded %s isn't a struct", decl.name)
+	}
+	return st, scope{pkg: decl.pkg, file: decl.file}, nil
+}
+
+// jsonTag returns the name a field's json tag gives it, if any, whether it's
+// omitted when empty and whether it's never encoded.
+func jsonTag(field *ast.Field) (name string, omitEmpty, skip bool) {
+	if field.Tag == nil {
+		return "", false, false
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return "", false, false
+	}
+	value := reflect.StructTag(tag).Get("json")
+	if value == "-" {
+		return "", false, true
+	}
+	name, options, _ := strings.Cut(value, ",")
+	for _, option := range strings.Split(options, ",") {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
+
+// optionalFields drops the required fields of a request body, since
+// handlers decode them all as optional and validate them themselves.
+// Component schemas are left alone, as responses share them.
+func optionalFields(schema *Schema) *Schema {
+	if schema.Ref != "" {
+		return schema
+	}
+	schema.Required = nil
+	for _, property := range schema.Properties {
+		optionalFields(property)
+	}
+	if schema.Items != nil {
+		optionalFields(schema.Items)
+	}
+	return schema
+}
+
+// nullable returns schema allowing null as well.
+func nullable(schema *Schema) *Schema {
+	switch typ := schema.Type.(type) {
+	case string:
+		copied := *schema
+		copied.Type = []string{typ, "null"}
+		return &copied
+	case nil:
+		if schema.Ref == "" {
+			return schema
+		}
+		return &Schema{AnyOf: []*Schema{schema, {Type: "null"}}}
+	}
+	return schema
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
@@ -0,0 +1,103 @@
+// Package openapi generates the OpenAPI document of the API from its source:
+// the routes main registers, the directives on their handlers and the Go
+// types they decode and respond with.
+//
+// Handlers are described with directive comments, which godoc doesn't show:
+//
+//	//openapi:summary List videos
+//	//openapi:tags videos
+//	//openapi:auth bearer
+//	//openapi:query limit integer How many videos to return
+//	//openapi:header X-Checksum-SHA256 string Hex SHA-256 of the upload
+//	//openapi:body parameters
+//	//openapi:form video file The video to upload
+//	//openapi:response 200 []database.Video
+//	//openapi:ignore
+//
+// Auth is bearer for handlers that require a token, or optional for those
+// that respond differently with one. Handlers wrapped in middlewareRequireRole
+// don't need it.
+//
+// Types are named like in the handler's package, and can be types declared
+// in the handler itself, such as its parameters. A response without a type has
+// no body, and one with a media type, like text/event-stream, isn't JSON.
+// Every documented handler needs a summary, and handlers that aren't part of
+// the public API are left out with ignore.
+//
+// A type whose JSON encoding isn't what its fields suggest is described by
+// a directive on its declaration, e.g. //openapi:schema string,null date-time.
+package openapi
+
+// Document is an OpenAPI 3.1 document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem maps the lowercase methods of a path to their operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Content map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// Schema is the subset of JSON Schema the generator produces. Type is a
+// string, or a list of them for nullable values.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+}
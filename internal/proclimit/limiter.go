@@ -0,0 +1,63 @@
+package proclimit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueTimeout is returned when no slot frees up within the limiter's
+// queue timeout.
+var ErrQueueTimeout = errors.New("timed out waiting for a free process slot")
+
+// Limiter caps how many expensive external processes, like ffmpeg, run at
+// once. Callers beyond the limit wait in line for up to the queue timeout.
+type Limiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+	waiting atomic.Int64
+}
+
+func New(maxConcurrent int, timeout time.Duration) *Limiter {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Limiter{
+		slots:   make(chan struct{}, maxConcurrent),
+		timeout: timeout,
+	}
+}
+
+// Acquire blocks until a slot is free and returns a function that releases
+// it. It gives up when ctx is done or the queue timeout elapses.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+
+	var timeout <-chan time.Time
+	if l.timeout > 0 {
+		timer := time.NewTimer(l.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		return nil, ErrQueueTimeout
+	}
+}
+
+// Waiting returns the number of callers queued for a slot.
+func (l *Limiter) Waiting() int64 {
+	return l.waiting.Load()
+}
+
+// Running returns the number of slots in use.
+func (l *Limiter) Running() int {
+	return len(l.slots)
+}
@@ -0,0 +1,150 @@
+package progress
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Stage string
+
+const (
+	StageUploading  Stage = "uploading"
+	StageQueued     Stage = "queued"
+	StageProcessing Stage = "processing"
+	StageStoring    Stage = "storing"
+	StageReady      Stage = "ready"
+	StageFailed     Stage = "failed"
+)
+
+// Done reports whether no further updates follow a stage.
+func (s Stage) Done() bool {
+	return s == StageReady || s == StageFailed
+}
+
+// Update is a snapshot of how far along a video is. Percent is the progress
+// of the current stage, from 0 to 100.
+type Update struct {
+	Stage         Stage   `json:"stage"`
+	Percent       float64 `json:"percent"`
+	BytesReceived int64   `json:"bytes_received,omitempty"`
+	BytesTotal    int64   `json:"bytes_total,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// retainDone is how long the final update of a video is kept for clients
+// that subscribe after it was published.
+const retainDone = 5 * time.Minute
+
+// Tracker fans out progress updates of videos to subscribers. It lives in
+// memory, so updates are only visible on the instance doing the work.
+type Tracker struct {
+	mu     sync.Mutex
+	videos map[uuid.UUID]*entry
+	closed bool
+}
+
+type entry struct {
+	last Update
+	subs map[chan Update]struct{}
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{videos: map[uuid.UUID]*entry{}}
+}
+
+// Publish records the latest update of a video and passes it on to its
+// subscribers. Slow subscribers skip intermediate updates rather than
+// blocking the publisher.
+func (t *Tracker) Publish(videoID uuid.UUID, update Update) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.videos[videoID]
+	if !ok {
+		e = &entry{subs: map[chan Update]struct{}{}}
+		t.videos[videoID] = e
+	}
+	e.last = update
+	for ch := range e.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- update
+	}
+
+	if update.Stage.Done() {
+		time.AfterFunc(retainDone, func() { t.forget(videoID, e) })
+	}
+}
+
+// Last returns the latest update of a video, if one is known.
+func (t *Tracker) Last(videoID uuid.UUID) (Update, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.videos[videoID]
+	if !ok || e.last.Stage == "" {
+		return Update{}, false
+	}
+	return e.last, true
+}
+
+// Subscribe returns a channel receiving the updates of a video. It's closed
+// when the tracker is closed; call cancel to stop receiving.
+func (t *Tracker) Subscribe(videoID uuid.UUID) (<-chan Update, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan Update, 1)
+	if t.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	e, ok := t.videos[videoID]
+	if !ok {
+		e = &entry{subs: map[chan Update]struct{}{}}
+		t.videos[videoID] = e
+	}
+	e.subs[ch] = struct{}{}
+
+	cancel := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := e.subs[ch]; ok {
+			delete(e.subs, ch)
+			close(ch)
+		}
+		if len(e.subs) == 0 && e.last.Stage == "" && t.videos[videoID] == e {
+			delete(t.videos, videoID)
+		}
+	}
+	return ch, cancel
+}
+
+// Close ends all subscriptions, e.g. so streaming responses don't hold up a
+// server shutdown.
+func (t *Tracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for _, e := range t.videos {
+		for ch := range e.subs {
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (t *Tracker) forget(videoID uuid.UUID, e *entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.videos[videoID] == e && e.last.Stage.Done() && len(e.subs) == 0 {
+		delete(t.videos, videoID)
+	}
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Guard limits attempts per key over a sliding window, and bans keys that
+// fail too often within the window for a while. Unlike a Limiter, it allows
+// no bursts beyond the limit, and it only forgets an attempt once it's
+// outside the window.
+type Guard struct {
+	window      time.Duration
+	maxAttempts int
+	maxFailures int
+	banDuration time.Duration
+
+	// now is the clock, replaced in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*guardClient
+	lastSweep time.Time
+}
+
+type guardClient struct {
+	attempts    []time.Time
+	failures    []time.Time
+	bannedUntil time.Time
+}
+
+// NewGuard creates a guard that allows maxAttempts attempts per key within
+// window, and bans a key for banDuration once maxFailures of its attempts
+// have failed within window. A limit that isn't positive is disabled; with
+// both disabled it returns nil, and a nil Guard allows everything.
+func NewGuard(window time.Duration, maxAttempts, maxFailures int, banDuration time.Duration) *Guard {
+	if window <= 0 || (maxAttempts <= 0 && (maxFailures <= 0 || banDuration <= 0)) {
+		return nil
+	}
+	return &Guard{
+		window:      window,
+		maxAttempts: maxAttempts,
+		maxFailures: maxFailures,
+		banDuration: banDuration,
+		now:         time.Now,
+		clients:     map[string]*guardClient{},
+		lastSweep:   time.Now(),
+	}
+}
+
+// Allow records an attempt by key. If the key is banned or has used up its
+// attempts, it reports false along with how long until it may try again;
+// attempts that aren't allowed aren't counted.
+func (g *Guard) Allow(key string) (bool, time.Duration) {
+	if g == nil {
+		return true, 0
+	}
+
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.sweep(now)
+
+	c, ok := g.clients[key]
+	if !ok {
+		c = &guardClient{}
+		g.clients[key] = c
+	}
+	if now.Before(c.bannedUntil) {
+		return false, c.bannedUntil.Sub(now)
+	}
+	c.attempts = g.prune(c.attempts, now)
+	if g.maxAttempts > 0 && len(c.attempts) >= g.maxAttempts {
+		return false, c.attempts[0].Add(g.window).Sub(now)
+	}
+	c.attempts = append(c.attempts, now)
+	return true, 0
+}
+
+// Fail records a failed attempt by key. It reports whether the failure got
+// the key banned.
+func (g *Guard) Fail(key string) bool {
+	if g == nil || g.maxFailures <= 0 || g.banDuration <= 0 {
+		return false
+	}
+
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.clients[key]
+	if !ok {
+		c = &guardClient{}
+		g.clients[key] = c
+	}
+	c.failures = append(g.prune(c.failures, now), now)
+	if len(c.failures) < g.maxFailures {
+		return false
+	}
+	c.failures = nil
+	c.bannedUntil = now.Add(g.banDuration)
+	return true
+}
+
+// prune drops the times that are outside the window.
+func (g *Guard) prune(times []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-g.window)
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < idleTimeout {
+		return
+	}
+	for key, c := range g.clients {
+		c.attempts = g.prune(c.attempts, now)
+		c.failures = g.prune(c.failures, now)
+		if len(c.attempts) == 0 && len(c.failures) == 0 && !now.Before(c.bannedUntil) {
+			delete(g.clients, key)
+		}
+	}
+	g.lastSweep = now
+}
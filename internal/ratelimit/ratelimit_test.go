@@ -0,0 +1,228 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a fake clock that only moves when advanced.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+func newClock() *clock {
+	return &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// step is one call in a test: advance the clock, then call with key and
+// check the result.
+type step struct {
+	advance  time.Duration
+	key      string
+	fail     bool // Guard.Fail instead of Allow
+	want     bool
+	wantWait time.Duration
+}
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		steps     []step
+	}{
+		{
+			name:      "burst then refill",
+			perMinute: 60,
+			burst:     2,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: time.Second},
+				{advance: 500 * time.Millisecond, key: "a", want: false, wantWait: 500 * time.Millisecond},
+				{advance: 500 * time.Millisecond, key: "a", want: true},
+				{key: "a", want: false, wantWait: time.Second},
+			},
+		},
+		{
+			name:      "refill is capped at the burst",
+			perMinute: 60,
+			burst:     2,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{advance: time.Hour, key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: time.Second},
+			},
+		},
+		{
+			name:      "keys are isolated",
+			perMinute: 1,
+			burst:     1,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: time.Minute},
+				{key: "b", want: true},
+				{key: "b", want: false, wantWait: time.Minute},
+			},
+		},
+		{
+			name:      "disabled",
+			perMinute: 0,
+			burst:     1,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			l := New(tt.perMinute, tt.burst)
+			if l != nil {
+				l.now = c.now
+				l.lastSweep = c.t
+			}
+			for i, s := range tt.steps {
+				c.t = c.t.Add(s.advance)
+				ok, wait := l.Allow(s.key)
+				if ok != s.want || wait != s.wantWait {
+					t.Errorf("step %d: Allow(%q) = %t, %s; want %t, %s", i, s.key, ok, wait, s.want, s.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterDropsIdleBuckets(t *testing.T) {
+	c := newClock()
+	l := New(60, 1)
+	l.now = c.now
+	l.lastSweep = c.t
+
+	l.Allow("a")
+	c.t = c.t.Add(idleTimeout + time.Second)
+	l.Allow("b")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("idle bucket wasn't dropped")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Error("active bucket was dropped")
+	}
+}
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      time.Duration
+		maxAttempts int
+		maxFailures int
+		banDuration time.Duration
+		steps       []step
+	}{
+		{
+			name:        "sliding window",
+			window:      time.Minute,
+			maxAttempts: 2,
+			steps: []step{
+				{key: "a", want: true},
+				{advance: 30 * time.Second, key: "a", want: true},
+				{advance: 15 * time.Second, key: "a", want: false, wantWait: 15 * time.Second},
+				// The first attempt leaves the window, the second doesn't.
+				{advance: 15 * time.Second, key: "a", want: true},
+				{key: "a", want: false, wantWait: 30 * time.Second},
+			},
+		},
+		{
+			name:        "denied attempts aren't counted",
+			window:      time.Minute,
+			maxAttempts: 1,
+			steps: []step{
+				{key: "a", want: true},
+				{advance: 30 * time.Second, key: "a", want: false, wantWait: 30 * time.Second},
+				{advance: 30 * time.Second, key: "a", want: true},
+			},
+		},
+		{
+			name:        "ban expires",
+			window:      time.Minute,
+			maxFailures: 2,
+			banDuration: time.Hour,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", fail: true, want: false},
+				{key: "a", want: true},
+				{key: "a", fail: true, want: true},
+				{key: "a", want: false, wantWait: time.Hour},
+				{advance: 59 * time.Minute, key: "a", want: false, wantWait: time.Minute},
+				{advance: time.Minute, key: "a", want: true},
+				// Failures from before the ban don't carry over.
+				{key: "a", fail: true, want: false},
+			},
+		},
+		{
+			name:        "failures outside the window don't count",
+			window:      time.Minute,
+			maxFailures: 2,
+			banDuration: time.Hour,
+			steps: []step{
+				{key: "a", fail: true, want: false},
+				{advance: time.Minute, key: "a", fail: true, want: false},
+				{key: "a", want: true},
+			},
+		},
+		{
+			name:        "keys are isolated",
+			window:      time.Minute,
+			maxAttempts: 1,
+			maxFailures: 1,
+			banDuration: time.Hour,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", fail: true, want: true},
+				{key: "a", want: false, wantWait: time.Hour},
+				{key: "b", want: true},
+				{key: "b", want: false, wantWait: time.Minute},
+			},
+		},
+		{
+			name:   "disabled",
+			window: time.Minute,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", fail: true, want: false},
+				{key: "a", want: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			g := NewGuard(tt.window, tt.maxAttempts, tt.maxFailures, tt.banDuration)
+			if g != nil {
+				g.now = c.now
+				g.lastSweep = c.t
+			}
+			for i, s := range tt.steps {
+				c.t = c.t.Add(s.advance)
+				if s.fail {
+					banned := g.Fail(s.key)
+					if banned != s.want {
+						t.Errorf("step %d: Fail(%q) = %t, want %t", i, s.key, banned, s.want)
+					}
+					continue
+				}
+				ok, wait := g.Allow(s.key)
+				if ok != s.want || wait != s.wantWait {
+					t.Errorf("step %d: Allow(%q) = %t, %s; want %t, %s", i, s.key, ok, wait, s.want, s.wantWait)
+				}
+			}
+		})
+	}
+}
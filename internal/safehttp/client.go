@@ -0,0 +1,116 @@
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when a request would connect to an address
+// that isn't on the public internet, such as the server's own network or the
+// cloud metadata endpoint.
+var ErrForbiddenAddress = errors.New("address is not publicly routable")
+
+const maxRedirects = 5
+
+// Schemes are the URL schemes requests may be made with.
+var Schemes = map[string]bool{
+	"http":  true,
+	"https": true,
+}
+
+// forbiddenPrefixes are ranges that are unicast but not public, on top of
+// those the netip predicates cover.
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// CheckURL parses a URL given by a client and checks that it can be
+// requested: it must be absolute, with an allowed scheme and a host.
+func CheckURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !Schemes[u.Scheme] {
+		return nil, fmt.Errorf("scheme %q isn't allowed", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("URL has no host")
+	}
+	return u, nil
+}
+
+// PublicAddress reports whether addr is on the public internet.
+func PublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDialAddress returns ErrForbiddenAddress unless the resolved address
+// about to be connected to is public.
+func checkDialAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// NewClient returns a client for URLs given by clients. Unless allowPrivate
+// is set, it refuses to connect to addresses that aren't public. Addresses
+// are checked once they're resolved, right before connecting, so a host name
+// can't resolve to a public address when checked and a private one when
+// used. Redirects are followed to allowed schemes only, and proxies from the
+// environment aren't used, since they'd connect on the client's behalf.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			return checkDialAddress(address)
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if !Schemes[req.URL.Scheme] {
+				return fmt.Errorf("redirect to scheme %q isn't allowed", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRefusesNonPublicAddresses(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+	}{
+		{"loopback", "127.0.0.1"},
+		{"unspecified", "0.0.0.0"},
+		{"private 10/8", "10.1.2.3"},
+		{"private 172.16/12", "172.16.0.1"},
+		{"private 192.168/16", "192.168.1.1"},
+		{"shared address space", "100.64.0.1"},
+		{"link-local", "169.254.1.1"},
+		{"metadata", "169.254.169.254"},
+		{"IPv6 loopback", "::1"},
+		{"IPv6 unique local", "fd00::1"},
+		{"IPv6 metadata", "fd00:ec2::254"},
+		{"IPv6 link-local", "fe80::1"},
+		{"IPv4-mapped loopback", "::ffff:127.0.0.1"},
+		{"IPv4-mapped private", "::ffff:10.0.0.1"},
+		{"IPv4-mapped metadata", "::ffff:169.254.169.254"},
+	}
+
+	client := NewClient(5*time.Second, false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Get("http://" + net.JoinHostPort(tt.host, "80") + "/")
+			if !errors.Is(err, ErrForbiddenAddress) {
+				t.Errorf("GET %s: got %v, want ErrForbiddenAddress", tt.host, err)
+			}
+		})
+	}
+}
+
+func TestCheckDialAddressAllowsPublicAddresses(t *testing.T) {
+	for _, address := range []string{
+		"93.184.216.34:443",
+		"8.8.8.8:80",
+		"[2606:4700:4700::1111]:443",
+		"[::ffff:8.8.8.8]:80",
+	} {
+		err := checkDialAddress(address)
+		if err != nil {
+			t.Errorf("checkDialAddress(%s) = %v, want nil", address, err)
+		}
+	}
+}
+
+func TestClientAllowPrivate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	resp, err := NewClient(5*time.Second, true).Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET with private addresses allowed: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scheduler runs named tasks in the background at fixed intervals. A task
+// never overlaps with itself: the next run is scheduled once the previous one
+// has returned.
+type Scheduler struct {
+	tasks  []task
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+type task struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Every registers run to be called every interval. Tasks with a
+// non-positive interval are disabled. It must be called before Start.
+func (s *Scheduler) Every(name string, interval time.Duration, run func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Printf("Scheduled task %s is disabled", name)
+		return
+	}
+	s.tasks = append(s.tasks, task{name: name, interval: interval, run: run})
+}
+
+// Start launches the registered tasks. They stop when ctx is cancelled or on
+// Shutdown.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go s.loop(ctx, t)
+	}
+}
+
+// Shutdown cancels running tasks and waits for them to return, or for ctx
+// to expire.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, t task) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(t.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		err := t.run(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Scheduled task %s failed after %s: %v", t.name, time.Since(start), err)
+		}
+		timer.Reset(t.interval)
+	}
+}
@@ -0,0 +1,107 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+const (
+	secretsManagerPrefix = "secretsmanager:"
+	parameterStorePrefix = "ssm:"
+)
+
+// Resolver looks up the secrets that configuration values refer to, so they
+// don't have to be kept in the environment. A value of the form
+// "secretsmanager:<secret-id>" is the string value of a Secrets Manager
+// secret, and "secretsmanager:<secret-id>#<key>" is one of the fields of a
+// secret holding JSON, like the password of an RDS-managed secret.
+// "ssm:<name>" is the value of a Parameter Store parameter, decrypted if
+// it's a SecureString. Anything else is used as is.
+type Resolver struct {
+	secretsManager *secretsmanager.Client
+	parameterStore *ssm.Client
+}
+
+// New returns a resolver that looks secrets up with cfg.
+func New(cfg aws.Config) *Resolver {
+	return &Resolver{
+		secretsManager: secretsmanager.NewFromConfig(cfg),
+		parameterStore: ssm.NewFromConfig(cfg),
+	}
+}
+
+// IsReference reports whether value refers to a secret rather than being
+// one.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, secretsManagerPrefix) || strings.HasPrefix(value, parameterStorePrefix)
+}
+
+// Resolve returns the secret value refers to, or value itself if it isn't a
+// reference. Secrets are looked up every time, so a rotated secret is
+// picked up by resolving it again.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if id, ok := strings.CutPrefix(value, secretsManagerPrefix); ok {
+		return r.secretValue(ctx, id)
+	}
+	if name, ok := strings.CutPrefix(value, parameterStorePrefix); ok {
+		return r.parameterValue(ctx, name)
+	}
+	return value, nil
+}
+
+func (r *Resolver) secretValue(ctx context.Context, ref string) (string, error) {
+	id, field, hasField := strings.Cut(ref, "#")
+	if id == "" {
+		return "", fmt.Errorf("invalid secret reference %q", secretsManagerPrefix+ref)
+	}
+
+	out, err := r.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get secret %s: %w", id, err)
+	}
+	value := string(out.SecretBinary)
+	if out.SecretString != nil {
+		value = *out.SecretString
+	}
+	if !hasField {
+		return value, nil
+	}
+
+	fields := map[string]any{}
+	err = json.Unmarshal([]byte(value), &fields)
+	if err != nil {
+		return "", fmt.Errorf("secret %s isn't a JSON object: %w", id, err)
+	}
+	switch v := fields[field].(type) {
+	case string:
+		return v, nil
+	case float64, bool:
+		return fmt.Sprint(v), nil
+	case nil:
+		return "", fmt.Errorf("secret %s has no field %q", id, field)
+	default:
+		return "", fmt.Errorf("field %q of secret %s isn't a string", field, id)
+	}
+}
+
+func (r *Resolver) parameterValue(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("invalid parameter reference %q", parameterStorePrefix)
+	}
+	out, err := r.parameterStore.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get parameter %s: %w", name, err)
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
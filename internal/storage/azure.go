@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// userDelegationKeyLifetime is how long the keys used to sign SAS URLs without
+// an account key are requested for, the most Azure allows.
+const userDelegationKeyLifetime = 7 * 24 * time.Hour
+
+// Azure stores objects as blobs in an Azure Storage container and serves them
+// through a public base URL, either the container's own or a CDN in front of
+// it.
+type Azure struct {
+	client    *azblob.Client
+	container string
+	baseURL   string
+
+	// The user delegation key signs SAS URLs for clients authenticated with
+	// Microsoft Entra ID, e.g. through a managed identity, rather than an
+	// account key. It's reused until it's about to expire.
+	mu                sync.Mutex
+	userDelegation    *service.UserDelegationCredential
+	userDelegationExp time.Time
+}
+
+func NewAzure(client *azblob.Client, container, baseURL string) *Azure {
+	if baseURL == "" {
+		baseURL = client.URL() + container
+	}
+	return &Azure{
+		client:    client,
+		container: container,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Put uploads body as blocks of multipartPartSize, up to
+// multipartConcurrency at a time. The blob only changes once every block is
+// uploaded, and blocks of failed uploads are discarded by Azure.
+func (st *Azure) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	_, err := st.client.UploadStream(ctx, st.container, key, body, &azblob.UploadStreamOptions{
+		BlockSize:   multipartPartSize,
+		Concurrency: multipartConcurrency,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr(contentType)},
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't upload blob: %w", err)
+	}
+	return nil
+}
+
+func (st *Azure) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := st.client.DownloadStream(ctx, st.container, key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (st *Azure) Delete(ctx context.Context, key string) error {
+	_, err := st.client.DeleteBlob(ctx, st.container, key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (st *Azure) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := st.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		err := st.Delete(ctx, object.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't delete %s: %w", object.Key, err)
+		}
+	}
+	return nil
+}
+
+func (st *Azure) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	pager := st.client.NewListBlobsFlatPager(st.container, &azblob.ListBlobsFlatOptions{
+		Prefix: to.Ptr(prefix),
+	})
+
+	var objects []ObjectInfo
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			objects = append(objects, ObjectInfo{
+				Key:          *item.Name,
+				Size:         *item.Properties.ContentLength,
+				LastModified: *item.Properties.LastModified,
+			})
+		}
+	}
+	return objects, nil
+}
+
+// PresignGet returns a read-only SAS URL for the blob. Clients created from a
+// connection string sign it with the account key; others sign it with a user
+// delegation key, which needs the Storage Blob Delegator role.
+func (st *Azure) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	blobClient := st.client.ServiceClient().NewContainerClient(st.container).NewBlobClient(key)
+	expiresAt := time.Now().Add(expiry)
+
+	signedURL, err := blobClient.GetSASURL(sas.BlobPermissions{Read: true}, expiresAt, nil)
+	if !errors.Is(err, bloberror.MissingSharedKeyCredential) {
+		return signedURL, err
+	}
+
+	credential, err := st.userDelegationCredential(ctx, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get user delegation key: %w", err)
+	}
+	params, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		ExpiryTime:    expiresAt,
+		Permissions:   to.Ptr(sas.BlobPermissions{Read: true}).String(),
+		ContainerName: st.container,
+		BlobName:      key,
+	}.SignWithUserDelegation(credential)
+	if err != nil {
+		return "", err
+	}
+	return blobClient.URL() + "?" + params.Encode(), nil
+}
+
+// userDelegationCredential returns a user delegation key valid until at least
+// until, requesting a new one when the current key expires too soon.
+func (st *Azure) userDelegationCredential(ctx context.Context, until time.Time) (*service.UserDelegationCredential, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.userDelegation != nil && st.userDelegationExp.After(until) {
+		return st.userDelegation, nil
+	}
+
+	// The start is backdated to allow for clock skew.
+	now := time.Now().UTC()
+	start := now.Add(-5 * time.Minute)
+	exp := now.Add(userDelegationKeyLifetime)
+	credential, err := st.client.ServiceClient().GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.Ptr(start.Format(sas.TimeFormat)),
+		Expiry: to.Ptr(exp.Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	st.userDelegation = credential
+	st.userDelegationExp = exp
+	return credential, nil
+}
+
+func (st *Azure) Bucket() string {
+	return st.container
+}
+
+func (st *Azure) URL(key string) string {
+	return st.baseURL + "/" + key
+}
+
+func (st *Azure) KeyFromURL(url string) (string, bool) {
+	return strings.CutPrefix(url, st.baseURL+"/")
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+)
+
+// probePrefix is where Probe stores its objects, apart from any asset.
+const probePrefix = "startup-probe/"
+
+// Probe checks that st accepts writes and deletes by storing a small object
+// and deleting it again.
+func Probe(ctx context.Context, st Storage) error {
+	key := probePrefix + uuid.NewString()
+	err := st.Put(ctx, key, strings.NewReader("probe"), "text/plain")
+	if err != nil {
+		return fmt.Errorf("couldn't store probe object: %w", err)
+	}
+	err = st.Delete(ctx, key)
+	if err != nil {
+		return fmt.Errorf("couldn't delete probe object %s: %w", key, err)
+	}
+	return nil
+}
+
+// CheckBucket checks that the bucket exists and the credentials can access
+// it.
+func (st *S3) CheckBucket(ctx context.Context) error {
+	_, err := st.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(st.bucket),
+	})
+	if err == nil {
+		return nil
+	}
+
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return fmt.Errorf("bucket %s doesn't exist: %w", st.bucket, err)
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusForbidden:
+			return fmt.Errorf("access to bucket %s is denied, the credentials need s3:ListBucket on it: %w", st.bucket, err)
+		case http.StatusMovedPermanently:
+			return fmt.Errorf("bucket %s is in another region than S3_REGION: %w", st.bucket, err)
+		}
+	}
+	return fmt.Errorf("couldn't access bucket %s: %w", st.bucket, err)
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Encryption is the server-side encryption S3 applies to the objects it
+// stores. The zero value leaves it to the bucket's default encryption.
+type Encryption struct {
+	Algorithm types.ServerSideEncryption
+	KMSKeyID  string
+}
+
+// ParseEncryption reads an encryption mode of "", "sse-s3" or "sse-kms". A
+// KMS key ID or ARN only applies to sse-kms; without one, S3 uses the
+// account's AWS managed key.
+func ParseEncryption(mode, kmsKeyID string) (Encryption, error) {
+	switch mode {
+	case "":
+		if kmsKeyID != "" {
+			return Encryption{}, fmt.Errorf("a KMS key requires sse-kms encryption")
+		}
+		return Encryption{}, nil
+	case "sse-s3":
+		if kmsKeyID != "" {
+			return Encryption{}, fmt.Errorf("a KMS key requires sse-kms encryption")
+		}
+		return Encryption{Algorithm: types.ServerSideEncryptionAes256}, nil
+	case "sse-kms":
+		return Encryption{Algorithm: types.ServerSideEncryptionAwsKms, KMSKeyID: kmsKeyID}, nil
+	default:
+		return Encryption{}, fmt.Errorf("unknown encryption mode %q", mode)
+	}
+}
+
+// KeyID returns the KMS key to set on a request, or nil to leave it unset.
+func (e Encryption) KeyID() *string {
+	if e.KMSKeyID == "" {
+		return nil
+	}
+	return aws.String(e.KMSKeyID)
+}
+
+// Headers returns the headers a request presigned with this encryption has
+// to be sent with, since they're part of the signature.
+func (e Encryption) Headers() []string {
+	var headers []string
+	if e.Algorithm != "" {
+		headers = append(headers, "x-amz-server-side-encryption: "+string(e.Algorithm))
+	}
+	if e.KMSKeyID != "" {
+		headers = append(headers, "x-amz-server-side-encryption-aws-kms-key-id: "+e.KMSKeyID)
+	}
+	return headers
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCS stores objects in a Google Cloud Storage bucket and serves them through
+// a public base URL, either the bucket's own or a CDN in front of it.
+type GCS struct {
+	client  *gcs.Client
+	bucket  string
+	baseURL string
+}
+
+func NewGCS(client *gcs.Client, bucket, baseURL string) *GCS {
+	if baseURL == "" {
+		baseURL = "https://storage.googleapis.com/" + bucket
+	}
+	return &GCS{
+		client:  client,
+		bucket:  bucket,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (st *GCS) object(key string) *gcs.ObjectHandle {
+	return st.client.Bucket(st.bucket).Object(key)
+}
+
+// Put streams body to GCS as a resumable upload sent in multipartPartSize
+// chunks. Chunks that fail transiently are retried, and a failed upload is
+// cancelled so no partial object is created.
+func (st *GCS) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Uploads replace the object, so retrying one can't do any harm even
+	// though GCS doesn't consider it idempotent.
+	w := st.object(key).Retryer(gcs.WithPolicy(gcs.RetryAlways)).NewWriter(ctx)
+	w.ContentType = contentType
+	w.ChunkSize = multipartPartSize
+
+	_, err := io.Copy(w, body)
+	if err != nil {
+		cancel()
+		w.Close()
+		return fmt.Errorf("couldn't upload object: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't upload object: %w", err)
+	}
+	return nil
+}
+
+func (st *GCS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := st.object(key).NewReader(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (st *GCS) Delete(ctx context.Context, key string) error {
+	err := st.object(key).Delete(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (st *GCS) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := st.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		err := st.Delete(ctx, object.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't delete %s: %w", object.Key, err)
+		}
+	}
+	return nil
+}
+
+func (st *GCS) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	it := st.client.Bucket(st.bucket).Objects(ctx, &gcs.Query{Prefix: prefix})
+
+	var objects []ObjectInfo
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          attrs.Name,
+			Size:         attrs.Size,
+			LastModified: attrs.Updated,
+		})
+	}
+}
+
+// PresignGet returns a V4 signed URL. Without a service account key, the
+// client signs through the IAM credentials API, which needs the
+// iam.serviceAccounts.signBlob permission.
+func (st *GCS) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return st.client.Bucket(st.bucket).SignedURL(key, &gcs.SignedURLOptions{
+		Scheme:  gcs.SigningSchemeV4,
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(expiry),
+	})
+}
+
+func (st *GCS) Bucket() string {
+	return st.bucket
+}
+
+func (st *GCS) URL(key string) string {
+	return st.baseURL + "/" + key
+}
+
+func (st *GCS) KeyFromURL(url string) (string, bool) {
+	return strings.CutPrefix(url, st.baseURL+"/")
+}
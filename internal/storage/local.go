@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidKey is returned for keys that aren't clean relative paths,
+	// which could otherwise point outside the root directory.
+	ErrInvalidKey = errors.New("invalid object key")
+	// ErrInvalidSignature is returned for signed URLs that are missing their
+	// signature, were tampered with or have expired.
+	ErrInvalidSignature = errors.New("invalid or expired signature")
+)
+
+// Local stores objects as files below a root directory that is served over
+// HTTP at baseURL. With a signing key set, its presigned URLs carry an
+// expiring HMAC signature for the server to check.
+type Local struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+}
+
+func NewLocal(root, baseURL string) *Local {
+	return &Local{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// SetSigningKey makes PresignGet sign the URLs it returns with key, so they
+// can be checked with CheckSignature.
+func (l *Local) SetSigningKey(key []byte) {
+	l.signingKey = key
+}
+
+func (l *Local) path(key string) (string, error) {
+	return LocalPath(l.root, key)
+}
+
+// LocalPath returns where key is stored below root. Keys must be clean
+// slash-separated relative paths, so that no key, however it was crafted,
+// resolves to a file outside root.
+func LocalPath(root, key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, "\\\x00") || path.IsAbs(key) || path.Clean(key) != key {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+
+	diskPath := filepath.Join(root, filepath.FromSlash(key))
+	rel, err := filepath.Rel(root, diskPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return diskPath, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	diskPath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(diskPath), 0755)
+	if err != nil {
+		return fmt.Errorf("couldn't create directory for %s: %w", key, err)
+	}
+
+	file, err := os.Create(diskPath)
+	if err != nil {
+		return fmt.Errorf("couldn't create %s: %w", key, err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, body)
+	if err != nil {
+		os.Remove(diskPath)
+		return fmt.Errorf("couldn't write %s: %w", key, err)
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	diskPath, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(diskPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	diskPath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(diskPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) DeletePrefix(ctx context.Context, prefix string) error {
+	// Prefixes name directories, which may be given with a trailing slash.
+	// An empty prefix would remove the root itself, so it's refused too.
+	diskPath, err := l.path(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(diskPath)
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(l.root, func(diskPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(l.root, diskPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list objects under %s: %w", prefix, err)
+	}
+	return objects, nil
+}
+
+// PresignGet returns the plain public URL, since local assets aren't access
+// controlled, unless a signing key is set. Then the URL carries its expiry
+// and a signature over the key and expiry.
+func (l *Local) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if l.signingKey == nil {
+		return l.URL(key), nil
+	}
+	_, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {l.sign(key, expires)},
+	}
+	return l.URL(key) + "?" + query.Encode(), nil
+}
+
+// CheckSignature checks that query holds a valid signature for key that
+// hasn't expired by now.
+func (l *Local) CheckSignature(key string, query url.Values, now time.Time) error {
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > unix {
+		return ErrInvalidSignature
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(query.Get("signature"))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(l.sign(key, expires))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (l *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) Bucket() string {
+	return ""
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// KeyFromURL ignores the query of signed URLs, and reports false for URLs
+// whose key isn't valid, such as ones climbing out of the root with "..".
+func (l *Local) KeyFromURL(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, l.baseURL+"/")
+	if !ok {
+		return "", false
+	}
+	key, _, _ = strings.Cut(key, "?")
+	_, err := l.path(key)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
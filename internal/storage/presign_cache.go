@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+const presignCacheSweepInterval = time.Minute
+
+type presignCacheKey struct {
+	key    string
+	expiry time.Duration
+}
+
+type presignCacheEntry struct {
+	url       string
+	expiresAt time.Time
+}
+
+// PresignCache wraps a Storage and reuses presigned URLs until they are
+// within refreshMargin of expiring. This saves signing work and keeps URLs
+// stable, so clients can cache the objects they point at.
+type PresignCache struct {
+	Storage
+	refreshMargin time.Duration
+
+	mu        sync.Mutex
+	entries   map[presignCacheKey]presignCacheEntry
+	lastSweep time.Time
+}
+
+func NewPresignCache(backend Storage, refreshMargin time.Duration) *PresignCache {
+	return &PresignCache{
+		Storage:       backend,
+		refreshMargin: refreshMargin,
+		entries:       map[presignCacheKey]presignCacheEntry{},
+		lastSweep:     time.Now(),
+	}
+}
+
+func (c *PresignCache) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	cacheKey := presignCacheKey{key: key, expiry: expiry}
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[cacheKey]
+	c.mu.Unlock()
+	if ok && entry.expiresAt.Sub(now) > c.refreshMargin {
+		metrics.PresignsTotal.WithLabelValues("cache").Inc()
+		return entry.url, nil
+	}
+
+	url, err := c.Storage.PresignGet(ctx, key, expiry)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey] = presignCacheEntry{url: url, expiresAt: now.Add(expiry)}
+	if now.Sub(c.lastSweep) > presignCacheSweepInterval {
+		c.sweep(now)
+	}
+	return url, nil
+}
+
+func (c *PresignCache) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	c.invalidate(key)
+	return c.Storage.Put(ctx, key, body, contentType)
+}
+
+func (c *PresignCache) Delete(ctx context.Context, key string) error {
+	c.invalidate(key)
+	return c.Storage.Delete(ctx, key)
+}
+
+func (c *PresignCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cacheKey := range c.entries {
+		if cacheKey.key == key {
+			delete(c.entries, cacheKey)
+		}
+	}
+}
+
+// sweep drops entries that can no longer be served. c.mu must be held.
+func (c *PresignCache) sweep(now time.Time) {
+	for cacheKey, entry := range c.entries {
+		if entry.expiresAt.Sub(now) <= c.refreshMargin {
+			delete(c.entries, cacheKey)
+		}
+	}
+	c.lastSweep = now
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+const (
+	defaultPutAttempts = 3
+	putRetryBaseDelay  = time.Second
+)
+
+// NewRetryer returns a retryer for AWS clients that makes up to maxAttempts
+// attempts per request, with jittered exponential backoff capped at
+// maxBackoff. The SDK's client-side retry quota is disabled so that a burst of
+// failures during one large upload doesn't stop every other request from
+// retrying. Retries are counted in metrics.AWSRetriesTotal.
+func NewRetryer(maxAttempts int, maxBackoff time.Duration) aws.Retryer {
+	return countingRetryer{retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxAttempts
+		o.MaxBackoff = maxBackoff
+		o.Backoff = retry.NewExponentialJitterBackoff(maxBackoff)
+		o.RateLimiter = ratelimit.None
+	})}
+}
+
+type countingRetryer struct {
+	aws.RetryerV2
+}
+
+// RetryDelay is called once before every retry.
+func (r countingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	metrics.AWSRetriesTotal.Inc()
+	return r.RetryerV2.RetryDelay(attempt, err)
+}
+
+// isTransient reports whether err is one the SDK would retry, such as a 503
+// or a reset connection.
+func isTransient(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// retryPut runs op, one request of an upload, again while it fails with a
+// transient error, up to st.putAttempts times in all. Each request already
+// gets the SDK's retries; these add a longer backoff so an outage of a few
+// seconds doesn't fail the whole upload.
+func (st *S3) retryPut(ctx context.Context, operation string, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= st.putAttempts || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		metrics.S3PutRetriesTotal.WithLabelValues(operation).Inc()
+
+		delay := putRetryBaseDelay << (attempt - 1)
+		delay += rand.N(delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+const (
+	multipartPartSize    = 16 << 20
+	multipartConcurrency = 4
+)
+
+// S3 stores objects in a bucket and serves them through a public base URL,
+// typically a CloudFront distribution in front of the bucket.
+type S3 struct {
+	client        *s3.Client
+	presignClient *s3.PresignClient
+	bucket        string
+	baseURL       string
+	putAttempts   int
+	encryption    Encryption
+	storageClass  types.StorageClass
+}
+
+func NewS3(client *s3.Client, bucket, baseURL string) *S3 {
+	return &S3{
+		client:        client,
+		presignClient: s3.NewPresignClient(client),
+		bucket:        bucket,
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		putAttempts:   defaultPutAttempts,
+	}
+}
+
+// BucketURL returns the URL objects in bucket can be read from directly,
+// for when there's no CDN in front of it. endpoint is the URL of an
+// S3-compatible service, or empty for AWS.
+func BucketURL(endpoint, bucket, region string, pathStyle bool) (string, error) {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%q isn't an absolute URL", endpoint)
+	}
+
+	if pathStyle {
+		u.Path = path.Join("/", u.Path, bucket)
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// SetPutAttempts sets how many times each request of an upload is made
+// before Put gives up on a transient failure.
+func (st *S3) SetPutAttempts(attempts int) {
+	st.putAttempts = max(attempts, 1)
+}
+
+// SetEncryption sets the server-side encryption of the objects Put stores.
+// Presigned GETs need nothing extra: they're signed with SigV4, which SSE-KMS
+// requires, and S3 decrypts for anyone whose credentials may use the key.
+func (st *S3) SetEncryption(encryption Encryption) {
+	st.encryption = encryption
+}
+
+// Put streams body to S3 in fixed-size parts, uploading up to
+// multipartConcurrency parts in parallel. Requests that fail transiently are
+// retried, and on failure the upload is aborted so no orphaned parts are left
+// behind in the bucket.
+func (st *S3) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	start := time.Now()
+	err := st.put(ctx, key, body, contentType)
+	metrics.S3PutDurationSeconds.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.S3PutErrorsTotal.Inc()
+	}
+	return err
+}
+
+func (st *S3) put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	var created *s3.CreateMultipartUploadOutput
+	err := st.retryPut(ctx, "create_multipart_upload", func() (err error) {
+		created, err = st.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(st.bucket),
+			Key:         aws.String(key),
+			ContentType: aws.String(contentType),
+			// Parts are encrypted with the settings of the upload.
+			ServerSideEncryption: st.encryption.Algorithm,
+			SSEKMSKeyId:          st.encryption.KeyID(),
+			StorageClass:         st.storageClass,
+			Tagging:              aws.String(ObjectTags(ctx, contentType).Encode()),
+			// Per-part SHA-256 checksums let S3 reject any part corrupted in transit.
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't create multipart upload: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		parts     []types.CompletedPart
+		uploadErr error
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if uploadErr == nil {
+			uploadErr = err
+			cancel()
+		}
+	}
+
+	sem := make(chan struct{}, multipartConcurrency)
+	for partNumber := int32(1); ; partNumber++ {
+		sem <- struct{}{}
+
+		mu.Lock()
+		failed := uploadErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		buf := make([]byte, multipartPartSize)
+		n, readErr := io.ReadFull(body, buf)
+		if readErr != nil && readErr != io.EOF && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			<-sem
+			setErr(fmt.Errorf("couldn't read part %d: %w", partNumber, readErr))
+			break
+		}
+		// S3 requires at least one part, even for an empty body.
+		if n == 0 && partNumber > 1 {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int32, data []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var out *s3.UploadPartOutput
+			err := st.retryPut(ctx, "upload_part", func() (err error) {
+				out, err = st.client.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:            aws.String(st.bucket),
+					Key:               aws.String(key),
+					UploadId:          created.UploadId,
+					PartNumber:        aws.Int32(partNumber),
+					Body:              bytes.NewReader(data),
+					ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+				})
+				return err
+			})
+			if err != nil {
+				setErr(fmt.Errorf("couldn't upload part %d: %w", partNumber, err))
+				return
+			}
+
+			mu.Lock()
+			parts = append(parts, types.CompletedPart{
+				ETag:           out.ETag,
+				PartNumber:     aws.Int32(partNumber),
+				ChecksumSHA256: out.ChecksumSHA256,
+			})
+			mu.Unlock()
+		}(partNumber, buf[:n])
+
+		if readErr != nil {
+			break
+		}
+	}
+	wg.Wait()
+
+	if uploadErr != nil {
+		return st.abortMultipart(key, created.UploadId, uploadErr)
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return *parts[i].PartNumber < *parts[j].PartNumber
+	})
+
+	err = st.retryPut(ctx, "complete_multipart_upload", func() error {
+		_, err := st.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(st.bucket),
+			Key:             aws.String(key),
+			UploadId:        created.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+		return err
+	})
+	if err != nil {
+		return st.abortMultipart(key, created.UploadId, fmt.Errorf("couldn't complete multipart upload: %w", err))
+	}
+	return nil
+}
+
+// abortMultipart aborts a multipart upload that failed with err, so its
+// parts aren't left behind and billed. It runs even if ctx was cancelled.
+func (st *S3) abortMultipart(key string, uploadID *string, err error) error {
+	_, abortErr := st.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(st.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if abortErr != nil {
+		return errors.Join(err, fmt.Errorf("couldn't abort multipart upload: %w", abortErr))
+	}
+	return err
+}
+
+func (st *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := st.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (st *S3) Delete(ctx context.Context, key string) error {
+	_, err := st.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// Copy copies the object at src to dst within the bucket, keeping its
+// content type, metadata and tags. The copy gets the configured storage
+// class and encryption. S3 copies objects of up to 5 GB in one request.
+func (st *S3) Copy(ctx context.Context, src, dst string) error {
+	_, err := st.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:               aws.String(st.bucket),
+		Key:                  aws.String(dst),
+		CopySource:           aws.String(st.copySource(src)),
+		MetadataDirective:    types.MetadataDirectiveCopy,
+		TaggingDirective:     types.TaggingDirectiveCopy,
+		StorageClass:         st.storageClass,
+		ServerSideEncryption: st.encryption.Algorithm,
+		SSEKMSKeyId:          st.encryption.KeyID(),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't copy %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+// copySource returns the URL-encoded bucket and key of an object, as
+// CopyObject expects them.
+func (st *S3) copySource(key string) string {
+	segments := strings.Split(path.Join(st.bucket, key), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (st *S3) DeletePrefix(ctx context.Context, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(st.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(st.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't list objects under %s: %w", prefix, err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+		out, err := st.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(st.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't delete objects under %s: %w", prefix, err)
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("couldn't delete %s: %s", aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
+		}
+	}
+	return nil
+}
+
+func (st *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	paginator := s3.NewListObjectsV2Paginator(st.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(st.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list objects under %s: %w", prefix, err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (st *S3) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	req, err := st.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	metrics.PresignsTotal.WithLabelValues("s3").Inc()
+	return req.URL, nil
+}
+
+// PresignDownload is like PresignGet, but responses to the URL tell
+// browsers to save the object as filename instead of displaying it.
+func (st *S3) PresignDownload(ctx context.Context, key, filename string, expiry time.Duration) (string, error) {
+	req, err := st.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(st.bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": filename})),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	metrics.PresignsTotal.WithLabelValues("s3").Inc()
+	return req.URL, nil
+}
+
+func (st *S3) Bucket() string {
+	return st.bucket
+}
+
+func (st *S3) URL(key string) string {
+	return st.baseURL + "/" + key
+}
+
+func (st *S3) KeyFromURL(url string) (string, bool) {
+	return strings.CutPrefix(url, st.baseURL+"/")
+}
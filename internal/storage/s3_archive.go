@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ArchiveStorageClasses are the storage classes objects can be archived to.
+// Objects in them have to be restored before they can be read.
+var ArchiveStorageClasses = []types.StorageClass{
+	types.StorageClassGlacier,
+	types.StorageClassDeepArchive,
+}
+
+// ParseStorageClass checks that class is one S3 knows.
+func ParseStorageClass(class string) (types.StorageClass, error) {
+	for _, known := range types.StorageClass("").Values() {
+		if string(known) == class {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown storage class %q", class)
+}
+
+// ParseRestoreTier checks that tier is one S3 restores objects at.
+func ParseRestoreTier(tier string) (types.Tier, error) {
+	for _, known := range types.Tier("").Values() {
+		if string(known) == tier {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown restore tier %q", tier)
+}
+
+// ArchiveState describes an object's storage class and any restore of it.
+type ArchiveState struct {
+	StorageClass types.StorageClass
+	// Restoring is set while a restore is in progress.
+	Restoring bool
+	// RestoredUntil is when the restored copy of an archived object is
+	// removed again, or nil if there's none.
+	RestoredUntil *time.Time
+}
+
+// SetStorageClass sets the storage class of the objects Put stores, or the
+// bucket's default if it's empty.
+func (st *S3) SetStorageClass(class types.StorageClass) {
+	st.storageClass = class
+}
+
+// Archive moves an object to the given storage class by copying it onto
+// itself. S3 copies objects of up to 5 GB in one request.
+func (st *S3) Archive(ctx context.Context, key string, class types.StorageClass) error {
+	_, err := st.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(st.bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(st.copySource(key)),
+		StorageClass:      class,
+		MetadataDirective: types.MetadataDirectiveCopy,
+		// Copies don't keep the source's encryption.
+		ServerSideEncryption: st.encryption.Algorithm,
+		SSEKMSKeyId:          st.encryption.KeyID(),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't archive %s: %w", key, err)
+	}
+	return nil
+}
+
+// Restore makes a readable copy of an archived object available for days,
+// retrieved at the given tier. Restoring an object that's already being
+// restored isn't an error, and restoring one that's already restored extends
+// how long its copy is kept.
+func (st *S3) Restore(ctx context.Context, key string, days int, tier types.Tier) error {
+	_, err := st.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(int32(days)),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: tier},
+		},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't restore %s: %w", key, err)
+	}
+	return nil
+}
+
+// restoreHeader matches the x-amz-restore header, e.g.
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT".
+var restoreHeader = regexp.MustCompile(`ongoing-request="(true|false)"(?:, expiry-date="([^"]+)")?`)
+
+// ArchiveState returns the storage class of an object and the state of any
+// restore of it.
+func (st *S3) ArchiveState(ctx context.Context, key string) (ArchiveState, error) {
+	out, err := st.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return ArchiveState{}, ErrNotFound
+		}
+		return ArchiveState{}, err
+	}
+
+	// S3 leaves the storage class out for STANDARD objects.
+	state := ArchiveState{StorageClass: out.StorageClass}
+	if state.StorageClass == "" {
+		state.StorageClass = types.StorageClassStandard
+	}
+	if out.Restore == nil {
+		return state, nil
+	}
+
+	match := restoreHeader.FindStringSubmatch(*out.Restore)
+	if match == nil {
+		return ArchiveState{}, fmt.Errorf("couldn't parse restore status %q", *out.Restore)
+	}
+	state.Restoring = match[1] == "true"
+	if match[2] != "" {
+		expiry, err := http.ParseTime(match[2])
+		if err != nil {
+			return ArchiveState{}, fmt.Errorf("couldn't parse restore expiry: %w", err)
+		}
+		state.RestoredUntil = &expiry
+	}
+	return state, nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Storage is a backend that stores assets by key.
+type Storage interface {
+	// Put stores body under key, replacing any existing object.
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get opens the object stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting a missing object
+	// is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every object whose key starts with prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PresignGet returns a URL granting temporary read access to key.
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// Bucket names the bucket objects are stored in, or is empty for
+	// backends without buckets.
+	Bucket() string
+	// URL returns the permanent public URL for key.
+	URL(key string) string
+	// KeyFromURL is the inverse of URL. It reports false if url doesn't
+	// belong to this backend.
+	KeyFromURL(url string) (string, bool)
+}
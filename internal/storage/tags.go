@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Tags are key-value pairs attached to stored objects, which S3 lifecycle
+// rules, cost allocation reports and IAM policies can select objects by.
+type Tags map[string]string
+
+type tagsKey struct{}
+
+// WithTags returns a context whose objects Put stores are tagged with tags,
+// along with their content type. Backends that don't support tags ignore
+// them.
+func WithTags(ctx context.Context, tags Tags) context.Context {
+	return context.WithValue(ctx, tagsKey{}, tags)
+}
+
+// ObjectTags returns the tags of ctx for an object with the given content
+// type.
+func ObjectTags(ctx context.Context, contentType string) Tags {
+	tags := Tags{}
+	if ctxTags, ok := ctx.Value(tagsKey{}).(Tags); ok {
+		for key, value := range ctxTags {
+			tags[key] = value
+		}
+	}
+	// Tag values can't contain the ; of media type parameters.
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	if mediaType = strings.TrimSpace(mediaType); mediaType != "" {
+		tags["content_type"] = mediaType
+	}
+	return tags
+}
+
+// Encode returns the tags in the query string format of the x-amz-tagging
+// header.
+func (t Tags) Encode() string {
+	values := url.Values{}
+	for key, value := range t {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// AddObjectTags tags an existing object with those of tags and its content
+// type that it doesn't have yet, keeping the values of the tags it already
+// has. It reports whether any tags were added.
+func (st *S3) AddObjectTags(ctx context.Context, key string, tags Tags) (bool, error) {
+	head, err := st.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, fmt.Errorf("couldn't get %s: %w", key, err)
+	}
+	current, err := st.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, fmt.Errorf("couldn't get tags of %s: %w", key, err)
+	}
+
+	existing := map[string]bool{}
+	for _, tag := range current.TagSet {
+		existing[aws.ToString(tag.Key)] = true
+	}
+	tagSet := current.TagSet
+	for key, value := range ObjectTags(WithTags(ctx, tags), aws.ToString(head.ContentType)) {
+		if !existing[key] {
+			tagSet = append(tagSet, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+	}
+	if len(tagSet) == len(current.TagSet) {
+		return false, nil
+	}
+
+	_, err = st.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(st.bucket),
+		Key:     aws.String(key),
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		return false, fmt.Errorf("couldn't tag %s: %w", key, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,105 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultOpenAIEndpoint = "https://api.openai.com/v1/audio/transcriptions"
+
+// maxErrorBody bounds how much of a failed response ends up in the error.
+const maxErrorBody = 4 << 10
+
+// OpenAI calls an OpenAI-compatible transcription API.
+type OpenAI struct {
+	endpoint string
+	apiKey   string
+	model    string
+	client   *http.Client
+}
+
+// NewOpenAI returns a backend that posts audio to endpoint, which must accept
+// the multipart form of OpenAI's /v1/audio/transcriptions.
+func NewOpenAI(endpoint, apiKey, model string) *OpenAI {
+	return &OpenAI{
+		endpoint: endpoint,
+		apiKey:   apiKey,
+		model:    model,
+		client:   &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+func (o *OpenAI) Transcribe(ctx context.Context, audioPath, language string) ([]byte, error) {
+	audio, err := os.Open(audioPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open audio: %w", err)
+	}
+	defer audio.Close()
+
+	// The form is streamed through a pipe so long recordings aren't held in
+	// memory.
+	body, form := io.Pipe()
+	writer := multipart.NewWriter(form)
+	go func() {
+		form.CloseWithError(writeTranscriptionForm(writer, audio, filepath.Base(audioPath), o.model, language))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("couldn't create transcription request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't reach transcription API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, fmt.Errorf("transcription API returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	vtt, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read transcription: %w", err)
+	}
+	if !strings.Contains(string(vtt), "-->") {
+		return nil, ErrNoSpeech
+	}
+	return vtt, nil
+}
+
+func writeTranscriptionForm(writer *multipart.Writer, audio io.Reader, filename, model, language string) error {
+	fields := [][2]string{
+		{"model", model},
+		{"language", language},
+		{"response_format", "vtt"},
+	}
+	for _, field := range fields {
+		err := writer.WriteField(field[0], field[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, audio)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
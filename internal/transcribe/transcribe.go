@@ -0,0 +1,16 @@
+package transcribe
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoSpeech is returned when a backend produced no cues, for example
+// because the audio has no speech.
+var ErrNoSpeech = errors.New("no speech found")
+
+// Transcriber turns an audio file into a WebVTT caption track. language is a
+// language tag such as en, used as a hint for recognition.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioPath, language string) ([]byte, error)
+}
@@ -0,0 +1,65 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Whisper runs a local whisper.cpp binary.
+type Whisper struct {
+	binary string
+	model  string
+	run    func(ctx context.Context, cmd *exec.Cmd) error
+}
+
+// NewWhisper returns a backend that invokes binary with the ggml model file at
+// modelPath. run executes the command, so the caller can bound how many
+// CPU-heavy processes run at once; nil runs it directly.
+func NewWhisper(binary, modelPath string, run func(ctx context.Context, cmd *exec.Cmd) error) *Whisper {
+	if run == nil {
+		run = func(ctx context.Context, cmd *exec.Cmd) error { return cmd.Run() }
+	}
+	return &Whisper{binary: binary, model: modelPath, run: run}
+}
+
+func (w *Whisper) Transcribe(ctx context.Context, audioPath, language string) ([]byte, error) {
+	outDir, err := os.MkdirTemp("", "tubely-transcript")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create temp dir: %w", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	// whisper.cpp appends .vtt to the output prefix.
+	outPrefix := filepath.Join(outDir, "captions")
+	cmd := exec.CommandContext(
+		ctx,
+		w.binary,
+		"-m", w.model,
+		"-f", audioPath,
+		"-l", language,
+		"-ovtt",
+		"-of", outPrefix,
+		"-np",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = w.run(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error running whisper: %s, %v", stderr.String(), err)
+	}
+
+	vtt, err := os.ReadFile(outPrefix + ".vtt")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read whisper output: %w", err)
+	}
+	if !strings.Contains(string(vtt), "-->") {
+		return nil, ErrNoSpeech
+	}
+	return vtt, nil
+}
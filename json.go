@@ -2,25 +2,70 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 )
 
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Code      errorCode `json:"code"`
+	Message   string    `json:"message"`
+	Details   any       `json:"details,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Error repeats Message for clients written before codes existed.
+	Error string `json:"error"`
+}
+
+// respondWithError responds with an error that has the code of its status.
+// err is logged if it's not nil.
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	respondWithErrorCode(w, code, statusErrorCode(code), msg, nil, err)
+}
+
+// respondWithErrorCode responds with an error clients can tell apart from
+// others with the same status by its code, along with details about it, if
+// any.
+func respondWithErrorCode(w http.ResponseWriter, status int, code errorCode, msg string, details any, err error) {
+	requestID := w.Header().Get(requestIDHeader)
 	if err != nil {
-		log.Println(err)
-	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		log.Printf("Request %s: %v", requestID, err)
 	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	if status > 499 {
+		log.Printf("Request %s: responding with 5XX error: %s", requestID, msg)
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+	respondWithJSON(w, status, errorResponse{
+		Code:      code,
+		Message:   msg,
+		Details:   details,
+		RequestID: requestID,
+		Error:     msg,
 	})
 }
 
+// respondWithTooLarge reports a request body that exceeded limit bytes with
+// code, if err was caused by http.MaxBytesReader. It returns false for other
+// errors.
+func respondWithTooLarge(w http.ResponseWriter, err error, code errorCode) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	respondWithSizeLimit(w, maxBytesErr.Limit, code)
+	return true
+}
+
+func respondWithSizeLimit(w http.ResponseWriter, limit int64, code errorCode) {
+	type sizeLimitDetails struct {
+		LimitBytes int64 `json:"limit_bytes"`
+	}
+	respondWithErrorCode(w, http.StatusRequestEntityTooLarge, code,
+		fmt.Sprintf("File exceeds the maximum size of %d bytes", limit),
+		sizeLimitDetails{LimitBytes: limit}, nil)
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
@@ -36,3 +81,28 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 		return
 	}
 }
+
+// nullableTime is an optional timestamp in a request body that tells an
+// absent field apart from an explicit null, which clears the value.
+//
+//openapi:schema string,null date-time
+type nullableTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (n *nullableTime) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+	var t time.Time
+	err := json.Unmarshal(data, &t)
+	if err != nil {
+		return err
+	}
+	t = t.UTC()
+	n.Value = &t
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// keyMigration copies the objects of one video stored in the legacy key
+// layout to the current one.
+type keyMigration struct {
+	cfg     *apiConfig
+	video   database.Video
+	current *regexp.Regexp
+	// copied maps the old keys of the objects copied so far to their new
+	// ones.
+	copied map[string]string
+	// cleanup deletes the old objects once nothing references them anymore.
+	cleanup []func(ctx context.Context) error
+}
+
+// moveKey copies the object at key to the current layout, unless it's
+// already in it, and returns its new key.
+func (m *keyMigration) moveKey(ctx context.Context, key string) (string, error) {
+	if m.current.MatchString(key) {
+		return key, nil
+	}
+	if newKey, ok := m.copied[key]; ok {
+		return newKey, nil
+	}
+	newKey := m.cfg.migratedObjectKey(m.video.UserID, key)
+	err := m.cfg.s3Objects.Copy(ctx, key, newKey)
+	if err != nil {
+		return "", err
+	}
+	m.copied[key] = newKey
+	return newKey, nil
+}
+
+// moveURL moves the object a URL field points at and returns the field's new
+// value. URLs outside the bucket are left alone.
+func (m *keyMigration) moveURL(ctx context.Context, url *string) (*string, string, error) {
+	if url == nil {
+		return nil, "", nil
+	}
+	key, ok := m.cfg.videoStorage.KeyFromURL(*url)
+	if !ok {
+		return url, "", nil
+	}
+	newKey, err := m.moveKey(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	newURL := m.cfg.videoStorage.URL(newKey)
+	return &newURL, key, nil
+}
+
+// movePrefix moves every object under prefix, for assets like HLS playlists
+// that refer to each other by relative paths.
+func (m *keyMigration) movePrefix(ctx context.Context, prefix string) error {
+	if m.current.MatchString(prefix) {
+		return nil
+	}
+	objects, err := m.cfg.videoStorage.List(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("couldn't list objects under %s: %w", prefix, err)
+	}
+	for _, object := range objects {
+		_, err := m.moveKey(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteUnlessShared deletes old objects of the video once no other video
+// references oldURL, which deduplicated uploads share.
+func (m *keyMigration) deleteUnlessShared(oldURL string, del func(ctx context.Context) error) {
+	m.cleanup = append(m.cleanup, func(ctx context.Context) error {
+		shared, err := m.cfg.isVideoURLShared(oldURL, m.video)
+		if err != nil || shared {
+			return err
+		}
+		return del(ctx)
+	})
+}
+
+// deleteObjectUnlessShared deletes an old video, version or rendition object
+// once no video, version or rendition is stored under it.
+func (m *keyMigration) deleteObjectUnlessShared(key string) {
+	m.cleanup = append(m.cleanup, func(ctx context.Context) error {
+		shared, err := m.cfg.isVideoObjectShared(m.cfg.s3Bucket, key, uuid.Nil)
+		if err != nil || shared {
+			return err
+		}
+		refs, err := m.cfg.db.CountRenditionStorageKeyReferences(m.cfg.s3Bucket, key, uuid.Nil)
+		if err != nil || refs > 0 {
+			return err
+		}
+		return m.cfg.videoStorage.Delete(ctx, key)
+	})
+}
+
+// migrateVideoKeys moves the objects of a video to the current key layout,
+// points the video, its versions, renditions and captions at the copies and
+// deletes the old objects nothing references anymore. It returns how many
+// objects it copied.
+func (cfg *apiConfig) migrateVideoKeys(ctx context.Context, video database.Video) (int, error) {
+	m := &keyMigration{
+		cfg:     cfg,
+		video:   video,
+		current: cfg.currentKeyPattern(video.UserID),
+		copied:  map[string]string{},
+	}
+	migrated := video
+	var err error
+
+	// Playlists and sprite tracks refer to their files relatively, so their
+	// directories move as a whole.
+	if video.HLSURL != nil {
+		if manifestKey, ok := cfg.videoStorage.KeyFromURL(*video.HLSURL); ok && !m.current.MatchString(manifestKey) {
+			prefix := path.Dir(manifestKey) + "/"
+			err = m.movePrefix(ctx, prefix)
+			if err != nil {
+				return len(m.copied), err
+			}
+			m.deleteUnlessShared(*video.HLSURL, func(ctx context.Context) error {
+				return cfg.videoStorage.DeletePrefix(ctx, prefix)
+			})
+		}
+	}
+	if video.SpriteURL != nil && video.SpriteVTTURL != nil {
+		if sheetKey, ok := cfg.videoStorage.KeyFromURL(*video.SpriteURL); ok && !m.current.MatchString(sheetKey) {
+			prefix := path.Dir(sheetKey) + "/"
+			err = m.movePrefix(ctx, prefix)
+			if err != nil {
+				return len(m.copied), err
+			}
+			m.deleteUnlessShared(*video.SpriteVTTURL, func(ctx context.Context) error {
+				return cfg.videoStorage.DeletePrefix(ctx, prefix)
+			})
+		}
+	}
+	migrated.HLSURL, _, err = m.moveURL(ctx, video.HLSURL)
+	if err != nil {
+		return len(m.copied), err
+	}
+	migrated.SpriteURL, _, err = m.moveURL(ctx, video.SpriteURL)
+	if err != nil {
+		return len(m.copied), err
+	}
+	migrated.SpriteVTTURL, _, err = m.moveURL(ctx, video.SpriteVTTURL)
+	if err != nil {
+		return len(m.copied), err
+	}
+
+	var oldKey string
+	migrated.PreviewURL, oldKey, err = m.moveURL(ctx, video.PreviewURL)
+	if err != nil {
+		return len(m.copied), err
+	}
+	if _, moved := m.copied[oldKey]; moved {
+		previewKey := oldKey
+		m.deleteUnlessShared(*video.PreviewURL, func(ctx context.Context) error {
+			return cfg.videoStorage.Delete(ctx, previewKey)
+		})
+	}
+
+	if video.StorageKey != nil && (video.StorageBucket == nil || *video.StorageBucket == cfg.s3Bucket) {
+		newKey, err := m.moveKey(ctx, *video.StorageKey)
+		if err != nil {
+			return len(m.copied), err
+		}
+		migrated.StorageKey = &newKey
+		if video.VideoURL != nil {
+			if key, ok := cfg.videoStorage.KeyFromURL(*video.VideoURL); ok && key == *video.StorageKey {
+				videoURL := cfg.videoStorage.URL(newKey)
+				migrated.VideoURL = &videoURL
+			}
+		}
+		if newKey != *video.StorageKey {
+			m.deleteObjectUnlessShared(*video.StorageKey)
+		}
+	}
+
+	if cfg.thumbnailStorageName == "s3" {
+		var oldThumbnails []string
+		migrated.ThumbnailURL, oldKey, err = m.moveURL(ctx, video.ThumbnailURL)
+		if err != nil {
+			return len(m.copied), err
+		}
+		oldThumbnails = append(oldThumbnails, oldKey)
+		if video.ThumbnailSrcset != nil {
+			migrated.ThumbnailSrcset = database.ThumbnailSrcset{}
+			for descriptor, variantURL := range video.ThumbnailSrcset {
+				newURL, oldKey, err := m.moveURL(ctx, &variantURL)
+				if err != nil {
+					return len(m.copied), err
+				}
+				migrated.ThumbnailSrcset[descriptor] = *newURL
+				oldThumbnails = append(oldThumbnails, oldKey)
+			}
+		}
+		for _, key := range oldThumbnails {
+			if _, moved := m.copied[key]; moved {
+				m.cleanup = append(m.cleanup, func(ctx context.Context) error {
+					return cfg.videoStorage.Delete(ctx, key)
+				})
+			}
+		}
+	}
+
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return len(m.copied), fmt.Errorf("couldn't get video versions: %w", err)
+	}
+	renditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		return len(m.copied), fmt.Errorf("couldn't get video renditions: %w", err)
+	}
+	captions, err := cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		return len(m.copied), fmt.Errorf("couldn't get captions: %w", err)
+	}
+
+	versionKeys := make([]string, len(versions))
+	for i, version := range versions {
+		versionKeys[i] = version.StorageKey
+		if version.StorageBucket == cfg.s3Bucket {
+			versionKeys[i], err = m.moveKey(ctx, version.StorageKey)
+			if err != nil {
+				return len(m.copied), err
+			}
+		}
+	}
+	renditionKeys := make([]string, len(renditions))
+	for i, rendition := range renditions {
+		renditionKeys[i] = rendition.StorageKey
+		if rendition.StorageBucket == cfg.s3Bucket {
+			renditionKeys[i], err = m.moveKey(ctx, rendition.StorageKey)
+			if err != nil {
+				return len(m.copied), err
+			}
+		}
+	}
+	captionKeys := make([]string, len(captions))
+	for i, caption := range captions {
+		captionKeys[i], err = m.moveKey(ctx, caption.StorageKey)
+		if err != nil {
+			return len(m.copied), err
+		}
+	}
+
+	// Captions and extracted audio are never shared.
+	for _, aspect := range []string{aspectCaptions, aspectAudio} {
+		prefix := expandObjectKey(legacyObjectKeyTemplate, video.UserID, aspect, video.ID.String()) + "/"
+		if aspect == aspectAudio {
+			err = m.movePrefix(ctx, prefix)
+			if err != nil {
+				return len(m.copied), err
+			}
+		}
+		if !m.current.MatchString(prefix) {
+			m.cleanup = append(m.cleanup, func(ctx context.Context) error {
+				return cfg.videoStorage.DeletePrefix(ctx, prefix)
+			})
+		}
+	}
+
+	if len(m.copied) == 0 {
+		return 0, nil
+	}
+
+	// Nothing refers to the copies until here, so a failure up to this
+	// point leaves the video as it was, with its old objects in place. A
+	// video whose media was replaced meanwhile is skipped, and the copies
+	// are left to the asset collector.
+	err = cfg.db.UpdateVideoObjectKeys(migrated, video.StorageKey)
+	if errors.Is(err, database.ErrVideoMediaChanged) {
+		return len(m.copied), err
+	}
+	if err != nil {
+		return len(m.copied), fmt.Errorf("couldn't update video: %w", err)
+	}
+	for i, version := range versions {
+		if versionKeys[i] == version.StorageKey {
+			continue
+		}
+		err = cfg.db.UpdateVideoVersionKey(version.ID, versionKeys[i], cfg.videoStorage.URL(versionKeys[i]))
+		if err != nil {
+			return len(m.copied), fmt.Errorf("couldn't update version %s: %w", version.ID, err)
+		}
+		m.deleteObjectUnlessShared(version.StorageKey)
+	}
+	for i, rendition := range renditions {
+		if renditionKeys[i] == rendition.StorageKey {
+			continue
+		}
+		err = cfg.db.UpdateVideoRenditionKey(rendition.ID, renditionKeys[i], cfg.videoStorage.URL(renditionKeys[i]))
+		if err != nil {
+			return len(m.copied), fmt.Errorf("couldn't update rendition %s: %w", rendition.ID, err)
+		}
+		m.deleteObjectUnlessShared(rendition.StorageKey)
+	}
+	for i, caption := range captions {
+		if captionKeys[i] == caption.StorageKey {
+			continue
+		}
+		err = cfg.db.UpdateCaptionKey(caption.ID, captionKeys[i], cfg.videoStorage.URL(captionKeys[i]))
+		if err != nil {
+			return len(m.copied), fmt.Errorf("couldn't update captions %s: %w", caption.ID, err)
+		}
+	}
+
+	for _, cleanup := range m.cleanup {
+		err := cleanup(ctx)
+		if err != nil {
+			log.Printf("Couldn't delete migrated objects of video %s: %v", video.ID, err)
+		}
+	}
+	return len(m.copied), nil
+}
+
+// migrateObjectKeys moves the objects of every video stored in the legacy
+// key layout to the current one. Videos are migrated one at a time, so a
+// migration that fails part way can be run again. Archived videos and videos
+// being processed are skipped.
+func (cfg *apiConfig) migrateObjectKeys(ctx context.Context) error {
+	if cfg.videoStorageName != "s3" {
+		return fmt.Errorf("migrating keys requires VIDEO_STORAGE=s3")
+	}
+	if cfg.objectKeyTemplate == legacyObjectKeyTemplate {
+		return fmt.Errorf("OBJECT_KEY_TEMPLATE is the legacy layout, there's nothing to migrate to")
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return fmt.Errorf("couldn't get videos: %w", err)
+	}
+
+	migrated, skipped, failed, objects := 0, 0, 0, 0
+	for _, video := range videos {
+		if video.ArchiveStatus != database.ArchiveStatusNone ||
+			video.ProcessingStatus == database.ProcessingStatusPending ||
+			video.ProcessingStatus == database.ProcessingStatusProcessing {
+			log.Printf("Skipping video %s, it's archived or being processed", video.ID)
+			skipped++
+			continue
+		}
+
+		copied, err := cfg.migrateVideoKeys(ctx, video)
+		if errors.Is(err, database.ErrVideoMediaChanged) {
+			log.Printf("Skipping video %s, its media was replaced during the migration", video.ID)
+			skipped++
+			continue
+		}
+		objects += copied
+		if err != nil {
+			log.Printf("Couldn't migrate keys of video %s: %v", video.ID, err)
+			failed++
+			continue
+		}
+		if copied > 0 {
+			migrated++
+		}
+	}
+	log.Printf("Migrated %d objects of %d videos to %s, skipped %d, %d failed", objects, migrated, cfg.objectKeyTemplate, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d videos failed to migrate", failed)
+	}
+	return nil
+}
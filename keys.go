@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultObjectKeyTemplate keeps everything stored for a user under one
+	// prefix, so bucket policies, lifecycle rules and account deletion can
+	// select a user's objects by prefix.
+	defaultObjectKeyTemplate = "users/{user_id}/{aspect}/{asset}"
+	// legacyObjectKeyTemplate is the layout objects were stored in before
+	// keys were namespaced per user.
+	legacyObjectKeyTemplate = "{aspect}/{asset}"
+)
+
+// The aspects group a video's objects by kind. Videos and their renditions
+// use their orientation instead.
+const (
+	aspectHLS        = "hls"
+	aspectCaptions   = "captions"
+	aspectAudio      = "audio"
+	aspectSprites    = "sprites"
+	aspectPreviews   = "previews"
+	aspectThumbnails = "thumbnails"
+)
+
+// validateObjectKeyTemplate checks that keys built from template keep the
+// aspect and asset apart. {user_id} is optional, but without it keys aren't
+// namespaced per user.
+func validateObjectKeyTemplate(template string) error {
+	for _, placeholder := range []string{"{aspect}", "{asset}"} {
+		if strings.Count(template, placeholder) != 1 {
+			return fmt.Errorf("template must contain %s exactly once", placeholder)
+		}
+	}
+	if strings.HasPrefix(template, "/") {
+		return fmt.Errorf("template must not start with /")
+	}
+	return nil
+}
+
+func expandObjectKey(template string, userID uuid.UUID, aspect, asset string) string {
+	key := strings.NewReplacer(
+		"{user_id}", userID.String(),
+		"{aspect}", aspect,
+		"{asset}", asset,
+	).Replace(template)
+	return path.Clean(key)
+}
+
+// objectKey returns the key of a user's asset of the given aspect, e.g.
+// users/<user id>/landscape/<asset>.mp4.
+func (cfg *apiConfig) objectKey(userID uuid.UUID, aspect, asset string) string {
+	return expandObjectKey(cfg.objectKeyTemplate, userID, aspect, asset)
+}
+
+// videoAssetPrefixes returns the prefixes, with a trailing slash, a video's
+// objects of one aspect may be stored under: that of the current layout and,
+// until they're migrated, that of the legacy one.
+func (cfg *apiConfig) videoAssetPrefixes(video database.Video, aspect string) []string {
+	prefixes := []string{cfg.objectKey(video.UserID, aspect, video.ID.String()) + "/"}
+	legacy := expandObjectKey(legacyObjectKeyTemplate, video.UserID, aspect, video.ID.String()) + "/"
+	if legacy != prefixes[0] {
+		prefixes = append(prefixes, legacy)
+	}
+	return prefixes
+}
+
+// objectKeyPattern matches keys in the current layout of the users matched
+// by userPattern, capturing their aspect and asset.
+func (cfg *apiConfig) objectKeyPattern(userPattern string) *regexp.Regexp {
+	pattern := strings.NewReplacer(
+		regexp.QuoteMeta("{user_id}"), userPattern,
+		regexp.QuoteMeta("{aspect}"), `(?P<aspect>[^/]+)`,
+		regexp.QuoteMeta("{asset}"), `(?P<asset>.+)`,
+	).Replace(regexp.QuoteMeta(cfg.objectKeyTemplate))
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// currentKeyPattern matches the keys of a user's objects in the current
+// layout.
+func (cfg *apiConfig) currentKeyPattern(userID uuid.UUID) *regexp.Regexp {
+	return cfg.objectKeyPattern(regexp.QuoteMeta(userID.String()))
+}
+
+// migratedObjectKey returns the key a user's object moves to in the current
+// layout. Deduplicated uploads can share objects another user already moved,
+// which keep their aspect and asset. Keys from before objects were grouped by
+// aspect are filed under "other".
+func (cfg *apiConfig) migratedObjectKey(userID uuid.UUID, key string) string {
+	pattern := cfg.objectKeyPattern(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	if match := pattern.FindStringSubmatch(key); match != nil {
+		aspect := match[pattern.SubexpIndex("aspect")]
+		asset := match[pattern.SubexpIndex("asset")]
+		return cfg.objectKey(userID, aspect, asset)
+	}
+	aspect, asset, ok := strings.Cut(key, "/")
+	if !ok {
+		aspect, asset = "other", key
+	}
+	return cfg.objectKey(userID, aspect, asset)
+}
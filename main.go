@@ -2,51 +2,183 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
+	gcs "cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	awsmediaconvert "github.com/aws/aws-sdk-go-v2/service/mediaconvert"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/clamav"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaconvert"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/proclimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/safehttp"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scheduler"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	s3Client         *s3.Client
-	port             string
+	db                        database.Client
+	jwtKeys                   *auth.Keys
+	secretResolver            *secrets.Resolver
+	platform                  string
+	filepathRoot              string
+	assetsRoot                string
+	s3Bucket                  string
+	s3Region                  string
+	s3CfDistribution          string
+	s3Client                  *s3.Client
+	s3PresignClient           *s3.PresignClient
+	s3Encryption              storage.Encryption
+	s3Objects                 *storage.S3
+	s3StorageClass            s3types.StorageClass
+	archiveStorageClass       s3types.StorageClass
+	restoreDays               int
+	restoreTier               s3types.Tier
+	presignExpiry             time.Duration
+	port                      string
+	videoStorage              storage.Storage
+	videoStorageName          string
+	videoDelivery             string
+	signedLocalAssets         bool
+	hlsSigningKey             []byte
+	cdnSigner                 *cdn.Signer
+	thumbnailStorage          storage.Storage
+	thumbnailStorageName      string
+	objectKeyTemplate         string
+	jobs                      jobs.Runner
+	progress                  *progress.Tracker
+	events                    *events.Broker
+	hlsEnabled                bool
+	spritesEnabled            bool
+	previewsEnabled           bool
+	renditionsEnabled         bool
+	transcriber               transcribe.Transcriber
+	moderator                 moderation.Moderator
+	moderationFrames          int
+	virusScanner              *clamav.Client
+	virusScanFailOpen         bool
+	videoVersions             int
+	transcriptionLanguage     string
+	autoThumbnailEnabled      bool
+	maxVideoSize              int64
+	maxThumbnailSize          int64
+	processingSpaceFactor     float64
+	minFreeDiskSpace          int64
+	videoLimits               videoLimits
+	watermark                 *watermark
+	mediaConvert              *mediaconvert.Client
+	mediaConvertCallbackToken string
+	remoteWorkers             bool
+	uploadEventsEnabled       bool
+	processingTimeout         time.Duration
+	assetGCMinAge             time.Duration
+	trashRetention            time.Duration
+	idempotencyKeyTTL         time.Duration
+	adminEmails               map[string]bool
+	uploadLimiter             *ratelimit.Limiter
+	authLimiter               *ratelimit.Limiter
+	playLimiter               *ratelimit.Limiter
+	uploadGuard               *ratelimit.Guard
+	uploadBanDuration         time.Duration
+	uploadAllowlist           []netip.Prefix
+	fetchClient               *http.Client
+	webhookClient             *http.Client
+	webhookAllowPrivate       bool
 }
 
 func main() {
-	err := godotenv.Load(".env")
+	var workerMode bool
+	cmd, err := parseCommand(os.Args[1:], &workerMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = godotenv.Load(".env")
 	if err != nil {
 		log.Fatal(".env file must exist")
 	}
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	// Secrets can be kept in Secrets Manager or Parameter Store, with the
+	// environment only referring to them.
+	secretsConfig, err := config.LoadDefaultConfig(
+		context.Background(),
+		config.WithRegion(getEnvString("SECRETS_REGION", os.Getenv("S3_REGION"))),
+	)
+	if err != nil {
+		log.Fatalf("Couldn't load AWS config for secrets: %v", err)
+	}
+	secretResolver := secrets.New(secretsConfig)
+
+	// DATABASE_URL points instances running side by side at a shared
+	// Postgres database; a single instance can use a SQLite file instead.
+	dsn, err := loadDatabaseDSN(context.Background(), secretResolver)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	db, err := database.NewClient(pathToDB)
+	db, err := database.Open(dsn, database.PoolConfig{
+		MaxOpenConns:    getEnvInt("DB_MAX_OPEN_CONNS", 0),
+		MaxIdleConns:    getEnvInt("DB_MAX_IDLE_CONNS", 0),
+		ConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", 0),
+	})
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	// Without AUTO_MIGRATE, schema changes are rolled out by running the
+	// migrate command before starting the new version.
+	if cmd.name == "migrate" || getEnvBool("AUTO_MIGRATE", true) {
+		err = db.Migrate()
+		if err != nil {
+			log.Fatalf("Couldn't migrate database: %v", err)
+		}
+	} else {
+		pending, err := db.PendingMigrations()
+		if err != nil {
+			log.Fatalf("Couldn't check database migrations: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database has pending migrations %s, run the migrate command first", strings.Join(pending, ", "))
+		}
+	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	jwtKeys, err := loadJWTKeys(context.Background(), secretResolver)
+	if err != nil {
+		log.Fatal(err)
 	}
+	jwtKeys.Revocations = db
 
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
@@ -63,6 +195,16 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	// Uploads and processing outputs go to the temp directory. Setting TMPDIR
+	// moves them for ffmpeg and the other tools we run as well.
+	if tempDir := os.Getenv("TEMP_DIR"); tempDir != "" {
+		err = os.MkdirAll(tempDir, 0755)
+		if err != nil {
+			log.Fatalf("Couldn't create temp directory: %v", err)
+		}
+		os.Setenv("TMPDIR", tempDir)
+	}
+
 	s3Bucket := os.Getenv("S3_BUCKET")
 	if s3Bucket == "" {
 		log.Fatal("S3_BUCKET environment variable is not set")
@@ -73,68 +215,678 @@ func main() {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
+	// Objects are served from S3_PUBLIC_URL, the CloudFront distribution or
+	// the bucket itself, in that order of preference.
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	s3PublicURL := os.Getenv("S3_PUBLIC_URL")
+	if s3PublicURL == "" && s3CfDistribution != "" {
+		s3PublicURL = fmt.Sprintf("https://%s.cloudfront.net", s3CfDistribution)
 	}
 
-	s3Config, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	// S3-compatible services such as MinIO, R2 and B2 are reached through
+	// their own endpoint. MinIO needs path-style addressing, and R2 expects
+	// requests signed for the "auto" region.
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+	s3PathStyle := getEnvBool("S3_FORCE_PATH_STYLE", false)
+	s3EndpointRegion := getEnvString("S3_ENDPOINT_REGION", s3Region)
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Couldn't set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	s3Config, err := config.LoadDefaultConfig(
+		context.Background(),
+		config.WithRegion(s3Region),
+		config.WithRetryer(func() aws.Retryer {
+			return storage.NewRetryer(getEnvInt("AWS_MAX_ATTEMPTS", 5), getEnvDuration("AWS_MAX_BACKOFF", 20*time.Second))
+		}),
+	)
 	if err != nil {
 		log.Fatalf("S3 Config could not be loaded %s", err)
 	}
 
-	s3Client := s3.NewFromConfig(s3Config)
+	otelaws.AppendMiddlewares(&s3Config.APIOptions)
+	s3Client := s3.NewFromConfig(s3Config, func(o *s3.Options) {
+		o.Region = s3EndpointRegion
+		o.UsePathStyle = s3PathStyle
+		if s3Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3Endpoint)
+			// Not every S3-compatible service accepts the checksums the SDK
+			// adds to requests by default.
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+	})
+	if s3PublicURL == "" {
+		s3PublicURL, err = storage.BucketURL(s3Endpoint, s3Bucket, s3EndpointRegion, s3PathStyle)
+		if err != nil {
+			log.Fatalf("Invalid S3_ENDPOINT: %v", err)
+		}
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	s3Objects := storage.NewS3(s3Client, s3Bucket, s3PublicURL)
+	s3Objects.SetPutAttempts(getEnvInt("S3_PUT_ATTEMPTS", 3))
+	s3Encryption, err := storage.ParseEncryption(os.Getenv("S3_ENCRYPTION"), os.Getenv("S3_KMS_KEY_ID"))
+	if err != nil {
+		log.Fatalf("Invalid S3_ENCRYPTION: %v", err)
+	}
+	s3Objects.SetEncryption(s3Encryption)
+	var s3StorageClass s3types.StorageClass
+	if class := os.Getenv("S3_STORAGE_CLASS"); class != "" {
+		s3StorageClass, err = storage.ParseStorageClass(class)
+		if err != nil {
+			log.Fatalf("Invalid S3_STORAGE_CLASS: %v", err)
+		}
+	}
+	s3Objects.SetStorageClass(s3StorageClass)
+	archiveStorageClass := s3types.StorageClass(getEnvString("ARCHIVE_STORAGE_CLASS", string(s3types.StorageClassGlacier)))
+	if !slices.Contains(storage.ArchiveStorageClasses, archiveStorageClass) {
+		log.Fatalf("ARCHIVE_STORAGE_CLASS must be one of GLACIER, DEEP_ARCHIVE: got %q", archiveStorageClass)
+	}
+	restoreTier, err := storage.ParseRestoreTier(getEnvString("RESTORE_TIER", string(s3types.TierStandard)))
+	if err != nil {
+		log.Fatalf("Invalid RESTORE_TIER: %v", err)
+	}
+	s3Storage := storage.NewPresignCache(s3Objects, getEnvDuration("PRESIGN_REFRESH_MARGIN", time.Hour))
+	localStorage := storage.NewLocal(assetsRoot, localAssetsURL(port))
+	localAssetSigningKey := os.Getenv("LOCAL_ASSET_SIGNING_KEY")
+	if localAssetSigningKey != "" {
+		localStorage.SetSigningKey([]byte(localAssetSigningKey))
+	}
+	storageBackends := map[string]storage.Storage{
+		"s3":    s3Storage,
+		"local": localStorage,
+	}
+
+	videoStorageName := getEnvString("VIDEO_STORAGE", "s3")
+	thumbnailStorageName := getEnvString("THUMBNAIL_STORAGE", "local")
+
+	// The GCS client needs Google credentials, so it's only created when
+	// GCS is used.
+	if videoStorageName == "gcs" || thumbnailStorageName == "gcs" {
+		gcsBucket := os.Getenv("GCS_BUCKET")
+		if gcsBucket == "" {
+			log.Fatal("GCS_BUCKET environment variable is not set")
+		}
+		gcsClient, err := gcs.NewClient(context.Background())
+		if err != nil {
+			log.Fatalf("Couldn't create GCS client: %v", err)
+		}
+		storageBackends["gcs"] = storage.NewPresignCache(
+			storage.NewGCS(gcsClient, gcsBucket, os.Getenv("GCS_PUBLIC_URL")),
+			getEnvDuration("PRESIGN_REFRESH_MARGIN", time.Hour),
+		)
+	}
+
+	// Azure authenticates with the account key in a connection string or,
+	// without one, through Entra ID, e.g. the host's managed identity.
+	if videoStorageName == "azure" || thumbnailStorageName == "azure" {
+		azureContainer := os.Getenv("AZURE_STORAGE_CONTAINER")
+		if azureContainer == "" {
+			log.Fatal("AZURE_STORAGE_CONTAINER environment variable is not set")
+		}
+		var azureClient *azblob.Client
+		if connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connectionString != "" {
+			azureClient, err = azblob.NewClientFromConnectionString(connectionString, nil)
+		} else {
+			accountURL := os.Getenv("AZURE_STORAGE_ACCOUNT_URL")
+			if accountURL == "" {
+				log.Fatal("AZURE_STORAGE_CONNECTION_STRING or AZURE_STORAGE_ACCOUNT_URL must be set for Azure storage")
+			}
+			credential, credErr := azidentity.NewDefaultAzureCredential(nil)
+			if credErr != nil {
+				log.Fatalf("Couldn't load Azure credentials: %v", credErr)
+			}
+			azureClient, err = azblob.NewClient(accountURL, credential, nil)
+		}
+		if err != nil {
+			log.Fatalf("Couldn't create Azure Blob client: %v", err)
+		}
+		storageBackends["azure"] = storage.NewPresignCache(
+			storage.NewAzure(azureClient, azureContainer, os.Getenv("AZURE_PUBLIC_URL")),
+			getEnvDuration("PRESIGN_REFRESH_MARGIN", time.Hour),
+		)
+	}
+
+	videoStorage, ok := storageBackends[videoStorageName]
+	if !ok {
+		log.Fatalf("VIDEO_STORAGE must be one of s3, gcs, azure, local: got %q", videoStorageName)
+	}
+
+	videoDelivery := getEnvString("VIDEO_DELIVERY", videoDeliveryPublic)
+	var cdnSigner *cdn.Signer
+	switch videoDelivery {
+	case videoDeliveryPublic, videoDeliveryPresigned:
+	case videoDeliveryCloudFrontSigned:
+		cloudFrontKeyID, cloudFrontPrivateKey, err := loadCloudFrontKey(context.Background(), secretResolver)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cloudFrontDomain := os.Getenv("CLOUDFRONT_DOMAIN")
+		if cloudFrontDomain == "" && s3CfDistribution != "" {
+			cloudFrontDomain = s3CfDistribution + ".cloudfront.net"
+		}
+		if cloudFrontDomain == "" {
+			log.Fatal("CLOUDFRONT_DOMAIN or S3_CF_DISTRO must be set for cloudfront-signed delivery")
+		}
+
+		cdnSigner, err = cdn.NewSigner(cloudFrontDomain, cloudFrontKeyID, cloudFrontPrivateKey)
+		if err != nil {
+			log.Fatalf("Couldn't create CloudFront signer: %v", err)
+		}
+	default:
+		log.Fatalf("VIDEO_DELIVERY must be one of public, presigned, cloudfront-signed: got %q", videoDelivery)
+	}
+
+	thumbnailStorage, ok := storageBackends[thumbnailStorageName]
+	if !ok {
+		log.Fatalf("THUMBNAIL_STORAGE must be one of s3, gcs, azure, local: got %q", thumbnailStorageName)
+	}
+
+	// Workers and the API only share what's in the bucket and the database.
+	remoteWorkers := getEnvBool("REMOTE_WORKERS", false)
+	if (remoteWorkers || workerMode) && videoStorageName == "local" {
+		log.Fatal("Remote workers require VIDEO_STORAGE=s3, gcs or azure")
+	}
+	if workerMode && thumbnailStorageName == "local" {
+		log.Fatal("Workers require THUMBNAIL_STORAGE=s3, gcs or azure")
+	}
+
+	jobWorkers := getEnvInt("JOB_WORKERS", 2)
+	var jobRunner jobs.Runner
+	switch jobQueue := getEnvString("JOB_QUEUE", "database"); jobQueue {
+	case "database":
+		queue := jobs.NewQueue(db, jobWorkers)
+		if workerMode {
+			queue.SetLease(getEnvDuration("JOB_LEASE_TIMEOUT", 2*time.Minute))
+		}
+		jobRunner = queue
+	case "sqs":
+		sqsQueueURL := os.Getenv("SQS_QUEUE_URL")
+		if sqsQueueURL == "" {
+			log.Fatal("SQS_QUEUE_URL environment variable is not set")
+		}
+		visibilityTimeout := getEnvDuration("SQS_VISIBILITY_TIMEOUT", 5*time.Minute)
+		if visibilityTimeout < 30*time.Second || visibilityTimeout > 12*time.Hour {
+			log.Fatalf("SQS_VISIBILITY_TIMEOUT must be between 30s and 12h: got %s", visibilityTimeout)
+		}
+		jobRunner = jobs.NewSQSQueue(db, sqs.NewFromConfig(s3Config), sqsQueueURL, jobWorkers, visibilityTimeout)
+	default:
+		log.Fatalf("JOB_QUEUE must be one of database, sqs: got %q", jobQueue)
+	}
+
+	// Direct uploads can be picked up from S3 event notifications instead of
+	// waiting for the client to complete them.
+	uploadEventsQueueURL := os.Getenv("UPLOAD_EVENTS_QUEUE_URL")
+	if uploadEventsQueueURL != "" && videoStorageName != "s3" {
+		log.Fatal("UPLOAD_EVENTS_QUEUE_URL requires VIDEO_STORAGE=s3")
+	}
+
+	objectKeyTemplate := getEnvString("OBJECT_KEY_TEMPLATE", defaultObjectKeyTemplate)
+	err = validateObjectKeyTemplate(objectKeyTemplate)
+	if err != nil {
+		log.Fatalf("Invalid OBJECT_KEY_TEMPLATE: %v", err)
+	}
+
+	var transcriber transcribe.Transcriber
+	switch backend := getEnvString("TRANSCRIPTION_BACKEND", ""); backend {
+	case "":
+	case "whisper":
+		whisperModel := os.Getenv("WHISPER_MODEL")
+		if whisperModel == "" {
+			log.Fatal("WHISPER_MODEL environment variable is not set")
+		}
+		transcriber = transcribe.NewWhisper(getEnvString("WHISPER_BINARY", "whisper-cli"), whisperModel, runLimited)
+	case "openai":
+		openAIKey := os.Getenv("OPENAI_API_KEY")
+		if openAIKey == "" {
+			log.Fatal("OPENAI_API_KEY environment variable is not set")
+		}
+		transcriber = transcribe.NewOpenAI(
+			getEnvString("TRANSCRIPTION_API_URL", transcribe.DefaultOpenAIEndpoint),
+			openAIKey,
+			getEnvString("TRANSCRIPTION_MODEL", "whisper-1"),
+		)
+	default:
+		log.Fatalf("TRANSCRIPTION_BACKEND must be one of whisper, openai or empty: got %q", backend)
+	}
+	transcriptionLanguage := getEnvString("TRANSCRIPTION_LANGUAGE", "en")
+	if !captions.ValidLanguage(transcriptionLanguage) {
+		log.Fatalf("TRANSCRIPTION_LANGUAGE must be a language tag: got %q", transcriptionLanguage)
+	}
+
+	var moderator moderation.Moderator = moderation.Noop{}
+	switch backend := getEnvString("MODERATION_BACKEND", ""); backend {
+	case "":
+	case "rekognition":
+		moderator = moderation.NewRekognition(
+			rekognition.NewFromConfig(s3Config),
+			getEnvFloat("MODERATION_MIN_CONFIDENCE", 80),
+		)
+	default:
+		log.Fatalf("MODERATION_BACKEND must be one of rekognition or empty: got %q", backend)
+	}
+
+	var virusScanner *clamav.Client
+	if address := os.Getenv("CLAMAV_ADDRESS"); address != "" {
+		virusScanner = clamav.New(address, getEnvDuration("CLAMAV_TIMEOUT", 5*time.Minute))
+	}
+
+	uploadAllowlist, err := parseIPAllowlist(os.Getenv("UPLOAD_IP_ALLOWLIST"))
+	if err != nil {
+		log.Fatalf("UPLOAD_IP_ALLOWLIST must be a comma-separated list of IPs and CIDR ranges: %v", err)
+	}
+	uploadBanDuration := getEnvDuration("UPLOAD_BAN_DURATION", time.Hour)
+	uploadGuard := ratelimit.NewGuard(
+		getEnvDuration("UPLOAD_IP_WINDOW", 10*time.Minute),
+		getEnvInt("UPLOAD_IP_LIMIT", 30),
+		getEnvInt("UPLOAD_BAN_FAILURES", 5),
+		uploadBanDuration,
+	)
+
+	// Webhook URLs are given by users, so deliveries are only made to the
+	// public internet unless the endpoints are known to be internal.
+	webhookAllowPrivate := getEnvBool("WEBHOOK_ALLOW_PRIVATE", false)
+
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		s3Client:         s3Client,
-		port:             port,
+		db:                    db,
+		jwtKeys:               jwtKeys,
+		secretResolver:        secretResolver,
+		platform:              platform,
+		filepathRoot:          filepathRoot,
+		assetsRoot:            assetsRoot,
+		s3Bucket:              s3Bucket,
+		s3Region:              s3Region,
+		s3CfDistribution:      s3CfDistribution,
+		s3Client:              s3Client,
+		s3PresignClient:       s3.NewPresignClient(s3Client),
+		s3Encryption:          s3Encryption,
+		s3Objects:             s3Objects,
+		s3StorageClass:        s3StorageClass,
+		archiveStorageClass:   archiveStorageClass,
+		restoreDays:           max(getEnvInt("RESTORE_DAYS", 7), 1),
+		restoreTier:           restoreTier,
+		presignExpiry:         getEnvDuration("PRESIGN_EXPIRY", 24*time.Hour),
+		port:                  port,
+		videoStorage:          videoStorage,
+		videoStorageName:      videoStorageName,
+		videoDelivery:         videoDelivery,
+		signedLocalAssets:     localAssetSigningKey != "",
+		hlsSigningKey:         []byte(os.Getenv("HLS_SIGNING_KEY")),
+		cdnSigner:             cdnSigner,
+		thumbnailStorage:      thumbnailStorage,
+		thumbnailStorageName:  thumbnailStorageName,
+		objectKeyTemplate:     objectKeyTemplate,
+		jobs:                  jobRunner,
+		progress:              progress.NewTracker(),
+		events:                events.NewBroker(),
+		hlsEnabled:            getEnvBool("HLS_ENABLED", false),
+		spritesEnabled:        getEnvBool("SPRITES_ENABLED", false),
+		previewsEnabled:       getEnvBool("PREVIEWS_ENABLED", false),
+		renditionsEnabled:     getEnvBool("RENDITIONS_ENABLED", false),
+		transcriber:           transcriber,
+		moderator:             moderator,
+		moderationFrames:      getEnvInt("MODERATION_FRAMES", 5),
+		virusScanner:          virusScanner,
+		virusScanFailOpen:     getEnvBool("CLAMAV_FAIL_OPEN", false),
+		videoVersions:         max(getEnvInt("VIDEO_VERSIONS", 5), 1),
+		transcriptionLanguage: transcriptionLanguage,
+		autoThumbnailEnabled:  getEnvBool("AUTO_THUMBNAIL_ENABLED", true),
+		maxVideoSize:          getEnvInt64("MAX_VIDEO_SIZE", 1<<30),
+		maxThumbnailSize:      getEnvInt64("MAX_THUMBNAIL_SIZE", 10<<20),
+		processingSpaceFactor: getEnvFloat("PROCESSING_SPACE_FACTOR", 3),
+		minFreeDiskSpace:      getEnvInt64("MIN_FREE_DISK_SPACE", 512<<20),
+		processingTimeout:     getEnvDuration("PROCESSING_TIMEOUT", time.Hour),
+		assetGCMinAge:         getEnvDuration("ASSET_GC_MIN_AGE", 24*time.Hour),
+		trashRetention:        getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		idempotencyKeyTTL:     getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		uploadEventsEnabled:   uploadEventsQueueURL != "",
+		remoteWorkers:         remoteWorkers,
+		adminEmails:           parseAdminEmails(os.Getenv("ADMIN_EMAILS")),
+		uploadLimiter:         ratelimit.New(getEnvInt("UPLOAD_RATE_LIMIT", 10), getEnvInt("UPLOAD_RATE_BURST", 5)),
+		authLimiter:           ratelimit.New(getEnvInt("AUTH_RATE_LIMIT", 20), getEnvInt("AUTH_RATE_BURST", 10)),
+		playLimiter:           ratelimit.New(getEnvInt("PLAY_RATE_LIMIT", 30), getEnvInt("PLAY_RATE_BURST", 10)),
+		uploadGuard:           uploadGuard,
+		uploadBanDuration:     uploadBanDuration,
+		uploadAllowlist:       uploadAllowlist,
+		fetchClient:           safehttp.NewClient(getEnvDuration("URL_FETCH_TIMEOUT", time.Hour), getEnvBool("URL_FETCH_ALLOW_PRIVATE", false)),
+		webhookClient:         safehttp.NewClient(webhookTimeout, webhookAllowPrivate),
+		webhookAllowPrivate:   webhookAllowPrivate,
+		videoLimits: videoLimits{
+			maxDuration: getEnvDuration("MAX_VIDEO_DURATION", 0),
+			maxWidth:    getEnvInt("MAX_VIDEO_WIDTH", 0),
+			maxHeight:   getEnvInt("MAX_VIDEO_HEIGHT", 0),
+		},
+	}
+
+	switch backend := getEnvString("PROCESSING_BACKEND", "ffmpeg"); backend {
+	case "ffmpeg":
+		ffmpegBinary = getEnvString("FFMPEG_PATH", "ffmpeg")
+		ffprobeBinary = getEnvString("FFPROBE_PATH", "ffprobe")
+		ffmpegRequired := getEnvString("FFMPEG_MIN_VERSION", ffmpegMinVersion)
+		for _, binary := range []string{ffmpegBinary, ffprobeBinary} {
+			version, err := checkFFmpegBinary(context.Background(), binary, ffmpegRequired)
+			if err != nil {
+				log.Fatalf("Couldn't use %s, set FFMPEG_PATH and FFPROBE_PATH to ffmpeg %s or newer: %v", binary, ffmpegRequired, err)
+			}
+			log.Printf("Using %s", version)
+		}
+		h264Encoder, err = detectVideoEncoder(
+			context.Background(),
+			getEnvString("VIDEO_ENCODER", "software"),
+			getEnvString("VAAPI_DEVICE", "/dev/dri/renderD128"),
+		)
+		if err != nil {
+			log.Fatalf("Couldn't set up video encoder: %v", err)
+		}
+		log.Printf("Encoding video with %s", h264Encoder.name)
+
+		cfg.watermark, err = newWatermark(
+			os.Getenv("WATERMARK_PATH"),
+			getEnvString("WATERMARK_POSITION", "bottom-right"),
+			getEnvInt("WATERMARK_MARGIN", 16),
+			getEnvFloat("WATERMARK_OPACITY", 0.8),
+		)
+		if err != nil {
+			log.Fatalf("Invalid watermark configuration: %v", err)
+		}
+	case "mediaconvert":
+		if videoStorageName != "s3" || s3Endpoint != "" {
+			log.Fatal("PROCESSING_BACKEND=mediaconvert requires VIDEO_STORAGE=s3 on AWS")
+		}
+		mediaConvertRole := os.Getenv("MEDIACONVERT_ROLE_ARN")
+		if mediaConvertRole == "" {
+			log.Fatal("MEDIACONVERT_ROLE_ARN environment variable is not set")
+		}
+		mediaConvertEndpoint := os.Getenv("MEDIACONVERT_ENDPOINT")
+		mediaConvertAPI := awsmediaconvert.NewFromConfig(s3Config, func(o *awsmediaconvert.Options) {
+			if mediaConvertEndpoint != "" {
+				o.BaseEndpoint = aws.String(mediaConvertEndpoint)
+			}
+		})
+		cfg.mediaConvert = mediaconvert.New(
+			mediaConvertAPI,
+			mediaConvertRole,
+			os.Getenv("MEDIACONVERT_QUEUE_ARN"),
+			getEnvDuration("MEDIACONVERT_POLL_INTERVAL", 30*time.Second),
+		)
+		cfg.mediaConvertCallbackToken = os.Getenv("MEDIACONVERT_CALLBACK_TOKEN")
+		cfg.disableFFmpegFeatures()
+		log.Printf("Processing videos with MediaConvert")
+	default:
+		log.Fatalf("PROCESSING_BACKEND must be one of ffmpeg, mediaconvert: got %q", backend)
 	}
 
+	ffmpegLimiter = proclimit.New(
+		getEnvInt("MAX_CONCURRENT_TRANSCODES", runtime.NumCPU()),
+		getEnvDuration("TRANSCODE_QUEUE_TIMEOUT", 5*time.Minute),
+	)
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "tubely_ffmpeg_queue_depth",
+		Help: "ffmpeg and ffprobe invocations waiting for a free slot.",
+	}, func() float64 { return float64(ffmpegLimiter.Waiting()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "tubely_ffmpeg_running",
+		Help: "ffmpeg and ffprobe processes currently running.",
+	}, func() float64 { return float64(ffmpegLimiter.Running()) })
+
 	err = cfg.ensureAssetsDir()
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	if getEnvBool("STARTUP_CHECKS", true) {
+		err = cfg.checkStartup(context.Background())
+		if err != nil {
+			log.Fatalf("Startup check failed: %v", err)
+		}
+	}
+
+	// Workers use the database and sign URLs as well, so they refresh
+	// secrets too.
+	secretsRefresh := scheduler.New()
+	secretsRefresh.Every("secrets", getEnvDuration("SECRETS_REFRESH_INTERVAL", 0), cfg.refreshSecrets)
+	secretsRefresh.Start(context.Background())
+	defer secretsRefresh.Shutdown(context.Background())
+
+	cfg.registerJobHandlers()
+	if workerMode {
+		cfg.removeStaleTempFiles()
+		err = cfg.jobs.Start(context.Background())
+		if err != nil {
+			log.Fatalf("Couldn't start job queue: %v", err)
+		}
+		cfg.runWorker(port)
+		return
+	}
+
+	err = cfg.promoteAdmins()
+	if err != nil {
+		log.Fatalf("Couldn't promote admin users: %v", err)
+	}
+
+	err = cfg.backfillVideoStorageKeys()
+	if err != nil {
+		log.Fatalf("Couldn't backfill video storage keys: %v", err)
+	}
+
+	err = cfg.backfillUsage()
+	if err != nil {
+		log.Fatalf("Couldn't backfill usage: %v", err)
+	}
+
+	if thumbnailStorageName != "local" {
+		err = cfg.migrateLocalThumbnails(context.Background(), localStorage)
+		if err != nil {
+			log.Fatalf("Couldn't migrate local thumbnails: %v", err)
+		}
+	}
+
+	if cmd.run != nil {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = cmd.run(ctx, &cfg)
+		stop()
+		if err != nil {
+			log.Fatalf("Couldn't run %s: %v", cmd.name, err)
+		}
+		return
+	}
+
+	// With remote workers the API only queues jobs.
+	if !cfg.remoteWorkers {
+		cfg.removeStaleTempFiles()
+		err = cfg.jobs.Start(context.Background())
+		if err != nil {
+			log.Fatalf("Couldn't start job queue: %v", err)
+		}
+	}
+
+	assetGCDryRun := getEnvBool("ASSET_GC_DRY_RUN", true)
+	sched := scheduler.New()
+	sched.Every("trash-purge", getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour), cfg.purgeTrash)
+	sched.Every("video-expiry", getEnvDuration("VIDEO_EXPIRY_INTERVAL", time.Minute), cfg.expireVideos)
+	sched.Every("idempotency-keys", time.Hour, cfg.purgeIdempotencyKeys)
+	sched.Every("revoked-tokens", time.Hour, cfg.purgeRevokedTokens)
+	sched.Every("upload-tokens", time.Hour, cfg.purgeUploadTokens)
+	if videoStorageName == "s3" {
+		sched.Every("archive-restores", getEnvDuration("RESTORE_CHECK_INTERVAL", 15*time.Minute), cfg.checkArchiveRestores)
+	}
+	sched.Every("asset-gc", getEnvDuration("ASSET_GC_INTERVAL", 24*time.Hour), func(ctx context.Context) error {
+		return cfg.runAssetGC(ctx, assetGCDryRun)
+	})
+	sched.Start(context.Background())
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("/assets/", assetsHandler(localStorage, assetsRoot, cfg.signedLocalAssets))
+
+	// Uploads run ffmpeg and move large files, so they get a tighter limit
+	// than the rest of the API, per user and per client IP.
+	upload := func(handler http.HandlerFunc) http.HandlerFunc {
+		return cfg.middlewareUploadGuard(cfg.middlewareRateLimit(cfg.uploadLimiter, cfg.middlewareRequireRole(auth.RoleUploader, handler)))
+	}
+
+	v1 := newAPIVersion("v1", nil)
+	v1.HandleFunc("POST /api/v1/login", cfg.middlewareAudit("user.login", "", cfg.middlewareRateLimit(cfg.authLimiter, cfg.handlerLogin)), "/api/login")
+	v1.HandleFunc("POST /api/v1/refresh", cfg.middlewareRateLimit(cfg.authLimiter, cfg.handlerRefresh), "/api/refresh")
+	v1.HandleFunc("POST /api/v1/revoke", cfg.handlerRevoke, "/api/revoke")
+	v1.HandleFunc("POST /api/v1/tokens/revoke", cfg.middlewareAudit("token.revoke", "", cfg.handlerTokenRevoke))
+	v1.HandleFunc("POST /api/v1/tokens/revoke-all", cfg.middlewareAudit("token.revoke_all", "", cfg.handlerTokensRevokeAll))
+
+	v1.HandleFunc("POST /api/v1/users", cfg.middlewareAudit("user.create", "", cfg.middlewareRateLimit(cfg.authLimiter, cfg.handlerUsersCreate)), "/api/users")
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	v1.HandleFunc("POST /api/v1/videos", cfg.middlewareAudit("video.create", "", cfg.middlewareRequireRole(auth.RoleUploader, cfg.handlerVideoMetaCreate)), "/api/videos")
+	v1.HandleFunc("POST /api/v1/thumbnail_upload/{videoID}", cfg.middlewareAudit("thumbnail.upload", "videoID", upload(cfg.middlewareDiskSpace(cfg.middlewareIdempotency(cfg.handlerUploadThumbnail)))), "/api/thumbnail_upload/{videoID}")
+	v1.HandleFunc("POST /api/v1/video_upload/{videoID}", cfg.middlewareAudit("video.upload", "videoID", upload(cfg.middlewareDiskSpace(cfg.middlewareIdempotency(cfg.handlerUploadVideo)))), "/api/video_upload/{videoID}")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/upload_url", upload(cfg.handlerUploadURLCreate), "/api/videos/{videoID}/upload_url")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/upload_token", cfg.middlewareAudit("upload_token.create", "videoID", upload(cfg.handlerUploadTokenCreate)))
+	// Uploads made with an upload token aren't authenticated with a JWT, so
+	// the uploader role of the token's owner is checked by the handler.
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/delegated_upload", cfg.middlewareAudit("video.upload", "videoID", cfg.middlewareUploadGuard(cfg.middlewareRateLimit(cfg.uploadLimiter, cfg.middlewareDiskSpace(cfg.handlerUploadVideoWithToken)))))
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/complete", cfg.middlewareAudit("video.upload", "videoID", upload(cfg.middlewareIdempotency(cfg.handlerUploadComplete))), "/api/videos/{videoID}/complete")
+	v1.HandleFunc("PUT /api/v1/videos/{videoID}/media", cfg.middlewareAudit("video.upload", "videoID", upload(cfg.middlewareDiskSpace(cfg.middlewareIdempotency(cfg.handlerUploadVideo)))), "/api/videos/{videoID}/media")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/upload", cfg.middlewareAudit("video.upload", "videoID", upload(cfg.middlewareDiskSpace(cfg.middlewareIdempotency(cfg.handlerUploadFiles)))))
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/fetch", cfg.middlewareAudit("video.fetch", "videoID", upload(cfg.middlewareIdempotency(cfg.handlerVideoFetch))))
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/versions", cfg.handlerVideoVersionsRetrieve, "/api/videos/{videoID}/versions")
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/renditions", cfg.handlerVideoRenditionsRetrieve, "/api/videos/{videoID}/renditions")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/versions/{versionID}/rollback", cfg.middlewareAudit("video.rollback", "videoID", cfg.handlerVideoVersionRollback), "/api/videos/{videoID}/versions/{versionID}/rollback")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/thumbnail/from-frame", cfg.middlewareAudit("thumbnail.upload", "videoID", upload(cfg.handlerThumbnailFromFrame)), "/api/videos/{videoID}/thumbnail/from-frame")
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/thumbnail/candidates", upload(cfg.handlerThumbnailCandidatesGet), "/api/videos/{videoID}/thumbnail/candidates")
+	v1.HandleFunc("GET /api/v1/videos", cfg.handlerVideosRetrieve, "/api/videos")
+	v1.HandleFunc("POST /api/v1/videos/batch", cfg.handlerVideoBatch)
+	v1.HandleFunc("GET /api/v1/videos/{videoID}", cfg.handlerVideoGet, "/api/videos/{videoID}")
+	v1.HandleFunc("PATCH /api/v1/videos/{videoID}", cfg.middlewareAudit("video.update", "videoID", cfg.handlerVideoMetaUpdate), "/api/videos/{videoID}")
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/status", cfg.handlerVideoStatusGet, "/api/videos/{videoID}/status")
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/progress", cfg.handlerVideoProgress, "/api/videos/{videoID}/progress")
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/stream", cfg.handlerVideoStream)
+	v1.HandleFunc("GET /api/v1/hls/{key...}", cfg.handlerVideoHLSPlaylist)
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/download", cfg.handlerVideoDownload)
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/plays", cfg.middlewareRateLimit(cfg.playLimiter, cfg.handlerVideoPlayRecord))
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/stats", cfg.handlerVideoStats)
+	v1.HandleFunc("PUT /api/v1/videos/{videoID}/tags", cfg.middlewareAudit("video.tags_update", "videoID", cfg.handlerVideoTagsUpdate))
+	v1.HandleFunc("PUT /api/v1/videos/{videoID}/tags/{tag}", cfg.middlewareAudit("video.tag_add", "videoID", cfg.handlerVideoTagAdd))
+	v1.HandleFunc("DELETE /api/v1/videos/{videoID}/tags/{tag}", cfg.middlewareAudit("video.tag_remove", "videoID", cfg.handlerVideoTagRemove))
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/captions", cfg.middlewareAudit("caption.upload", "videoID", upload(cfg.handlerCaptionUpload)), "/api/videos/{videoID}/captions")
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/captions", cfg.handlerCaptionsRetrieve, "/api/videos/{videoID}/captions")
+	v1.HandleFunc("DELETE /api/v1/videos/{videoID}/captions/{language}", cfg.middlewareAudit("caption.delete", "videoID", cfg.handlerCaptionDelete), "/api/videos/{videoID}/captions/{language}")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/audio", cfg.middlewareAudit("audio.extract", "videoID", upload(cfg.handlerAudioExtract)), "/api/videos/{videoID}/audio")
+	v1.HandleFunc("DELETE /api/v1/videos/{videoID}", cfg.middlewareAudit("video.delete", "videoID", cfg.handlerVideoMetaDelete), "/api/videos/{videoID}")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/restore", cfg.middlewareAudit("video.restore", "videoID", cfg.handlerVideoRestore), "/api/videos/{videoID}/restore")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/archive", cfg.middlewareAudit("video.archive", "videoID", cfg.handlerVideoArchive), "/api/videos/{videoID}/archive")
+	v1.HandleFunc("GET /api/v1/videos/{videoID}/archive", cfg.handlerVideoArchiveGet, "/api/videos/{videoID}/archive")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/archive/restore", cfg.middlewareAudit("video.archive_restore", "videoID", cfg.handlerVideoArchiveRestore), "/api/videos/{videoID}/archive/restore")
+	v1.HandleFunc("GET /api/v1/trash", cfg.handlerTrashRetrieve, "/api/trash")
+	v1.HandleFunc("POST /api/v1/videos/{videoID}/share", cfg.middlewareAudit("share.create", "videoID", cfg.handlerShareLinkCreate), "/api/videos/{videoID}/share")
+	v1.HandleFunc("GET /api/v1/share/{token}", cfg.handlerShareLinkGet, "/api/share/{token}")
+	v1.HandleFunc("GET /api/v1/share/{token}/stream", cfg.handlerShareLinkStream)
+	v1.HandleFunc("DELETE /api/v1/share/{token}", cfg.middlewareAudit("share.revoke", "", cfg.handlerShareLinkRevoke), "/api/share/{token}")
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	v1.HandleFunc("GET /api/v1/events", cfg.handlerEvents)
+	v1.HandleFunc("POST /api/v1/webhooks", cfg.middlewareAudit("webhook.create", "", cfg.handlerWebhookCreate), "/api/webhooks")
+	v1.HandleFunc("GET /api/v1/webhooks", cfg.handlerWebhooksRetrieve, "/api/webhooks")
+	v1.HandleFunc("DELETE /api/v1/webhooks/{webhookID}", cfg.middlewareAudit("webhook.delete", "webhookID", cfg.handlerWebhookDelete), "/api/webhooks/{webhookID}")
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	v1.HandleFunc("GET /api/v1/openapi.json", handlerOpenAPIDocument, "/api/openapi.json")
+	if getEnvBool("OPENAPI_UI", false) {
+		v1.HandleFunc("GET /api/v1/docs", handlerOpenAPIDocs, "/api/docs")
+	}
+
+	if cfg.mediaConvert != nil {
+		v1.HandleFunc("POST /api/v1/mediaconvert/events", cfg.handlerMediaConvertEvents, "/api/mediaconvert/events")
+	}
+
+	v1.HandleFunc("POST /api/v1/admin/reset", cfg.middlewareAudit("admin.reset", "", cfg.handlerReset), "/admin/reset")
+	v1.HandleFunc("GET /api/v1/admin/users", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminUsersRetrieve), "/admin/users")
+	v1.HandleFunc("PUT /api/v1/admin/users/{userID}/role", cfg.middlewareAudit("user.role_update", "userID", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminUserRoleUpdate)), "/admin/users/{userID}/role")
+	v1.HandleFunc("POST /api/v1/admin/users/{userID}/tokens/revoke", cfg.middlewareAudit("user.tokens_revoke", "userID", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminUserTokensRevoke)))
+	v1.HandleFunc("PUT /api/v1/admin/users/{userID}/watermark", cfg.middlewareAudit("user.watermark_update", "userID", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminUserWatermarkUpdate)), "/admin/users/{userID}/watermark")
+	v1.HandleFunc("POST /api/v1/admin/assets/gc", cfg.middlewareAudit("admin.asset_gc", "", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminAssetGC)), "/admin/assets/gc")
+	v1.HandleFunc("POST /api/v1/admin/assets/tags", cfg.middlewareAudit("admin.tag_backfill", "", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminTagBackfill)), "/admin/assets/tags")
+	v1.HandleFunc("POST /api/v1/admin/import", cfg.middlewareAudit("admin.import", "", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminImport)), "/api/admin/import")
+	v1.HandleFunc("GET /api/v1/admin/usage", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminUsage), "/api/admin/usage")
+	v1.HandleFunc("POST /api/v1/admin/reconcile", cfg.middlewareAudit("admin.reconcile", "", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminReconcile)), "/api/admin/reconcile")
+	v1.HandleFunc("GET /api/v1/admin/moderation", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminModerationRetrieve))
+	v1.HandleFunc("PUT /api/v1/admin/videos/{videoID}/moderation", cfg.middlewareAudit("video.moderate", "videoID", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminVideoModerationUpdate)))
+	v1.HandleFunc("POST /api/v1/admin/videos/{videoID}/reprocess", cfg.middlewareAudit("video.reprocess", "videoID", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminVideoReprocess)), "/api/admin/videos/{videoID}/reprocess")
+	v1.HandleFunc("GET /api/v1/admin/jobs/dead", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminDeadJobsRetrieve), "/api/admin/jobs/dead")
+	v1.HandleFunc("POST /api/v1/admin/jobs/{jobID}/requeue", cfg.middlewareAudit("job.requeue", "jobID", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminJobRequeue)), "/api/admin/jobs/{jobID}/requeue")
+	v1.HandleFunc("GET /api/v1/admin/audit", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminAuditLog), "/api/admin/audit")
+	v1.HandleFunc("DELETE /api/v1/admin/jobs/{jobID}", cfg.middlewareAudit("job.discard", "jobID", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerAdminJobDiscard)), "/api/admin/jobs/{jobID}")
+
+	var legacy *legacyAPI
+	if getEnvBool("LEGACY_API_ENABLED", true) {
+		legacy = &legacyAPI{}
+		if value := os.Getenv("LEGACY_API_SUNSET"); value != "" {
+			legacy.sunset, err = time.Parse(time.DateOnly, value)
+			if err != nil {
+				log.Fatalf("LEGACY_API_SUNSET must be a date like 2006-01-02: %v", err)
+			}
+		}
+	}
+	v1.register(mux, legacy)
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("GET /metrics", promhttp.Handler())
 
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: requestIDMiddleware(metricsMiddleware(mux)),
 	}
+	// Progress streams only end on their own once a video is done, so they
+	// are closed for the server to drain.
+	srv.RegisterOnShutdown(cfg.progress.Close)
+	srv.RegisterOnShutdown(cfg.events.Close)
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.uploadEventsEnabled {
+		go cfg.consumeUploadEvents(ctx, sqs.NewFromConfig(s3Config), uploadEventsQueueURL)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving on: http://localhost:%s/app/\n", port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// In-flight uploads and processing jobs share one deadline. Jobs that
+	// don't finish in time are requeued and resume on the next start.
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	log.Printf("Shutting down, waiting up to %s for in-flight work", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Couldn't drain HTTP requests: %v", err)
+	}
+	if !cfg.remoteWorkers {
+		err = cfg.jobs.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("Interrupted running jobs: %v", err)
+		}
+	}
+	err = sched.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Interrupted scheduled tasks: %v", err)
+	}
+	if !cfg.remoteWorkers {
+		cfg.removeStaleTempFiles()
+	}
 }
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaconvert"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+// mediaConvertPrefix holds the source and outputs of a processing job while
+// MediaConvert works on them, until the video is moved into place.
+func mediaConvertPrefix(jobID uuid.UUID) string {
+	return path.Join("mediaconvert", jobID.String())
+}
+
+func (cfg *apiConfig) s3URL(key string) string {
+	return fmt.Sprintf("s3://%s/%s", cfg.s3Bucket, key)
+}
+
+// processVideoWithMediaConvert is processVideo for hosts without ffmpeg: the
+// source is transcoded by a MediaConvert job, which writes the MP4 and the
+// HLS ladder to the bucket. Thumbnails, sprites, previews, renditions and
+// watermarks need ffmpeg and aren't produced.
+func (cfg *apiConfig) processVideoWithMediaConvert(ctx context.Context, job database.Job, video database.Video, payload processVideoPayload) (_ database.Video, err error) {
+	previousKey := video.StorageKey
+	video.HLSURL = nil
+	video.SpriteURL = nil
+	video.SpriteVTTURL = nil
+	video.PreviewURL = nil
+
+	prefix := mediaConvertPrefix(job.ID)
+	defer func() {
+		// A job interrupted by shutdown resumes waiting for its MediaConvert
+		// job, which still needs the staged source.
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return
+		}
+		deleteErr := cfg.videoStorage.DeletePrefix(context.Background(), prefix+"/")
+		if deleteErr != nil {
+			log.Printf("Couldn't delete MediaConvert objects under %s: %v", prefix, deleteErr)
+		}
+	}()
+
+	sourceKey := payload.SourceKey
+	if payload.SourcePath != "" {
+		sourceKey = path.Join(prefix, "source")
+	} else if !payload.Reprocess {
+		start := time.Now()
+		checksum, err := cfg.checksumObject(ctx, sourceKey)
+		recordStage(ctx, "download", start)
+		if err != nil {
+			return video, err
+		}
+		err = verifyChecksum(payload.ExpectedChecksum, checksum)
+		if err != nil {
+			return video, err
+		}
+		video.ChecksumSHA256 = &checksum
+		if !payload.Scanned {
+			err = cfg.scanStagedSource(ctx, video.ID, sourceKey)
+			if err != nil {
+				return video, err
+			}
+		}
+	}
+
+	if video.ChecksumSHA256 != nil && !payload.Reprocess {
+		existing, err := cfg.db.GetProcessedVideoByChecksum(video.UserID, *video.ChecksumSHA256, video.ID)
+		if err != nil {
+			return video, fmt.Errorf("couldn't look up duplicate videos: %w", err)
+		}
+		if existing != nil {
+			return cfg.reuseProcessedVideo(ctx, video, *existing, "", previousKey)
+		}
+	}
+
+	mediaConvertID := cfg.runningMediaConvertJob(ctx, job)
+	if mediaConvertID == "" && payload.SourcePath != "" {
+		start := time.Now()
+		err = cfg.stageMediaConvertSource(ctx, sourceKey, payload)
+		recordStage(ctx, "stage_source", start)
+		if err != nil {
+			return video, err
+		}
+	}
+
+	start := time.Now()
+	spanCtx, span := tracer.Start(ctx, "video.probe")
+	metadata, hasAudio, err := cfg.mediaConvert.Probe(spanCtx, cfg.s3URL(sourceKey))
+	endSpan(span, err)
+	recordStage(ctx, "probe", start)
+	if err != nil {
+		return video, fmt.Errorf("couldn't probe video: %w", err)
+	}
+	var report validationReport
+	report.checkLimits(metadata, cfg.videoLimits)
+	if len(report.Issues) > 0 {
+		return video, fmt.Errorf("video rejected: %s", report.summary())
+	}
+
+	if mediaConvertID == "" {
+		spec := mediaconvert.JobSpec{
+			Token:                fmt.Sprintf("%s-%d", job.ID, job.Attempts),
+			Input:                cfg.s3URL(sourceKey),
+			HasAudio:             hasAudio,
+			Destination:          cfg.s3URL(path.Join(prefix, "video")),
+			Metadata:             map[string]string{"video_id": video.ID.String(), "job_id": job.ID.String()},
+			ServerSideEncryption: string(cfg.s3Encryption.Algorithm),
+			KMSKeyID:             cfg.s3Encryption.KMSKeyID,
+			StorageClass:         string(cfg.s3StorageClass),
+		}
+		if cfg.hlsEnabled {
+			spec.HLSDestination = cfg.s3URL(path.Join(cfg.hlsPrefix(video), strings.TrimSuffix(hlsMasterPlaylist, ".m3u8")))
+			// Matches the top of hlsLadder.
+			spec.HLSMaxRenditions = len(hlsLadder)
+			spec.HLSMaxBitrate = 5_000_000
+		}
+		mediaConvertID, err = cfg.mediaConvert.Submit(ctx, spec)
+		if err != nil {
+			return video, err
+		}
+		err = cfg.db.UpdateJobExternalID(job.ID, &mediaConvertID)
+		if err != nil {
+			log.Printf("Couldn't record MediaConvert job %s of job %s: %v", mediaConvertID, job.ID, err)
+		}
+	}
+
+	start = time.Now()
+	spanCtx, span = tracer.Start(ctx, "video.transcode")
+	result, err := cfg.mediaConvert.Wait(spanCtx, mediaConvertID, cfg.stageProgress(video.ID, progress.StageProcessing))
+	endSpan(span, err)
+	recordStage(ctx, "transcode", start)
+	if errors.Is(err, context.DeadlineExceeded) {
+		cancelErr := cfg.mediaConvert.Cancel(context.Background(), mediaConvertID)
+		if cancelErr != nil {
+			log.Printf("Couldn't cancel timed out MediaConvert job: %v", cancelErr)
+		}
+	}
+	if err != nil {
+		return video, err
+	}
+
+	if result.Width > 0 && result.Height > 0 {
+		metadata.Width = result.Width
+		metadata.Height = result.Height
+	}
+	if result.DurationSeconds > 0 {
+		metadata.DurationSeconds = result.DurationSeconds
+	}
+	metadata.AspectRatio = getVideoAspectRatio(metadata.Width, metadata.Height)
+	video.Metadata = &metadata
+
+	fileKey := cfg.objectKey(video.UserID, videoOrientation(metadata.Width, metadata.Height), getAssetPath("video/mp4"))
+	start = time.Now()
+	spanCtx, span = tracer.Start(ctx, "storage.copy")
+	err = cfg.copyVideoObject(spanCtx, path.Join(prefix, "video.mp4"), fileKey, "video/mp4")
+	endSpan(span, err)
+	recordStage(ctx, "upload", start)
+	if err != nil {
+		return video, err
+	}
+	var undo rollback
+	defer undo.run()
+	undo.add(cfg.deleteVideoObject(fileKey))
+
+	fileURL := cfg.videoStorage.URL(fileKey)
+	bucket := cfg.videoStorage.Bucket()
+	video.VideoURL = &fileURL
+	video.StorageBucket = &bucket
+	video.StorageKey = &fileKey
+	// The output never passes through the server, so its checksum is only
+	// recorded by a reconciliation.
+	video.ContentSHA256 = nil
+	if cfg.hlsEnabled {
+		hlsURL := cfg.videoStorage.URL(path.Join(cfg.hlsPrefix(video), hlsMasterPlaylist))
+		video.HLSURL = &hlsURL
+	}
+
+	video.ProcessingStatus = database.ProcessingStatusReady
+	video.ProcessingError = nil
+	cfg.holdForModeration(&video)
+	_, span = tracer.Start(ctx, "db.update")
+	err = cfg.db.SwapVideoMedia(video, previousKey)
+	endSpan(span, err)
+	if errors.Is(err, database.ErrVideoMediaChanged) {
+		return video, err
+	}
+	if err != nil {
+		return video, fmt.Errorf("couldn't update video: %w", err)
+	}
+	undo.commit()
+
+	// MediaConvert doesn't tag the objects it writes.
+	_, err = cfg.tagVideoObjects(ctx, video)
+	if err != nil {
+		log.Printf("Couldn't tag objects of video %s: %v", video.ID, err)
+	}
+
+	// The video has no renditions without ffmpeg, so any left from media
+	// processed before switching backends are dropped with it.
+	err = cfg.replaceVideoRenditions(ctx, video.ID, nil)
+	if err != nil {
+		log.Printf("Couldn't replace renditions of video %s: %v", video.ID, err)
+	}
+
+	return video, nil
+}
+
+// runningMediaConvertJob returns the ID of the MediaConvert job submitted by
+// an earlier attempt of job if it's still running, so it can be picked up
+// again. Otherwise it returns "" and a new one is submitted, since the outputs
+// of a finished job may already have been cleaned up.
+func (cfg *apiConfig) runningMediaConvertJob(ctx context.Context, job database.Job) string {
+	if job.ExternalID == nil {
+		return ""
+	}
+	running, err := cfg.mediaConvert.Status(ctx, *job.ExternalID)
+	if err != nil || !running {
+		return ""
+	}
+	log.Printf("Resuming MediaConvert job %s for job %s", *job.ExternalID, job.ID)
+	return *job.ExternalID
+}
+
+// stageMediaConvertSource uploads a local source to key, where MediaConvert
+// can read it.
+func (cfg *apiConfig) stageMediaConvertSource(ctx context.Context, key string, payload processVideoPayload) error {
+	source, err := os.Open(payload.SourcePath)
+	if err != nil {
+		return fmt.Errorf("couldn't open source: %w", err)
+	}
+	defer source.Close()
+
+	err = cfg.videoStorage.Put(ctx, key, source, payload.MediaType)
+	if err != nil {
+		return fmt.Errorf("couldn't stage source for MediaConvert: %w", err)
+	}
+	return nil
+}
+
+// checksumObject returns the SHA-256 digest of a stored object.
+func (cfg *apiConfig) checksumObject(ctx context.Context, key string) (string, error) {
+	object, err := cfg.videoStorage.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("couldn't download object %s: %w", key, err)
+	}
+	defer object.Close()
+
+	checksum, err := hashingCopy(io.Discard, object)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read object %s: %w", key, err)
+	}
+	return checksum, nil
+}
+
+// copyVideoObject copies an object within the bucket. S3 copies objects of
+// up to 5 GB in one request.
+func (cfg *apiConfig) copyVideoObject(ctx context.Context, srcKey, dstKey, contentType string) error {
+	segments := strings.Split(path.Join(cfg.s3Bucket, srcKey), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	_, err := cfg.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(cfg.s3Bucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(strings.Join(segments, "/")),
+		ContentType:       aws.String(contentType),
+		MetadataDirective: "REPLACE",
+		// Copies don't keep the source's encryption.
+		ServerSideEncryption: cfg.s3Encryption.Algorithm,
+		SSEKMSKeyId:          cfg.s3Encryption.KeyID(),
+		StorageClass:         cfg.s3StorageClass,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// handlerMediaConvertEvents receives MediaConvert job state changes, either
+// as EventBridge events or wrapped in SNS notifications. Events only prompt
+// waiting jobs to check on their MediaConvert job right away, so their
+// content doesn't need to be trusted.
+//
+//openapi:ignore
+func (cfg *apiConfig) handlerMediaConvertEvents(w http.ResponseWriter, r *http.Request) {
+	type snsMessage struct {
+		Type         string `json:"Type"`
+		Message      string `json:"Message"`
+		SubscribeURL string `json:"SubscribeURL"`
+	}
+	type event struct {
+		Detail struct {
+			JobID string `json:"jobId"`
+		} `json:"detail"`
+	}
+
+	if cfg.mediaConvertCallbackToken != "" {
+		token := r.URL.Query().Get("token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.mediaConvertCallbackToken)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Invalid callback token", nil)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 256<<10))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read event", err)
+		return
+	}
+
+	var message snsMessage
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode event", err)
+		return
+	}
+	switch message.Type {
+	case "SubscriptionConfirmation":
+		err = cfg.confirmSNSSubscription(r.Context(), message.SubscribeURL)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't confirm subscription", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "Notification":
+		body = []byte(message.Message)
+	}
+
+	var e event
+	err = json.Unmarshal(body, &e)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode event", err)
+		return
+	}
+	if e.Detail.JobID != "" {
+		cfg.mediaConvert.Notify(e.Detail.JobID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// confirmSNSSubscription visits the confirmation URL of a new SNS
+// subscription. Only SNS endpoints in the configured region are visited.
+func (cfg *apiConfig) confirmSNSSubscription(ctx context.Context, subscribeURL string) error {
+	parsed, err := url.Parse(subscribeURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" || parsed.Host != fmt.Sprintf("sns.%s.amazonaws.com", cfg.s3Region) {
+		return fmt.Errorf("unexpected subscription URL %q", subscribeURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("subscription confirmation returned %s", resp.Status)
+	}
+	log.Printf("Confirmed SNS subscription for MediaConvert events")
+	return nil
+}
+
+// disableFFmpegFeatures turns off the features that need ffmpeg on the host,
+// which MediaConvert deployments may not have.
+func (cfg *apiConfig) disableFFmpegFeatures() {
+	features := []struct {
+		name    string
+		enabled *bool
+	}{
+		{"AUTO_THUMBNAIL_ENABLED", &cfg.autoThumbnailEnabled},
+		{"SPRITES_ENABLED", &cfg.spritesEnabled},
+		{"PREVIEWS_ENABLED", &cfg.previewsEnabled},
+		{"RENDITIONS_ENABLED", &cfg.renditionsEnabled},
+	}
+	for _, feature := range features {
+		if *feature.enabled {
+			log.Printf("%s isn't supported with MediaConvert, disabling it", feature.name)
+			*feature.enabled = false
+		}
+	}
+	if cfg.transcriber != nil {
+		log.Printf("TRANSCRIPTION_BACKEND isn't supported with MediaConvert, disabling it")
+		cfg.transcriber = nil
+	}
+	if cfg.moderationEnabled() && cfg.moderationFrames > 0 {
+		log.Printf("MODERATION_FRAMES isn't supported with MediaConvert, only moderating thumbnails")
+		cfg.moderationFrames = 0
+	}
+	if os.Getenv("WATERMARK_PATH") != "" {
+		log.Printf("WATERMARK_PATH isn't supported with MediaConvert, disabling it")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	jobTypeModerateVideo = "moderate_video"
+
+	// moderationFrameWidth is wide enough for moderation to tell what a
+	// frame shows, while keeping frames well below moderation.MaxImageSize.
+	moderationFrameWidth = 640
+	// moderationThumbnailWidth is what thumbnails too large to be moderated
+	// are scaled down to.
+	moderationThumbnailWidth = 1920
+)
+
+type moderateVideoPayload struct {
+	VideoID      uuid.UUID         `json:"video_id"`
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// moderationJobReference keeps moderation jobs apart from the processing
+// jobs of the same video, which are referenced by its bare ID.
+func moderationJobReference(videoID uuid.UUID) string {
+	return "moderation:" + videoID.String()
+}
+
+// moderationEnabled reports whether a moderation backend is configured.
+// Without one, videos are published without being held for moderation.
+func (cfg *apiConfig) moderationEnabled() bool {
+	_, noop := cfg.moderator.(moderation.Noop)
+	return !noop
+}
+
+// holdForModeration marks a video whose media is about to be swapped as
+// awaiting moderation, so that the status is written along with the new
+// media and it's never visible to others before it's scanned.
+func (cfg *apiConfig) holdForModeration(video *database.Video) {
+	if cfg.moderationEnabled() {
+		video.ModerationStatus = database.ModerationStatusPending
+	}
+}
+
+// enqueueModeration hides a video from everyone but its owner until its
+// thumbnail and frames are scanned, and queues the scan. It's a no-op when
+// moderation isn't enabled.
+func (cfg *apiConfig) enqueueModeration(ctx context.Context, video *database.Video) error {
+	if !cfg.moderationEnabled() {
+		return nil
+	}
+	err := cfg.db.UpdateVideoModeration(video.ID, database.ModerationStatusPending, video.ModerationLabels)
+	if err != nil {
+		return fmt.Errorf("couldn't hold video for moderation: %w", err)
+	}
+	video.ModerationStatus = database.ModerationStatusPending
+
+	_, err = cfg.jobs.Enqueue(jobTypeModerateVideo, moderationJobReference(video.ID), moderateVideoPayload{
+		VideoID:      video.ID,
+		TraceContext: injectTraceContext(ctx),
+	})
+	return err
+}
+
+func (cfg *apiConfig) runModerateVideoJob(ctx context.Context, job database.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.processingTimeout)
+	defer cancel()
+
+	var payload moderateVideoPayload
+	err = json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("couldn't decode job payload: %w", err)
+	}
+
+	ctx, span := tracer.Start(extractTraceContext(ctx, payload.TraceContext), "video.moderate", trace.WithAttributes(
+		attribute.String("video.id", payload.VideoID.String()),
+		attribute.Int("job.attempt", job.Attempts),
+	))
+	defer func() { endSpan(span, err) }()
+
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		log.Printf("Video %s was deleted, skipping moderation", payload.VideoID)
+		return nil
+	}
+	// An admin may have decided already, or another scan finished first.
+	if video.ModerationStatus != database.ModerationStatusPending {
+		log.Printf("Video %s isn't awaiting moderation, skipping it", video.ID)
+		return nil
+	}
+	ctx = withVideoTags(ctx, video)
+
+	labels, err := cfg.moderateVideo(ctx, video)
+	if err != nil {
+		return err
+	}
+	status := database.ModerationStatusApproved
+	if len(labels) > 0 {
+		status = database.ModerationStatusReview
+	}
+
+	err = cfg.db.CompleteVideoModeration(video, status, labels)
+	if errors.Is(err, database.ErrVideoMediaChanged) {
+		log.Printf("Video %s changed while it was scanned, leaving it to the next scan", video.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't record moderation of video: %w", err)
+	}
+	video.ModerationStatus = status
+	video.ModerationLabels = labels
+
+	if status == database.ModerationStatusReview {
+		log.Printf("Video %s was flagged for review: %d labels", video.ID, len(labels))
+		cfg.publishEvent(video.UserID, eventModerationFlagged, video)
+		return nil
+	}
+	cfg.publishEvent(video.UserID, eventModerationApproved, video)
+	return nil
+}
+
+// moderateVideo scans the video's thumbnail and frames sampled evenly across
+// it, and returns everything flagged in any of them. Archived videos have
+// only their thumbnail scanned, since their frames can't be read.
+func (cfg *apiConfig) moderateVideo(ctx context.Context, video database.Video) (database.ModerationLabels, error) {
+	labels := database.ModerationLabels{}
+
+	if video.ThumbnailURL != nil {
+		image, ok, err := cfg.moderationThumbnail(ctx, *video.ThumbnailURL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found, err := cfg.moderator.Moderate(ctx, image)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't moderate thumbnail: %w", err)
+			}
+			labels = appendModerationLabels(labels, found, "thumbnail")
+		}
+	}
+
+	if cfg.moderationFrames <= 0 || video.StorageKey == nil || !video.ArchiveStatus.Playable() {
+		return labels, nil
+	}
+	if video.Metadata == nil || video.Metadata.DurationSeconds <= 0 {
+		return labels, nil
+	}
+	// Like thumbnail candidates, frames are taken from the middle of evenly
+	// sized slices of the video.
+	step := video.Metadata.DurationSeconds / float64(cfg.moderationFrames)
+	for i := 0; i < cfg.moderationFrames; i++ {
+		timestamp := step * (float64(i) + 0.5)
+		frame, err := cfg.extractStoredFrame(ctx, video, timestamp, moderationFrameWidth)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't extract frame at %.1fs: %w", timestamp, err)
+		}
+		found, err := cfg.moderator.Moderate(ctx, frame)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't moderate frame at %.1fs: %w", timestamp, err)
+		}
+		labels = appendModerationLabels(labels, found, fmt.Sprintf("frame at %.1fs", timestamp))
+	}
+	return labels, nil
+}
+
+// moderationThumbnail reads a thumbnail to moderate, scaled down if it's
+// too large for the moderator. It reports false for thumbnails that aren't
+// in the thumbnail storage.
+func (cfg *apiConfig) moderationThumbnail(ctx context.Context, thumbnailURL string) ([]byte, bool, error) {
+	key, ok := cfg.thumbnailStorage.KeyFromURL(thumbnailURL)
+	if !ok {
+		return nil, false, nil
+	}
+	object, err := cfg.thumbnailStorage.Get(ctx, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't get thumbnail: %w", err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't read thumbnail: %w", err)
+	}
+	if len(data) <= moderation.MaxImageSize {
+		return data, true, nil
+	}
+
+	img, err := imaging.Decode(data, mime.TypeByExtension(path.Ext(key)))
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't decode thumbnail: %w", err)
+	}
+	if img.Bounds().Dx() > moderationThumbnailWidth {
+		img = imaging.ResizeToWidth(img, moderationThumbnailWidth)
+	}
+	data, err = imaging.Encode(img, "image/jpeg")
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't encode thumbnail: %w", err)
+	}
+	return data, true, nil
+}
+
+func appendModerationLabels(labels database.ModerationLabels, found []moderation.Label, source string) database.ModerationLabels {
+	for _, label := range found {
+		labels = append(labels, database.ModerationLabel{
+			Name:       label.Name,
+			Confidence: label.Confidence,
+			Source:     source,
+		})
+	}
+	return labels
+}
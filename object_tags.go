@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// videoTags identify the objects stored for a video and its owner, so
+// lifecycle rules, cost allocation and incident response can find everything
+// stored for either.
+func videoTags(video database.Video) storage.Tags {
+	return storage.Tags{
+		"user_id":  video.UserID.String(),
+		"video_id": video.ID.String(),
+	}
+}
+
+// withVideoTags returns a context whose stored objects get the video's tags.
+func withVideoTags(ctx context.Context, video database.Video) context.Context {
+	return storage.WithTags(ctx, videoTags(video))
+}
+
+// videoObjectKeys returns the keys of the S3 objects stored for a video: its
+// MP4 and previous versions, its renditions, HLS files, captions, extracted
+// audio, sprites and preview clip, and its thumbnails when they're kept in
+// S3 too.
+func (cfg *apiConfig) videoObjectKeys(ctx context.Context, video database.Video) ([]string, error) {
+	var keys []string
+	if video.StorageKey != nil && (video.StorageBucket == nil || *video.StorageBucket == cfg.s3Bucket) {
+		keys = append(keys, *video.StorageKey)
+	}
+
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get video versions: %w", err)
+	}
+	for _, version := range versions {
+		if version.StorageBucket == cfg.s3Bucket {
+			keys = append(keys, version.StorageKey)
+		}
+	}
+
+	renditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get video renditions: %w", err)
+	}
+	for _, rendition := range renditions {
+		if rendition.StorageBucket == cfg.s3Bucket {
+			keys = append(keys, rendition.StorageKey)
+		}
+	}
+
+	prefixes := append(cfg.videoAssetPrefixes(video, aspectCaptions), cfg.videoAssetPrefixes(video, aspectAudio)...)
+	if video.HLSURL != nil {
+		manifestKey, ok := cfg.videoStorage.KeyFromURL(*video.HLSURL)
+		if ok {
+			prefixes = append(prefixes, path.Dir(manifestKey)+"/")
+		}
+	}
+	for _, prefix := range prefixes {
+		objects, err := cfg.videoStorage.List(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list objects under %s: %w", prefix, err)
+		}
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+	}
+
+	for _, url := range []*string{video.SpriteURL, video.SpriteVTTURL, video.PreviewURL} {
+		if url == nil {
+			continue
+		}
+		key, ok := cfg.videoStorage.KeyFromURL(*url)
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+
+	if cfg.thumbnailStorageName == "s3" {
+		for _, thumbnailURL := range thumbnailURLs(video) {
+			key, ok := cfg.thumbnailStorage.KeyFromURL(thumbnailURL)
+			if ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// tagVideoObjects tags the objects stored for a video that aren't tagged
+// yet, returning how many it tagged. Objects shared by deduplicated uploads
+// keep the tags of the video that stored them.
+func (cfg *apiConfig) tagVideoObjects(ctx context.Context, video database.Video) (int, error) {
+	keys, err := cfg.videoObjectKeys(ctx, video)
+	if err != nil {
+		return 0, err
+	}
+
+	tags := videoTags(video)
+	tagged := 0
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		added, err := cfg.s3Objects.AddObjectTags(ctx, key, tags)
+		if err != nil {
+			return tagged, err
+		}
+		if added {
+			tagged++
+		}
+	}
+	return tagged, nil
+}
+
+// handlerAdminTagBackfill tags the objects of every video stored before
+// objects were tagged on upload.
+//
+//openapi:summary Tag stored objects with their video and user
+//openapi:tags admin
+//openapi:response 200 response
+func (cfg *apiConfig) handlerAdminTagBackfill(w http.ResponseWriter, r *http.Request) {
+	type failure struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Error   string    `json:"error"`
+	}
+	type response struct {
+		Videos        int       `json:"videos"`
+		TaggedObjects int       `json:"tagged_objects"`
+		Failures      []failure `json:"failures"`
+	}
+
+	if cfg.videoStorageName != "s3" {
+		respondWithError(w, http.StatusNotImplemented, "Tagging requires S3 video storage", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+
+	resp := response{Videos: len(videos), Failures: []failure{}}
+	for _, video := range videos {
+		tagged, err := cfg.tagVideoObjects(r.Context(), video)
+		resp.TaggedObjects += tagged
+		if err != nil {
+			log.Printf("Couldn't tag objects of video %s: %v", video.ID, err)
+			resp.Failures = append(resp.Failures, failure{VideoID: video.ID, Error: err.Error()})
+		}
+	}
+	log.Printf("Tag backfill tagged %d objects of %d videos, %d failed", resp.TaggedObjects, resp.Videos, len(resp.Failures))
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+)
+
+//go:generate go run ./internal/openapi/gen -out openapi.json
+
+// openAPIDocument describes the API. It's generated from the routes and the
+// //openapi: directives on their handlers, so run go generate after changing
+// either; go test fails while it's out of date.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// swaggerUIVersion is the version of Swagger UI the docs page loads.
+const swaggerUIVersion = "5.17.14"
+
+//openapi:ignore
+func handlerOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(openAPIDocument)
+	if err != nil {
+		log.Printf("Error writing to the HTTP response: %s", err)
+	}
+}
+
+// handlerOpenAPIDocs serves Swagger UI for the document, loaded from a CDN.
+//
+//openapi:ignore
+func handlerOpenAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := w.Write([]byte(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Tubely API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js"></script>
+  <script>
+    SwaggerUIBundle({ url: "/api/v1/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`))
+	if err != nil {
+		log.Printf("Error writing to the HTTP response: %s", err)
+	}
+}
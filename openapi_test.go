@@ -0,0 +1,18 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+func TestOpenAPIDocumentUpToDate(t *testing.T) {
+	doc, err := openapi.Generate(".", openapi.APIOptions)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !bytes.Equal(doc, openAPIDocument) {
+		t.Fatal("openapi.json is out of date, run go generate")
+	}
+}
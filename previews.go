@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// previewDuration is the length in seconds of the looping hover preview.
+	previewDuration = 4.0
+	previewWidth    = 480
+)
+
+// generatePreview cuts a short, silent, downscaled MP4 clip from the video at
+// filePath, starting where the automatic thumbnail is taken, and returns the
+// path of a new temp file. The caller must remove it.
+func generatePreview(ctx context.Context, filePath string, metadata database.VideoMetadata) (string, error) {
+	if metadata.DurationSeconds <= 0 {
+		return "", fmt.Errorf("can't generate a preview for a video without a duration")
+	}
+	length := math.Min(previewDuration, metadata.DurationSeconds)
+	start := math.Min(metadata.DurationSeconds*autoThumbnailPosition, metadata.DurationSeconds-length)
+
+	previewTmp, err := os.CreateTemp("", "tubely-preview*.mp4")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	previewTmp.Close()
+
+	cmd := exec.CommandContext(
+		ctx,
+		ffmpegBinary,
+		"-y",
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(length),
+		"-i", filePath,
+		"-an",
+		"-vf", fmt.Sprintf("scale=%d:-2", previewWidth),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "28",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "faststart",
+		"-f", "mp4",
+		previewTmp.Name(),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = runLimited(ctx, cmd)
+	if err != nil {
+		os.Remove(previewTmp.Name())
+		return "", fmt.Errorf("error generating preview clip: %s, %v", stderr.String(), err)
+	}
+	return previewTmp.Name(), nil
+}
+
+// generateAndUploadPreview renders the hover preview for the video at
+// filePath and stores it under the previews/ prefix.
+func (cfg *apiConfig) generateAndUploadPreview(ctx context.Context, video *database.Video, filePath string) error {
+	if video.Metadata == nil {
+		return fmt.Errorf("video metadata is missing")
+	}
+	previewPath, err := generatePreview(ctx, filePath, *video.Metadata)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(previewPath)
+
+	file, err := os.Open(previewPath)
+	if err != nil {
+		return fmt.Errorf("couldn't open preview clip: %w", err)
+	}
+	defer file.Close()
+
+	key := cfg.previewKey(*video)
+	err = cfg.videoStorage.Put(ctx, key, file, "video/mp4")
+	if err != nil {
+		return fmt.Errorf("couldn't upload preview clip: %w", err)
+	}
+
+	previewURL := cfg.videoStorage.URL(key)
+	video.PreviewURL = &previewURL
+	return nil
+}
+
+func (cfg *apiConfig) previewKey(video database.Video) string {
+	return cfg.objectKey(video.UserID, aspectPreviews, video.ID.String()+".mp4")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+var (
+	errUnreadableVideo = errors.New("ffprobe couldn't read the file")
+	errNoVideoStream   = errors.New("no video streams found")
+)
+
+// probeVideo runs ffprobe on filePath and extracts the metadata stored on
+// the video record.
+func probeVideo(ctx context.Context, filePath string) (database.VideoMetadata, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		ffprobeBinary,
+		"-v",
+		"error",
+		"-print_format",
+		"json",
+		"-show_streams",
+		"-show_format",
+		filePath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := runLimited(ctx, cmd)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return database.VideoMetadata{}, fmt.Errorf("%w: %s", errUnreadableVideo, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return database.VideoMetadata{}, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width,omitempty"`
+			Height       int    `json:"height,omitempty"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			Tags         struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideDataList []struct {
+				SideDataType string  `json:"side_data_type"`
+				Rotation     float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	err = json.Unmarshal(stdout.Bytes(), &probe)
+	if err != nil {
+		return database.VideoMetadata{}, fmt.Errorf("Couldn't parse ffprobe output: %v", err)
+	}
+
+	var metadata database.VideoMetadata
+	foundVideo := false
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if foundVideo {
+				continue
+			}
+			foundVideo = true
+			metadata.VideoCodec = stream.CodecName
+			metadata.FrameRate = parseFrameRate(stream.AvgFrameRate)
+
+			// Older muxers store the rotation as a tag, newer ones as a
+			// display matrix.
+			rotation, _ := strconv.ParseFloat(stream.Tags.Rotate, 64)
+			for _, sideData := range stream.SideDataList {
+				if sideData.SideDataType == "Display Matrix" {
+					rotation = sideData.Rotation
+				}
+			}
+			metadata.Rotation = normalizeRotation(rotation)
+
+			metadata.Width = stream.Width
+			metadata.Height = stream.Height
+			if metadata.Rotation == 90 || metadata.Rotation == 270 {
+				metadata.Width, metadata.Height = metadata.Height, metadata.Width
+			}
+			metadata.AspectRatio = getVideoAspectRatio(metadata.Width, metadata.Height)
+		case "audio":
+			if metadata.AudioCodec == "" {
+				metadata.AudioCodec = stream.CodecName
+			}
+		}
+	}
+	if !foundVideo {
+		return database.VideoMetadata{}, errNoVideoStream
+	}
+
+	metadata.DurationSeconds, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	metadata.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	return metadata, nil
+}
+
+// normalizeRotation rounds a rotation in degrees to the nearest quarter turn
+// in [0, 360).
+func normalizeRotation(degrees float64) int {
+	quarters := int(math.Round(degrees / 90))
+	return ((quarters % 4) + 4) % 4 * 90
+}
+
+// getVideoAspectRatio reduces width:height to lowest terms, e.g. "16:9" for
+// 1920x1080, or returns "" if either is unknown.
+func getVideoAspectRatio(width, height int) string {
+	if width <= 0 || height <= 0 {
+		return ""
+	}
+	d := gcd(width, height)
+	return fmt.Sprintf("%d:%d", width/d, height/d)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// videoOrientation classifies the display dimensions of a video, which also
+// name the key prefix its media is stored under.
+func videoOrientation(width, height int) string {
+	switch {
+	case width <= 0 || height <= 0 || width == height:
+		return "other"
+	case width > height:
+		return "landscape"
+	default:
+		return "portrait"
+	}
+}
+
+// parseFrameRate converts ffprobe's rational frame rate (e.g. "30000/1001")
+// to frames per second.
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		fps, _ := strconv.ParseFloat(rate, 64)
+		return fps
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const jobTypeProcessVideo = "process_video"
+
+// processVideoPayload describes where the source of a processing job lives:
+// either a local temp file or a staging object in the bucket.
+type processVideoPayload struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	SourcePath string    `json:"source_path,omitempty"`
+	SourceKey  string    `json:"source_key,omitempty"`
+	MediaType  string    `json:"media_type"`
+	// ExpectedChecksum is the client-declared SHA-256 of a staged source,
+	// verified once it has been downloaded.
+	ExpectedChecksum string `json:"expected_checksum,omitempty"`
+	// Checksum is the SHA-256 of a local source, computed while it was
+	// received. It's only recorded on the video once the media is swapped,
+	// so a pending replacement isn't matched by deduplication.
+	Checksum string `json:"checksum,omitempty"`
+	// Filename is the name of the uploaded file, recorded on the video along
+	// with its media.
+	Filename string `json:"filename,omitempty"`
+	// TraceContext links the processing trace to the upload request.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	// Reprocess is set when the source is the video's own stored object,
+	// which is processed again as is: it's kept afterwards, the checksum of
+	// the original upload stays, and duplicates aren't reused.
+	Reprocess bool `json:"reprocess,omitempty"`
+	// RegenerateThumbnail replaces the thumbnail of a reprocessed video with
+	// a generated one.
+	RegenerateThumbnail bool `json:"regenerate_thumbnail,omitempty"`
+	// Scanned is set when the source was scanned for viruses as it was
+	// received. Other staged sources, such as direct uploads, are scanned
+	// once they're downloaded.
+	Scanned bool `json:"scanned,omitempty"`
+}
+
+func (cfg *apiConfig) registerJobHandlers() {
+	cfg.jobs.Register(jobTypeProcessVideo, jobs.Handler{
+		Run:    cfg.runProcessVideoJob,
+		Failed: cfg.failProcessVideoJob,
+	})
+	cfg.jobs.Register(jobTypeFetchVideo, jobs.Handler{
+		Run:    cfg.runFetchVideoJob,
+		Failed: cfg.failProcessVideoJob,
+	})
+	cfg.jobs.Register(jobTypeTranscribeVideo, jobs.Handler{
+		Run: cfg.runTranscribeVideoJob,
+	})
+	cfg.jobs.Register(jobTypeModerateVideo, jobs.Handler{
+		Run: cfg.runModerateVideoJob,
+	})
+	cfg.jobs.Register(jobTypeDeliverWebhook, jobs.Handler{
+		Run: cfg.runDeliverWebhookJob,
+	})
+}
+
+// enqueueVideoProcessing marks the video as pending and queues it for
+// processing by the worker pool. Only the processing status is written, so
+// edits made since the video was read are kept.
+func (cfg *apiConfig) enqueueVideoProcessing(ctx context.Context, video database.Video, payload processVideoPayload) (database.Video, error) {
+	video.ProcessingStatus = database.ProcessingStatusPending
+	video.ProcessingError = nil
+	err := cfg.db.UpdateVideoProcessingStatus(video.ID, video.ProcessingStatus, nil)
+	if err != nil {
+		return video, fmt.Errorf("couldn't update video: %w", err)
+	}
+
+	payload.VideoID = video.ID
+	payload.TraceContext = injectTraceContext(ctx)
+	_, err = cfg.jobs.Enqueue(jobTypeProcessVideo, video.ID.String(), payload)
+	if err != nil {
+		return video, err
+	}
+	cfg.progress.Publish(video.ID, progress.Update{Stage: progress.StageQueued})
+	return video, nil
+}
+
+func (cfg *apiConfig) runProcessVideoJob(ctx context.Context, job database.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.processingTimeout)
+	defer cancel()
+
+	var payload processVideoPayload
+	err = json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("couldn't decode job payload: %w", err)
+	}
+
+	ctx, span := tracer.Start(extractTraceContext(ctx, payload.TraceContext), "video.process", trace.WithAttributes(
+		attribute.String("video.id", payload.VideoID.String()),
+		attribute.Int("job.attempt", job.Attempts),
+	))
+	defer func() { endSpan(span, err) }()
+
+	ctx, timings := withStageTimings(ctx)
+	defer func() { cfg.saveStageTimings(job, timings, err) }()
+
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		log.Printf("Video %s was deleted, skipping processing", payload.VideoID)
+		cfg.cleanupProcessVideoSource(payload)
+		return nil
+	}
+	ctx = withVideoTags(ctx, video)
+	defer cfg.recordProcessingTime(video.UserID, time.Now())
+
+	err = cfg.db.UpdateVideoProcessingStatus(video.ID, database.ProcessingStatusProcessing, nil)
+	if err != nil {
+		log.Printf("Couldn't mark video %s as processing: %v", video.ID, err)
+	}
+	cfg.progress.Publish(video.ID, progress.Update{Stage: progress.StageProcessing})
+
+	if payload.Checksum != "" {
+		video.ChecksumSHA256 = &payload.Checksum
+	}
+	if payload.Filename != "" {
+		video.OriginalFilename = &payload.Filename
+	}
+
+	previous := video
+	if cfg.mediaConvert != nil {
+		video, err = cfg.processVideoWithMediaConvert(ctx, job, video, payload)
+	} else {
+		video, err = cfg.processVideoSource(ctx, video, payload)
+	}
+	if errors.Is(err, database.ErrVideoMediaChanged) {
+		log.Printf("Video %s was replaced while processing, discarding this upload", video.ID)
+		cfg.cleanupProcessVideoSource(payload)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = cfg.recordVideoVersion(ctx, previous, video)
+	if err != nil {
+		log.Printf("Couldn't record version of video %s: %v", video.ID, err)
+	}
+	cfg.deleteReplacedMedia(ctx, previous, video)
+	if payload.RegenerateThumbnail && !slices.Equal(thumbnailURLs(previous), thumbnailURLs(video)) {
+		cfg.deleteThumbnail(ctx, previous)
+	}
+	cfg.cleanupProcessVideoSource(payload)
+	cfg.progress.Publish(video.ID, progress.Update{Stage: progress.StageReady, Percent: 100})
+	cfg.publishEvent(video.UserID, eventUploadCompleted, video)
+
+	err = cfg.enqueueTranscription(ctx, video)
+	if err != nil {
+		log.Printf("Couldn't queue transcription of video %s: %v", video.ID, err)
+	}
+	err = cfg.enqueueModeration(ctx, &video)
+	if err != nil {
+		log.Printf("Couldn't queue moderation of video %s: %v", video.ID, err)
+	}
+	return nil
+}
+
+// processVideoSource downloads a staged source, if needed, and processes it
+// with ffmpeg.
+func (cfg *apiConfig) processVideoSource(ctx context.Context, video database.Video, payload processVideoPayload) (database.Video, error) {
+	srcPath := payload.SourcePath
+	if srcPath == "" {
+		start := time.Now()
+		downloaded, checksum, err := cfg.downloadObjectToTemp(ctx, payload.SourceKey)
+		recordStage(ctx, "download", start)
+		if err != nil {
+			return video, err
+		}
+		defer os.Remove(downloaded)
+
+		err = verifyChecksum(payload.ExpectedChecksum, checksum)
+		if err != nil {
+			return video, err
+		}
+		if !payload.Scanned && !payload.Reprocess {
+			err = cfg.scanSourceFile(ctx, video.ID, downloaded)
+			if err != nil {
+				return video, err
+			}
+		}
+		srcPath = downloaded
+		if !payload.Reprocess {
+			video.ChecksumSHA256 = &checksum
+		}
+	}
+	if payload.RegenerateThumbnail {
+		video.ThumbnailURL = nil
+		video.ThumbnailSrcset = nil
+	}
+	return cfg.processVideo(ctx, video, srcPath, payload.MediaType, !payload.Reprocess)
+}
+
+// saveStageTimings records the stage timings of a processing attempt on its
+// job and logs them.
+func (cfg *apiConfig) saveStageTimings(job database.Job, timings *stageTimings, runErr error) {
+	all := timings.all()
+	if len(all) == 0 {
+		return
+	}
+	result := "succeeded"
+	if runErr != nil {
+		result = "failed"
+	}
+	log.Printf("Processing job %s (attempt %d) %s: %s", job.ID, job.Attempts, result, timings)
+
+	err := cfg.db.UpdateJobTimings(job.ID, all)
+	if err != nil {
+		log.Printf("Couldn't save timings of job %s: %v", job.ID, err)
+	}
+}
+
+func (cfg *apiConfig) failProcessVideoJob(job database.Job, cause error) {
+	var payload processVideoPayload
+	err := json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		log.Printf("Couldn't decode payload of job %s: %v", job.ID, err)
+		return
+	}
+
+	// The source is kept while the job is dead, so it can be requeued.
+	// Discarding the job deletes it.
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		log.Printf("Couldn't get video %s: %v", payload.VideoID, err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		return
+	}
+
+	// A failed replacement leaves the previous media in place, so the video
+	// stays playable and only reports the error.
+	status := database.ProcessingStatusFailed
+	if video.StorageKey != nil {
+		status = database.ProcessingStatusReady
+	}
+	msg := cause.Error()
+	err = cfg.db.UpdateVideoProcessingStatus(video.ID, status, &msg)
+	if err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", video.ID, err)
+	}
+	video.ProcessingStatus = status
+	video.ProcessingError = &msg
+	cfg.progress.Publish(video.ID, progress.Update{Stage: progress.StageFailed, Error: msg})
+	cfg.publishEvent(video.UserID, eventProcessingFailed, video)
+}
+
+func (cfg *apiConfig) cleanupProcessVideoSource(payload processVideoPayload) {
+	if payload.SourcePath != "" {
+		os.Remove(payload.SourcePath)
+	}
+	if payload.SourceKey != "" && !payload.Reprocess {
+		err := cfg.videoStorage.Delete(context.Background(), payload.SourceKey)
+		if err != nil {
+			log.Printf("Couldn't delete staging object %s: %v", payload.SourceKey, err)
+		}
+	}
+}
+
+// downloadObjectToTemp copies a stored video object into a new temp file and
+// returns its path and SHA-256 digest. The caller is responsible for removing
+// the file.
+func (cfg *apiConfig) downloadObjectToTemp(ctx context.Context, key string) (string, string, error) {
+	object, err := cfg.videoStorage.Get(ctx, key)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't download object %s: %w", key, err)
+	}
+	defer object.Close()
+
+	fileTmp, err := os.CreateTemp("", "tubely-upload.mp4")
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer fileTmp.Close()
+
+	checksum, err := hashingCopy(fileTmp, object)
+	if err != nil {
+		os.Remove(fileTmp.Name())
+		return "", "", fmt.Errorf("couldn't save object %s to disk: %w", key, err)
+	}
+	return fileTmp.Name(), checksum, nil
+}
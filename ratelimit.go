@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// middlewareRateLimit limits requests per authenticated user, falling back
+// to the client IP for anonymous requests or invalid tokens.
+func (cfg *apiConfig) middlewareRateLimit(limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := limiter.Allow(cfg.rateLimitKey(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			respondWithErrorCode(w, http.StatusTooManyRequests, errCodeRateLimited, "Too many requests", nil, nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (cfg *apiConfig) rateLimitKey(r *http.Request) string {
+	token, err := auth.GetBearerToken(r.Header)
+	if err == nil {
+		userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+		if err == nil {
+			return "user:" + userID.String()
+		}
+	}
+	return "ip:" + limitKey(clientIP(r))
+}
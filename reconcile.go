@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+type reconcileReport struct {
+	assetGCReport
+	ChecksumsVerified  bool               `json:"checksums_verified"`
+	ChecksumMismatches []checksumMismatch `json:"checksum_mismatches"`
+	// ChecksumsRecorded counts the objects stored without a checksum that a
+	// repair recorded one for.
+	ChecksumsRecorded int `json:"checksums_recorded"`
+}
+
+// checksumMismatch is a stored video or version whose content no longer
+// matches the checksum recorded when it was stored.
+type checksumMismatch struct {
+	VideoID   uuid.UUID  `json:"video_id"`
+	VersionID *uuid.UUID `json:"version_id,omitempty"`
+	Key       string     `json:"key"`
+	Expected  string     `json:"expected"`
+	Actual    string     `json:"actual"`
+}
+
+// reconcile compares the storage backends and the assets directory against
+// the database. Unless repair is set it only reports. A repair deletes
+// orphaned objects, clears references to missing ones and records the
+// checksums of objects stored without one; mismatched checksums are only
+// reported, since there's no telling which side is right. Verifying
+// checksums downloads every stored video, so it's opt-in.
+func (cfg *apiConfig) reconcile(ctx context.Context, repair, verifyChecksums bool) (reconcileReport, error) {
+	gc, err := cfg.collectAssetGarbage(ctx, !repair, cfg.assetGCMinAge, true)
+	report := reconcileReport{
+		assetGCReport:      gc,
+		ChecksumsVerified:  verifyChecksums,
+		ChecksumMismatches: []checksumMismatch{},
+	}
+	if err != nil || !verifyChecksums {
+		return report, err
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return report, fmt.Errorf("couldn't get videos: %w", err)
+	}
+	versions, err := cfg.db.GetAllVideoVersions()
+	if err != nil {
+		return report, fmt.Errorf("couldn't get video versions: %w", err)
+	}
+
+	bucket := cfg.videoStorage.Bucket()
+	// Versions usually share the object of their video, which is only
+	// downloaded once.
+	digests := map[string]string{}
+	digest := func(key string) (string, bool, error) {
+		if sum, ok := digests[key]; ok {
+			return sum, sum != "", nil
+		}
+		sum, err := cfg.storedObjectDigest(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Missing objects are reported by the sweep.
+			digests[key] = ""
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("couldn't hash %s: %w", key, err)
+		}
+		digests[key] = sum
+		return sum, true, nil
+	}
+
+	archived := map[uuid.UUID]bool{}
+	for _, video := range videos {
+		if !video.ArchiveStatus.Playable() {
+			archived[video.ID] = true
+			continue
+		}
+		if video.StorageKey == nil || video.StorageBucket == nil || *video.StorageBucket != bucket {
+			continue
+		}
+		key := *video.StorageKey
+		if video.ContentSHA256 == nil && !repair {
+			continue
+		}
+		actual, ok, err := digest(key)
+		if err != nil {
+			return report, err
+		}
+		if !ok {
+			continue
+		}
+
+		if video.ContentSHA256 == nil {
+			err = cfg.db.UpdateVideoContentChecksum(video.ID, key, actual)
+			if err != nil {
+				return report, fmt.Errorf("couldn't record checksum of video %s: %w", video.ID, err)
+			}
+			report.ChecksumsRecorded++
+			continue
+		}
+		if *video.ContentSHA256 != actual {
+			report.ChecksumMismatches = append(report.ChecksumMismatches, checksumMismatch{
+				VideoID:  video.ID,
+				Key:      key,
+				Expected: *video.ContentSHA256,
+				Actual:   actual,
+			})
+		}
+	}
+
+	for _, version := range versions {
+		if archived[version.VideoID] || version.StorageBucket != bucket {
+			continue
+		}
+		if version.ContentSHA256 == nil && !repair {
+			continue
+		}
+		actual, ok, err := digest(version.StorageKey)
+		if err != nil {
+			return report, err
+		}
+		if !ok {
+			continue
+		}
+
+		if version.ContentSHA256 == nil {
+			err = cfg.db.UpdateVideoVersionContentChecksum(version.ID, actual)
+			if err != nil {
+				return report, fmt.Errorf("couldn't record checksum of version %s: %w", version.ID, err)
+			}
+			report.ChecksumsRecorded++
+			continue
+		}
+		if *version.ContentSHA256 != actual {
+			report.ChecksumMismatches = append(report.ChecksumMismatches, checksumMismatch{
+				VideoID:   version.VideoID,
+				VersionID: &version.ID,
+				Key:       version.StorageKey,
+				Expected:  *version.ContentSHA256,
+				Actual:    actual,
+			})
+		}
+	}
+	return report, nil
+}
+
+// storedObjectDigest returns the hex SHA-256 of a stored video object.
+func (cfg *apiConfig) storedObjectDigest(ctx context.Context, key string) (string, error) {
+	body, err := cfg.videoStorage.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, body)
+	if err != nil {
+		return "", err
+	}
+	return hexDigest(hasher), nil
+}
+
+func logReconcileReport(report reconcileReport) {
+	logAssetGCReport(report.assetGCReport)
+	if !report.ChecksumsVerified {
+		return
+	}
+	log.Printf("Reconciliation: %d checksum mismatches, %d checksums recorded", len(report.ChecksumMismatches), report.ChecksumsRecorded)
+	for _, mismatch := range report.ChecksumMismatches {
+		log.Printf("Video %s object %s has checksum %s, expected %s", mismatch.VideoID, mismatch.Key, mismatch.Actual, mismatch.Expected)
+	}
+}
+
+//openapi:summary Compare storage with the database
+//openapi:tags admin
+//openapi:query repair boolean Delete orphans and clear missing references
+//openapi:query checksums boolean Verify the checksums of stored videos
+//openapi:response 200 reconcileReport
+func (cfg *apiConfig) handlerAdminReconcile(w http.ResponseWriter, r *http.Request) {
+	flags := map[string]bool{"repair": false, "checksums": false}
+	for name := range flags {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid "+name, err)
+			return
+		}
+		flags[name] = parsed
+	}
+
+	report, err := cfg.reconcile(r.Context(), flags["repair"], flags["checksums"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reconcile storage", err)
+		return
+	}
+	logReconcileReport(report)
+
+	respondWithJSON(w, http.StatusOK, report)
+}
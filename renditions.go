@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// renditionFile is a rendition transcoded to a local file. nominal is the
+// ladder step it was made for, e.g. 720 for both 1280x720 and 720x1280.
+type renditionFile struct {
+	nominal int
+	width   int
+	height  int
+	bitrate string
+	path    string
+}
+
+// renditionDimensions returns the size of the rendition at a nominal
+// resolution of a video displayed at width x height. The orientation is kept
+// and the sizes are rounded to even numbers, which libx264 requires.
+func renditionDimensions(width, height, nominal int) (int, int) {
+	scale := func(long, short int) int {
+		return int(math.Round(float64(long)*float64(nominal)/float64(short)/2)) * 2
+	}
+	if width >= height {
+		return scale(width, height), nominal
+	}
+	return nominal, scale(height, width)
+}
+
+// generateRenditions transcodes the video at filePath to an MP4 for every
+// step of the HLS ladder below its own resolution, inside a new temp
+// directory. Videos at or below the lowest step get no renditions. The caller
+// must remove the directory.
+func generateRenditions(ctx context.Context, filePath string, metadata database.VideoMetadata) (string, []renditionFile, error) {
+	var files []renditionFile
+	for _, step := range hlsLadder {
+		if step.Height >= min(metadata.Width, metadata.Height) {
+			continue
+		}
+		width, height := renditionDimensions(metadata.Width, metadata.Height, step.Height)
+		files = append(files, renditionFile{
+			nominal: step.Height,
+			width:   width,
+			height:  height,
+			bitrate: step.Bitrate,
+		})
+	}
+	if len(files) == 0 {
+		return "", nil, nil
+	}
+
+	outDir, err := os.MkdirTemp("", "tubely-renditions")
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't create renditions directory: %w", err)
+	}
+
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(files))
+	for i := range files {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	split := filter.String()
+	for i := range files {
+		files[i].path = filepath.Join(outDir, fmt.Sprintf("%d.mp4", files[i].nominal))
+	}
+
+	var stderr bytes.Buffer
+	err = encodeWithFallback(ctx, func(encoder videoEncoder) *exec.Cmd {
+		graph := split
+		for i, file := range files {
+			graph += fmt.Sprintf(";[v%d]%s[v%dout]", i, encoder.filter(fmt.Sprintf("scale=%d:%d", file.width, file.height)), i)
+		}
+
+		args := append(append([]string{"-y"}, encoder.inputArgs...), "-i", filePath, "-filter_complex", graph)
+		for i, file := range files {
+			args = append(args,
+				"-map", fmt.Sprintf("[v%dout]", i),
+				"-map", "0:a:0?",
+				"-c:v", encoder.name,
+				"-b:v", file.bitrate,
+			)
+			args = append(args, encoder.options...)
+			args = append(args,
+				"-c:a", "aac",
+				"-movflags", "faststart",
+				file.path,
+			)
+		}
+
+		cmd := exec.CommandContext(ctx, ffmpegBinary, args...)
+		stderr.Reset()
+		cmd.Stderr = &stderr
+		return cmd
+	})
+	if err != nil {
+		os.RemoveAll(outDir)
+		return "", nil, fmt.Errorf("error generating renditions: %s, %v", stderr.String(), err)
+	}
+
+	return outDir, files, nil
+}
+
+// createRenditions generates the renditions of the video at filePath and
+// uploads them under the owner's keys of the video's orientation, e.g.
+// users/<user id>/landscape/720/. They're only recorded once the media they
+// belong to has been swapped in.
+func (cfg *apiConfig) createRenditions(ctx context.Context, video database.Video, filePath string, metadata database.VideoMetadata, orientation string) ([]database.CreateVideoRenditionParams, error) {
+	dir, files, err := generateRenditions(ctx, filePath, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return nil, nil
+	}
+	defer os.RemoveAll(dir)
+
+	var renditions []database.CreateVideoRenditionParams
+	for _, file := range files {
+		rendition, err := cfg.uploadRendition(ctx, video, file, orientation)
+		if err != nil {
+			cfg.deleteRenditionObjects(context.Background(), video.ID, renditions)
+			return nil, err
+		}
+		renditions = append(renditions, rendition)
+	}
+	return renditions, nil
+}
+
+func (cfg *apiConfig) uploadRendition(ctx context.Context, video database.Video, file renditionFile, orientation string) (database.CreateVideoRenditionParams, error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return database.CreateVideoRenditionParams{}, fmt.Errorf("couldn't open %dp rendition: %w", file.nominal, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return database.CreateVideoRenditionParams{}, fmt.Errorf("couldn't stat %dp rendition: %w", file.nominal, err)
+	}
+
+	key := cfg.objectKey(video.UserID, orientation, path.Join(strconv.Itoa(file.nominal), getAssetPath("video/mp4")))
+	err = cfg.videoStorage.Put(ctx, key, f, "video/mp4")
+	if err != nil {
+		return database.CreateVideoRenditionParams{}, fmt.Errorf("couldn't upload %dp rendition: %w", file.nominal, err)
+	}
+
+	return database.CreateVideoRenditionParams{
+		VideoID:       video.ID,
+		Height:        file.nominal,
+		Width:         file.width,
+		StorageBucket: cfg.videoStorage.Bucket(),
+		StorageKey:    key,
+		VideoURL:      cfg.videoStorage.URL(key),
+		Size:          info.Size(),
+	}, nil
+}
+
+// replaceVideoRenditions records the renditions of a video's new media and
+// deletes the objects of the ones they replace.
+func (cfg *apiConfig) replaceVideoRenditions(ctx context.Context, videoID uuid.UUID, renditions []database.CreateVideoRenditionParams) error {
+	replaced, err := cfg.db.ReplaceVideoRenditions(videoID, renditions)
+	if err != nil {
+		return fmt.Errorf("couldn't record renditions: %w", err)
+	}
+
+	kept := map[string]bool{}
+	for _, rendition := range renditions {
+		kept[rendition.StorageKey] = true
+	}
+	var stale []database.CreateVideoRenditionParams
+	for _, rendition := range replaced {
+		if !kept[rendition.StorageKey] {
+			stale = append(stale, rendition.CreateVideoRenditionParams)
+		}
+	}
+	cfg.deleteRenditionObjects(ctx, videoID, stale)
+	return nil
+}
+
+// deleteRenditionObjects deletes the stored renditions of a video, except
+// those a deduplicated upload still uses. Failures are only logged; the asset
+// GC collects whatever is left behind.
+func (cfg *apiConfig) deleteRenditionObjects(ctx context.Context, videoID uuid.UUID, renditions []database.CreateVideoRenditionParams) {
+	for _, rendition := range renditions {
+		refs, err := cfg.db.CountRenditionStorageKeyReferences(rendition.StorageBucket, rendition.StorageKey, videoID)
+		if err != nil {
+			log.Printf("Couldn't count references to %s: %v", rendition.StorageKey, err)
+			continue
+		}
+		if refs > 0 {
+			continue
+		}
+		err = cfg.videoStorage.Delete(ctx, rendition.StorageKey)
+		if err != nil {
+			log.Printf("Couldn't delete rendition %s: %v", rendition.StorageKey, err)
+		}
+	}
+}
+
+// reuseVideoRenditions gives video the renditions of existing, an earlier
+// upload with identical content.
+func (cfg *apiConfig) reuseVideoRenditions(ctx context.Context, video, existing database.Video) error {
+	existingRenditions, err := cfg.db.GetVideoRenditions(existing.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get renditions of video %s: %w", existing.ID, err)
+	}
+	renditions := make([]database.CreateVideoRenditionParams, len(existingRenditions))
+	for i, rendition := range existingRenditions {
+		renditions[i] = rendition.CreateVideoRenditionParams
+		renditions[i].VideoID = video.ID
+	}
+	return cfg.replaceVideoRenditions(ctx, video.ID, renditions)
+}
+
+//openapi:summary List the renditions of a video
+//openapi:tags videos
+//openapi:auth optional
+//openapi:query expires_in integer How long signed URLs are valid, in seconds
+//openapi:response 200 []database.VideoRendition
+func (cfg *apiConfig) handlerVideoRenditionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	expiry, err := cfg.presignExpiryFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid expiry", err)
+		return
+	}
+
+	userID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !canViewVideo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	renditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve renditions", err)
+		return
+	}
+	if cfg.signsURLsIn(cfg.videoStorage) {
+		for i := range renditions {
+			renditions[i].VideoURL, err = cfg.signedURL(r.Context(), cfg.videoStorage, renditions[i].StorageKey, videoExpiry(video, expiry))
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't sign rendition URLs", err)
+				return
+			}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, renditions)
+}
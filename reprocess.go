@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerAdminVideoReprocess queues a video's stored object to be processed
+// again with the current settings, e.g. after the encoding settings changed.
+// The new media replaces the old once processing succeeds; if it fails, the
+// video keeps playing its previous media.
+//
+//openapi:summary Process a video again
+//openapi:tags admin
+//openapi:body parameters
+//openapi:response 202 database.Video
+func (cfg *apiConfig) handlerAdminVideoReprocess(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Thumbnail replaces the thumbnail with a generated one. Otherwise
+		// the current thumbnail, which may be a custom one, is kept.
+		Thumbnail bool `json:"thumbnail"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Thumbnail && !cfg.autoThumbnailEnabled {
+		respondWithError(w, http.StatusConflict, "Thumbnail generation is disabled", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.StorageKey == nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoNotProcessed, "Video hasn't been processed yet", nil, nil)
+		return
+	}
+	if video.ProcessingStatus == database.ProcessingStatusPending || video.ProcessingStatus == database.ProcessingStatusProcessing {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoProcessing, "Video is being processed", nil, nil)
+		return
+	}
+	if !video.ArchiveStatus.Playable() {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Video is archived and must be restored first", nil, nil)
+		return
+	}
+	if video.StorageBucket == nil || *video.StorageBucket != cfg.videoStorage.Bucket() {
+		respondWithError(w, http.StatusConflict, "Video isn't stored in the current video storage", nil)
+		return
+	}
+
+	video, err = cfg.enqueueVideoProcessing(r.Context(), video, processVideoPayload{
+		SourceKey:           *video.StorageKey,
+		MediaType:           storedVideoMediaType(*video.StorageKey),
+		Reprocess:           true,
+		RegenerateThumbnail: params.Thumbnail,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return
+	}
+	log.Printf("Queued video %s for reprocessing", video.ID)
+
+	respondWithJSON(w, http.StatusAccepted, video)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// middlewareRequireRole rejects requests whose user doesn't have at least
+// the required role. The user's role is read from the database on every
+// request so demotions take effect without waiting for tokens to expire.
+func (cfg *apiConfig) middlewareRequireRole(required auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user == nil {
+			respondWithError(w, http.StatusUnauthorized, "User not found", nil)
+			return
+		}
+		if !auth.Role(user.Role).Has(required) {
+			respondWithError(w, http.StatusForbidden, "Insufficient role", nil)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// parseAdminEmails splits the ADMIN_EMAILS list into a set of lowercased
+// addresses.
+func parseAdminEmails(value string) map[string]bool {
+	emails := map[string]bool{}
+	for _, email := range strings.Split(value, ",") {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			emails[email] = true
+		}
+	}
+	return emails
+}
+
+// roleForNewUser returns the role a user signing up with email gets.
+func (cfg *apiConfig) roleForNewUser(email string) auth.Role {
+	if cfg.adminEmails[strings.ToLower(email)] {
+		return auth.RoleAdmin
+	}
+	return auth.RoleUploader
+}
+
+// promoteAdmins gives the admin role to existing users listed in
+// ADMIN_EMAILS, so operators can bootstrap the first admin account.
+func (cfg *apiConfig) promoteAdmins() error {
+	for email := range cfg.adminEmails {
+		user, err := cfg.db.GetUserByEmail(email)
+		if err != nil {
+			return err
+		}
+		if user.Email == "" || auth.Role(user.Role) == auth.RoleAdmin {
+			continue
+		}
+		err = cfg.db.UpdateUserRole(user.ID, string(auth.RoleAdmin))
+		if err != nil {
+			return err
+		}
+		log.Printf("Promoted %s to admin", user.Email)
+	}
+	return nil
+}
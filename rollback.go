@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// rollback collects the steps that undo the parts of an operation that
+// already happened, such as objects stored before the database update that
+// was to reference them. Unless the operation commits, run undoes them in
+// reverse order, so nothing is left behind that no row refers to.
+type rollback struct {
+	steps     []func()
+	committed bool
+}
+
+func (r *rollback) add(step func()) {
+	r.steps = append(r.steps, step)
+}
+
+// commit keeps everything done so far. It's called once the database
+// references the new state.
+func (r *rollback) commit() {
+	r.committed = true
+}
+
+// run undoes the recorded steps unless the operation committed. It's meant
+// to be deferred.
+func (r *rollback) run() {
+	if r.committed {
+		return
+	}
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		r.steps[i]()
+	}
+}
+
+// deleteVideoObject returns a rollback step that deletes an object stored in
+// video storage.
+func (cfg *apiConfig) deleteVideoObject(key string) func() {
+	return func() {
+		err := cfg.videoStorage.Delete(context.Background(), key)
+		if err != nil {
+			log.Printf("Couldn't delete video object %s: %v", key, err)
+		}
+	}
+}
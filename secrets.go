@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+)
+
+// refreshSecrets looks up the secrets the environment refers to in Secrets
+// Manager or Parameter Store again, so rotating them doesn't take a restart.
+// New tokens are signed with the current JWT secret, with the one it
+// replaced still accepted; new database connections use the current
+// credentials; and URLs are signed with the current CloudFront key. Previous
+// JWT secrets are only read at startup.
+func (cfg *apiConfig) refreshSecrets(ctx context.Context) error {
+	var errs []error
+
+	if secrets.IsReference(os.Getenv("JWT_SECRET")) {
+		secret, err := getEnvSecret(ctx, cfg.secretResolver, "JWT_SECRET")
+		if err == nil && secret == "" {
+			err = errors.New("JWT_SECRET is empty")
+		}
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			cfg.jwtKeys.SetSecret(secret)
+		}
+	}
+
+	if secrets.IsReference(os.Getenv("DATABASE_URL")) || secrets.IsReference(os.Getenv("DATABASE_PASSWORD")) {
+		dsn, err := loadDatabaseDSN(ctx, cfg.secretResolver)
+		if err == nil {
+			err = cfg.db.SetDSN(dsn)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if cfg.cdnSigner != nil && (secrets.IsReference(os.Getenv("CLOUDFRONT_KEY_ID")) || secrets.IsReference(os.Getenv("CLOUDFRONT_PRIVATE_KEY"))) {
+		keyID, privateKey, err := loadCloudFrontKey(ctx, cfg.secretResolver)
+		if err == nil {
+			err = cfg.cdnSigner.SetKey(keyID, privateKey)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
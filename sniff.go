@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+)
+
+const sniffLen = 512
+
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// videoContainerFamily groups the accepted media types by the container
+// format their bytes must start with.
+var videoContainerFamily = map[string]string{
+	"video/mp4":        "isobmff",
+	"video/quicktime":  "isobmff",
+	"video/webm":       "ebml",
+	"video/x-matroska": "ebml",
+}
+
+// sniffVideoContainer identifies the container family from the first bytes
+// of a file: an ISO base media file (MP4/MOV) starts with an ftyp box and a
+// Matroska/WebM file with the EBML magic number.
+func sniffVideoContainer(header []byte) string {
+	if len(header) >= 8 && string(header[4:8]) == "ftyp" {
+		return "isobmff"
+	}
+	if bytes.HasPrefix(header, ebmlMagic) {
+		return "ebml"
+	}
+	return ""
+}
+
+// isFastStart reports whether the moov box of the MP4 at path precedes its
+// mdat box, i.e. whether players can start before the whole file is loaded.
+// Only the headers of the top-level boxes are read.
+func isFastStart(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var offset int64
+	header := make([]byte, 16)
+	for {
+		_, err := f.ReadAt(header[:8], offset)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		switch string(header[4:8]) {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch size {
+		case 0:
+			// The box extends to the end of the file.
+			return false, nil
+		case 1:
+			_, err = f.ReadAt(header[8:16], offset+8)
+			if err != nil {
+				return false, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			if size < 16 {
+				return false, fmt.Errorf("invalid box size %d at offset %d", size, offset)
+			}
+		default:
+			if size < 8 {
+				return false, fmt.Errorf("invalid box size %d at offset %d", size, offset)
+			}
+		}
+		offset += size
+	}
+}
+
+// checkVideoContent verifies that the content read from r matches the
+// declared mediaType, rather than trusting the client-supplied header.
+func checkVideoContent(r io.ReadSeeker, mediaType string) error {
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	if sniffVideoContainer(header) != videoContainerFamily[mediaType] {
+		return fmt.Errorf("file content doesn't match media type %s", mediaType)
+	}
+	return nil
+}
+
+// checkImageContent verifies that r holds a decodable image of the declared
+// mediaType.
+func checkImageContent(r io.ReadSeeker, mediaType string) error {
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	detected := http.DetectContentType(header)
+	if detected != mediaType {
+		return fmt.Errorf("file content is %s, not %s", detected, mediaType)
+	}
+
+	_, _, err = image.DecodeConfig(r)
+	if err != nil {
+		return fmt.Errorf("couldn't decode image: %w", err)
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
+
+// readHeader reads up to sniffLen bytes from the start of r and rewinds it.
+func readHeader(r io.ReadSeeker) ([]byte, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return header[:n], nil
+}
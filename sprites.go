@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	spriteTileWidth   = 160
+	spriteColumns     = 10
+	spriteMaxTiles    = 100
+	spriteMinInterval = 2.0
+
+	spriteSheetName = "sprite.jpg"
+	spriteVTTName   = "sprite.vtt"
+)
+
+// spriteLayout describes how the frames of a scrub preview sprite sheet are
+// sampled and tiled.
+type spriteLayout struct {
+	Interval   float64
+	Tiles      int
+	Rows       int
+	TileWidth  int
+	TileHeight int
+}
+
+// newSpriteLayout samples a frame every spriteMinInterval seconds, or less
+// often for long videos so the sheet never holds more than spriteMaxTiles.
+func newSpriteLayout(metadata database.VideoMetadata) spriteLayout {
+	interval := math.Max(spriteMinInterval, metadata.DurationSeconds/spriteMaxTiles)
+	tiles := min(int(math.Ceil(metadata.DurationSeconds/interval)), spriteMaxTiles)
+
+	tileHeight := spriteTileWidth * 9 / 16
+	if metadata.Width > 0 && metadata.Height > 0 {
+		tileHeight = spriteTileWidth * metadata.Height / metadata.Width
+	}
+	// Keep the height even, as ffmpeg's scaler requires for YUV output.
+	tileHeight = max(2, tileHeight&^1)
+
+	return spriteLayout{
+		Interval:   interval,
+		Tiles:      tiles,
+		Rows:       (tiles + spriteColumns - 1) / spriteColumns,
+		TileWidth:  spriteTileWidth,
+		TileHeight: tileHeight,
+	}
+}
+
+// generateSprites renders a scrub preview sprite sheet and the WebVTT file
+// mapping time ranges to its tiles into a new temp directory and returns it.
+// The caller must remove the directory.
+func generateSprites(ctx context.Context, filePath string, metadata database.VideoMetadata) (string, error) {
+	if metadata.DurationSeconds <= 0 {
+		return "", fmt.Errorf("can't generate sprites for a video without a duration")
+	}
+	layout := newSpriteLayout(metadata)
+
+	outDir, err := os.MkdirTemp("", "tubely-sprites")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create sprites directory: %w", err)
+	}
+
+	cmd := exec.CommandContext(
+		ctx,
+		ffmpegBinary,
+		"-y",
+		"-i",
+		filePath,
+		"-vf",
+		fmt.Sprintf(
+			"fps=1/%s,scale=%d:%d,tile=%dx%d",
+			formatSeconds(layout.Interval),
+			layout.TileWidth,
+			layout.TileHeight,
+			spriteColumns,
+			layout.Rows,
+		),
+		"-frames:v",
+		"1",
+		"-q:v",
+		"4",
+		filepath.Join(outDir, spriteSheetName),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = runLimited(ctx, cmd)
+	if err != nil {
+		os.RemoveAll(outDir)
+		return "", fmt.Errorf("error generating sprite sheet: %s, %v", stderr.String(), err)
+	}
+
+	vtt := spriteVTT(layout, metadata.DurationSeconds)
+	err = os.WriteFile(filepath.Join(outDir, spriteVTTName), []byte(vtt), 0644)
+	if err != nil {
+		os.RemoveAll(outDir)
+		return "", fmt.Errorf("couldn't write sprite WebVTT: %w", err)
+	}
+	return outDir, nil
+}
+
+// spriteVTT builds the WebVTT track for a sprite sheet. Each cue points at
+// its tile with a media fragment relative to the track, so the sheet must be
+// stored next to it.
+func spriteVTT(layout spriteLayout, duration float64) string {
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	for i := 0; i < layout.Tiles; i++ {
+		start := float64(i) * layout.Interval
+		end := math.Min(start+layout.Interval, duration)
+		x := (i % spriteColumns) * layout.TileWidth
+		y := (i / spriteColumns) * layout.TileHeight
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTimestamp(start),
+			formatVTTTimestamp(end),
+			spriteSheetName,
+			x, y, layout.TileWidth, layout.TileHeight,
+		)
+	}
+	return vtt.String()
+}
+
+func formatVTTTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// generateAndUploadSprites renders the scrub preview for the video at
+// filePath and stores it alongside the video.
+func (cfg *apiConfig) generateAndUploadSprites(ctx context.Context, video *database.Video, filePath string) error {
+	if video.Metadata == nil {
+		return fmt.Errorf("video metadata is missing")
+	}
+	dir, err := generateSprites(ctx, filePath, *video.Metadata)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	return cfg.uploadSprites(ctx, video, dir)
+}
+
+// uploadSprites stores the sprite sheet and its WebVTT track under the
+// per-video sprites prefix and points the video at them.
+func (cfg *apiConfig) uploadSprites(ctx context.Context, video *database.Video, dir string) error {
+	prefix := cfg.spritesPrefix(*video)
+	files := []struct {
+		name        string
+		contentType string
+	}{
+		{spriteSheetName, "image/jpeg"},
+		{spriteVTTName, "text/vtt"},
+	}
+
+	for _, f := range files {
+		file, err := os.Open(filepath.Join(dir, f.name))
+		if err != nil {
+			return err
+		}
+		err = cfg.videoStorage.Put(ctx, path.Join(prefix, f.name), file, f.contentType)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't upload %s: %w", f.name, err)
+		}
+	}
+
+	spriteURL := cfg.videoStorage.URL(path.Join(prefix, spriteSheetName))
+	spriteVTTURL := cfg.videoStorage.URL(path.Join(prefix, spriteVTTName))
+	video.SpriteURL = &spriteURL
+	video.SpriteVTTURL = &spriteVTTURL
+	return nil
+}
+
+func (cfg *apiConfig) spritesPrefix(video database.Video) string {
+	return cfg.objectKey(video.UserID, aspectSprites, video.ID.String())
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+type stageTimingsKey struct{}
+
+// stageTimings collects how long the stages of one processing attempt take,
+// so slow uploads can be narrowed down to e.g. the transcode or the upload
+// to storage.
+type stageTimings struct {
+	mu      sync.Mutex
+	stages  []string
+	seconds database.JobTimings
+}
+
+// withStageTimings returns a context that the stages run with it are
+// recorded on.
+func withStageTimings(ctx context.Context) (context.Context, *stageTimings) {
+	timings := &stageTimings{seconds: database.JobTimings{}}
+	return context.WithValue(ctx, stageTimingsKey{}, timings), timings
+}
+
+// recordStage records a stage that started at start and has just ended, on
+// the timings of ctx if it has any.
+func recordStage(ctx context.Context, stage string, start time.Time) {
+	elapsed := time.Since(start)
+	metrics.ProcessingStageDurationSeconds.WithLabelValues(stage).Observe(elapsed.Seconds())
+
+	timings, ok := ctx.Value(stageTimingsKey{}).(*stageTimings)
+	if !ok {
+		return
+	}
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+	if _, ok := timings.seconds[stage]; !ok {
+		timings.stages = append(timings.stages, stage)
+	}
+	timings.seconds[stage] += math.Round(elapsed.Seconds()*1000) / 1000
+}
+
+func (t *stageTimings) all() database.JobTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	all := make(database.JobTimings, len(t.seconds))
+	for stage, seconds := range t.seconds {
+		all[stage] = seconds
+	}
+	return all
+}
+
+// String lists the stages in the order they ran, e.g.
+// "probe=0.12s faststart=1.5s upload=8.2s".
+func (t *stageTimings) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, len(t.stages))
+	for i, stage := range t.stages {
+		parts[i] = fmt.Sprintf("%s=%gs", stage, t.seconds[stage])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// startupCheckTimeout bounds the startup checks, so unreachable storage
+// fails the boot instead of hanging it.
+const startupCheckTimeout = 30 * time.Second
+
+// checkStartup verifies that the assets root is writable and that the
+// configured storage can be reached and written to, so misconfiguration stops
+// the server at boot instead of failing the first upload.
+func (cfg *apiConfig) checkStartup(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startupCheckTimeout)
+	defer cancel()
+
+	// Signed HLS is served through playlists rewritten by the API, whose
+	// URLs need a key of their own.
+	if cfg.hlsEnabled && cfg.signsURLsIn(cfg.videoStorage) && len(cfg.hlsSigningKey) == 0 {
+		return fmt.Errorf("HLS_ENABLED with signed video URLs requires HLS_SIGNING_KEY")
+	}
+
+	probe, err := os.CreateTemp(cfg.assetsRoot, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("assets root %s isn't writable, check ASSETS_ROOT and its permissions: %w", cfg.assetsRoot, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if cfg.videoStorageName == "s3" || cfg.thumbnailStorageName == "s3" {
+		err = cfg.s3Objects.CheckBucket(ctx)
+		if err != nil {
+			return fmt.Errorf("%w; check S3_BUCKET, S3_REGION and the AWS credentials", err)
+		}
+	}
+
+	backends := []struct {
+		env     string
+		name    string
+		storage storage.Storage
+	}{
+		{"VIDEO_STORAGE", cfg.videoStorageName, cfg.videoStorage},
+		{"THUMBNAIL_STORAGE", cfg.thumbnailStorageName, cfg.thumbnailStorage},
+	}
+	for i, backend := range backends {
+		if i > 0 && backend.storage == backends[0].storage {
+			continue
+		}
+		err = storage.Probe(ctx, backend.storage)
+		if err != nil {
+			return fmt.Errorf("%s=%s isn't writable, check its location and that the credentials can store and delete objects: %w", backend.env, backend.name, err)
+		}
+	}
+
+	log.Printf("Startup checks passed")
+	return nil
+}
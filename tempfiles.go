@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// staleTempPatterns match intermediate files that are only useful while a
+// processing run is in progress, and upload sources, which are kept while a
+// queued or dead job still needs them.
+var staleTempPatterns = []string{
+	"tubely-upload*",
+	"tubely-hls*",
+	"tubely-thumbnail*.jpg",
+	"tubely-sprites*",
+	"tubely-renditions*",
+	"tubely-preview*.mp4",
+	"tubely-audio*",
+	"tubely-transcript*",
+}
+
+// removeStaleTempFiles deletes intermediate files left behind by processing
+// runs that were interrupted, and uploads no job is waiting on. It must only
+// run while no jobs or uploads are running.
+func (cfg *apiConfig) removeStaleTempFiles() {
+	sources, err := cfg.pendingUploadSources()
+	if err != nil {
+		// Without knowing which uploads are still queued, none are safe to
+		// remove.
+		log.Printf("Couldn't list queued uploads, keeping upload temp files: %v", err)
+	}
+
+	for _, pattern := range staleTempPatterns {
+		matches, err := filepath.Glob(filepath.Join(os.TempDir(), pattern))
+		if err != nil {
+			log.Printf("Couldn't list temp files: %v", err)
+			continue
+		}
+		for _, match := range matches {
+			if (sources == nil && pattern == "tubely-upload*") || sources[match] {
+				continue
+			}
+			err := os.RemoveAll(match)
+			if err != nil {
+				log.Printf("Couldn't remove stale temp file %s: %v", match, err)
+			}
+		}
+	}
+}
+
+// pendingUploadSources returns the local upload files that unfinished
+// processing jobs will read, including dead ones that may be requeued.
+func (cfg *apiConfig) pendingUploadSources() (map[string]bool, error) {
+	pending, err := cfg.db.GetUnfinishedJobs(jobTypeProcessVideo)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[string]bool{}
+	for _, job := range pending {
+		var payload processVideoPayload
+		err := json.Unmarshal([]byte(job.Payload), &payload)
+		if err != nil {
+			log.Printf("Couldn't decode payload of job %s: %v", job.ID, err)
+			continue
+		}
+		if payload.SourcePath != "" {
+			sources[filepath.Clean(payload.SourcePath)] = true
+		}
+	}
+	return sources, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// autoThumbnailPosition is the fraction of the video duration at which the
+// generated thumbnail frame is taken.
+const autoThumbnailPosition = 0.1
+
+// thumbnailWidths are the widths in pixels of the resized variants stored
+// alongside each thumbnail, in increasing order.
+var thumbnailWidths = []int{320, 640, 1280}
+
+// thumbnailKey returns a new key for a user's thumbnail, under its own aspect
+// so thumbnails stay apart from videos when both share a bucket.
+func (cfg *apiConfig) thumbnailKey(userID uuid.UUID, mediaType string) string {
+	return cfg.objectKey(userID, aspectThumbnails, getAssetPath(mediaType))
+}
+
+// generateThumbnail extracts a representative frame from the video at
+// filePath and stores it as the video's thumbnail.
+func (cfg *apiConfig) generateThumbnail(ctx context.Context, video *database.Video, filePath string) error {
+	var duration float64
+	if video.Metadata != nil {
+		duration = video.Metadata.DurationSeconds
+	}
+
+	frameTmp, err := os.CreateTemp("", "tubely-thumbnail*.jpg")
+	if err != nil {
+		return fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer os.Remove(frameTmp.Name())
+	frameTmp.Close()
+
+	err = extractFrame(ctx, filePath, duration*autoThumbnailPosition, 0, frameTmp.Name())
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(frameTmp.Name())
+	if err != nil {
+		return fmt.Errorf("couldn't read extracted frame: %w", err)
+	}
+	return cfg.storeThumbnail(ctx, video, data, "image/jpeg")
+}
+
+// storeThumbnail stores data as the video's thumbnail, along with a resized
+// variant for each of thumbnailWidths narrower than the image, and points
+// the video at them. The srcset also lists the original at its own width.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, video *database.Video, data []byte, mediaType string) (err error) {
+	img, err := imaging.Decode(data, mediaType)
+	if err != nil {
+		return fmt.Errorf("couldn't decode thumbnail: %w", err)
+	}
+
+	// Re-encoding drops EXIF and other metadata, such as GPS coordinates,
+	// that shouldn't be published. The EXIF orientation is applied to the
+	// pixels first so the image still displays upright.
+	img = imaging.ApplyOrientation(img, imaging.Orientation(data))
+	data, err = imaging.Encode(img, mediaType)
+	if err != nil {
+		return fmt.Errorf("couldn't encode thumbnail: %w", err)
+	}
+
+	var stored []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, key := range stored {
+			cfg.deleteThumbnailObject(context.Background(), video.UserID, key)
+		}
+	}()
+
+	key := cfg.thumbnailKey(video.UserID, mediaType)
+	err = cfg.putThumbnailObject(ctx, video.UserID, key, data, mediaType)
+	if err != nil {
+		return fmt.Errorf("couldn't store thumbnail: %w", err)
+	}
+	stored = append(stored, key)
+	thumbnailURL := cfg.thumbnailStorage.URL(key)
+
+	width := img.Bounds().Dx()
+	srcset := database.ThumbnailSrcset{
+		srcsetDescriptor(width): thumbnailURL,
+	}
+	for _, variantWidth := range thumbnailWidths {
+		if variantWidth >= width {
+			break
+		}
+
+		variant, err := imaging.Encode(imaging.ResizeToWidth(img, variantWidth), mediaType)
+		if err != nil {
+			return fmt.Errorf("couldn't encode %dpx thumbnail: %w", variantWidth, err)
+		}
+		variantKey := thumbnailVariantKey(key, variantWidth)
+		err = cfg.putThumbnailObject(ctx, video.UserID, variantKey, variant, mediaType)
+		if err != nil {
+			return fmt.Errorf("couldn't store %dpx thumbnail: %w", variantWidth, err)
+		}
+		stored = append(stored, variantKey)
+		srcset[srcsetDescriptor(variantWidth)] = cfg.thumbnailStorage.URL(variantKey)
+	}
+
+	video.ThumbnailURL = &thumbnailURL
+	video.ThumbnailSrcset = srcset
+	return nil
+}
+
+// thumbnailVariantKey derives the key of a resized variant from the key of
+// the original, e.g. "thumbnails/ab/cd/abcd.jpeg" becomes
+// "thumbnails/ab/cd/abcd-320w.jpeg".
+func thumbnailVariantKey(key string, width int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(key, ext), srcsetDescriptor(width), ext)
+}
+
+func srcsetDescriptor(width int) string {
+	return strconv.Itoa(width) + "w"
+}
+
+// thumbnailURLs returns the distinct URLs of a video's thumbnail and its
+// resized variants.
+func thumbnailURLs(video database.Video) []string {
+	urls := []string{}
+	if video.ThumbnailURL != nil {
+		urls = append(urls, *video.ThumbnailURL)
+	}
+	for _, url := range video.ThumbnailSrcset {
+		if !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// deleteThumbnail removes a previously stored thumbnail and its variants.
+// Failures are only logged since a leftover file doesn't affect the video.
+func (cfg *apiConfig) deleteThumbnail(ctx context.Context, video database.Video) {
+	for _, thumbnailURL := range thumbnailURLs(video) {
+		key, ok := cfg.thumbnailStorage.KeyFromURL(thumbnailURL)
+		if !ok {
+			log.Printf("Couldn't delete old thumbnail: %s isn't managed by the thumbnail storage", thumbnailURL)
+			continue
+		}
+		err := cfg.deleteThumbnailObject(ctx, video.UserID, key)
+		if err != nil {
+			log.Printf("Couldn't delete old thumbnail: %v", err)
+		}
+	}
+}
+
+// migrateLocalThumbnails moves thumbnails still stored on local disk to the
+// configured thumbnail storage, e.g. after switching THUMBNAIL_STORAGE to s3.
+// Videos whose thumbnail can't be moved keep their local URL and are retried
+// on the next start.
+func (cfg *apiConfig) migrateLocalThumbnails(ctx context.Context, local *storage.Local) error {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		if video.ThumbnailURL == nil {
+			continue
+		}
+		_, ok := local.KeyFromURL(*video.ThumbnailURL)
+		if !ok {
+			continue
+		}
+
+		err := cfg.migrateLocalThumbnail(ctx, local, video)
+		if err != nil {
+			log.Printf("Couldn't migrate thumbnail of video %s: %v", video.ID, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) migrateLocalThumbnail(ctx context.Context, local *storage.Local, video database.Video) error {
+	ctx = withVideoTags(ctx, video)
+	migrated := video
+
+	thumbnailURL, err := cfg.copyLocalThumbnail(ctx, local, video.UserID, *video.ThumbnailURL)
+	if err != nil {
+		return err
+	}
+	migrated.ThumbnailURL = &thumbnailURL
+
+	if video.ThumbnailSrcset != nil {
+		migrated.ThumbnailSrcset = database.ThumbnailSrcset{}
+		for descriptor, variantURL := range video.ThumbnailSrcset {
+			migrated.ThumbnailSrcset[descriptor], err = cfg.copyLocalThumbnail(ctx, local, video.UserID, variantURL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = cfg.db.UpdateVideoThumbnail(video.ID, migrated.ThumbnailURL, migrated.ThumbnailSrcset)
+	if err != nil {
+		return fmt.Errorf("couldn't update video: %w", err)
+	}
+
+	for _, oldURL := range thumbnailURLs(video) {
+		localKey, ok := local.KeyFromURL(oldURL)
+		if !ok {
+			continue
+		}
+		size := cfg.assetFileSize(localKey)
+		err = local.Delete(ctx, localKey)
+		if err != nil {
+			log.Printf("Couldn't delete migrated thumbnail %s: %v", localKey, err)
+			continue
+		}
+		if size > 0 {
+			cfg.addUsage(video.UserID, database.UsageDelta{ThumbnailBytes: -size})
+		}
+	}
+	return nil
+}
+
+// copyLocalThumbnail copies a user's thumbnail from local disk to the
+// thumbnail storage, moving it into the current key layout if needed, and
+// returns its new URL.
+func (cfg *apiConfig) copyLocalThumbnail(ctx context.Context, local *storage.Local, userID uuid.UUID, thumbnailURL string) (string, error) {
+	localKey, ok := local.KeyFromURL(thumbnailURL)
+	if !ok {
+		return thumbnailURL, nil
+	}
+
+	file, err := local.Get(ctx, localKey)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	mediaType := mime.TypeByExtension(path.Ext(localKey))
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	key := localKey
+	if !cfg.currentKeyPattern(userID).MatchString(key) {
+		key = cfg.objectKey(userID, aspectThumbnails, strings.TrimPrefix(key, aspectThumbnails+"/"))
+	}
+
+	err = cfg.thumbnailStorage.Put(ctx, key, file, mediaType)
+	if err != nil {
+		return "", fmt.Errorf("couldn't upload %s: %w", localKey, err)
+	}
+	return cfg.thumbnailStorage.URL(key), nil
+}
+
+// extractFrame writes the frame at the given offset in seconds to outPath as
+// a JPEG, scaled down to width pixels unless width is 0. filePath can also be
+// an HTTP URL, in which case ffmpeg seeks with range requests.
+func extractFrame(ctx context.Context, filePath string, seconds float64, width int, outPath string) error {
+	args := []string{
+		"-y",
+		"-ss",
+		strconv.FormatFloat(seconds, 'f', 3, 64),
+		"-i",
+		filePath,
+		"-frames:v",
+		"1",
+		"-q:v",
+		"2",
+	}
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", width))
+	}
+	args = append(args, "-f", "image2", outPath)
+	cmd := exec.CommandContext(ctx, ffmpegBinary, args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := runLimited(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("error extracting frame: %s, %v", stderr.String(), err)
+	}
+	return nil
+}
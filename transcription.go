@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const jobTypeTranscribeVideo = "transcribe_video"
+
+type transcribeVideoPayload struct {
+	VideoID      uuid.UUID         `json:"video_id"`
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// transcriptionJobReference keeps transcription jobs apart from the
+// processing jobs of the same video, which are referenced by its bare ID.
+func transcriptionJobReference(videoID uuid.UUID) string {
+	return "transcription:" + videoID.String()
+}
+
+// enqueueTranscription queues automatic captions for a processed video. It's
+// a no-op when no transcription backend is configured.
+func (cfg *apiConfig) enqueueTranscription(ctx context.Context, video database.Video) error {
+	if cfg.transcriber == nil {
+		return nil
+	}
+	_, err := cfg.jobs.Enqueue(jobTypeTranscribeVideo, transcriptionJobReference(video.ID), transcribeVideoPayload{
+		VideoID:      video.ID,
+		TraceContext: injectTraceContext(ctx),
+	})
+	return err
+}
+
+func (cfg *apiConfig) runTranscribeVideoJob(ctx context.Context, job database.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.processingTimeout)
+	defer cancel()
+
+	var payload transcribeVideoPayload
+	err = json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("couldn't decode job payload: %w", err)
+	}
+
+	ctx, span := tracer.Start(extractTraceContext(ctx, payload.TraceContext), "video.transcribe", trace.WithAttributes(
+		attribute.String("video.id", payload.VideoID.String()),
+		attribute.Int("job.attempt", job.Attempts),
+	))
+	defer func() { endSpan(span, err) }()
+
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		log.Printf("Video %s was deleted, skipping transcription", payload.VideoID)
+		return nil
+	}
+	ctx = withVideoTags(ctx, video)
+	if video.Metadata != nil && video.Metadata.AudioCodec == "" {
+		log.Printf("Video %s has no audio track, skipping transcription", video.ID)
+		return nil
+	}
+
+	language := cfg.transcriptionLanguage
+	existing, err := cfg.db.GetCaption(video.ID, language)
+	if err != nil {
+		return fmt.Errorf("couldn't get existing captions: %w", err)
+	}
+	// Captions uploaded by the owner are never overwritten by generated ones.
+	if existing != nil && existing.Source != database.CaptionSourceTranscription {
+		log.Printf("Video %s already has %s captions, skipping transcription", video.ID, language)
+		return nil
+	}
+
+	audioPath, err := cfg.extractStoredAudio(ctx, video, audioFormatSpeech)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(audioPath)
+
+	// Recognizers take a bare language code, without the region.
+	vtt, err := cfg.transcriber.Transcribe(ctx, audioPath, strings.SplitN(language, "-", 2)[0])
+	if errors.Is(err, transcribe.ErrNoSpeech) {
+		log.Printf("No speech found in video %s, no captions generated", video.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't transcribe video: %w", err)
+	}
+
+	_, err = cfg.storeCaption(ctx, video, language, language+" (auto-generated)", database.CaptionSourceTranscription, vtt)
+	return err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// purgeVideo permanently deletes a video with its stored objects and
+// everything recorded about it.
+func (cfg *apiConfig) purgeVideo(ctx context.Context, video database.Video) error {
+	err := cfg.deleteVideoAssets(ctx, video)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video files: %w", err)
+	}
+	err = cfg.db.DeleteShareLinksForVideo(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete share links: %w", err)
+	}
+	err = cfg.db.DeleteCaptionsForVideo(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete captions: %w", err)
+	}
+	err = cfg.db.DeleteVideoVersions(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video versions: %w", err)
+	}
+	err = cfg.db.DeleteVideoRenditions(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video renditions: %w", err)
+	}
+	err = cfg.db.DeleteVideoTags(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video tags: %w", err)
+	}
+	err = cfg.db.DeleteVideoStats(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video stats: %w", err)
+	}
+	err = cfg.db.DeleteVideo(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video: %w", err)
+	}
+	return nil
+}
+
+// purgeTrash purges the videos that have been in the trash for longer than
+// the retention window.
+func (cfg *apiConfig) purgeTrash(ctx context.Context) error {
+	videos, err := cfg.db.GetVideosDeletedBefore(time.Now().Add(-cfg.trashRetention))
+	if err != nil {
+		return fmt.Errorf("couldn't get videos to purge: %w", err)
+	}
+	for _, video := range videos {
+		err := cfg.purgeVideo(ctx, video)
+		if err != nil {
+			return fmt.Errorf("couldn't purge video %s: %w", video.ID, err)
+		}
+		log.Printf("Purged video %s, deleted at %s", video.ID, video.DeletedAt)
+	}
+	return nil
+}
+
+// expireVideos moves videos past their scheduled expiration to the trash,
+// from where they're purged like any deleted video.
+func (cfg *apiConfig) expireVideos(ctx context.Context) error {
+	videos, err := cfg.db.GetVideosExpiredBefore(time.Now())
+	if err != nil {
+		return fmt.Errorf("couldn't get expired videos: %w", err)
+	}
+	for _, video := range videos {
+		err := cfg.db.SoftDeleteVideo(video.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't expire video %s: %w", video.ID, err)
+		}
+		log.Printf("Video %s expired at %s", video.ID, video.ExpiresAt)
+		cfg.publishEvent(video.UserID, eventVideoDeleted, video)
+	}
+	return nil
+}
+
+//openapi:summary List deleted videos
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 []trashedVideo
+func (cfg *apiConfig) handlerTrashRetrieve(w http.ResponseWriter, r *http.Request) {
+	type trashedVideo struct {
+		database.Video
+		PurgeAt time.Time `json:"purge_at"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.GetDeletedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trash", err)
+		return
+	}
+
+	resp := make([]trashedVideo, len(videos))
+	for i, video := range videos {
+		resp[i] = trashedVideo{
+			Video:   video,
+			PurgeAt: video.DeletedAt.Add(cfg.trashRetention),
+		}
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+//openapi:summary Restore a deleted video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 database.Video
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	deleted, err := cfg.db.GetDeletedVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if deleted == nil || deleted.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Video isn't in the trash", nil)
+		return
+	}
+
+	err = cfg.db.RestoreVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+const uploadEventRetryDelay = 5 * time.Second
+
+// s3Event is the part of an S3 event notification we use. Notifications
+// forwarded through SNS arrive wrapped, with the event in Message.
+type s3Event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+	Message string `json:"Message"`
+}
+
+// consumeUploadEvents processes direct uploads as S3 reports them, so clients
+// don't have to call the complete endpoint. The bucket should send
+// s3:ObjectCreated events for the uploads/ prefix to the SQS queue at
+// queueURL. It returns when ctx is cancelled; an event being handled then is
+// delivered again later.
+func (cfg *apiConfig) consumeUploadEvents(ctx context.Context, client *sqs.Client, queueURL string) {
+	for ctx.Err() == nil {
+		out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Couldn't receive upload events: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(uploadEventRetryDelay):
+			}
+			continue
+		}
+
+		for _, message := range out.Messages {
+			err := cfg.handleUploadEvent(ctx, aws.ToString(message.Body))
+			if err != nil {
+				// The message becomes visible again and is retried.
+				log.Printf("Couldn't handle upload event: %v", err)
+				continue
+			}
+			_, err = client.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil {
+				log.Printf("Couldn't delete upload event: %v", err)
+			}
+		}
+	}
+}
+
+// handleUploadEvent processes the uploads announced by an event. Events it
+// has no use for, like the test event S3 sends when notifications are set
+// up, are ignored.
+func (cfg *apiConfig) handleUploadEvent(ctx context.Context, body string) error {
+	var event s3Event
+	err := json.Unmarshal([]byte(body), &event)
+	if err != nil {
+		log.Printf("Ignoring malformed upload event: %v", err)
+		return nil
+	}
+	if len(event.Records) == 0 && event.Message != "" {
+		return cfg.handleUploadEvent(ctx, event.Message)
+	}
+
+	for _, record := range event.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") || record.S3.Bucket.Name != cfg.s3Bucket {
+			continue
+		}
+		// Keys in notifications are URL-encoded.
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			log.Printf("Ignoring upload event for invalid key %q: %v", record.S3.Object.Key, err)
+			continue
+		}
+		videoID, ok := parseDirectUploadKey(key)
+		if !ok {
+			continue
+		}
+		err = cfg.processDirectUpload(ctx, videoID)
+		if err != nil {
+			return fmt.Errorf("couldn't process upload of video %s: %w", videoID, err)
+		}
+	}
+	return nil
+}
+
+// parseDirectUploadKey returns the video a direct upload key belongs to.
+// Other objects under uploads/, like sources staged for remote workers, don't
+// match.
+func parseDirectUploadKey(key string) (uuid.UUID, bool) {
+	name, ok := strings.CutPrefix(key, "uploads/")
+	if !ok {
+		return uuid.Nil, false
+	}
+	name, ok = strings.CutSuffix(name, ".mp4")
+	if !ok {
+		return uuid.Nil, false
+	}
+	videoID, err := uuid.Parse(name)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return videoID, true
+}
+
+// processDirectUpload checks and queues a video uploaded straight to the
+// bucket. Rejected uploads are deleted and the reason is recorded on the
+// video, since there's no request to respond to. An error means the upload
+// couldn't be checked and should be tried again.
+func (cfg *apiConfig) processDirectUpload(ctx context.Context, videoID uuid.UUID) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		log.Printf("Ignoring upload for deleted video %s", videoID)
+		return nil
+	}
+	// The client may have completed the upload itself, or the event may be
+	// a duplicate.
+	if video.ProcessingStatus == database.ProcessingStatusPending || video.ProcessingStatus == database.ProcessingStatusProcessing {
+		return nil
+	}
+
+	stagingKey := directUploadKey(videoID)
+	size, err := cfg.checkDirectUpload(ctx, stagingKey)
+	if errors.Is(err, errDirectUploadNotFound) {
+		// Already processed and cleaned up.
+		return nil
+	}
+	if errors.Is(err, errDirectUploadMediaType) || errors.Is(err, errDirectUploadTooLarge) || errors.Is(err, errDirectUploadMismatch) {
+		cfg.rejectDirectUpload(video, stagingKey, err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sourceURL, err := cfg.videoStorage.PresignGet(ctx, stagingKey, cfg.processingTimeout)
+	if err != nil {
+		return fmt.Errorf("couldn't read uploaded object: %w", err)
+	}
+	report, err := cfg.validateVideo(ctx, sourceURL)
+	if err != nil {
+		return fmt.Errorf("couldn't validate video: %w", err)
+	}
+	if len(report.Issues) > 0 {
+		cfg.rejectDirectUpload(video, stagingKey, report.summary())
+		return nil
+	}
+
+	_, err = cfg.enqueueVideoProcessing(ctx, video, processVideoPayload{
+		SourceKey: stagingKey,
+		MediaType: "video/mp4",
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't queue video for processing: %w", err)
+	}
+	metrics.UploadsTotal.WithLabelValues("video").Inc()
+	metrics.UploadSizeBytes.WithLabelValues("video").Observe(float64(size))
+	return nil
+}
+
+func (cfg *apiConfig) rejectDirectUpload(video database.Video, stagingKey, reason string) {
+	log.Printf("Rejected upload of video %s: %s", video.ID, reason)
+
+	err := cfg.videoStorage.Delete(context.Background(), stagingKey)
+	if err != nil {
+		log.Printf("Couldn't delete staging object %s: %v", stagingKey, err)
+	}
+
+	// As with failed processing, media from an earlier upload stays in
+	// place.
+	status := database.ProcessingStatusFailed
+	if video.StorageKey != nil {
+		status = database.ProcessingStatusReady
+	}
+	err = cfg.db.UpdateVideoProcessingStatus(video.ID, status, &reason)
+	if err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", video.ID, err)
+	}
+	video.ProcessingStatus = status
+	video.ProcessingError = &reason
+	cfg.progress.Publish(video.ID, progress.Update{Stage: progress.StageFailed, Error: reason})
+	cfg.publishEvent(video.UserID, eventProcessingFailed, video)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+// uploadProgressStep is how many bytes of an upload are received between two
+// progress updates.
+const uploadProgressStep = 1 << 20
+
+// progressReader passes the running number of bytes read through it to
+// report.
+type progressReader struct {
+	r      io.Reader
+	read   int64
+	report func(read int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.report(p.read)
+	}
+	return n, err
+}
+
+// Close closes the underlying reader, so a progressReader can stand in for a
+// request body.
+func (p *progressReader) Close() error {
+	if c, ok := p.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// uploadProgress returns a callback reporting the bytes received of an upload
+// of total bytes, or of unknown size if total isn't positive.
+func (cfg *apiConfig) uploadProgress(videoID uuid.UUID, total int64) func(received int64) {
+	var last int64
+	return func(received int64) {
+		if received-last < uploadProgressStep && received != total {
+			return
+		}
+		last = received
+
+		update := progress.Update{
+			Stage:         progress.StageUploading,
+			BytesReceived: received,
+		}
+		if total > 0 {
+			update.BytesTotal = total
+			update.Percent = min(float64(received)/float64(total)*100, 100)
+		}
+		cfg.progress.Publish(videoID, update)
+	}
+}
+
+// stageProgress returns a callback reporting the percentage done of a stage.
+// Updates that advance it by less than a percent are dropped.
+func (cfg *apiConfig) stageProgress(videoID uuid.UUID, stage progress.Stage) func(percent float64) {
+	last := -1.0
+	return func(percent float64) {
+		if percent-last < 1 && (percent < 100 || last >= 100) {
+			return
+		}
+		last = percent
+		cfg.progress.Publish(videoID, progress.Update{Stage: stage, Percent: percent})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// addUsage records a change to a user's usage. Failures are only logged, so
+// accounting never fails the change it accounts for.
+func (cfg *apiConfig) addUsage(userID uuid.UUID, delta database.UsageDelta) {
+	err := cfg.db.AddUserUsage(userID, delta)
+	if err != nil {
+		log.Printf("Couldn't record usage of user %s: %v", userID, err)
+	}
+}
+
+// recordProcessingTime adds the time a processing attempt for a user's
+// video took, whether it succeeded or not.
+func (cfg *apiConfig) recordProcessingTime(userID uuid.UUID, start time.Time) {
+	cfg.addUsage(userID, database.UsageDelta{ProcessingSeconds: time.Since(start).Seconds()})
+}
+
+// assetFileSize returns the size of a file stored in the assets directory,
+// or 0 if it doesn't exist.
+func (cfg *apiConfig) assetFileSize(key string) int64 {
+	diskPath, err := storage.LocalPath(cfg.assetsRoot, key)
+	if err != nil {
+		return 0
+	}
+	info, err := os.Stat(diskPath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// putThumbnailObject stores a user's thumbnail, counting it towards the
+// user's usage if it's stored on disk.
+func (cfg *apiConfig) putThumbnailObject(ctx context.Context, userID uuid.UUID, key string, data []byte, mediaType string) error {
+	err := cfg.thumbnailStorage.Put(ctx, key, bytes.NewReader(data), mediaType)
+	if err != nil {
+		return err
+	}
+	if cfg.thumbnailStorageName == "local" {
+		cfg.addUsage(userID, database.UsageDelta{ThumbnailBytes: int64(len(data))})
+	}
+	return nil
+}
+
+// deleteThumbnailObject deletes a user's thumbnail, taking it off the user's
+// usage if it was stored on disk.
+func (cfg *apiConfig) deleteThumbnailObject(ctx context.Context, userID uuid.UUID, key string) error {
+	var size int64
+	if cfg.thumbnailStorageName == "local" {
+		size = cfg.assetFileSize(key)
+	}
+	err := cfg.thumbnailStorage.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		cfg.addUsage(userID, database.UsageDelta{ThumbnailBytes: -size})
+	}
+	return nil
+}
+
+// backfillUsage computes the usage of existing videos once, when usage
+// tracking is first enabled on a database. Thumbnails on disk are measured
+// here since their sizes aren't recorded.
+func (cfg *apiConfig) backfillUsage() error {
+	backfilled, err := cfg.db.BackfillUserUsage()
+	if err != nil {
+		return fmt.Errorf("couldn't backfill usage: %w", err)
+	}
+	if !backfilled {
+		return nil
+	}
+
+	if cfg.thumbnailStorageName == "local" {
+		videos, err := cfg.db.GetAllVideos()
+		if err != nil {
+			return fmt.Errorf("couldn't get videos: %w", err)
+		}
+		for _, video := range videos {
+			var size int64
+			for _, thumbnailURL := range thumbnailURLs(video) {
+				key, ok := cfg.thumbnailStorage.KeyFromURL(thumbnailURL)
+				if ok {
+					size += cfg.assetFileSize(key)
+				}
+			}
+			if size > 0 {
+				err = cfg.db.AddUserUsage(video.UserID, database.UsageDelta{ThumbnailBytes: size})
+				if err != nil {
+					return fmt.Errorf("couldn't backfill thumbnail usage: %w", err)
+				}
+			}
+		}
+	}
+	log.Printf("Backfilled storage usage of existing videos")
+	return nil
+}
+
+// userUsage is the usage of a user as reported to admins.
+type userUsage struct {
+	UserID            uuid.UUID `json:"user_id"`
+	Email             string    `json:"email"`
+	VideoCount        int       `json:"video_count"`
+	StorageBytes      int64     `json:"storage_bytes"`
+	ThumbnailBytes    int64     `json:"thumbnail_bytes"`
+	ProcessingMinutes float64   `json:"processing_minutes"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// usageReport returns the usage of every user that has stored anything,
+// largest storage first.
+func (cfg *apiConfig) usageReport() ([]userUsage, error) {
+	usages, err := cfg.db.GetAllUserUsage()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get usage: %w", err)
+	}
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get users: %w", err)
+	}
+	emails := map[uuid.UUID]string{}
+	for _, user := range users {
+		emails[user.ID] = user.Email
+	}
+
+	report := make([]userUsage, 0, len(usages))
+	for _, usage := range usages {
+		report = append(report, userUsage{
+			UserID:            usage.UserID,
+			Email:             emails[usage.UserID],
+			VideoCount:        usage.VideoCount,
+			StorageBytes:      usage.StorageBytes,
+			ThumbnailBytes:    usage.ThumbnailBytes,
+			ProcessingMinutes: math.Round(usage.ProcessingSeconds/60*100) / 100,
+			UpdatedAt:         usage.UpdatedAt,
+		})
+	}
+	return report, nil
+}
+
+//openapi:summary Get the usage of every user
+//openapi:tags admin
+//openapi:response 200 []userUsage
+func (cfg *apiConfig) handlerAdminUsage(w http.ResponseWriter, r *http.Request) {
+	report, err := cfg.usageReport()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
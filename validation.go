@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// validationDecodeSeconds is how much of an upload is decoded to check it for
+// corruption. Decoding all of it would take as long as a transcode.
+const validationDecodeSeconds = 5
+
+// Rules an upload can break, reported in validationIssue.Rule.
+const (
+	ruleUnreadable    = "unreadable"
+	ruleNoVideoStream = "no_video_stream"
+	ruleZeroDuration  = "zero_duration"
+	ruleDecodeErrors  = "decode_errors"
+	ruleMaxDuration   = "max_duration"
+	ruleMaxResolution = "max_resolution"
+)
+
+// videoLimits bound the uploads that are accepted. Zero disables a limit.
+// The resolution limits apply to either orientation, so a 1080x1920 video
+// passes a limit of 1920x1080.
+type videoLimits struct {
+	maxDuration time.Duration
+	maxWidth    int
+	maxHeight   int
+}
+
+type validationIssue struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// validationReport lists why an upload was rejected, along with what ffprobe
+// could make of it.
+type validationReport struct {
+	Metadata *database.VideoMetadata `json:"metadata,omitempty"`
+	Issues   []validationIssue       `json:"issues"`
+}
+
+func (r *validationReport) add(rule, format string, args ...any) {
+	r.Issues = append(r.Issues, validationIssue{Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateVideo checks the video at source, a path or URL, before it's
+// accepted for processing. Content problems are reported as issues; an error
+// means the video couldn't be checked at all.
+func (cfg *apiConfig) validateVideo(ctx context.Context, source string) (validationReport, error) {
+	var report validationReport
+
+	// Hosts using MediaConvert may not have ffmpeg; the video is checked
+	// against the limits once MediaConvert has probed it for processing.
+	if cfg.mediaConvert != nil {
+		return report, nil
+	}
+
+	metadata, err := probeVideo(ctx, source)
+	if errors.Is(err, errUnreadableVideo) {
+		report.add(ruleUnreadable, "%v", err)
+		return report, nil
+	}
+	if errors.Is(err, errNoVideoStream) {
+		report.add(ruleNoVideoStream, "The file has no video stream")
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	report.Metadata = &metadata
+	report.checkLimits(metadata, cfg.videoLimits)
+
+	decodeErrors, err := checkVideoDecodes(ctx, source)
+	if err != nil {
+		return report, err
+	}
+	if decodeErrors != "" {
+		report.add(ruleDecodeErrors, "%s", decodeErrors)
+	}
+	return report, nil
+}
+
+// checkLimits reports the ways the probed video breaks limits.
+func (r *validationReport) checkLimits(metadata database.VideoMetadata, limits videoLimits) {
+	if metadata.DurationSeconds <= 0 {
+		r.add(ruleZeroDuration, "The video has no duration")
+	}
+	duration := time.Duration(metadata.DurationSeconds * float64(time.Second))
+	if limits.maxDuration > 0 && duration > limits.maxDuration {
+		r.add(ruleMaxDuration, "The video is %s long, the maximum is %s", duration.Round(time.Second), limits.maxDuration)
+	}
+	if !limits.allowsResolution(metadata.Width, metadata.Height) {
+		r.add(ruleMaxResolution, "The video is %dx%d, the maximum is %dx%d", metadata.Width, metadata.Height, limits.maxWidth, limits.maxHeight)
+	}
+}
+
+// summary joins the messages of the issues into one line.
+func (r validationReport) summary() string {
+	messages := make([]string, len(r.Issues))
+	for i, issue := range r.Issues {
+		messages[i] = issue.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (l videoLimits) allowsResolution(width, height int) bool {
+	if l.maxWidth <= 0 || l.maxHeight <= 0 {
+		return true
+	}
+	long, short := max(width, height), min(width, height)
+	return long <= max(l.maxWidth, l.maxHeight) && short <= min(l.maxWidth, l.maxHeight)
+}
+
+// checkVideoDecodes decodes the start of the video stream at source and
+// returns the errors ffmpeg reported, if any.
+func checkVideoDecodes(ctx context.Context, source string) (string, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		ffmpegBinary,
+		"-v",
+		"error",
+		"-t",
+		fmt.Sprint(validationDecodeSeconds),
+		"-i",
+		source,
+		"-map",
+		"0:v:0",
+		"-f",
+		"null",
+		"-",
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := runLimited(ctx, cmd)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", err
+	}
+	output := strings.TrimSpace(stderr.String())
+	if output == "" && err != nil {
+		output = err.Error()
+	}
+	return output, nil
+}
+
+func respondWithValidationReport(w http.ResponseWriter, report validationReport) {
+	respondWithErrorCode(w, http.StatusUnprocessableEntity, errCodeValidationFailed, "Video failed validation", report, nil)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+// legacyAPIDeprecatedAt is when the unversioned API paths were deprecated in
+// favor of /api/v1, reported in the Deprecation header of their responses.
+var legacyAPIDeprecatedAt = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// apiVersion collects the routes of a version of the API, served under
+// /api/<name>. A version can be based on an earlier one and inherit the
+// routes it doesn't register itself, so a breaking change, like a new shape
+// of video URLs, only needs the affected routes registered in a new version.
+type apiVersion struct {
+	name   string
+	base   *apiVersion
+	routes []apiRoute
+}
+
+type apiRoute struct {
+	method string
+	// path is relative to the version's prefix, e.g. /videos/{videoID}.
+	path    string
+	handler http.HandlerFunc
+	// legacyPath is the unversioned path the route was served at before
+	// the API was versioned, if any.
+	legacyPath string
+}
+
+func newAPIVersion(name string, base *apiVersion) *apiVersion {
+	return &apiVersion{name: name, base: base}
+}
+
+func (v *apiVersion) prefix() string {
+	return "/api/" + v.name
+}
+
+// HandleFunc registers a route of the version. The pattern has to include
+// the version's prefix, e.g. GET /api/v1/videos. Routes that existed before
+// the API was versioned pass their old path as well, which is served too
+// until the legacy API is turned off.
+func (v *apiVersion) HandleFunc(pattern string, handler http.HandlerFunc, legacyPath ...string) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		panic(fmt.Sprintf("route %s has no method", pattern))
+	}
+	path, ok = strings.CutPrefix(path, v.prefix())
+	if !ok || !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("route %s isn't under %s", pattern, v.prefix()))
+	}
+	if len(legacyPath) > 1 {
+		panic(fmt.Sprintf("route %s has more than one legacy path", pattern))
+	}
+
+	route := apiRoute{method: method, path: path, handler: handler}
+	if len(legacyPath) == 1 {
+		route.legacyPath = legacyPath[0]
+	}
+	v.routes = append(v.routes, route)
+}
+
+// legacyAPI configures how the unversioned paths are served during their
+// deprecation period.
+type legacyAPI struct {
+	// sunset is when the paths will stop working, if that's been decided.
+	sunset time.Time
+}
+
+// register serves the version's routes, and those it inherits, on mux. The
+// legacy paths of its own routes are served as well unless legacy is nil.
+func (v *apiVersion) register(mux *http.ServeMux, legacy *legacyAPI) {
+	registered := map[string]bool{}
+	for version := v; version != nil; version = version.base {
+		for _, route := range version.routes {
+			key := route.method + " " + route.path
+			if registered[key] {
+				continue
+			}
+			registered[key] = true
+			mux.HandleFunc(route.method+" "+v.prefix()+route.path, route.handler)
+		}
+	}
+
+	if legacy == nil {
+		return
+	}
+	for _, route := range v.routes {
+		if route.legacyPath != "" {
+			mux.HandleFunc(route.method+" "+route.legacyPath, legacy.handler(v.prefix()+route.path, route.handler))
+		}
+	}
+}
+
+var routeParam = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// handler serves a legacy path with next, telling clients in the response
+// headers that it's deprecated and which path replaces it.
+func (l *legacyAPI) handler(successorPath string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := routeParam.ReplaceAllStringFunc(successorPath, func(param string) string {
+			name := routeParam.FindStringSubmatch(param)[1]
+			return url.PathEscape(r.PathValue(name))
+		})
+		if r.URL.RawQuery != "" {
+			successor += "?" + r.URL.RawQuery
+		}
+
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(legacyAPIDeprecatedAt.Unix(), 10))
+		if !l.sunset.IsZero() {
+			w.Header().Set("Sunset", l.sunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		metrics.LegacyAPIRequestsTotal.WithLabelValues(r.Pattern).Inc()
+		next(w, r)
+	}
+}
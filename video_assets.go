@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// deleteVideoAssets removes everything stored for a video: the MP4 and its
+// previous versions, its MP4 and HLS renditions, its scrub preview sprites,
+// its preview clip, its captions and the thumbnail with its variants. Objects
+// shared with deduplicated uploads are kept until the last video referencing
+// them is deleted.
+func (cfg *apiConfig) deleteVideoAssets(ctx context.Context, video database.Video) error {
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video versions: %w", err)
+	}
+	objects := map[[2]string]bool{}
+	if video.StorageKey != nil {
+		bucket := ""
+		if video.StorageBucket != nil {
+			bucket = *video.StorageBucket
+		}
+		objects[[2]string{bucket, *video.StorageKey}] = true
+	}
+	for _, version := range versions {
+		objects[[2]string{version.StorageBucket, version.StorageKey}] = true
+	}
+	for object := range objects {
+		shared, err := cfg.isVideoObjectShared(object[0], object[1], video.ID)
+		if err != nil {
+			return err
+		}
+		if shared {
+			continue
+		}
+		err = cfg.videoStorage.Delete(ctx, object[1])
+		if err != nil {
+			return fmt.Errorf("couldn't delete video object: %w", err)
+		}
+	}
+
+	renditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video renditions: %w", err)
+	}
+	for _, rendition := range renditions {
+		refs, err := cfg.db.CountRenditionStorageKeyReferences(rendition.StorageBucket, rendition.StorageKey, video.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't count references to %s: %w", rendition.StorageKey, err)
+		}
+		if refs > 0 {
+			continue
+		}
+		err = cfg.videoStorage.Delete(ctx, rendition.StorageKey)
+		if err != nil {
+			return fmt.Errorf("couldn't delete rendition: %w", err)
+		}
+	}
+
+	if video.HLSURL != nil {
+		shared, err := cfg.isVideoURLShared(*video.HLSURL, video)
+		if err != nil {
+			return err
+		}
+		manifestKey, ok := cfg.videoStorage.KeyFromURL(*video.HLSURL)
+		if ok && !shared {
+			err := cfg.videoStorage.DeletePrefix(ctx, path.Dir(manifestKey)+"/")
+			if err != nil {
+				return fmt.Errorf("couldn't delete HLS renditions: %w", err)
+			}
+		}
+	}
+
+	if video.SpriteVTTURL != nil {
+		shared, err := cfg.isVideoURLShared(*video.SpriteVTTURL, video)
+		if err != nil {
+			return err
+		}
+		vttKey, ok := cfg.videoStorage.KeyFromURL(*video.SpriteVTTURL)
+		if ok && !shared {
+			err := cfg.videoStorage.DeletePrefix(ctx, path.Dir(vttKey)+"/")
+			if err != nil {
+				return fmt.Errorf("couldn't delete sprites: %w", err)
+			}
+		}
+	}
+
+	if video.PreviewURL != nil {
+		shared, err := cfg.isVideoURLShared(*video.PreviewURL, video)
+		if err != nil {
+			return err
+		}
+		key, ok := cfg.videoStorage.KeyFromURL(*video.PreviewURL)
+		if ok && !shared {
+			err := cfg.videoStorage.Delete(ctx, key)
+			if err != nil {
+				return fmt.Errorf("couldn't delete preview clip: %w", err)
+			}
+		}
+	}
+
+	for _, prefix := range cfg.videoAssetPrefixes(video, aspectAudio) {
+		err = cfg.videoStorage.DeletePrefix(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("couldn't delete extracted audio: %w", err)
+		}
+	}
+
+	for _, prefix := range cfg.videoAssetPrefixes(video, aspectCaptions) {
+		err = cfg.videoStorage.DeletePrefix(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("couldn't delete captions: %w", err)
+		}
+	}
+
+	for _, thumbnailURL := range thumbnailURLs(video) {
+		key, ok := cfg.thumbnailStorage.KeyFromURL(thumbnailURL)
+		if ok {
+			err := cfg.deleteThumbnailObject(ctx, video.UserID, key)
+			if err != nil {
+				return fmt.Errorf("couldn't delete thumbnail: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) isVideoURLShared(url string, video database.Video) (bool, error) {
+	refs, err := cfg.db.CountVideoURLReferences(url, video.ID)
+	if err != nil {
+		return false, fmt.Errorf("couldn't count references to %s: %w", url, err)
+	}
+	return refs > 0, nil
+}
+
+// backfillVideoStorageKeys records the storage location of videos uploaded
+// before it was stored separately, by parsing their video URL once.
+func (cfg *apiConfig) backfillVideoStorageKeys() error {
+	videos, err := cfg.db.GetVideosMissingStorageKey()
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		key, ok := cfg.videoStorage.KeyFromURL(*video.VideoURL)
+		if !ok {
+			log.Printf("Couldn't backfill storage key of video %s: unrecognized URL %s", video.ID, *video.VideoURL)
+			continue
+		}
+		err = cfg.db.SetVideoStorageLocation(video.ID, cfg.videoStorage.Bucket(), key)
+		if err != nil {
+			return fmt.Errorf("couldn't backfill storage key of video %s: %w", video.ID, err)
+		}
+	}
+	return nil
+}
+
+// reuseProcessedVideo points video at the stored objects of existing, an
+// earlier upload with identical content, skipping transcoding and upload.
+func (cfg *apiConfig) reuseProcessedVideo(ctx context.Context, video, existing database.Video, srcPath string, previousKey *string) (database.Video, error) {
+	video.VideoURL = existing.VideoURL
+	video.StorageBucket = existing.StorageBucket
+	video.StorageKey = existing.StorageKey
+	video.ContentSHA256 = existing.ContentSHA256
+	video.HLSURL = existing.HLSURL
+	video.SpriteURL = existing.SpriteURL
+	video.SpriteVTTURL = existing.SpriteVTTURL
+	video.PreviewURL = existing.PreviewURL
+	video.Metadata = existing.Metadata
+
+	var undo rollback
+	defer undo.run()
+
+	if video.ThumbnailURL == nil && cfg.autoThumbnailEnabled {
+		err := cfg.generateThumbnail(ctx, &video, srcPath)
+		if err != nil {
+			log.Printf("Couldn't generate thumbnail for video %s: %v", video.ID, err)
+		} else {
+			thumbnailed := video
+			undo.add(func() { cfg.deleteThumbnail(context.Background(), thumbnailed) })
+		}
+	}
+
+	video.ProcessingStatus = database.ProcessingStatusReady
+	video.ProcessingError = nil
+	cfg.holdForModeration(&video)
+	err := cfg.db.SwapVideoMedia(video, previousKey)
+	if errors.Is(err, database.ErrVideoMediaChanged) {
+		return video, err
+	}
+	if err != nil {
+		return video, fmt.Errorf("couldn't update video: %w", err)
+	}
+	undo.commit()
+
+	err = cfg.reuseVideoRenditions(ctx, video, existing)
+	if err != nil {
+		log.Printf("Couldn't reuse renditions for video %s: %v", video.ID, err)
+	}
+	return video, nil
+}
+
+// deleteReplacedMedia removes the assets derived from the media of previous
+// that video no longer uses after its media was replaced. The video object
+// itself is kept as a version and deleted once it's pruned from the history.
+// The swap is already committed, so failures are only logged; the asset GC
+// collects whatever is left behind.
+func (cfg *apiConfig) deleteReplacedMedia(ctx context.Context, previous, video database.Video) {
+	if previous.StorageKey == nil {
+		return
+	}
+
+	// HLS renditions and sprites are stored under a prefix next to the URL
+	// that references them; previews are a single object.
+	derived := []struct {
+		old, new *string
+		prefix   bool
+	}{
+		{previous.HLSURL, video.HLSURL, true},
+		{previous.SpriteVTTURL, video.SpriteVTTURL, true},
+		{previous.PreviewURL, video.PreviewURL, false},
+	}
+	for _, asset := range derived {
+		if asset.old == nil || (asset.new != nil && *asset.old == *asset.new) {
+			continue
+		}
+		shared, err := cfg.isVideoURLShared(*asset.old, video)
+		if err != nil {
+			log.Printf("Couldn't check whether %s is shared: %v", *asset.old, err)
+			continue
+		}
+		key, ok := cfg.videoStorage.KeyFromURL(*asset.old)
+		if !ok || shared {
+			continue
+		}
+		if asset.prefix {
+			err = cfg.videoStorage.DeletePrefix(ctx, path.Dir(key)+"/")
+		} else {
+			err = cfg.videoStorage.Delete(ctx, key)
+		}
+		if err != nil {
+			log.Printf("Couldn't delete replaced asset %s: %v", key, err)
+		}
+	}
+
+	// Extracted audio belongs to the old media and is extracted again on
+	// request.
+	for _, prefix := range cfg.videoAssetPrefixes(video, aspectAudio) {
+		err := cfg.videoStorage.DeletePrefix(ctx, prefix)
+		if err != nil {
+			log.Printf("Couldn't delete extracted audio of video %s: %v", video.ID, err)
+		}
+	}
+}
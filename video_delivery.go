@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	videoDeliveryPublic    = "public"
+	videoDeliveryPresigned = "presigned"
+	// videoDeliveryCloudFrontSigned serves videos through a CloudFront
+	// distribution using signed URLs instead of S3 presigning.
+	videoDeliveryCloudFrontSigned = "cloudfront-signed"
+
+	// publicVideoExpiry is used for public videos so their links can be
+	// embedded and cached. It's the longest expiry SigV4 presigning allows.
+	publicVideoExpiry = 7 * 24 * time.Hour
+)
+
+// dbVideoToSignedVideo rewrites the stored VideoURL into a signed URL valid
+// for expiry when videos are delivered through presigned or CloudFront
+// signed links. Sprites, previews, captions and thumbnails kept in a bucket
+// are signed the same way since they live in the same private bucket. The
+// HLS playlist is served through hlsPlaylistRoute, which signs the segments
+// it refers to. The sprite WebVTT track refers to its sheet by a relative
+// URL, which carries no signature, so players should load the sheet from
+// sprite_url in these modes. In public delivery mode the video is returned
+// unchanged, except that archived videos have no video URL in any mode and
+// that assets on local disk are still signed when LOCAL_ASSET_SIGNING_KEY is
+// set.
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video, expiry time.Duration) (database.Video, error) {
+	// An archived video can't be played until it's restored.
+	if !video.ArchiveStatus.Playable() {
+		video.VideoURL = nil
+	}
+	signVideos := cfg.signsURLsIn(cfg.videoStorage)
+	if !signVideos && !cfg.signsURLsIn(cfg.thumbnailStorage) {
+		return video, nil
+	}
+
+	if signVideos && video.StorageKey != nil && video.VideoURL != nil {
+		signedURL, err := cfg.signedURL(ctx, cfg.videoStorage, *video.StorageKey, expiry)
+		if err != nil {
+			return video, fmt.Errorf("couldn't sign video URL: %w", err)
+		}
+		video.VideoURL = &signedURL
+	}
+
+	if signVideos && video.HLSURL != nil {
+		if key, ok := cfg.videoStorage.KeyFromURL(*video.HLSURL); ok {
+			hlsURL := cfg.signedHLSPlaylistURL(key, time.Now().Add(expiry))
+			video.HLSURL = &hlsURL
+		}
+	}
+
+	var err error
+	video.SpriteURL, err = cfg.signVideoStorageURL(ctx, video.SpriteURL, expiry)
+	if err != nil {
+		return video, fmt.Errorf("couldn't sign sprite URL: %w", err)
+	}
+	video.SpriteVTTURL, err = cfg.signVideoStorageURL(ctx, video.SpriteVTTURL, expiry)
+	if err != nil {
+		return video, fmt.Errorf("couldn't sign sprite WebVTT URL: %w", err)
+	}
+	video.PreviewURL, err = cfg.signVideoStorageURL(ctx, video.PreviewURL, expiry)
+	if err != nil {
+		return video, fmt.Errorf("couldn't sign preview URL: %w", err)
+	}
+	err = cfg.signCaptions(ctx, video.Captions, expiry)
+	if err != nil {
+		return video, fmt.Errorf("couldn't sign caption URLs: %w", err)
+	}
+
+	if video.ThumbnailURL != nil && cfg.signsURLsIn(cfg.thumbnailStorage) {
+		signed := map[string]string{}
+		for _, thumbnailURL := range thumbnailURLs(video) {
+			key, ok := cfg.thumbnailStorage.KeyFromURL(thumbnailURL)
+			if !ok {
+				continue
+			}
+			signedURL, err := cfg.signedURL(ctx, cfg.thumbnailStorage, key, expiry)
+			if err != nil {
+				return video, fmt.Errorf("couldn't sign thumbnail URL: %w", err)
+			}
+			signed[thumbnailURL] = signedURL
+		}
+
+		if signedURL, ok := signed[*video.ThumbnailURL]; ok {
+			video.ThumbnailURL = &signedURL
+		}
+		if video.ThumbnailSrcset != nil {
+			srcset := make(database.ThumbnailSrcset, len(video.ThumbnailSrcset))
+			for descriptor, variantURL := range video.ThumbnailSrcset {
+				if signedURL, ok := signed[variantURL]; ok {
+					variantURL = signedURL
+				}
+				srcset[descriptor] = variantURL
+			}
+			video.ThumbnailSrcset = srcset
+		}
+	}
+	return video, nil
+}
+
+// signVideoStorageURL signs a URL of an object in the video storage. URLs
+// that are nil or point elsewhere are returned unchanged.
+func (cfg *apiConfig) signVideoStorageURL(ctx context.Context, url *string, expiry time.Duration) (*string, error) {
+	if url == nil || !cfg.signsURLsIn(cfg.videoStorage) {
+		return url, nil
+	}
+	key, ok := cfg.videoStorage.KeyFromURL(*url)
+	if !ok {
+		return url, nil
+	}
+	signedURL, err := cfg.signedURL(ctx, cfg.videoStorage, key, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return &signedURL, nil
+}
+
+// signsURLsIn reports whether URLs of objects in st are signed when they're
+// handed out. Local assets are served by this server rather than a bucket or
+// CDN, so they're only signed when LOCAL_ASSET_SIGNING_KEY is set, whatever
+// the delivery mode.
+func (cfg *apiConfig) signsURLsIn(st storage.Storage) bool {
+	if _, ok := st.(*storage.Local); ok {
+		return cfg.signedLocalAssets
+	}
+	return cfg.videoDelivery != videoDeliveryPublic
+}
+
+func (cfg *apiConfig) signedURL(ctx context.Context, st storage.Storage, key string, expiry time.Duration) (string, error) {
+	if _, ok := st.(*storage.Local); ok {
+		return st.PresignGet(ctx, key, expiry)
+	}
+	if cfg.videoDelivery == videoDeliveryCloudFrontSigned {
+		return cfg.cdnSigner.SignedURL(key, expiry)
+	}
+	return st.PresignGet(ctx, key, expiry)
+}
+
+// presignExpiryFromRequest returns the presign TTL for a request. Clients can
+// ask for shorter-lived links, e.g. for sharing, with the expires_in query
+// parameter in seconds; it can't exceed the configured TTL.
+func (cfg *apiConfig) presignExpiryFromRequest(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("expires_in")
+	if value == "" {
+		return cfg.presignExpiry, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, errors.New("expires_in must be a positive number of seconds")
+	}
+	expiry := time.Duration(seconds) * time.Second
+	if expiry > cfg.presignExpiry {
+		return cfg.presignExpiry, nil
+	}
+	return expiry, nil
+}
+
+// canViewVideo reports whether userID, which is uuid.Nil for anonymous
+// requests, may get a playable URL for the video. Private videos are only
+// visible to their owner; unlisted and public ones to anyone with the ID once
+// they're published and cleared by moderation.
+func canViewVideo(video database.Video, userID uuid.UUID) bool {
+	if video.UserID == userID {
+		return true
+	}
+	if !isPublished(video, time.Now()) || !video.ModerationStatus.Cleared() {
+		return false
+	}
+	return video.Visibility == database.VisibilityUnlisted || video.Visibility == database.VisibilityPublic
+}
+
+// isPublished reports whether a video's scheduled publish time, if any, has
+// passed. Until then the video is treated as private.
+func isPublished(video database.Video, now time.Time) bool {
+	return video.PublishAt == nil || !video.PublishAt.After(now)
+}
+
+// videoExpiry returns the signed URL expiry for a video. Public videos get
+// long-lived links regardless of what was requested. Links never outlive a
+// scheduled expiration.
+func videoExpiry(video database.Video, requested time.Duration) time.Duration {
+	expiry := requested
+	if video.Visibility == database.VisibilityPublic && isPublished(video, time.Now()) {
+		expiry = publicVideoExpiry
+	}
+	if video.ExpiresAt != nil {
+		if remaining := time.Until(*video.ExpiresAt); remaining > 0 && remaining < expiry {
+			expiry = remaining
+		}
+	}
+	return expiry
+}
+
+// optionalUserID returns the authenticated user for requests that carry a
+// bearer token and uuid.Nil for anonymous ones. A token that is present but
+// invalid is still an error.
+func (cfg *apiConfig) optionalUserID(r *http.Request) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return auth.ValidateJWT(token, cfg.jwtKeys)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// With public video delivery and signed local thumbnails, only the
+// thumbnails are signed; the rest of the video's URLs are left as they are.
+func TestSignedVideoWithOnlyThumbnailsSigned(t *testing.T) {
+	videoStorage := storage.NewS3(s3.New(s3.Options{Region: "us-east-1"}), "tubely", "https://tubely.s3.amazonaws.com")
+	thumbnailStorage := storage.NewLocal(t.TempDir(), localAssetsURL("8091"))
+	thumbnailStorage.SetSigningKey([]byte("test-signing-key"))
+	cfg := apiConfig{
+		videoDelivery:     videoDeliveryPublic,
+		videoStorage:      videoStorage,
+		thumbnailStorage:  thumbnailStorage,
+		signedLocalAssets: true,
+	}
+
+	ptr := func(s string) *string { return &s }
+	video := database.Video{
+		ID:           uuid.New(),
+		VideoURL:     ptr(videoStorage.URL("videos/a.mp4")),
+		StorageKey:   ptr("videos/a.mp4"),
+		ThumbnailURL: ptr(thumbnailStorage.URL("ab/cd/abcd.png")),
+		SpriteURL:    ptr(videoStorage.URL("sprites/a.jpg")),
+		SpriteVTTURL: ptr(videoStorage.URL("sprites/a.vtt")),
+		PreviewURL:   ptr(videoStorage.URL("previews/a.mp4")),
+	}
+
+	signed, err := cfg.dbVideoToSignedVideo(context.Background(), video, time.Hour)
+	if err != nil {
+		t.Fatalf("dbVideoToSignedVideo: %v", err)
+	}
+
+	unchanged := []struct {
+		name      string
+		got, want *string
+	}{
+		{"video_url", signed.VideoURL, video.VideoURL},
+		{"sprite_url", signed.SpriteURL, video.SpriteURL},
+		{"sprite_vtt_url", signed.SpriteVTTURL, video.SpriteVTTURL},
+		{"preview_url", signed.PreviewURL, video.PreviewURL},
+	}
+	for _, tt := range unchanged {
+		if tt.got == nil || *tt.got != *tt.want {
+			t.Errorf("%s: got %v, want %q", tt.name, tt.got, *tt.want)
+		}
+	}
+
+	if signed.ThumbnailURL == nil {
+		t.Fatal("thumbnail_url: got nil")
+	}
+	u, err := url.Parse(*signed.ThumbnailURL)
+	if err != nil {
+		t.Fatalf("parsing thumbnail_url %s: %v", *signed.ThumbnailURL, err)
+	}
+	if u.Query().Get("signature") == "" {
+		t.Errorf("thumbnail_url isn't signed: %s", *signed.ThumbnailURL)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultVideoPageSize = 50
+	maxVideoPageSize     = 100
+
+	// nextCursorHeader carries the cursor for the next page of a video
+	// listing. It's absent on the last page.
+	nextCursorHeader = "X-Next-Cursor"
+
+	// videoCursorVersion prefixes cursors, so a change of their format can
+	// tell the cursors issued before it apart. Unversioned cursors are from
+	// before their timestamps had microseconds, so they can't be read
+	// reliably.
+	videoCursorVersion = "v2"
+)
+
+// errStaleCursor is returned for cursors in a format that's no longer
+// issued.
+var errStaleCursor = errors.New("cursor is from an older version of the API, list again from the first page")
+
+// listVideosParamsFromRequest parses the limit, cursor, status, tag and sort
+// query parameters of a video listing.
+func listVideosParamsFromRequest(r *http.Request, userID uuid.UUID) (database.ListVideosParams, error) {
+	query := r.URL.Query()
+	params := database.ListVideosParams{
+		UserID: userID,
+		Limit:  defaultVideoPageSize,
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return params, errors.New("limit must be a positive number")
+		}
+		params.Limit = min(limit, maxVideoPageSize)
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeVideoCursor(value)
+		if errors.Is(err, errStaleCursor) {
+			return params, err
+		}
+		if err != nil {
+			return params, errors.New("invalid cursor")
+		}
+		params.After = &cursor
+	}
+
+	if value := query.Get("status"); value != "" {
+		status := database.ProcessingStatus(value)
+		switch status {
+		case database.ProcessingStatusPending,
+			database.ProcessingStatusProcessing,
+			database.ProcessingStatusReady,
+			database.ProcessingStatusFailed:
+		default:
+			return params, fmt.Errorf("unknown processing status %q", value)
+		}
+		params.Status = &status
+	}
+
+	if value := query.Get("tag"); value != "" {
+		tag, err := normalizeTag(value)
+		if err != nil {
+			return params, err
+		}
+		params.Tag = &tag
+	}
+
+	switch query.Get("sort") {
+	case "", "-created_at":
+	case "created_at":
+		params.Ascending = true
+	default:
+		return params, errors.New("sort must be created_at or -created_at")
+	}
+
+	return params, nil
+}
+
+// signURLsFromRequest reports whether video URLs should be signed for the
+// listing. Clients that only need titles and statuses can pass
+// sign_urls=false and fetch a signed URL per video on demand.
+func signURLsFromRequest(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("sign_urls")
+	if value == "" {
+		return true, nil
+	}
+	sign, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("sign_urls must be true or false")
+	}
+	return sign, nil
+}
+
+func encodeVideoCursor(cursor database.VideoCursor) string {
+	raw := fmt.Sprintf("%s:%d:%s", videoCursorVersion, cursor.CreatedAt.UnixMicro(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeVideoCursor(value string) (database.VideoCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) == 2 {
+		return database.VideoCursor{}, errStaleCursor
+	}
+	if len(parts) != 3 || parts[0] != videoCursorVersion {
+		return database.VideoCursor{}, errors.New("malformed cursor")
+	}
+	timestamp, id := parts[1], parts[2]
+	micros, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	videoID, err := uuid.Parse(id)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	return database.VideoCursor{
+		CreatedAt: time.UnixMicro(micros).UTC(),
+		ID:        videoID,
+	}, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// isVideoObjectShared reports whether a video object is used by a video
+// other than videoID, either as its current media or as one of its versions.
+func (cfg *apiConfig) isVideoObjectShared(bucket, key string, videoID uuid.UUID) (bool, error) {
+	refs, err := cfg.db.CountStorageKeyReferences(bucket, key, videoID)
+	if err != nil {
+		return false, fmt.Errorf("couldn't count references to %s: %w", key, err)
+	}
+	if refs > 0 {
+		return true, nil
+	}
+	refs, err = cfg.db.CountVersionStorageKeyReferences(bucket, key, videoID)
+	if err != nil {
+		return false, fmt.Errorf("couldn't count version references to %s: %w", key, err)
+	}
+	return refs > 0, nil
+}
+
+// recordVideoVersion adds the media video was just given to its history,
+// along with the media it replaced if that predates version tracking, and
+// prunes versions beyond the configured limit.
+func (cfg *apiConfig) recordVideoVersion(ctx context.Context, previous, video database.Video) error {
+	if previous.StorageKey != nil {
+		err := cfg.ensureVideoVersion(ctx, previous)
+		if err != nil {
+			return err
+		}
+	}
+	err := cfg.ensureVideoVersion(ctx, video)
+	if err != nil {
+		return err
+	}
+	return cfg.pruneVideoVersions(ctx, video)
+}
+
+// ensureVideoVersion records the current media of video as a version unless
+// it already is one.
+func (cfg *apiConfig) ensureVideoVersion(ctx context.Context, video database.Video) error {
+	if video.StorageKey == nil || video.VideoURL == nil {
+		return nil
+	}
+	bucket := ""
+	if video.StorageBucket != nil {
+		bucket = *video.StorageBucket
+	}
+
+	existing, err := cfg.db.GetVideoVersionByKey(video.ID, bucket, *video.StorageKey)
+	if err != nil {
+		return fmt.Errorf("couldn't get video version: %w", err)
+	}
+	if existing != nil {
+		return nil
+	}
+
+	size, err := cfg.videoObjectSize(ctx, *video.StorageKey)
+	if err != nil {
+		log.Printf("Couldn't get size of %s: %v", *video.StorageKey, err)
+	}
+
+	_, err = cfg.db.CreateVideoVersion(database.CreateVideoVersionParams{
+		VideoID:        video.ID,
+		StorageBucket:  bucket,
+		StorageKey:     *video.StorageKey,
+		VideoURL:       *video.VideoURL,
+		Size:           size,
+		ChecksumSHA256: video.ChecksumSHA256,
+		Metadata:       video.Metadata,
+		ContentSHA256:  video.ContentSHA256,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't record video version: %w", err)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) videoObjectSize(ctx context.Context, key string) (int64, error) {
+	objects, err := cfg.videoStorage.List(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	for _, object := range objects {
+		if object.Key == key {
+			return object.Size, nil
+		}
+	}
+	return 0, fmt.Errorf("object %s not found", key)
+}
+
+// pruneVideoVersions drops the oldest versions of a video beyond the
+// configured limit, deleting their objects unless another video uses them.
+// The current media is never pruned.
+func (cfg *apiConfig) pruneVideoVersions(ctx context.Context, video database.Video) error {
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video versions: %w", err)
+	}
+
+	kept := 0
+	for _, version := range versions {
+		if isCurrentVersion(video, version) || kept < cfg.videoVersions {
+			kept++
+			continue
+		}
+
+		err := cfg.db.DeleteVideoVersion(version.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't delete video version: %w", err)
+		}
+		shared, err := cfg.isVideoObjectShared(version.StorageBucket, version.StorageKey, video.ID)
+		if err != nil {
+			return err
+		}
+		if shared || version.StorageBucket != cfg.videoStorage.Bucket() {
+			continue
+		}
+		err = cfg.videoStorage.Delete(ctx, version.StorageKey)
+		if err != nil {
+			log.Printf("Couldn't delete pruned video version %s: %v", version.StorageKey, err)
+		}
+	}
+	return nil
+}
+
+func isCurrentVersion(video database.Video, version database.VideoVersion) bool {
+	return video.StorageKey != nil && *video.StorageKey == version.StorageKey &&
+		video.StorageBucket != nil && *video.StorageBucket == version.StorageBucket
+}
+
+//openapi:summary List the media versions of a video
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 []versionResponse
+func (cfg *apiConfig) handlerVideoVersionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	type versionResponse struct {
+		database.VideoVersion
+		Current bool `json:"current"`
+	}
+
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video versions", err)
+		return
+	}
+
+	resp := make([]versionResponse, len(versions))
+	for i, version := range versions {
+		resp[i] = versionResponse{
+			VideoVersion: version,
+			Current:      isCurrentVersion(video, version),
+		}
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerVideoVersionRollback makes a previous version the video's media
+// again. HLS renditions, sprites and the preview clip were generated from the
+// replaced media, so they're dropped and players fall back to the MP4.
+//
+//openapi:summary Roll a video back to an earlier version
+//openapi:tags videos
+//openapi:auth bearer
+//openapi:response 200 database.Video
+func (cfg *apiConfig) handlerVideoVersionRollback(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	versionID, err := uuid.Parse(r.PathValue("versionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid version ID", err)
+		return
+	}
+	version, err := cfg.db.GetVideoVersion(versionID)
+	if err != nil || version.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find version", err)
+		return
+	}
+
+	switch video.ProcessingStatus {
+	case database.ProcessingStatusPending, database.ProcessingStatusProcessing:
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoProcessing, "Video is being processed", nil, nil)
+		return
+	}
+	if isCurrentVersion(video, version) {
+		respondWithJSON(w, http.StatusOK, video)
+		return
+	}
+
+	previous := video
+	videoURL := version.VideoURL
+	bucket := version.StorageBucket
+	key := version.StorageKey
+	video.VideoURL = &videoURL
+	video.StorageBucket = &bucket
+	video.StorageKey = &key
+	video.ContentSHA256 = version.ContentSHA256
+	video.ChecksumSHA256 = version.ChecksumSHA256
+	video.Metadata = version.Metadata
+	video.HLSURL = nil
+	video.SpriteURL = nil
+	video.SpriteVTTURL = nil
+	video.PreviewURL = nil
+	video.ProcessingStatus = database.ProcessingStatusReady
+	video.ProcessingError = nil
+	cfg.holdForModeration(&video)
+
+	err = cfg.db.SwapVideoMedia(video, previous.StorageKey)
+	if errors.Is(err, database.ErrVideoMediaChanged) {
+		respondWithError(w, http.StatusConflict, "Video media changed, try again", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't roll back video", err)
+		return
+	}
+
+	cfg.deleteReplacedMedia(r.Context(), previous, video)
+	err = cfg.replaceVideoRenditions(r.Context(), video.ID, nil)
+	if err != nil {
+		log.Printf("Couldn't drop renditions of video %s: %v", video.ID, err)
+	}
+	err = cfg.enqueueTranscription(r.Context(), video)
+	if err != nil {
+		log.Printf("Couldn't queue transcription of video %s: %v", video.ID, err)
+	}
+	err = cfg.enqueueModeration(r.Context(), &video)
+	if err != nil {
+		log.Printf("Couldn't queue moderation of video %s: %v", video.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
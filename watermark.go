@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// watermark is an image overlaid on processed videos. Since an overlay can't
+// be applied by remuxing, MP4 uploads are re-encoded while it's enabled.
+type watermark struct {
+	path     string
+	position string
+	// margin is the distance to the edges of the video, in pixels.
+	margin  int
+	opacity float64
+}
+
+// newWatermark checks the configured watermark. It returns nil if no image
+// is configured.
+func newWatermark(path, position string, margin int, opacity float64) (*watermark, error) {
+	if path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	wm := &watermark{path: path, position: position, margin: margin, opacity: opacity}
+	if wm.coordinates() == "" {
+		return nil, fmt.Errorf("position must be top-left, top-right, bottom-left, bottom-right or center: got %q", position)
+	}
+	if opacity <= 0 || opacity > 1 {
+		return nil, fmt.Errorf("opacity must be above 0 and at most 1: got %g", opacity)
+	}
+	return wm, nil
+}
+
+// coordinates returns the overlay filter's position of the watermark, or ""
+// for an unknown position.
+func (wm *watermark) coordinates() string {
+	m := wm.margin
+	switch wm.position {
+	case "top-left":
+		return fmt.Sprintf("%d:%d", m, m)
+	case "top-right":
+		return fmt.Sprintf("W-w-%d:%d", m, m)
+	case "bottom-left":
+		return fmt.Sprintf("%d:H-h-%d", m, m)
+	case "bottom-right":
+		return fmt.Sprintf("W-w-%d:H-h-%d", m, m)
+	case "center":
+		return "(W-w)/2:(H-h)/2"
+	}
+	return ""
+}
+
+// filterGraph overlays the watermark, the second input, on the video of the
+// first input and labels the result [vout].
+func (wm *watermark) filterGraph(encoder videoEncoder) string {
+	overlay := "overlay=" + wm.coordinates()
+	return fmt.Sprintf("[1:v]format=rgba,colorchannelmixer=aa=%g[wm];[0:v][wm]%s[vout]", wm.opacity, encoder.filter(overlay))
+}
+
+// watermarkFor returns the watermark to overlay on the media of video, or
+// nil if watermarking is disabled or the video or its owner opted out.
+func (cfg *apiConfig) watermarkFor(video database.Video) (*watermark, error) {
+	if cfg.watermark == nil || video.WatermarkDisabled {
+		return nil, nil
+	}
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get owner of video %s: %w", video.ID, err)
+	}
+	if owner != nil && owner.WatermarkOptOut {
+		return nil, nil
+	}
+	return cfg.watermark, nil
+}
+
+//openapi:summary Exempt a user from the watermark
+//openapi:tags admin
+//openapi:body parameters
+//openapi:response 200 adminUser
+func (cfg *apiConfig) handlerAdminUserWatermarkUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		OptOut bool `json:"opt_out"`
+	}
+
+	userIDString := r.PathValue("userID")
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.UpdateUserWatermarkOptOut(userID, params.OptOut)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update watermark opt-out", err)
+		return
+	}
+	user.WatermarkOptOut = params.OptOut
+
+	respondWithJSON(w, http.StatusOK, newAdminUser(*user))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/google/uuid"
+)
+
+const (
+	jobTypeDeliverWebhook = "deliver_webhook"
+
+	eventUploadCompleted  = "upload.completed"
+	eventProcessingFailed = "processing.failed"
+	eventVideoDeleted     = "video.deleted"
+	// Moderation events tell owners whether their videos were published
+	// or held for, and then decided by, a review.
+	eventModerationApproved = "moderation.approved"
+	eventModerationFlagged  = "moderation.flagged"
+	eventModerationRejected = "moderation.rejected"
+
+	webhookSignatureHeader = "X-Tubely-Signature"
+	webhookTimestampHeader = "X-Tubely-Timestamp"
+	webhookTimeout         = 10 * time.Second
+)
+
+type webhookEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type deliverWebhookPayload struct {
+	WebhookID uuid.UUID       `json:"webhook_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// publishEvent streams an event to the user's open event feeds and queues
+// its delivery to every webhook registered by the user. Deliveries are
+// retried with backoff by the job queue.
+func (cfg *apiConfig) publishEvent(userID uuid.UUID, eventType string, data any) {
+	event, err := newEvent(eventType, data)
+	if err != nil {
+		log.Printf("Couldn't encode %s event: %v", eventType, err)
+		return
+	}
+	cfg.events.Publish(userID, event)
+
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		log.Printf("Couldn't get webhooks for user %s: %v", userID, err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		_, err := cfg.jobs.Enqueue(jobTypeDeliverWebhook, webhook.ID.String(), deliverWebhookPayload{
+			WebhookID: webhook.ID,
+			Event:     event.Data,
+		})
+		if err != nil {
+			log.Printf("Couldn't queue %s event for webhook %s: %v", eventType, webhook.ID, err)
+		}
+	}
+}
+
+// newEvent encodes an event the way both event feeds and webhooks get it.
+func newEvent(eventType string, data any) (events.Event, error) {
+	eventID := uuid.New()
+	event, err := json.Marshal(webhookEvent{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return events.Event{}, err
+	}
+	return events.Event{ID: eventID.String(), Type: eventType, Data: event}, nil
+}
+
+func (cfg *apiConfig) runDeliverWebhookJob(ctx context.Context, job database.Job) error {
+	var payload deliverWebhookPayload
+	err := json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("couldn't decode job payload: %w", err)
+	}
+
+	webhook, err := cfg.db.GetWebhook(payload.WebhookID)
+	if err != nil {
+		return fmt.Errorf("couldn't get webhook: %w", err)
+	}
+	if webhook.ID == uuid.Nil {
+		// The webhook was deleted after the event was queued.
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload.Event))
+	if err != nil {
+		return fmt.Errorf("couldn't create webhook request: %w", err)
+	}
+	// Every attempt is signed when it's sent, so retries carry a fresh
+	// timestamp that receivers accept.
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookPayload(webhook.Secret, timestamp, payload.Event))
+
+	resp, err := cfg.webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook endpoint responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhookPayload signs a delivery with the webhook's secret: the HMAC
+// covers the timestamp as well as the body, so a captured delivery can't be
+// replayed later with a new timestamp.
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
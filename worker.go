@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// stagedUploadKey is where an upload received by the API waits for a worker
+// on another host to process it.
+func stagedUploadKey(videoID uuid.UUID, mediaType string) string {
+	return fmt.Sprintf("uploads/%s/%s%s", videoID, uuid.New(), mediaTypeToExt(mediaType))
+}
+
+// stageUploadedSource moves the local source of payload into the bucket,
+// since workers on other hosts can't read the API's temp files. The returned
+// payload points at the staged object instead, and the temp file is removed.
+func (cfg *apiConfig) stageUploadedSource(ctx context.Context, videoID uuid.UUID, payload processVideoPayload) (processVideoPayload, error) {
+	defer os.Remove(payload.SourcePath)
+
+	source, err := os.Open(payload.SourcePath)
+	if err != nil {
+		return payload, fmt.Errorf("couldn't open upload: %w", err)
+	}
+	defer source.Close()
+
+	key := stagedUploadKey(videoID, payload.MediaType)
+	err = cfg.videoStorage.Put(ctx, key, source, payload.MediaType)
+	if err != nil {
+		return payload, fmt.Errorf("couldn't upload %s: %w", key, err)
+	}
+
+	return processVideoPayload{
+		SourceKey:        key,
+		MediaType:        payload.MediaType,
+		ExpectedChecksum: payload.Checksum,
+		Filename:         payload.Filename,
+		Scanned:          payload.Scanned,
+	}, nil
+}
+
+// runWorker runs queued jobs without serving the API, until the process is
+// signalled to stop. Only the metrics endpoint is served, on port.
+func (cfg *apiConfig) runWorker(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: mux,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Worker serving metrics on: http://localhost:%s/metrics\n", port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	log.Printf("Shutting down, waiting up to %s for running jobs", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := cfg.jobs.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Interrupted running jobs: %v", err)
+	}
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Couldn't stop metrics server: %v", err)
+	}
+	cfg.removeStaleTempFiles()
+}